@@ -0,0 +1,1094 @@
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/encryption"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	"go.etcd.io/bbolt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// The statuses a task can have, the same as the ones of the status index of the redis broker.
+const (
+	statusPending   = "pending"
+	statusDelayed   = "delayed"
+	statusScheduled = "scheduled"
+	statusRunning   = "running"
+	statusRetry     = "retry"
+	statusFailed    = "failed"
+	statusSucceeded = "succeeded"
+)
+
+// maxDelayedTasksPerTick bounds the delayed and the cron tasks enqueued by a single scheduler tick per queue,
+// the rest is enqueued on the next one.
+const maxDelayedTasksPerTick = 1000
+
+var errNotFound = errors.New("not found")
+
+// The top level buckets.
+var (
+	bucketTasks      = []byte("tasks")      // task ID -> record
+	bucketMessages   = []byte("messages")   // task ID -> encoded message
+	bucketCreated    = []byte("created")    // creation time and task ID, the index of all tasks
+	bucketQueues     = []byte("queues")     // queue name -> bucket of the indexes of the queue
+	bucketDeliveries = []byte("deliveries") // task ID -> bucket of the delivery log of the task
+	bucketEvents     = []byte("events")     // event ID -> event
+	bucketLeader     = []byte("leader")     // leaderKey -> leadership
+	bucketWorkers    = []byte("workers")    // worker ID -> worker info
+	bucketLists      = []byte("lists")      // its sequence gives the positions in the pending and in the failed lists
+)
+
+// The indexes of a queue, nested in its bucket. The ones of times are keyed by the time and the task ID, sorted by time.
+var (
+	indexPending   = []byte("pending")   // position -> task ID, dequeued from the lowest position
+	indexLease     = []byte("lease")     // lease expiration time and task ID of the running tasks
+	indexRetry     = []byte("retry")     // retry time and task ID
+	indexFailed    = []byte("failed")    // position -> task ID, the last failed has the highest position
+	indexDelayed   = []byte("delayed")   // process at time and task ID
+	indexCron      = []byte("cron")      // next run time and task ID
+	indexScheduled = []byte("scheduled") // task ID of the recurring tasks with a period
+	indexCreated   = []byte("created")   // creation time and task ID
+)
+
+var queueIndexes = [][]byte{
+	indexPending, indexLease, indexRetry, indexFailed, indexDelayed, indexCron, indexScheduled, indexCreated,
+}
+
+// record holds the state of a stored task, the counterpart of the task hash of the redis broker.
+// The position and the times locate the task in the indexes of its queue.
+type record struct {
+	Queue        string        `json:"queue"`
+	Status       string        `json:"status"`
+	CreatedAt    int64         `json:"created_at"`
+	PendingSince int64         `json:"pending_since"`
+	Period       time.Duration `json:"period,omitempty"`
+	Cron         string        `json:"cron,omitempty"`
+	Timezone     string        `json:"timezone,omitempty"`
+	// Recurring is set for the recurring tasks with a period, they are enqueued again once the period passes
+	Recurring bool `json:"recurring,omitempty"`
+	// Position is the position of a pending task in the pending list, or of a failed task in the failed list
+	Position       int64 `json:"position,omitempty"`
+	ProcessAt      int64 `json:"process_at,omitempty"`
+	RetryAt        int64 `json:"retry_at,omitempty"`
+	LeaseExpiresAt int64 `json:"lease_expires_at,omitempty"`
+	// LeaseToken is the token of the lease the running task was dequeued with
+	LeaseToken string `json:"lease_token,omitempty"`
+	// NextRun is the next run time of a cron task, 0 for the other tasks
+	NextRun int64 `json:"next_run,omitempty"`
+}
+
+// queue holds the indexes of a queue.
+type queue struct {
+	pending   *bbolt.Bucket
+	lease     *bbolt.Bucket
+	retry     *bbolt.Bucket
+	failed    *bbolt.Bucket
+	delayed   *bbolt.Bucket
+	cron      *bbolt.Bucket
+	scheduled *bbolt.Bucket
+	created   *bbolt.Bucket
+}
+
+func queueOf(bucket *bbolt.Bucket) *queue {
+	return &queue{
+		pending:   bucket.Bucket(indexPending),
+		lease:     bucket.Bucket(indexLease),
+		retry:     bucket.Bucket(indexRetry),
+		failed:    bucket.Bucket(indexFailed),
+		delayed:   bucket.Bucket(indexDelayed),
+		cron:      bucket.Bucket(indexCron),
+		scheduled: bucket.Bucket(indexScheduled),
+		created:   bucket.Bucket(indexCreated),
+	}
+}
+
+// lookupQueue returns the indexes of the queue, nil if the queue does not exist.
+func lookupQueue(tx *bbolt.Tx, qname string) *queue {
+	bucket := tx.Bucket(bucketQueues).Bucket([]byte(qname))
+	if bucket == nil {
+		return nil
+	}
+	return queueOf(bucket)
+}
+
+// queueNames returns the names of all queues tasks have been enqueued to, sorted.
+func queueNames(tx *bbolt.Tx) ([]string, error) {
+	qnames := make([]string, 0)
+	err := tx.Bucket(bucketQueues).ForEachBucket(func(k []byte) error {
+		qnames = append(qnames, string(k))
+		return nil
+	})
+	return qnames, err
+}
+
+// sortable encodes v so that the keys sort like the numbers, the negative ones included.
+func sortable(v int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(v)^(1<<63))
+	return key
+}
+
+// seqKey is the key of an event or of a delivery, sorted by their sequence.
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// timeKey is the key of a task in an index of times.
+func timeKey(t int64, id string) []byte {
+	return append(sortable(t), id...)
+}
+
+func timeOf(key []byte) int64 {
+	return int64(binary.BigEndian.Uint64(key[:8]) ^ (1 << 63))
+}
+
+func idOf(key []byte) string {
+	return string(key[8:])
+}
+
+// due returns the IDs of the index of times with a time up to until, sorted by time, at most limit of them if limit is not 0.
+func due(index *bbolt.Bucket, until int64, limit int) []string {
+	var ids []string
+	c := index.Cursor()
+	for key, _ := c.First(); key != nil && timeOf(key) <= until; key, _ = c.Next() {
+		ids = append(ids, idOf(key))
+		if limit > 0 && len(ids) == limit {
+			break
+		}
+	}
+	return ids
+}
+
+// unindex takes the task off the index of its status.
+func (q *queue) unindex(id string, r *record) error {
+	switch r.Status {
+	case statusPending:
+		return q.pending.Delete(sortable(r.Position))
+	case statusRunning:
+		return q.lease.Delete(timeKey(r.LeaseExpiresAt, id))
+	case statusRetry:
+		return q.retry.Delete(timeKey(r.RetryAt, id))
+	case statusFailed:
+		return q.failed.Delete(sortable(r.Position))
+	case statusDelayed:
+		return q.delayed.Delete(timeKey(r.ProcessAt, id))
+	}
+	return nil
+}
+
+func getRecord(tx *bbolt.Tx, id string) (*record, error) {
+	value := tx.Bucket(bucketTasks).Get([]byte(id))
+	if value == nil {
+		return nil, errNotFound
+	}
+	var r record
+	if err := json.Unmarshal(value, &r); err != nil {
+		return nil, fmt.Errorf("cannot decode task %s: %v", id, err)
+	}
+	return &r, nil
+}
+
+func putRecord(tx *bbolt.Tx, id string, r *record) error {
+	encoded, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("cannot encode task %s: %v", id, err)
+	}
+	return tx.Bucket(bucketTasks).Put([]byte(id), encoded)
+}
+
+// BDB is a broker which stores the tasks in a bbolt file, for running the manager and the workers in a single process.
+// The file is locked by the process which opened it, so unlike redis and postgres it cannot be shared by several processes.
+// The state transitions are transactional, a task is never lost or duplicated by a crash.
+type BDB struct {
+	db      *bbolt.DB
+	clock   timeutil.Clock
+	keyring *encryption.Keyring
+
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+	// eventsChanged is closed and replaced whenever events are published, to wake up the readers
+	eventsChanged chan struct{}
+}
+
+// subscriber is notified when tasks are pushed to the pending list of one of its queues.
+type subscriber struct {
+	qnames []string
+	notify chan struct{}
+}
+
+func NewBDB(db *bbolt.DB, clock timeutil.Clock) *BDB {
+	return &BDB{
+		db:            db,
+		clock:         clock,
+		subscribers:   make(map[*subscriber]struct{}),
+		eventsChanged: make(chan struct{}),
+	}
+}
+
+// WithKeyring encrypts the payloads of the tasks stored from now on with the keyring, and decrypts them when read.
+func (b *BDB) WithKeyring(keyring *encryption.Keyring) *BDB {
+	b.keyring = keyring
+	return b
+}
+
+func (b *BDB) Close() error {
+	return b.db.Close()
+}
+
+// txn is a write transaction. The workers of the queues with new pending tasks and the readers of the events
+// are woken up once it is committed.
+type txn struct {
+	*bbolt.Tx
+	b         *BDB
+	now       time.Time
+	notify    map[string]bool
+	published bool
+}
+
+// update runs fn in a write transaction, which is rolled back if fn returns an error.
+func (b *BDB) update(fn func(t *txn) error) error {
+	t := &txn{
+		b:      b,
+		now:    b.clock.Now(),
+		notify: make(map[string]bool),
+	}
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		t.Tx = tx
+		return fn(t)
+	})
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for qname := range t.notify {
+		b.notifyPending(qname)
+	}
+	if t.published {
+		close(b.eventsChanged)
+		b.eventsChanged = make(chan struct{})
+	}
+	return nil
+}
+
+// queue returns the indexes of the queue, creating them if the queue does not exist.
+func (t *txn) queue(qname string) (*queue, error) {
+	bucket, err := t.Bucket(bucketQueues).CreateBucketIfNotExists([]byte(qname))
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range queueIndexes {
+		if _, err := bucket.CreateBucketIfNotExists(index); err != nil {
+			return nil, err
+		}
+	}
+	return queueOf(bucket), nil
+}
+
+// position returns the next position in a list, increasing ones to push to its head
+// and decreasing negative ones to push to its tail.
+func (t *txn) position(tail bool) (int64, error) {
+	seq, err := t.Bucket(bucketLists).NextSequence()
+	if err != nil {
+		return 0, err
+	}
+	if tail {
+		return -int64(seq), nil
+	}
+	return int64(seq), nil
+}
+
+// setPending pushes the task to the pending list of the queue, to the tail if it should be processed next.
+func (t *txn) setPending(q *queue, id string, r *record, next bool) error {
+	if err := q.unindex(id, r); err != nil {
+		return err
+	}
+	position, err := t.position(next)
+	if err != nil {
+		return err
+	}
+	if err := q.pending.Put(sortable(position), []byte(id)); err != nil {
+		return err
+	}
+	r.Status = statusPending
+	r.Position = position
+	r.PendingSince = t.now.UnixMilli()
+	t.notify[r.Queue] = true
+	return putRecord(t.Tx, id, r)
+}
+
+// promote moves a task the scheduler enqueues to the pending list and publishes its enqueued event.
+func (t *txn) promote(q *queue, id string, r *record, next bool) error {
+	if err := t.setPending(q, id, r, next); err != nil {
+		return err
+	}
+	msg, err := t.b.message(t.Tx, id)
+	if err != nil {
+		return err
+	}
+	return t.publishEvent(base.EventEnqueued, msg)
+}
+
+// setRunning leases the task until leaseExpiresAt.
+func (t *txn) setRunning(q *queue, id string, r *record, leaseExpiresAt int64) error {
+	if err := q.unindex(id, r); err != nil {
+		return err
+	}
+	if err := q.lease.Put(timeKey(leaseExpiresAt, id), nil); err != nil {
+		return err
+	}
+	r.Status = statusRunning
+	r.LeaseExpiresAt = leaseExpiresAt
+	return putRecord(t.Tx, id, r)
+}
+
+// setRetry makes the task wait for its retry time.
+func (t *txn) setRetry(q *queue, id string, r *record, retryAt int64) error {
+	if err := q.unindex(id, r); err != nil {
+		return err
+	}
+	if err := q.retry.Put(timeKey(retryAt, id), nil); err != nil {
+		return err
+	}
+	r.Status = statusRetry
+	r.RetryAt = retryAt
+	return putRecord(t.Tx, id, r)
+}
+
+// setFailed pushes the task to the head of the failed list, the dead letter queue.
+func (t *txn) setFailed(q *queue, id string, r *record) error {
+	if err := q.unindex(id, r); err != nil {
+		return err
+	}
+	position, err := t.position(false)
+	if err != nil {
+		return err
+	}
+	if err := q.failed.Put(sortable(position), []byte(id)); err != nil {
+		return err
+	}
+	r.Status = statusFailed
+	r.Position = position
+	return putRecord(t.Tx, id, r)
+}
+
+// setDelayed makes the task wait until processAt.
+func (t *txn) setDelayed(q *queue, id string, r *record, processAt int64) error {
+	if err := q.unindex(id, r); err != nil {
+		return err
+	}
+	if err := q.delayed.Put(timeKey(processAt, id), nil); err != nil {
+		return err
+	}
+	r.Status = statusDelayed
+	r.ProcessAt = processAt
+	return putRecord(t.Tx, id, r)
+}
+
+// setStatus sets a status which has no index, scheduled or succeeded.
+func (t *txn) setStatus(q *queue, id string, r *record, status string) error {
+	if err := q.unindex(id, r); err != nil {
+		return err
+	}
+	r.Status = status
+	return putRecord(t.Tx, id, r)
+}
+
+// setCron moves the cron task to its next run, 0 takes it off the cron tasks.
+func (t *txn) setCron(q *queue, id string, r *record, nextRun int64) error {
+	if r.NextRun != 0 {
+		if err := q.cron.Delete(timeKey(r.NextRun, id)); err != nil {
+			return err
+		}
+	}
+	if nextRun != 0 {
+		if err := q.cron.Put(timeKey(nextRun, id), nil); err != nil {
+			return err
+		}
+	}
+	r.NextRun = nextRun
+	return putRecord(t.Tx, id, r)
+}
+
+// setRecurring adds the task to or takes it off the recurring tasks with a period.
+func (t *txn) setRecurring(q *queue, id string, r *record, recurring bool) error {
+	var err error
+	if recurring {
+		err = q.scheduled.Put([]byte(id), nil)
+	} else {
+		err = q.scheduled.Delete([]byte(id))
+	}
+	if err != nil {
+		return err
+	}
+	r.Recurring = recurring
+	return putRecord(t.Tx, id, r)
+}
+
+func (b *BDB) decode(encoded []byte) (*task.Message, error) {
+	msg, err := task.DecodeMessage(string(encoded), b.keyring)
+	if err != nil {
+		logger.Error("Error decoding msg", "error", err)
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (b *BDB) encode(msg *task.Message) ([]byte, error) {
+	encoded, err := task.EncodeMessage(msg, b.keyring)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode message: %v", err)
+	}
+	return encoded, nil
+}
+
+// message returns the task with the given ID with the status of its record,
+// the stored message keeps the status it was last updated with.
+func (b *BDB) message(tx *bbolt.Tx, id string) (*task.Message, error) {
+	encoded := tx.Bucket(bucketMessages).Get([]byte(id))
+	if encoded == nil {
+		return nil, errNotFound
+	}
+	msg, err := b.decode(encoded)
+	if err != nil {
+		return nil, err
+	}
+	r, err := getRecord(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if status, ok := task.StatusFromIndex(r.Status); ok {
+		msg.Status = status
+	}
+	return msg, nil
+}
+
+// GetAllTasks fetches tasks sorted by creation time, newest first, with a given offset.
+// The tasks can be narrowed down to a queue and to a status within that queue, leave them empty to get all tasks.
+func (b *BDB) GetAllTasks(ctx context.Context, qname string, status string, offset int, limit int) (int64, []*task.Message, error) {
+	if status != "" && qname == "" {
+		return 0, nil, errors.New("filtering by status requires a queue")
+	}
+	logger.Info("Fetching all tasks", "queue", qname, "status", status, "offset", offset, "limit", limit)
+
+	var total int64
+	var tasks []*task.Message
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		index := tx.Bucket(bucketCreated)
+		if qname != "" {
+			q := lookupQueue(tx, qname)
+			if q == nil {
+				return nil
+			}
+			index = q.created
+		}
+
+		c := index.Cursor()
+		for key, _ := c.Last(); key != nil; key, _ = c.Prev() {
+			id := idOf(key)
+			if status != "" {
+				r, err := getRecord(tx, id)
+				if err != nil {
+					return err
+				}
+				if r.Status != status {
+					continue
+				}
+			}
+			total++
+			if total <= int64(offset) || len(tasks) >= limit {
+				continue
+			}
+			msg, err := b.message(tx, id)
+			if err != nil {
+				return err
+			}
+			tasks = append(tasks, msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return total, tasks, nil
+}
+
+// GetTask fetches a task by its ID.
+func (b *BDB) GetTask(ctx context.Context, taskID string) (*task.Message, error) {
+	var msg *task.Message
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		msg, err = b.message(tx, taskID)
+		return err
+	})
+	return msg, err
+}
+
+// nextCronRunMilli returns the next run time of a cron task after now in unix milli sec, 0 if msg is not a cron task.
+func nextCronRunMilli(msg *task.Message, now time.Time) (int64, error) {
+	if msg.Cron == "" {
+		return 0, nil
+	}
+	next, err := task.NextRun(msg.Cron, msg.Timezone, now)
+	if err != nil {
+		return 0, err
+	}
+	return next.UnixMilli(), nil
+}
+
+// EnqueueTask adds the given task to the pending list of the queue,
+// or to the delayed tasks of the queue if it should be processed later.
+func (b *BDB) EnqueueTask(ctx context.Context, msg *task.Message) error {
+	encoded, err := b.encode(msg)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Adding task", "id", msg.ID, "queue", msg.Queue)
+	return b.update(func(t *txn) error {
+		nextRun, err := nextCronRunMilli(msg, t.now)
+		if err != nil {
+			return err
+		}
+		id := []byte(msg.ID)
+		if t.Bucket(bucketTasks).Get(id) != nil {
+			return errors.New("task id already exists")
+		}
+		q, err := t.queue(msg.Queue)
+		if err != nil {
+			return err
+		}
+
+		if err := t.Bucket(bucketMessages).Put(id, encoded); err != nil {
+			return err
+		}
+		r := &record{
+			Queue:        msg.Queue,
+			CreatedAt:    t.now.UnixMilli(),
+			PendingSince: t.now.UnixMilli(),
+			Period:       msg.Period,
+			Cron:         msg.Cron,
+			Timezone:     msg.Timezone,
+		}
+		created := timeKey(r.CreatedAt, msg.ID)
+		if err := t.Bucket(bucketCreated).Put(created, nil); err != nil {
+			return err
+		}
+		if err := q.created.Put(created, nil); err != nil {
+			return err
+		}
+
+		switch {
+		case msg.Cron != "":
+			// a cron task is enqueued by the scheduler on its first run
+			r.Status = statusScheduled
+			err = t.setCron(q, msg.ID, r, nextRun)
+		case msg.ProcessAt != nil && msg.ProcessAt.After(t.now):
+			if err := t.setRecurring(q, msg.ID, r, msg.Type == task.TypeRecurring); err != nil {
+				return err
+			}
+			err = t.setDelayed(q, msg.ID, r, msg.ProcessAt.UnixMilli())
+		default:
+			if err := t.setRecurring(q, msg.ID, r, msg.Type == task.TypeRecurring); err != nil {
+				return err
+			}
+			err = t.setPending(q, msg.ID, r, false)
+		}
+		if err != nil {
+			return err
+		}
+		return t.publishEvent(base.EventEnqueued, msg)
+	})
+}
+
+// DequeueTask moves a task from the pending to the running list of the first non-empty queue
+// and leases it for the given duration.
+func (b *BDB) DequeueTask(ctx context.Context, lease time.Duration, qnames ...string) (*task.Message, error) {
+	var msg *task.Message
+	err := b.update(func(t *txn) error {
+		for _, qname := range qnames {
+			q := lookupQueue(t.Tx, qname)
+			if q == nil {
+				continue
+			}
+			_, value := q.pending.Cursor().First()
+			if value == nil {
+				continue
+			}
+			id := string(value)
+			r, err := getRecord(t.Tx, id)
+			if err != nil {
+				return err
+			}
+			r.LeaseToken = task.NewLeaseToken()
+			if err := t.setRunning(q, id, r, t.now.Add(lease).UnixMilli()); err != nil {
+				return err
+			}
+			msg, err = b.message(t.Tx, id)
+			if err != nil {
+				return err
+			}
+			msg.LeaseToken = r.LeaseToken
+			return t.publishEvent(base.EventStarted, msg)
+		}
+		return base.ErrorNoTasksInQueue
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// notifyPending wakes up the subscribers of the queue.
+// Notifications are coalesced, so a subscriber which was not woken up yet is not notified again.
+func (b *BDB) notifyPending(qname string) {
+	for sub := range b.subscribers {
+		if !slices.Contains(sub.qnames, qname) {
+			continue
+		}
+		select {
+		case sub.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// NotifyPending returns a channel which receives a value when tasks are pushed to the pending list of any of the given queues.
+// Notifications are coalesced, so a single receive may stand for many tasks. The channel is closed when ctx is done.
+func (b *BDB) NotifyPending(ctx context.Context, qnames ...string) <-chan struct{} {
+	sub := &subscriber{
+		qnames: qnames,
+		notify: make(chan struct{}, 1),
+	}
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, sub)
+		close(sub.notify)
+	}()
+
+	return sub.notify
+}
+
+// running returns the indexes of the queue and the record of a running task, or false if it does not hold the lease anymore.
+// A worker whose lease expired cannot change the task anymore, even once it is dequeued again.
+func (t *txn) running(msg *task.Message) (*queue, *record, bool, error) {
+	r, err := getRecord(t.Tx, msg.ID)
+	if errors.Is(err, errNotFound) {
+		return nil, nil, false, nil
+	}
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if r.Status != statusRunning || r.Queue != msg.Queue || r.LeaseToken != msg.LeaseToken {
+		return nil, nil, false, nil
+	}
+	q, err := t.queue(r.Queue)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return q, r, true, nil
+}
+
+// ExtendLease extends the lease of a running task by the given duration from now.
+// A lease is never shortened, so a processor can hold a longer lease than the worker renews.
+func (b *BDB) ExtendLease(ctx context.Context, msg *task.Message, lease time.Duration) error {
+	return b.update(func(t *txn) error {
+		q, r, ok, err := t.running(msg)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return base.ErrorLeaseExpired
+		}
+		return t.setRunning(q, msg.ID, r, max(r.LeaseExpiresAt, t.now.Add(lease).UnixMilli()))
+	})
+}
+
+// UpdateTask stores the given task and reschedules it if its schedule changed.
+func (b *BDB) UpdateTask(ctx context.Context, msg *task.Message) error {
+	encoded, err := b.encode(msg)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Updating task", "id", msg.ID)
+	return b.update(func(t *txn) error {
+		nextRun, err := nextCronRunMilli(msg, t.now)
+		if err != nil {
+			return err
+		}
+		r, err := getRecord(t.Tx, msg.ID)
+		if errors.Is(err, errNotFound) {
+			return errors.New("task id does not exist")
+		}
+		if err != nil {
+			return err
+		}
+		if msg.LeaseToken != "" && (r.Status != statusRunning || r.LeaseToken != msg.LeaseToken) {
+			return base.ErrorLeaseExpired
+		}
+		q, err := t.queue(r.Queue)
+		if err != nil {
+			return err
+		}
+
+		if err := t.Bucket(bucketMessages).Put([]byte(msg.ID), encoded); err != nil {
+			return err
+		}
+		r.Period = msg.Period
+		r.Cron = msg.Cron
+		r.Timezone = msg.Timezone
+		if err := t.setCron(q, msg.ID, r, nextRun); err != nil {
+			return err
+		}
+		if err := t.setRecurring(q, msg.ID, r, msg.Cron == "" && msg.Type == task.TypeRecurring); err != nil {
+			return err
+		}
+		// reschedule only tasks which are still delayed
+		if r.Status == statusDelayed && msg.ProcessAt != nil {
+			return t.setDelayed(q, msg.ID, r, msg.ProcessAt.UnixMilli())
+		}
+		return nil
+	})
+}
+
+// RemoveTask deletes the task from all queues and the task itself
+func (b *BDB) RemoveTask(ctx context.Context, taskID string) error {
+	return b.update(func(t *txn) error {
+		return t.removeTask(taskID)
+	})
+}
+
+func (t *txn) removeTask(id string) error {
+	r, err := getRecord(t.Tx, id)
+	if err != nil {
+		return err
+	}
+	// the task is read first, so its event tells which task it was
+	msg, err := t.b.message(t.Tx, id)
+	if err != nil {
+		msg = &task.Message{ID: id, Queue: r.Queue}
+	}
+
+	q, err := t.queue(r.Queue)
+	if err != nil {
+		return err
+	}
+	if err := q.unindex(id, r); err != nil {
+		return err
+	}
+	if err := t.setCron(q, id, r, 0); err != nil {
+		return err
+	}
+	if err := t.setRecurring(q, id, r, false); err != nil {
+		return err
+	}
+	created := timeKey(r.CreatedAt, id)
+	if err := q.created.Delete(created); err != nil {
+		return err
+	}
+	if err := t.Bucket(bucketCreated).Delete(created); err != nil {
+		return err
+	}
+	if t.Bucket(bucketDeliveries).Bucket([]byte(id)) != nil {
+		if err := t.Bucket(bucketDeliveries).DeleteBucket([]byte(id)); err != nil {
+			return err
+		}
+	}
+	if err := t.Bucket(bucketMessages).Delete([]byte(id)); err != nil {
+		return err
+	}
+	if err := t.Bucket(bucketTasks).Delete([]byte(id)); err != nil {
+		return err
+	}
+
+	return t.publishEvent(base.EventDeleted, msg)
+}
+
+// RequeueTaskRetry moves the task from running queue to the retry queue.
+// The scheduler moves it back to the pending queue once its RetryAt time has passed.
+// It returns base.ErrorLeaseExpired if the lease of the task was lost.
+func (b *BDB) RequeueTaskRetry(ctx context.Context, msg *task.Message) error {
+	return b.update(func(t *txn) error {
+		q, r, ok, err := t.running(msg)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return base.ErrorLeaseExpired
+		}
+		var retryAt int64
+		if msg.RetryAt != nil {
+			retryAt = msg.RetryAt.UnixMilli()
+		}
+		if err := t.setRetry(q, msg.ID, r, retryAt); err != nil {
+			return err
+		}
+		return t.publishEvent(base.EventRetrying, msg)
+	})
+}
+
+// RequeueTaskPending moves the task from running queue back to the pending queue, to be processed next,
+// e.g. when the processing was interrupted by the worker shutting down. It does not count as an attempt.
+// It returns base.ErrorLeaseExpired if the lease of the task was lost, e.g. it expired and the task was reclaimed.
+func (b *BDB) RequeueTaskPending(ctx context.Context, msg *task.Message) error {
+	msg.Status = task.StatusPending
+	encoded, err := b.encode(msg)
+	if err != nil {
+		return err
+	}
+	return b.update(func(t *txn) error {
+		q, r, ok, err := t.running(msg)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return base.ErrorLeaseExpired
+		}
+		if err := t.Bucket(bucketMessages).Put([]byte(msg.ID), encoded); err != nil {
+			return err
+		}
+		if err := t.setPending(q, msg.ID, r, true); err != nil {
+			return err
+		}
+		return t.publishEvent(base.EventEnqueued, msg)
+	})
+}
+
+// RequeueTaskFailed moves the task from running queue to the failed queue.
+// It returns base.ErrorLeaseExpired if the lease of the task was lost.
+func (b *BDB) RequeueTaskFailed(ctx context.Context, msg *task.Message) error {
+	return b.update(func(t *txn) error {
+		q, r, ok, err := t.running(msg)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return base.ErrorLeaseExpired
+		}
+		if err := t.setFailed(q, msg.ID, r); err != nil {
+			return err
+		}
+		return t.publishEvent(base.EventFailed, msg)
+	})
+}
+
+// MarkTaskAsComplete takes the task off the running queue and marks it as succeeded.
+// It returns base.ErrorLeaseExpired if the lease of the task was lost.
+func (b *BDB) MarkTaskAsComplete(ctx context.Context, msg *task.Message) error {
+	return b.update(func(t *txn) error {
+		q, r, ok, err := t.running(msg)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return base.ErrorLeaseExpired
+		}
+		if err := t.setStatus(q, msg.ID, r, statusSucceeded); err != nil {
+			return err
+		}
+		return t.publishEvent(base.EventSucceeded, msg)
+	})
+}
+
+// GetQueues returns the names of all queues tasks have been enqueued to.
+func (b *BDB) GetQueues(ctx context.Context) ([]string, error) {
+	var qnames []string
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		qnames, err = queueNames(tx)
+		return err
+	})
+	return qnames, err
+}
+
+// isWaiting reports whether the task is waiting to be processed or is being processed,
+// a recurring task in such a state is not enqueued again.
+func isWaiting(status string) bool {
+	switch status {
+	case statusFailed, statusRetry, statusRunning, statusPending, statusDelayed:
+		return true
+	}
+	return false
+}
+
+// EnqueueScheduledTasks checks for scheduled tasks in all queues and pass them to their pending queue.
+// It returns base.ErrorNotLeader if epoch is not the epoch of the current leadership.
+func (b *BDB) EnqueueScheduledTasks(ctx context.Context, epoch int64) error {
+	return b.update(func(t *txn) error {
+		leader, err := t.isLeader(epoch)
+		if err != nil {
+			return err
+		}
+		if !leader {
+			return base.ErrorNotLeader
+		}
+		qnames, err := queueNames(t.Tx)
+		if err != nil {
+			return err
+		}
+		for _, qname := range qnames {
+			if err := t.enqueueScheduledTasks(qname); err != nil {
+				return fmt.Errorf("queue %s: %w", qname, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (t *txn) enqueueScheduledTasks(qname string) error {
+	q, err := t.queue(qname)
+	if err != nil {
+		return err
+	}
+	nowMilli := t.now.UnixMilli()
+
+	for _, id := range due(q.delayed, nowMilli, maxDelayedTasksPerTick) {
+		r, err := getRecord(t.Tx, id)
+		if err != nil {
+			return err
+		}
+		if err := t.promote(q, id, r, false); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range due(q.retry, nowMilli, 0) {
+		r, err := getRecord(t.Tx, id)
+		if err != nil {
+			return err
+		}
+		if err := t.promote(q, id, r, true); err != nil {
+			return err
+		}
+	}
+
+	var recurring []string
+	err = q.scheduled.ForEach(func(k, _ []byte) error {
+		recurring = append(recurring, string(k))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range recurring {
+		r, err := getRecord(t.Tx, id)
+		if err != nil {
+			return err
+		}
+		if isWaiting(r.Status) || nowMilli <= r.PendingSince+r.Period.Milliseconds() {
+			continue
+		}
+		if err := t.promote(q, id, r, false); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range due(q.cron, nowMilli, maxDelayedTasksPerTick) {
+		r, err := getRecord(t.Tx, id)
+		if err != nil {
+			return err
+		}
+		next, err := task.NextRun(r.Cron, r.Timezone, t.now)
+		if err != nil {
+			logger.Error("error computing next run of cron task", "id", id, "error", err)
+			continue
+		}
+		if err := t.setCron(q, id, r, next.UnixMilli()); err != nil {
+			return err
+		}
+		if isWaiting(r.Status) {
+			continue
+		}
+		if err := t.promote(q, id, r, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReclaimExpiredLeases fails the attempts of the running tasks whose lease has expired.
+// A lease expires when the worker processing the task died without handing it back, e.g. it crashed processing it.
+// The tasks with attempts left are retried right away, the other ones are moved to the dead letter queue.
+// It returns base.ErrorNotLeader if epoch is not the epoch of the current leadership.
+func (b *BDB) ReclaimExpiredLeases(ctx context.Context, lease time.Duration, epoch int64) error {
+	return b.update(func(t *txn) error {
+		leader, err := t.isLeader(epoch)
+		if err != nil {
+			return err
+		}
+		if !leader {
+			return base.ErrorNotLeader
+		}
+		qnames, err := queueNames(t.Tx)
+		if err != nil {
+			return err
+		}
+		for _, qname := range qnames {
+			q, err := t.queue(qname)
+			if err != nil {
+				return err
+			}
+			expired := due(q.lease, t.now.UnixMilli(), 0)
+			for _, id := range expired {
+				r, err := getRecord(t.Tx, id)
+				if err != nil {
+					return err
+				}
+				if err := t.failExpiredLease(q, id, r); err != nil {
+					return err
+				}
+			}
+			if len(expired) > 0 {
+				logger.Warn("Reclaimed tasks with expired lease", "queue", qname, "count", len(expired))
+			}
+		}
+		return nil
+	})
+}
+
+// failExpiredLease moves the task whose lease expired to the retry index, or to the failed list if it has no attempts left.
+// A task which cannot be decoded cannot be retried either.
+func (t *txn) failExpiredLease(q *queue, id string, r *record) error {
+	msg, err := t.b.message(t.Tx, id)
+	if err != nil {
+		return t.setFailed(q, id, r)
+	}
+	retry := msg.FailExpiredLease(t.now)
+	encoded, err := t.b.encode(msg)
+	if err != nil {
+		return err
+	}
+	if err := t.Bucket(bucketMessages).Put([]byte(id), encoded); err != nil {
+		return err
+	}
+	if retry {
+		if err := t.setRetry(q, id, r, msg.RetryAt.UnixMilli()); err != nil {
+			return err
+		}
+		return t.publishEvent(base.EventRetrying, msg)
+	}
+	if err := t.setFailed(q, id, r); err != nil {
+		return err
+	}
+	return t.publishEvent(base.EventFailed, msg)
+}
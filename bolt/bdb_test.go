@@ -0,0 +1,124 @@
+package bolt
+
+import (
+	"context"
+	"github.com/engpetarmarinov/gotama/internal/brokertest"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestBDB opens the bolt file at path, the file is closed when the test ends unless it is closed before.
+func openTestBDB(t *testing.T, path string, clock timeutil.Clock) *BDB {
+	t.Helper()
+	db, err := ClientOpt{Path: path}.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewBDB(db, clock)
+	t.Cleanup(func() { _ = b.Close() })
+	if err := b.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestConformance(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T, clock timeutil.Clock) brokertest.Broker {
+		return openTestBDB(t, filepath.Join(t.TempDir(), "gotama.db"), clock)
+	})
+}
+
+func TestPendingOrder(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	ctx := context.Background()
+	clock := timeutil.NewSimulatedClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	b := openTestBDB(t, filepath.Join(t.TempDir(), "gotama.db"), clock)
+
+	first := brokertest.NewMessage(t, &task.Request{Name: "email", Type: "once"})
+	second := brokertest.NewMessage(t, &task.Request{Name: "email", Type: "once"})
+	interrupted := brokertest.NewMessage(t, &task.Request{Name: "email", Type: "once"})
+	for _, msg := range []*task.Message{interrupted, first, second} {
+		if err := b.EnqueueTask(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	running, err := b.DequeueTask(ctx, time.Minute, task.QueueDefault)
+	if err != nil || running.ID != interrupted.ID {
+		t.Fatalf("expected to dequeue %s, got %v, %v", interrupted.ID, running, err)
+	}
+	// an interrupted task is processed next
+	if err := b.RequeueTaskPending(ctx, running); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []*task.Message{interrupted, first, second} {
+		got, err := b.DequeueTask(ctx, time.Minute, task.QueueDefault)
+		if err != nil || got.ID != want.ID {
+			t.Fatalf("expected to dequeue %s, got %v, %v", want.ID, got, err)
+		}
+	}
+}
+
+func TestRestart(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	ctx := context.Background()
+	clock := timeutil.NewSimulatedClock(time.Now())
+	path := filepath.Join(t.TempDir(), "gotama.db")
+	b := openTestBDB(t, path, clock)
+	epoch := brokertest.Leadership(t, b)
+
+	pending := brokertest.NewMessage(t, &task.Request{Name: "email", Type: "once"})
+	recurring := brokertest.NewMessage(t, &task.Request{Name: "email", Type: "recurring", Period: "1m"})
+	for _, msg := range []*task.Message{pending, recurring} {
+		if err := b.EnqueueTask(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	running, err := b.DequeueTask(ctx, time.Minute, task.QueueDefault)
+	if err != nil || running.ID != pending.ID {
+		t.Fatalf("expected to dequeue %s, got %v, %v", pending.ID, running, err)
+	}
+	running, err = b.DequeueTask(ctx, time.Minute, task.QueueDefault)
+	if err != nil || running.ID != recurring.ID {
+		t.Fatalf("expected to dequeue %s, got %v, %v", recurring.ID, running, err)
+	}
+	if err := b.MarkTaskAsComplete(ctx, running); err != nil {
+		t.Fatal(err)
+	}
+	// the process stops without handing back the running task
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b = openTestBDB(t, path, clock)
+	clock.AdvanceTime(2 * time.Minute)
+	if _, err := b.AcquireLeadership(ctx, "manager-1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.EnqueueScheduledTasks(ctx, epoch); err != nil {
+		t.Fatalf("expected the leadership to survive the restart, got %v", err)
+	}
+	if err := b.ReclaimExpiredLeases(ctx, time.Minute, epoch); err != nil {
+		t.Fatal(err)
+	}
+	// the attempt of the running task failed, it is retried right away
+	if err := b.EnqueueScheduledTasks(ctx, epoch); err != nil {
+		t.Fatal(err)
+	}
+
+	dequeued := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		msg, err := b.DequeueTask(ctx, time.Minute, task.QueueDefault)
+		if err != nil {
+			t.Fatalf("expected the tasks to survive the restart, got %v", err)
+		}
+		dequeued[msg.ID] = true
+	}
+	if !dequeued[pending.ID] || !dequeued[recurring.ID] {
+		t.Errorf("expected %s and %s after the restart, got %v", pending.ID, recurring.ID, dequeued)
+	}
+}
@@ -0,0 +1,18 @@
+package bolt
+
+import (
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+// ClientOpt is used to open the bbolt file of the tasks.
+type ClientOpt struct {
+	// Path of the file, it is created if it does not exist.
+	Path string
+}
+
+// Open opens the file specified by ClientOpt. It fails if another process has it open,
+// after waiting a second for it to close the file, e.g. while it restarts.
+func (opt ClientOpt) Open() (*bbolt.DB, error) {
+	return bbolt.Open(opt.Path, 0600, &bbolt.Options{Timeout: time.Second})
+}
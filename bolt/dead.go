@@ -0,0 +1,161 @@
+package bolt
+
+import (
+	"context"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+// GetDeadTasks returns the tasks of the queue which failed all their attempts, the last failed first.
+func (b *BDB) GetDeadTasks(ctx context.Context, qname string, offset int, limit int) (int64, []*task.Message, error) {
+	logger.Info("Fetching dead tasks", "queue", qname, "offset", offset, "limit", limit)
+	var total int64
+	var tasks []*task.Message
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		q := lookupQueue(tx, qname)
+		if q == nil {
+			return nil
+		}
+		total = int64(q.failed.Stats().KeyN)
+		c := q.failed.Cursor()
+		skipped := 0
+		for _, value := c.Last(); value != nil && len(tasks) < limit; _, value = c.Prev() {
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			msg, err := b.message(tx, string(value))
+			if err != nil {
+				return err
+			}
+			tasks = append(tasks, msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return total, tasks, nil
+}
+
+// deadTaskIDs returns the IDs of the tasks of the queue which failed all their attempts, the last failed first.
+func deadTaskIDs(tx *bbolt.Tx, qname string) []string {
+	q := lookupQueue(tx, qname)
+	if q == nil {
+		return nil
+	}
+	var ids []string
+	c := q.failed.Cursor()
+	for _, value := c.Last(); value != nil; _, value = c.Prev() {
+		ids = append(ids, string(value))
+	}
+	return ids
+}
+
+// RequeueDeadTask moves a task of the queue which failed all its attempts back to the pending queue.
+// Its attempts and error are reset, so it gets all its attempts again.
+func (b *BDB) RequeueDeadTask(ctx context.Context, qname string, taskID string) (*task.Message, error) {
+	var msg *task.Message
+	err := b.update(func(t *txn) error {
+		var err error
+		msg, err = t.requeueDeadTask(qname, taskID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (t *txn) requeueDeadTask(qname string, taskID string) (*task.Message, error) {
+	r, err := getRecord(t.Tx, taskID)
+	if err != nil || r.Queue != qname || r.Status != statusFailed {
+		return nil, base.ErrorNotInDeadLetterQueue
+	}
+	msg, err := t.b.message(t.Tx, taskID)
+	if err != nil {
+		return nil, base.ErrorNotInDeadLetterQueue
+	}
+
+	msg.Status = task.StatusPending
+	msg.NumRetries = 0
+	msg.NumHandBacks = 0
+	msg.Error = nil
+	msg.FailedAt = nil
+	msg.RetryAt = nil
+	encoded, err := t.b.encode(msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Bucket(bucketMessages).Put([]byte(taskID), encoded); err != nil {
+		return nil, err
+	}
+	q, err := t.queue(qname)
+	if err != nil {
+		return nil, err
+	}
+	r.RetryAt = 0
+	if err := t.setPending(q, taskID, r, false); err != nil {
+		return nil, err
+	}
+
+	if err := t.publishEvent(base.EventEnqueued, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// RequeueDeadTasks moves all tasks of the queue which failed all their attempts back to the pending queue.
+// It returns the number of requeued tasks.
+func (b *BDB) RequeueDeadTasks(ctx context.Context, qname string) (int64, error) {
+	var requeued int64
+	err := b.update(func(t *txn) error {
+		for _, id := range deadTaskIDs(t.Tx, qname) {
+			if _, err := t.requeueDeadTask(qname, id); err != nil {
+				logger.Warn("error requeueing dead task", "id", id, "error", err)
+				continue
+			}
+			requeued++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return requeued, nil
+}
+
+// PurgeDeadTasks deletes the tasks of the queue which failed all their attempts,
+// only the ones which failed more than olderThan ago if it is not 0.
+// It returns the number of deleted tasks.
+func (b *BDB) PurgeDeadTasks(ctx context.Context, qname string, olderThan time.Duration) (int64, error) {
+	var purged int64
+	err := b.update(func(t *txn) error {
+		var cutoff time.Time
+		if olderThan > 0 {
+			cutoff = t.now.Add(-olderThan)
+		}
+		for _, id := range deadTaskIDs(t.Tx, qname) {
+			msg, err := t.b.message(t.Tx, id)
+			if err != nil {
+				logger.Warn("error purging dead task", "id", id, "error", err)
+				continue
+			}
+			if !cutoff.IsZero() && (msg.FailedAt == nil || msg.FailedAt.After(cutoff)) {
+				continue
+			}
+			if err := t.removeTask(id); err != nil {
+				return err
+			}
+			purged++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
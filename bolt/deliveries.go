@@ -0,0 +1,71 @@
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"go.etcd.io/bbolt"
+)
+
+// maxDeliveries bounds the delivery log of a task, only the recent attempts are kept.
+const maxDeliveries = 100
+
+// RecordDelivery adds the attempt to deliver a callback to the delivery log of its task.
+// Task IDs are unique across the queues in bolt, so the log is kept by the task ID alone.
+func (b *BDB) RecordDelivery(ctx context.Context, qname string, delivery *base.Delivery) error {
+	encoded, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("cannot encode delivery: %v", err)
+	}
+	return b.update(func(t *txn) error {
+		log, err := t.Bucket(bucketDeliveries).CreateBucketIfNotExists([]byte(delivery.TaskID))
+		if err != nil {
+			return err
+		}
+		seq, err := log.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := log.Put(seqKey(seq), encoded); err != nil {
+			return err
+		}
+
+		if seq > maxDeliveries {
+			c := log.Cursor()
+			for key, _ := c.First(); key != nil && binary.BigEndian.Uint64(key) <= seq-maxDeliveries; key, _ = c.First() {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// GetDeliveries returns the delivery log of the callback of a task, the last attempt first.
+func (b *BDB) GetDeliveries(ctx context.Context, taskID string) ([]*base.Delivery, error) {
+	deliveries := make([]*base.Delivery, 0)
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		log := tx.Bucket(bucketDeliveries).Bucket([]byte(taskID))
+		if log == nil {
+			return nil
+		}
+		c := log.Cursor()
+		for _, value := c.Last(); value != nil; _, value = c.Prev() {
+			var delivery base.Delivery
+			if err := json.Unmarshal(value, &delivery); err != nil {
+				logger.Warn("error decoding delivery", "task_id", taskID, "error", err)
+				continue
+			}
+			deliveries = append(deliveries, &delivery)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,114 @@
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"go.etcd.io/bbolt"
+	"strconv"
+	"time"
+)
+
+// maxEvents bounds the number of events kept, only the recent events can be resumed.
+const maxEvents = 10000
+
+// readEventsCount is the maximum number of events returned by a single read.
+const readEventsCount = 100
+
+// publishEvent appends a state transition of the task to the events, they are published with the transaction.
+func (t *txn) publishEvent(eventType base.EventType, msg *task.Message) error {
+	events := t.Bucket(bucketEvents)
+	id, err := events.NextSequence()
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(base.TaskEvent{
+		ID:       strconv.FormatUint(id, 10),
+		Type:     eventType,
+		TaskID:   msg.ID,
+		Name:     msg.Name,
+		Queue:    msg.Queue,
+		Attempts: msg.NumRetries,
+		Error:    msg.Error,
+		Time:     t.now.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot encode task event: %v", err)
+	}
+	if err := events.Put(seqKey(id), encoded); err != nil {
+		return err
+	}
+
+	if id > maxEvents {
+		c := events.Cursor()
+		for key, _ := c.First(); key != nil && binary.BigEndian.Uint64(key) <= id-maxEvents; key, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+	}
+	t.published = true
+	return nil
+}
+
+// ReadEvents returns the events after the event with lastID, see LastEventID for reading the events from now on.
+// It waits up to block for new events and returns none if there are none by then.
+func (b *BDB) ReadEvents(ctx context.Context, lastID string, block time.Duration) ([]*base.TaskEvent, error) {
+	last, err := strconv.ParseUint(lastID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event id %s", lastID)
+	}
+
+	timer := time.NewTimer(block)
+	defer timer.Stop()
+	for {
+		// the channel is taken before reading, so events published in between wake up the wait
+		b.mu.Lock()
+		changed := b.eventsChanged
+		b.mu.Unlock()
+		events, err := b.eventsAfter(last)
+		if err != nil || len(events) > 0 {
+			return events, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			return nil, nil
+		case <-changed:
+		}
+	}
+}
+
+// eventsAfter returns the events after last, at most readEventsCount of them.
+func (b *BDB) eventsAfter(last uint64) ([]*base.TaskEvent, error) {
+	var events []*base.TaskEvent
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bucketEvents).Cursor()
+		for key, value := c.Seek(seqKey(last + 1)); key != nil && len(events) < readEventsCount; key, value = c.Next() {
+			var event base.TaskEvent
+			if err := json.Unmarshal(value, &event); err != nil {
+				logger.Warn("error decoding task event", "id", binary.BigEndian.Uint64(key), "error", err)
+				continue
+			}
+			events = append(events, &event)
+		}
+		return nil
+	})
+	return events, err
+}
+
+// LastEventID returns the ID of the last event, events published later are after it.
+func (b *BDB) LastEventID(ctx context.Context) (string, error) {
+	var id uint64
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		id = tx.Bucket(bucketEvents).Sequence()
+		return nil
+	})
+	return strconv.FormatUint(id, 10), err
+}
@@ -0,0 +1,120 @@
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+// leaderKey is the key of the leadership in the leader bucket.
+var leaderKey = []byte("scheduler")
+
+// leader holds the leadership, it expires unless it is renewed. The times are in unix milli sec.
+// It is kept when it expires, so the epoch keeps increasing with each election.
+type leader struct {
+	ID        string `json:"id"`
+	Epoch     int64  `json:"epoch"`
+	ElectedAt int64  `json:"elected_at"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// currentLeader returns the leadership which has not expired yet, nil if there is none, and the last epoch.
+func currentLeader(tx *bbolt.Tx, now time.Time) (*leader, int64, error) {
+	value := tx.Bucket(bucketLeader).Get(leaderKey)
+	if value == nil {
+		return nil, 0, nil
+	}
+	var l leader
+	if err := json.Unmarshal(value, &l); err != nil {
+		return nil, 0, fmt.Errorf("cannot decode leader: %v", err)
+	}
+	if l.ExpiresAt <= now.UnixMilli() {
+		return nil, l.Epoch, nil
+	}
+	return &l, l.Epoch, nil
+}
+
+func putLeader(tx *bbolt.Tx, l *leader) error {
+	encoded, err := json.Marshal(l)
+	if err != nil {
+		return fmt.Errorf("cannot encode leader: %v", err)
+	}
+	return tx.Bucket(bucketLeader).Put(leaderKey, encoded)
+}
+
+// isLeader checks the fencing token of a scheduler operation, so that a replica
+// which lost its leadership, e.g. while paused, cannot schedule next to the new leader.
+func (t *txn) isLeader(epoch int64) (bool, error) {
+	l, _, err := currentLeader(t.Tx, t.now)
+	if err != nil {
+		return false, err
+	}
+	return l != nil && l.Epoch == epoch, nil
+}
+
+// AcquireLeadership makes the replica the leader for ttl if there is no leader, or renews its leadership.
+// It returns the epoch of the leadership, the fencing token of the scheduler operations,
+// or base.ErrorNotLeader if another replica is the leader.
+func (b *BDB) AcquireLeadership(ctx context.Context, id string, ttl time.Duration) (int64, error) {
+	var epoch int64
+	err := b.update(func(t *txn) error {
+		l, last, err := currentLeader(t.Tx, t.now)
+		if err != nil {
+			return err
+		}
+		if l != nil && l.ID != id {
+			return base.ErrorNotLeader
+		}
+		if l == nil {
+			l = &leader{
+				ID:        id,
+				Epoch:     last + 1,
+				ElectedAt: t.now.UnixMilli(),
+			}
+		}
+		l.ExpiresAt = t.now.Add(ttl).UnixMilli()
+		epoch = l.Epoch
+		return putLeader(t.Tx, l)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return epoch, nil
+}
+
+// ReleaseLeadership gives up the leadership of the replica, so another one can take over without waiting for it to expire.
+func (b *BDB) ReleaseLeadership(ctx context.Context, id string) error {
+	return b.update(func(t *txn) error {
+		l, _, err := currentLeader(t.Tx, t.now)
+		if err != nil || l == nil || l.ID != id {
+			return err
+		}
+		l.ExpiresAt = 0
+		return putLeader(t.Tx, l)
+	})
+}
+
+// GetLeader returns the current leader or base.ErrorNoLeader if there is none.
+func (b *BDB) GetLeader(ctx context.Context) (*base.Leader, error) {
+	var l *leader
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		l, _, err = currentLeader(tx, b.clock.Now())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if l == nil {
+		return nil, base.ErrorNoLeader
+	}
+	return &base.Leader{
+		ID:        l.ID,
+		Epoch:     l.Epoch,
+		ElectedAt: time.UnixMilli(l.ElectedAt).UTC().Format(time.RFC3339),
+		ExpiresAt: time.UnixMilli(l.ExpiresAt).UTC().Format(time.RFC3339),
+	}, nil
+}
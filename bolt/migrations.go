@@ -0,0 +1,134 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"go.etcd.io/bbolt"
+)
+
+// migrationBatchSize is the number of tasks rewritten in a transaction during migrations.
+const migrationBatchSize = 500
+
+// Migrate creates the buckets which do not exist yet, it is run on startup.
+func (b *BDB) Migrate(ctx context.Context) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		buckets := [][]byte{
+			bucketTasks, bucketMessages, bucketCreated, bucketQueues, bucketDeliveries,
+			bucketEvents, bucketLeader, bucketWorkers, bucketLists,
+		}
+		for _, bucket := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return fmt.Errorf("error creating bucket %s: %w", bucket, err)
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateEncoding rewrites the messages of the tasks still stored in the legacy JSON encoding in the binary one.
+// Bolt never stored the legacy encoding, it is there for parity with the redis broker.
+func (b *BDB) MigrateEncoding(ctx context.Context, progress base.MigrationProgress) (int64, error) {
+	logger.Info("Migrating tasks to the binary encoding...")
+	migrated, err := b.rewriteMessages(ctx, progress, func(encoded string) (bool, error) {
+		return task.IsLegacyEncoding(encoded), nil
+	})
+	if err != nil {
+		return migrated, fmt.Errorf("error migrating encoding: %w", err)
+	}
+
+	logger.Info("Migrated tasks to the binary encoding", "count", migrated)
+	return migrated, nil
+}
+
+// RotateEncryption re-encrypts the payloads of the tasks which are not encrypted with the primary key of the keyring,
+// including the ones stored in plaintext. The keys rotated out can be removed from the keyring once it is done.
+// It reports its progress after each batch of tasks, returns the number of re-encrypted messages and can be run again safely.
+func (b *BDB) RotateEncryption(ctx context.Context, progress base.MigrationProgress) (int64, error) {
+	if b.keyring == nil {
+		return 0, base.ErrorNoEncryptionKeys
+	}
+
+	logger.Info("Re-encrypting tasks...", "key", b.keyring.PrimaryKeyID())
+	rotated, err := b.rewriteMessages(ctx, progress, func(encoded string) (bool, error) {
+		keyID, err := task.PayloadKeyID(encoded)
+		if err != nil {
+			return false, err
+		}
+		return keyID != b.keyring.PrimaryKeyID(), nil
+	})
+	if err != nil {
+		return rotated, fmt.Errorf("error rotating encryption: %w", err)
+	}
+
+	logger.Info("Re-encrypted tasks", "key", b.keyring.PrimaryKeyID(), "count", rotated)
+	return rotated, nil
+}
+
+// rewriteMessages decodes and encodes again the messages of the tasks for which rewrite returns true.
+// The tasks are rewritten in batches by ID, each in its own transaction, so the other transactions are not held up for long.
+func (b *BDB) rewriteMessages(ctx context.Context, progress base.MigrationProgress, rewrite func(encoded string) (bool, error)) (int64, error) {
+	var scanned, rewritten int64
+	var after []byte
+	for {
+		if err := ctx.Err(); err != nil {
+			return rewritten, err
+		}
+
+		var batch int
+		rewrites := make(map[string][]byte)
+		err := b.update(func(t *txn) error {
+			messages := t.Bucket(bucketMessages)
+			c := messages.Cursor()
+			k, v := c.First()
+			if after != nil {
+				if k, v = c.Seek(after); bytes.Equal(k, after) {
+					k, v = c.Next()
+				}
+			}
+			for ; k != nil && batch < migrationBatchSize; k, v = c.Next() {
+				batch++
+				after = append(after[:0], k...)
+				id := string(k)
+				ok, err := rewrite(string(v))
+				if err != nil {
+					logger.Warn("error reading task, skipping it", "id", id, "error", err)
+					continue
+				}
+				if !ok {
+					continue
+				}
+				msg, err := task.DecodeMessage(string(v), b.keyring)
+				if err != nil {
+					logger.Warn("error decoding task, skipping it", "id", id, "error", err)
+					continue
+				}
+				encoded, err := b.encode(msg)
+				if err != nil {
+					return err
+				}
+				rewrites[id] = encoded
+			}
+
+			// the bucket cannot be changed while the cursor iterates it
+			for id, encoded := range rewrites {
+				if err := messages.Put([]byte(id), encoded); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return rewritten, err
+		}
+		if batch == 0 {
+			return rewritten, nil
+		}
+		scanned += int64(batch)
+		rewritten += int64(len(rewrites))
+		progress(scanned, rewritten)
+	}
+}
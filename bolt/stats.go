@@ -0,0 +1,84 @@
+package bolt
+
+import (
+	"context"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+// GetAllQueueStats returns the stats of all queues, sorted by name.
+func (b *BDB) GetAllQueueStats(ctx context.Context) ([]*base.QueueStats, error) {
+	var stats []*base.QueueStats
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		qnames, err := queueNames(tx)
+		if err != nil {
+			return err
+		}
+		stats = make([]*base.QueueStats, 0, len(qnames))
+		for _, qname := range qnames {
+			stats = append(stats, b.queueStats(tx, qname, lookupQueue(tx, qname)))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// GetQueueStats returns the number of tasks of the queue in each state, the age of its oldest pending task and its disk usage.
+func (b *BDB) GetQueueStats(ctx context.Context, qname string) (*base.QueueStats, error) {
+	var stats *base.QueueStats
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		q := lookupQueue(tx, qname)
+		if q == nil {
+			return base.ErrorQueueNotFound
+		}
+		stats = b.queueStats(tx, qname, q)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (b *BDB) queueStats(tx *bbolt.Tx, qname string, q *queue) *base.QueueStats {
+	stats := &base.QueueStats{
+		Queue:            qname,
+		Total:            int64(q.created.Stats().KeyN),
+		Pending:          int64(q.pending.Stats().KeyN),
+		Running:          int64(q.lease.Stats().KeyN),
+		Retry:            int64(q.retry.Stats().KeyN),
+		Failed:           int64(q.failed.Stats().KeyN),
+		Scheduled:        int64(q.scheduled.Stats().KeyN),
+		Cron:             int64(q.cron.Stats().KeyN),
+		Delayed:          int64(q.delayed.Stats().KeyN),
+		OldestPendingAge: (0 * time.Second).String(),
+	}
+
+	// the disk usage is approximated by the size of the encoded tasks
+	messages := tx.Bucket(bucketMessages)
+	c := q.created.Cursor()
+	for key, _ := c.First(); key != nil; key, _ = c.Next() {
+		stats.MemoryUsage += int64(len(messages.Get([]byte(idOf(key)))))
+	}
+
+	// retried and reclaimed tasks are pushed to the lowest position of the pending list, so it is not sorted by pending since
+	var oldest int64
+	c = q.pending.Cursor()
+	for _, value := c.First(); value != nil; _, value = c.Next() {
+		if r, err := getRecord(tx, string(value)); err == nil && (oldest == 0 || r.PendingSince < oldest) {
+			oldest = r.PendingSince
+		}
+	}
+	if oldest > 0 {
+		age := b.clock.Now().Sub(time.UnixMilli(oldest)).Truncate(time.Second)
+		if age > 0 {
+			stats.OldestPendingAge = age.String()
+		}
+	}
+
+	return stats
+}
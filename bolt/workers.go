@@ -0,0 +1,69 @@
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"time"
+)
+
+// workerEntry holds the info of a worker until it expires, in unix milli sec.
+type workerEntry struct {
+	Info      *base.WorkerInfo `json:"info"`
+	ExpiresAt int64            `json:"expires_at"`
+}
+
+// WriteWorkerInfo registers the worker until ttl passes without it being written again.
+func (b *BDB) WriteWorkerInfo(ctx context.Context, info *base.WorkerInfo, ttl time.Duration) error {
+	return b.update(func(t *txn) error {
+		encoded, err := json.Marshal(workerEntry{Info: info, ExpiresAt: t.now.Add(ttl).UnixMilli()})
+		if err != nil {
+			return fmt.Errorf("cannot encode worker info: %v", err)
+		}
+		return t.Bucket(bucketWorkers).Put([]byte(info.ID), encoded)
+	})
+}
+
+// ClearWorkerInfo unregisters the worker.
+func (b *BDB) ClearWorkerInfo(ctx context.Context, id string) error {
+	return b.update(func(t *txn) error {
+		return t.Bucket(bucketWorkers).Delete([]byte(id))
+	})
+}
+
+// GetWorkers returns the workers which are alive, sorted by ID.
+// The workers which stopped without unregistering are dropped once their info expires.
+func (b *BDB) GetWorkers(ctx context.Context) ([]*base.WorkerInfo, error) {
+	workers := make([]*base.WorkerInfo, 0)
+	err := b.update(func(t *txn) error {
+		var expired [][]byte
+		err := t.Bucket(bucketWorkers).ForEach(func(k, v []byte) error {
+			var w workerEntry
+			if err := json.Unmarshal(v, &w); err != nil {
+				logger.Warn("error decoding worker info", "id", string(k), "error", err)
+				return nil
+			}
+			if w.ExpiresAt < t.now.UnixMilli() {
+				expired = append(expired, k)
+				return nil
+			}
+			workers = append(workers, w.Info)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range expired {
+			if err := t.Bucket(bucketWorkers).Delete(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return workers, nil
+}
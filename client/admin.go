@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// ListWorkers returns the workers which are alive.
+func (c *Client) ListWorkers(ctx context.Context) ([]*Worker, error) {
+	var resp struct {
+		Workers []*Worker `json:"workers"`
+	}
+	if err := c.get(ctx, "workers", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Workers, nil
+}
+
+// ListProcessors returns the processors registered in the manager, the task names which can be submitted.
+func (c *Client) ListProcessors(ctx context.Context) ([]*Processor, error) {
+	var resp struct {
+		Processors []*Processor `json:"processors"`
+	}
+	if err := c.get(ctx, "processors", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Processors, nil
+}
+
+// GetLeader returns the manager replica which runs the scheduler.
+func (c *Client) GetLeader(ctx context.Context) (*Leader, error) {
+	var leader Leader
+	if err := c.get(ctx, "admin/leader", nil, &leader); err != nil {
+		return nil, err
+	}
+	return &leader, nil
+}
+
+// MigrateEncoding starts rewriting the tasks stored in the legacy JSON encoding in the binary one in the background.
+// Its progress can be polled with GetMigration.
+func (c *Client) MigrateEncoding(ctx context.Context) (*Migration, error) {
+	var migration Migration
+	if err := c.do(ctx, http.MethodPost, "admin/migrations/encoding", nil, nil, &migration); err != nil {
+		return nil, err
+	}
+	return &migration, nil
+}
+
+// RotateEncryption starts re-encrypting the payloads which are not encrypted with the primary key in the background.
+// Its progress can be polled with GetMigration.
+func (c *Client) RotateEncryption(ctx context.Context) (*Migration, error) {
+	var migration Migration
+	if err := c.do(ctx, http.MethodPost, "admin/migrations/encryption", nil, nil, &migration); err != nil {
+		return nil, err
+	}
+	return &migration, nil
+}
+
+// GetMigration returns the last migration of the kind, MigrationEncoding or MigrationEncryption,
+// started by the manager replica.
+func (c *Client) GetMigration(ctx context.Context, kind string) (*Migration, error) {
+	var migration Migration
+	if err := c.get(ctx, "admin/migrations/"+url.PathEscape(kind), nil, &migration); err != nil {
+		return nil, err
+	}
+	return &migration, nil
+}
@@ -0,0 +1,195 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 200 * time.Millisecond
+)
+
+// APIError is returned when the manager responds with an error.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Message is the error message of the response.
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("error received: code: %d, message: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is the response to a request for a task, queue or leader which does not exist.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// Client calls the RESTful API of a gotama manager. It is safe for concurrent use.
+type Client struct {
+	baseURL      string
+	httpClient   *http.Client
+	token        string
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+// New returns a client of the manager at baseURL, e.g. http://localhost:8080.
+// The requests have no timeout of their own, their context bounds them.
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL:      strings.TrimRight(baseURL, "/") + "/api/v1/",
+		httpClient:   &http.Client{},
+		maxRetries:   defaultMaxRetries,
+		retryBackoff: defaultRetryBackoff,
+	}
+}
+
+// WithHTTPClient sets the HTTP client the requests are sent with, e.g. to configure TLS or a proxy.
+func (c *Client) WithHTTPClient(httpClient *http.Client) *Client {
+	c.httpClient = httpClient
+	return c
+}
+
+// WithToken sets the token the requests are authenticated with, sent as a bearer token in the Authorization header.
+func (c *Client) WithToken(token string) *Client {
+	c.token = token
+	return c
+}
+
+// WithRetries sets how many times a request is retried when it fails with a network error or a 5xx or 429 response,
+// waiting backoff before the first retry and doubling it before each of the next ones. 0 disables the retries.
+// Creating a task is never retried, a retry could enqueue it twice.
+func (c *Client) WithRetries(maxRetries int, backoff time.Duration) *Client {
+	c.maxRetries = maxRetries
+	c.retryBackoff = backoff
+	return c
+}
+
+// get sends a GET request and decodes the data of the response into out.
+func (c *Client) get(ctx context.Context, path string, params url.Values, out any) error {
+	return c.do(ctx, http.MethodGet, path, params, nil, out)
+}
+
+// do sends a request with body encoded as JSON, if it is not nil, and decodes the data of the response into out,
+// if it is not nil. The requests which can be sent again safely are retried.
+func (c *Client) do(ctx context.Context, method string, path string, params url.Values, body any, out any) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	retries := c.maxRetries
+	if method == http.MethodPost && path == "tasks" {
+		retries = 0
+	}
+	backoff := c.retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := c.send(ctx, method, path, params, payload, out)
+		if err == nil || attempt >= retries || !retryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// retryable reports whether a request which failed with err can succeed if it is sent again.
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError || apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// send sends a request once and decodes the data of the response into out.
+func (c *Client) send(ctx context.Context, method string, path string, params url.Values, payload []byte, out any) error {
+	req, err := c.newRequest(ctx, method, path, params, payload)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var response struct {
+		Data  json.RawMessage     `json:"data"`
+		Error *base.ResponseError `json:"error"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		if resp.StatusCode >= http.StatusBadRequest {
+			return &APIError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		}
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+	if response.Error != nil {
+		return &APIError{StatusCode: response.Error.Code, Message: response.Error.Message}
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return &APIError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(response.Data, out)
+}
+
+// newRequest returns a request to the API with payload as its JSON body, if it is not nil.
+func (c *Client) newRequest(ctx context.Context, method string, path string, params url.Values, payload []byte) (*http.Request, error) {
+	apiURL := c.baseURL + path
+	if len(params) > 0 {
+		apiURL = apiURL + "?" + params.Encode()
+	}
+
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, apiURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	return req, nil
+}
+
+// countResponse is the response of the requests which affect many tasks.
+type countResponse struct {
+	Count int64 `json:"count"`
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"github.com/engpetarmarinov/gotama/internal/config"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/manager"
+	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	"github.com/engpetarmarinov/gotama/memory"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	broker := memory.NewBroker(timeutil.NewRealClock())
+	var auth atomic.Value
+	routes := manager.NewRouter().RegisterRoutes(config.NewConfig(), broker)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth.Store(r.Header.Get("Authorization"))
+		routes.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	c := New(server.URL).WithToken("t0k3n")
+
+	req := NewEmailTask(EmailPayload{To: "gotama@gotama.io", Title: "Hi", Body: "Take a break!"})
+	req.Queue = "critical"
+	created, err := c.CreateTask(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Name != NameEmail || created.Queue != "critical" {
+		t.Errorf("unexpected task %+v", created)
+	}
+	if auth.Load() != "Bearer t0k3n" {
+		t.Errorf("expected the token in the Authorization header, got %v", auth.Load())
+	}
+
+	got, err := c.GetTask(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != created.ID {
+		t.Errorf("expected task %s, got %s", created.ID, got.ID)
+	}
+
+	list, err := c.ListTasks(ctx, ListOptions{Queue: "critical", Status: "pending"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Total != 1 || len(list.Tasks) != 1 {
+		t.Errorf("expected 1 pending task, got %d", list.Total)
+	}
+
+	update := NewEmailTask(EmailPayload{To: "gotama@gotama.io", Title: "Hello", Body: "Take a break!"})
+	update.Type = "recurring"
+	update.Period = "45m"
+	updated, err := c.UpdateTask(ctx, created.ID, update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Period != "45m0s" {
+		t.Errorf("expected the period to be updated, got %s", updated.Period)
+	}
+
+	queue, err := c.GetQueue(ctx, "critical")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queue.Total != 1 {
+		t.Errorf("expected 1 task in the queue, got %d", queue.Total)
+	}
+
+	procs, err := c.ListProcessors(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(procs) == 0 {
+		t.Error("expected the registered processors")
+	}
+
+	if _, err := c.DeleteTask(ctx, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetTask(ctx, created.ID); !IsNotFound(err) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+
+	if _, err := c.CreateTask(ctx, &TaskRequest{Name: "nope", Type: "once", Payload: []byte(`{}`)}); err == nil {
+		t.Error("expected an error creating a task without a processor")
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"data":{"workers":[]}}`))
+	}))
+	defer server.Close()
+
+	c := New(server.URL).WithRetries(2, time.Millisecond)
+	if _, err := c.ListWorkers(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 calls, got %d", calls.Load())
+	}
+
+	calls.Store(0)
+	if _, err := c.CreateTask(context.Background(), NewSMSTask(SMSPayload{Phone: "+359", Text: "hi"})); err == nil {
+		t.Error("expected an error creating a task")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected creating a task not to be retried, got %d calls", calls.Load())
+	}
+}
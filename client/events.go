@@ -0,0 +1,63 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// WatchEvents streams the task events which pass the filter, calling fn with each of them
+// until ctx is done or the stream ends. It returns nil once ctx is done.
+func (c *Client) WatchEvents(ctx context.Context, filter EventFilter, fn func(*Event)) error {
+	params := url.Values{}
+	if filter.TaskID != "" {
+		params.Set("task_id", filter.TaskID)
+	}
+	if filter.Name != "" {
+		params.Set("name", filter.Name)
+	}
+	if filter.Queue != "" {
+		params.Set("queue", filter.Queue)
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, "events", params, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &APIError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+
+		var event Event
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return err
+		}
+		fn(&event)
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"encoding/json"
+	"github.com/engpetarmarinov/gotama/internal/task"
+)
+
+const (
+	NameEmail = "EMAIL"
+	NameSMS   = "SMS"
+	NameSlack = "SLACK"
+)
+
+// EmailPayload is the payload of the EMAIL tasks.
+type EmailPayload struct {
+	To    string `json:"to"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+// SMSPayload is the payload of the SMS tasks.
+type SMSPayload struct {
+	Phone string `json:"phone"`
+	Text  string `json:"text"`
+}
+
+// SlackPayload is the payload of the SLACK tasks.
+type SlackPayload struct {
+	Channel string `json:"channel"`
+	Text    string `json:"text"`
+}
+
+// NewTask returns the request of a task with the name which runs once, with payload encoded as JSON.
+// The fields of the request can be set before it is submitted, e.g. its Queue or Type.
+func NewTask(name string, payload any) (*TaskRequest, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &TaskRequest{
+		Name:    name,
+		Type:    task.TypeOnce.String(),
+		Payload: encoded,
+	}, nil
+}
+
+// NewEmailTask returns the request of a task which sends an email once.
+func NewEmailTask(payload EmailPayload) *TaskRequest {
+	req, _ := NewTask(NameEmail, payload)
+	return req
+}
+
+// NewSMSTask returns the request of a task which sends a text message once.
+func NewSMSTask(payload SMSPayload) *TaskRequest {
+	req, _ := NewTask(NameSMS, payload)
+	return req
+}
+
+// NewSlackTask returns the request of a task which posts a message to a Slack channel once.
+func NewSlackTask(payload SlackPayload) *TaskRequest {
+	req, _ := NewTask(NameSlack, payload)
+	return req
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ListQueues returns the stats of all queues, sorted by name.
+func (c *Client) ListQueues(ctx context.Context) ([]*QueueStats, error) {
+	var resp struct {
+		Queues []*QueueStats `json:"queues"`
+	}
+	if err := c.get(ctx, "queues", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Queues, nil
+}
+
+// GetQueue returns the stats of the queue.
+func (c *Client) GetQueue(ctx context.Context, queue string) (*QueueStats, error) {
+	var stats QueueStats
+	if err := c.get(ctx, "queues/"+url.PathEscape(queue), nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// ListDeadTasks returns a page of the tasks of the queue which failed all their attempts, the last failed first.
+// A limit of 0 returns up to 100 tasks.
+func (c *Client) ListDeadTasks(ctx context.Context, queue string, offset int, limit int) (*TaskList, error) {
+	var list TaskList
+	if err := c.get(ctx, "queues/"+url.PathEscape(queue)+"/dead", pagination(offset, limit), &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// RequeueDeadTask moves a dead task of the queue back to the pending tasks, with its attempts and error reset.
+func (c *Client) RequeueDeadTask(ctx context.Context, queue string, id string) (*Task, error) {
+	var t Task
+	uri := "queues/" + url.PathEscape(queue) + "/dead/" + url.PathEscape(id) + "/requeue"
+	if err := c.do(ctx, http.MethodPost, uri, nil, nil, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// RequeueDeadTasks moves all dead tasks of the queue back to the pending tasks and returns their number.
+func (c *Client) RequeueDeadTasks(ctx context.Context, queue string) (int64, error) {
+	var resp countResponse
+	if err := c.do(ctx, http.MethodPost, "queues/"+url.PathEscape(queue)+"/dead/requeue", nil, nil, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Count, nil
+}
+
+// PurgeDeadTasks deletes the dead tasks of the queue, only the ones which failed more than olderThan ago
+// if it is not 0, and returns their number.
+func (c *Client) PurgeDeadTasks(ctx context.Context, queue string, olderThan time.Duration) (int64, error) {
+	var params url.Values
+	if olderThan > 0 {
+		params = url.Values{"older_than": []string{olderThan.String()}}
+	}
+
+	var resp countResponse
+	if err := c.do(ctx, http.MethodDelete, "queues/"+url.PathEscape(queue)+"/dead", params, nil, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Count, nil
+}
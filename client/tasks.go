@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// CreateTask submits a new task.
+func (c *Client) CreateTask(ctx context.Context, req *TaskRequest) (*Task, error) {
+	var t Task
+	if err := c.do(ctx, http.MethodPost, "tasks", nil, req, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetTask returns the task with the ID.
+func (c *Client) GetTask(ctx context.Context, id string) (*Task, error) {
+	var t Task
+	if err := c.get(ctx, "tasks/"+url.PathEscape(id), nil, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ListTasks returns a page of the tasks, newest first.
+func (c *Client) ListTasks(ctx context.Context, opts ListOptions) (*TaskList, error) {
+	params := pagination(opts.Offset, opts.Limit)
+	if opts.Queue != "" {
+		params.Set("queue", opts.Queue)
+	}
+	if opts.Status != "" {
+		params.Set("status", opts.Status)
+	}
+
+	var list TaskList
+	if err := c.get(ctx, "tasks", params, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// UpdateTask replaces the task with the ID by req. The queue of a task cannot be changed.
+func (c *Client) UpdateTask(ctx context.Context, id string, req *TaskRequest) (*Task, error) {
+	var t Task
+	if err := c.do(ctx, http.MethodPut, "tasks/"+url.PathEscape(id), nil, req, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// DeleteTask removes the task with the ID and returns it.
+func (c *Client) DeleteTask(ctx context.Context, id string) (*Task, error) {
+	var t Task
+	if err := c.do(ctx, http.MethodDelete, "tasks/"+url.PathEscape(id), nil, nil, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetTaskSchedule returns the next n runs of the cron task with the ID, 5 if n is 0.
+func (c *Client) GetTaskSchedule(ctx context.Context, id string, n int) (*Schedule, error) {
+	var params url.Values
+	if n > 0 {
+		params = url.Values{"n": []string{strconv.Itoa(n)}}
+	}
+
+	var schedule Schedule
+	if err := c.get(ctx, "tasks/"+url.PathEscape(id)+"/schedule", params, &schedule); err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// GetTaskDeliveries returns the attempts to post the task with the ID to its callback URL.
+func (c *Client) GetTaskDeliveries(ctx context.Context, id string) ([]*Delivery, error) {
+	var resp struct {
+		Deliveries []*Delivery `json:"deliveries"`
+	}
+	if err := c.get(ctx, "tasks/"+url.PathEscape(id)+"/deliveries", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Deliveries, nil
+}
+
+// pagination returns the query parameters of a page of tasks, the defaults of the manager are used for zero values.
+func pagination(offset int, limit int) url.Values {
+	params := url.Values{}
+	if offset > 0 {
+		params.Set("offset", strconv.Itoa(offset))
+	}
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+	return params
+}
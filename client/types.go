@@ -0,0 +1,89 @@
+package client
+
+import (
+	"encoding/json"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/task"
+)
+
+// TaskRequest is the task to create or the new version of a task to update.
+type TaskRequest = task.Request
+
+// RetryPolicy is how a failed task is retried, every field is optional.
+type RetryPolicy = task.RetryPolicyRequest
+
+// Task is a task as the manager returns it.
+type Task = task.Response
+
+// Schedule is the next runs of a cron task.
+type Schedule = task.ScheduleResponse
+
+// Delivery is an attempt to post a task to its callback URL.
+type Delivery = base.Delivery
+
+// QueueStats is the number of tasks of a queue in each state.
+type QueueStats = base.QueueStats
+
+// Worker is a worker which is alive, with the queues it consumes and the tasks it is processing.
+type Worker = base.WorkerInfo
+
+// Leader is the manager replica which runs the scheduler.
+type Leader = base.Leader
+
+// Migration is a rewrite of the stored tasks running in the background of a manager replica.
+type Migration = base.Migration
+
+const (
+	MigrationEncoding   = base.MigrationEncoding
+	MigrationEncryption = base.MigrationEncryption
+)
+
+const (
+	MigrationRunning   = base.MigrationRunning
+	MigrationSucceeded = base.MigrationSucceeded
+	MigrationFailed    = base.MigrationFailed
+)
+
+// Event is a state transition of a task.
+type Event = base.TaskEvent
+
+// EventType is the state transition of a task an event reports.
+type EventType = base.EventType
+
+const (
+	EventEnqueued  = base.EventEnqueued
+	EventStarted   = base.EventStarted
+	EventRetrying  = base.EventRetrying
+	EventSucceeded = base.EventSucceeded
+	EventFailed    = base.EventFailed
+	EventDeleted   = base.EventDeleted
+)
+
+// EventFilter selects the events of a task ID, name or queue, an empty field matches any.
+type EventFilter = base.EventFilter
+
+// Processor describes the processor of the tasks with a name.
+type Processor struct {
+	Name          string          `json:"name"`
+	Description   string          `json:"description,omitempty"`
+	PayloadSchema json.RawMessage `json:"payload_schema,omitempty"`
+	RetryPolicy   *RetryPolicy    `json:"retry_policy,omitempty"`
+}
+
+// TaskList is a page of tasks and the total number of tasks.
+type TaskList struct {
+	Total int64   `json:"total"`
+	Tasks []*Task `json:"tasks"`
+}
+
+// ListOptions selects a page of the tasks, newest first.
+type ListOptions struct {
+	// Queue returns only the tasks of the queue.
+	Queue string
+	// Status returns only the tasks with the status, e.g. pending or failed. It requires a Queue.
+	Status string
+	// Offset of the first task to return.
+	Offset int
+	// Limit is the maximum number of tasks to return, 100 if 0.
+	Limit int
+}
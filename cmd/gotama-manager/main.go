@@ -1,13 +1,13 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	brk "github.com/engpetarmarinov/gotama/internal/broker"
 	"github.com/engpetarmarinov/gotama/internal/config"
 	"github.com/engpetarmarinov/gotama/internal/logger"
 	"github.com/engpetarmarinov/gotama/internal/manager"
 	"github.com/engpetarmarinov/gotama/internal/timeutil"
-	rdb "github.com/engpetarmarinov/gotama/redis"
-	"github.com/redis/go-redis/v9"
+	"github.com/engpetarmarinov/gotama/internal/tracing"
 	"os"
 	"os/signal"
 	"syscall"
@@ -16,17 +16,19 @@ import (
 func main() {
 	cfg := config.NewConfig()
 	logger.Init(logger.NewConfigOpt().WithLevel(cfg.GetLogLevel()))
-	rco := rdb.ClientOpt{
-		Addr:     fmt.Sprintf("%s:%s", cfg.Get("REDIS_ADDR"), cfg.Get("REDIS_PORT")),
-		Password: cfg.Get("REDIS_PASSWORD"),
+	shutdownTracing, err := tracing.Init(context.Background(), cfg, "gotama-manager")
+	if err != nil {
+		panic(err.Error())
 	}
-
-	client, ok := rco.NewRedisClient().(redis.UniversalClient)
-	if !ok {
-		panic("panic casting to redis client")
+	broker, err := brk.NewBrokerFromConfig(context.Background(), cfg, timeutil.NewRealClock())
+	if err != nil {
+		panic(err.Error())
+	}
+	err = broker.Migrate(context.Background())
+	if err != nil {
+		panic(err.Error())
 	}
 
-	broker := rdb.NewRDB(client, timeutil.NewRealClock())
 	mgr := manager.NewManager(broker, cfg)
 	mgr.Run()
 
@@ -35,7 +37,7 @@ func main() {
 
 	<-shutdown
 	logger.Info("graceful shutdown...")
-	err := mgr.Shutdown()
+	err = mgr.Shutdown()
 	if err != nil {
 		logger.Error("error shutting down manager", "error", err)
 	}
@@ -44,4 +46,9 @@ func main() {
 	if err != nil {
 		logger.Error("error closing broker", "error", err)
 	}
+
+	err = shutdownTracing(context.Background())
+	if err != nil {
+		logger.Error("error shutting down tracing", "error", err)
+	}
 }
@@ -1,13 +1,13 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	brk "github.com/engpetarmarinov/gotama/internal/broker"
 	"github.com/engpetarmarinov/gotama/internal/config"
 	"github.com/engpetarmarinov/gotama/internal/logger"
 	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	"github.com/engpetarmarinov/gotama/internal/tracing"
 	"github.com/engpetarmarinov/gotama/internal/worker"
-	rdb "github.com/engpetarmarinov/gotama/redis"
-	"github.com/redis/go-redis/v9"
 	"os"
 	"os/signal"
 	"syscall"
@@ -16,18 +16,15 @@ import (
 func main() {
 	cfg := config.NewConfig()
 	logger.Init(logger.NewConfigOpt().WithLevel(cfg.GetLogLevel()))
-	rco := rdb.ClientOpt{
-		Addr:     fmt.Sprintf("%s:%s", cfg.Get("REDIS_ADDR"), cfg.Get("REDIS_PORT")),
-		Password: cfg.Get("REDIS_PASSWORD"),
-	}
-
-	client, ok := rco.NewRedisClient().(redis.UniversalClient)
-	if !ok {
-		panic("panic casting to redis client")
+	shutdownTracing, err := tracing.Init(context.Background(), cfg, "gotama-worker")
+	if err != nil {
+		panic(err.Error())
 	}
-
 	clock := timeutil.NewRealClock()
-	broker := rdb.NewRDB(client, clock)
+	broker, err := brk.NewBrokerFromConfig(context.Background(), cfg, clock)
+	if err != nil {
+		panic(err.Error())
+	}
 	wrk := worker.NewWorker(cfg, broker, clock)
 	wrk.Run()
 
@@ -36,7 +33,7 @@ func main() {
 
 	<-shutdown
 	logger.Info("graceful shutdown...")
-	err := wrk.Shutdown()
+	err = wrk.Shutdown()
 	if err != nil {
 		logger.Error("error shutting down worker", "error", err)
 	}
@@ -45,4 +42,9 @@ func main() {
 	if err != nil {
 		logger.Error("error closing broker", "error", err)
 	}
+
+	err = shutdownTracing(context.Background())
+	if err != nil {
+		logger.Error("error shutting down tracing", "error", err)
+	}
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"github.com/engpetarmarinov/gotama/bolt"
+	"github.com/engpetarmarinov/gotama/internal/config"
+	"github.com/engpetarmarinov/gotama/internal/encryption"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/manager"
+	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	"github.com/engpetarmarinov/gotama/internal/tracing"
+	"github.com/engpetarmarinov/gotama/internal/worker"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// defaultDBPath is the file the tasks are stored in if GOTAMA_DB_PATH is not set.
+const defaultDBPath = "gotama.db"
+
+// main runs the manager, the scheduler and the worker in a single process,
+// with the tasks stored in a bbolt file instead of redis.
+func main() {
+	cfg := config.NewConfig()
+	logger.Init(logger.NewConfigOpt().WithLevel(cfg.GetLogLevel()))
+	shutdownTracing, err := tracing.Init(context.Background(), cfg, "gotama")
+	if err != nil {
+		panic(err.Error())
+	}
+
+	path := cfg.Get("GOTAMA_DB_PATH")
+	if path == "" {
+		path = defaultDBPath
+	}
+	db, err := bolt.ClientOpt{Path: path}.Open()
+	if err != nil {
+		panic(err.Error())
+	}
+
+	clock := timeutil.NewRealClock()
+	keyring, err := encryption.NewKeyringFromConfig(cfg)
+	if err != nil {
+		panic(err.Error())
+	}
+	broker := bolt.NewBDB(db, clock).WithKeyring(keyring)
+	err = broker.Migrate(context.Background())
+	if err != nil {
+		panic(err.Error())
+	}
+
+	mgr := manager.NewManager(broker, cfg)
+	mgr.Run()
+	wrk := worker.NewWorker(cfg, broker, clock)
+	wrk.Run()
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, syscall.SIGTERM, syscall.SIGINT)
+
+	<-shutdown
+	logger.Info("graceful shutdown...")
+	// the worker stops first, so it hands back the interrupted tasks before the broker is closed
+	err = wrk.Shutdown()
+	if err != nil {
+		logger.Error("error shutting down worker", "error", err)
+	}
+
+	err = mgr.Shutdown()
+	if err != nil {
+		logger.Error("error shutting down manager", "error", err)
+	}
+
+	err = broker.Close()
+	if err != nil {
+		logger.Error("error closing broker", "error", err)
+	}
+
+	err = shutdownTracing(context.Background())
+	if err != nil {
+		logger.Error("error shutting down tracing", "error", err)
+	}
+}
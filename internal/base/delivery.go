@@ -0,0 +1,41 @@
+package base
+
+// Delivery represents an attempt to post a task to its callback URL.
+// swagger:model delivery
+type Delivery struct {
+	// The ID of the webhook task which delivers the callback
+	// example: 5b1f7e4c-3a0e-4c55-9d3e-6f2a1c8b7d90
+	ID string `json:"id"`
+
+	// The ID of the task the callback is about
+	// example: aac6ed79-4fc6-4b14-8614-889a8236ba54
+	TaskID string `json:"task_id"`
+
+	// The outcome of the task (e.g., task.succeeded, task.dead)
+	// example: task.succeeded
+	Event string `json:"event"`
+
+	// The URL the task was posted to
+	// example: https://example.com/hooks/gotama
+	URL string `json:"url"`
+
+	// The number of the attempt to deliver the callback
+	// example: 1
+	Attempt int `json:"attempt"`
+
+	// The HTTP status code of the response, 0 if there was none
+	// example: 200
+	StatusCode int `json:"status_code"`
+
+	// The error of the attempt, if it failed
+	// example: unexpected status code 503
+	Error *string `json:"error,omitempty"`
+
+	// How long the attempt took in milliseconds
+	// example: 120
+	DurationMs int64 `json:"duration_ms"`
+
+	// When the attempt was made
+	// example: 2023-05-19T14:28:23Z
+	Time string `json:"time"`
+}
@@ -3,3 +3,17 @@ package base
 import "errors"
 
 var ErrorNoTasksInQueue = errors.New("no tasks in queue")
+
+var ErrorLeaseExpired = errors.New("task lease expired")
+
+var ErrorNotInDeadLetterQueue = errors.New("task is not in the dead letter queue")
+
+var ErrorQueueNotFound = errors.New("queue not found")
+
+var ErrorNotLeader = errors.New("not the leader")
+
+var ErrorNoLeader = errors.New("no leader elected")
+
+var ErrorNoEncryptionKeys = errors.New("no encryption keys configured")
+
+var ErrorUnknownTaskName = errors.New("task name unknown")
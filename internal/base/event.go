@@ -0,0 +1,65 @@
+package base
+
+import "strings"
+
+// EventType is the state transition of a task an event reports.
+type EventType string
+
+const (
+	EventEnqueued  EventType = "enqueued"
+	EventStarted   EventType = "started"
+	EventRetrying  EventType = "retrying"
+	EventSucceeded EventType = "succeeded"
+	EventFailed    EventType = "failed"
+	EventDeleted   EventType = "deleted"
+)
+
+// TaskEvent represents a state transition of a task.
+// swagger:model taskEvent
+type TaskEvent struct {
+	// The ID of the event, events can be resumed after it
+	// example: 1718882753000-0
+	ID string `json:"id"`
+
+	// The state transition (e.g., enqueued, started, retrying, succeeded, failed, deleted)
+	// example: succeeded
+	Type EventType `json:"type"`
+
+	// The ID of the task
+	// example: aac6ed79-4fc6-4b14-8614-889a8236ba54
+	TaskID string `json:"task_id"`
+
+	// The name of the task
+	// example: EMAIL
+	Name string `json:"name"`
+
+	// The queue of the task
+	// example: default
+	Queue string `json:"queue"`
+
+	// The attempts to process the task so far
+	// example: 1
+	Attempts int `json:"attempts"`
+
+	// The error of the last attempt, if it failed
+	// example: error sending an email
+	Error *string `json:"error,omitempty"`
+
+	// When the transition happened
+	// example: 2023-05-19T14:28:23Z
+	Time string `json:"time"`
+}
+
+// EventFilter selects the events of a task ID, name or queue, an empty field matches any.
+type EventFilter struct {
+	TaskID string
+	Name   string
+	Queue  string
+}
+
+// Match reports whether the event passes the filter.
+func (f EventFilter) Match(e *TaskEvent) bool {
+	return (f.TaskID == "" || strings.EqualFold(f.TaskID, e.TaskID)) &&
+		(f.Name == "" || strings.EqualFold(f.Name, e.Name)) &&
+		(f.Queue == "" || f.Queue == e.Queue)
+}
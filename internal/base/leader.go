@@ -0,0 +1,21 @@
+package base
+
+// Leader represents the manager replica which runs the scheduler.
+// swagger:model leader
+type Leader struct {
+	// The ID of the manager replica
+	// example: gotama-manager-7f9c-1-5e3c4995
+	ID string `json:"id"`
+
+	// The fencing token of the leadership, incremented on each election
+	// example: 3
+	Epoch int64 `json:"epoch"`
+
+	// When the replica was elected
+	// example: 2023-05-19T14:28:23Z
+	ElectedAt string `json:"elected_at"`
+
+	// When the leadership expires unless it is renewed
+	// example: 2023-05-19T14:28:33Z
+	ExpiresAt string `json:"expires_at"`
+}
@@ -0,0 +1,47 @@
+package base
+
+// Migration represents a rewrite of the stored tasks which runs in the background of a manager replica.
+// swagger:model migration
+type Migration struct {
+	// The kind of the migration, encoding or encryption
+	// example: encryption
+	Kind string `json:"kind"`
+
+	// The state of the migration, running, succeeded or failed
+	// example: running
+	Status string `json:"status"`
+
+	// The number of tasks checked so far
+	// example: 1500
+	Scanned int64 `json:"scanned"`
+
+	// The number of tasks rewritten so far
+	// example: 120
+	Count int64 `json:"count"`
+
+	// The error the migration failed with, if it failed
+	// example: error rotating encryption: context canceled
+	Error *string `json:"error,omitempty"`
+
+	// When the migration started
+	// example: 2023-05-19T14:28:23Z
+	StartedAt string `json:"started_at"`
+
+	// When the migration finished, if it did
+	// example: 2023-05-19T14:30:41Z
+	FinishedAt string `json:"finished_at,omitempty"`
+}
+
+const (
+	MigrationEncoding   = "encoding"
+	MigrationEncryption = "encryption"
+)
+
+const (
+	MigrationRunning   = "running"
+	MigrationSucceeded = "succeeded"
+	MigrationFailed    = "failed"
+)
+
+// MigrationProgress is called by a migration with the number of tasks it checked and rewrote so far.
+type MigrationProgress func(scanned int64, rewritten int64)
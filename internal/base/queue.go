@@ -0,0 +1,49 @@
+package base
+
+// QueueStats represents the state of a queue.
+// swagger:model queueStats
+type QueueStats struct {
+	// The name of the queue
+	// example: default
+	Queue string `json:"queue"`
+
+	// The number of all tasks of the queue
+	// example: 120
+	Total int64 `json:"total"`
+
+	// The number of tasks waiting to be processed
+	// example: 12
+	Pending int64 `json:"pending"`
+
+	// The number of tasks being processed
+	// example: 8
+	Running int64 `json:"running"`
+
+	// The number of failed tasks waiting for a retry
+	// example: 2
+	Retry int64 `json:"retry"`
+
+	// The number of tasks which failed all their attempts, the dead letter queue
+	// example: 1
+	Failed int64 `json:"failed"`
+
+	// The number of recurring tasks with a period
+	// example: 5
+	Scheduled int64 `json:"scheduled"`
+
+	// The number of recurring tasks with a cron schedule
+	// example: 3
+	Cron int64 `json:"cron"`
+
+	// The number of tasks delayed until their process_at time
+	// example: 4
+	Delayed int64 `json:"delayed"`
+
+	// How long the oldest pending task has been waiting to be processed, 0s if there are no pending tasks
+	// example: 1m30s
+	OldestPendingAge string `json:"oldest_pending_age"`
+
+	// The approximate memory used by the queue in bytes
+	// example: 204800
+	MemoryUsage int64 `json:"memory_usage"`
+}
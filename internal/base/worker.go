@@ -0,0 +1,41 @@
+package base
+
+// WorkerInfo represents a running worker process.
+// swagger:model workerInfo
+type WorkerInfo struct {
+	// The ID of the worker
+	// example: worker-1-5e3c4995
+	ID string `json:"id"`
+
+	// The host the worker runs on
+	// example: worker-1
+	Hostname string `json:"hostname"`
+
+	// The process ID of the worker
+	// example: 1
+	PID int `json:"pid"`
+
+	// The number of goroutines processing tasks
+	// example: 8
+	Concurrency int `json:"concurrency"`
+
+	// The queues the worker consumes
+	// example: ["critical", "default", "low"]
+	Queues []string `json:"queues"`
+
+	// The names of the tasks the worker has processors for
+	// example: ["EMAIL", "SMS", "SLACK"]
+	Processors []string `json:"processors"`
+
+	// The IDs of the tasks being processed
+	// example: ["aac6ed79-4fc6-4b14-8614-889a8236ba54"]
+	Tasks []string `json:"tasks"`
+
+	// When the worker was started
+	// example: 2023-05-19T14:28:23Z
+	StartedAt string `json:"started_at"`
+
+	// When the worker last reported it is alive
+	// example: 2023-05-19T14:45:20Z
+	LastHeartbeat string `json:"last_heartbeat"`
+}
@@ -0,0 +1,130 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/config"
+	"github.com/engpetarmarinov/gotama/internal/encryption"
+	"github.com/engpetarmarinov/gotama/internal/manager"
+	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	"github.com/engpetarmarinov/gotama/internal/worker"
+	"github.com/engpetarmarinov/gotama/postgres"
+	rdb "github.com/engpetarmarinov/gotama/redis"
+	"github.com/redis/go-redis/v9"
+)
+
+// Broker stores the tasks for both the manager and the workers.
+type Broker interface {
+	manager.Broker
+	worker.Broker
+	// Migrate prepares the storage of the broker for this version, it is run by the manager on startup.
+	Migrate(ctx context.Context) error
+	Close() error
+}
+
+// options of the broker returned by New.
+type options struct {
+	redis       *rdb.ClientOpt
+	redisClient redis.UniversalClient
+	postgresURL string
+	keyring     *encryption.Keyring
+	clock       timeutil.Clock
+}
+
+// Option configures the broker returned by New.
+type Option func(*options)
+
+// WithRedis stores the tasks in the redis server of opt, the default is a redis server at localhost:6379.
+func WithRedis(opt rdb.ClientOpt) Option {
+	return func(o *options) {
+		o.redis = &opt
+		o.postgresURL = ""
+	}
+}
+
+// WithRedisClient stores the tasks with an existing redis client, which is closed with the broker.
+func WithRedisClient(client redis.UniversalClient) Option {
+	return func(o *options) {
+		o.redisClient = client
+		o.postgresURL = ""
+	}
+}
+
+// WithPostgres stores the tasks in the postgres database of url.
+func WithPostgres(url string) Option {
+	return func(o *options) {
+		o.postgresURL = url
+		o.redis = nil
+		o.redisClient = nil
+	}
+}
+
+// WithKeyring encrypts the payloads of the tasks with the keyring.
+func WithKeyring(keyring *encryption.Keyring) Option {
+	return func(o *options) {
+		o.keyring = keyring
+	}
+}
+
+// WithClock sets the clock of the broker, the real one by default.
+func WithClock(clock timeutil.Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// New returns the broker configured by opts, redis at localhost:6379 if no storage is set.
+func New(ctx context.Context, opts ...Option) (Broker, error) {
+	o := &options{
+		clock: timeutil.NewRealClock(),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.postgresURL != "" {
+		pco := postgres.ClientOpt{URL: o.postgresURL}
+		pool, err := pco.NewPool(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("invalid postgres url: %w", err)
+		}
+		return postgres.NewPDB(pool, o.clock).WithKeyring(o.keyring), nil
+	}
+
+	client := o.redisClient
+	if client == nil {
+		rco := rdb.ClientOpt{Addr: "localhost:6379"}
+		if o.redis != nil {
+			rco = *o.redis
+		}
+		client = rco.NewRedisClient()
+	}
+	return rdb.NewRDB(client, o.clock).WithKeyring(o.keyring), nil
+}
+
+// NewBrokerFromConfig returns the broker of the BROKER config, redis (the default) or postgres.
+// Redis is configured with REDIS_ADDR, REDIS_PORT and REDIS_PASSWORD, and postgres with POSTGRES_URL.
+// The payloads are encrypted with the keys of ENCRYPTION_KEYS if any.
+func NewBrokerFromConfig(ctx context.Context, cfg config.API, clock timeutil.Clock) (Broker, error) {
+	keyring, err := encryption.NewKeyringFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	opts := []Option{WithKeyring(keyring), WithClock(clock)}
+
+	switch cfg.Get("BROKER") {
+	case "", "redis":
+		opts = append(opts, WithRedis(rdb.ClientOpt{
+			Addr:     fmt.Sprintf("%s:%s", cfg.Get("REDIS_ADDR"), cfg.Get("REDIS_PORT")),
+			Password: cfg.Get("REDIS_PASSWORD"),
+		}))
+	case "postgres":
+		if cfg.Get("POSTGRES_URL") == "" {
+			return nil, fmt.Errorf("POSTGRES_URL is required by BROKER postgres")
+		}
+		opts = append(opts, WithPostgres(cfg.Get("POSTGRES_URL")))
+	default:
+		return nil, fmt.Errorf("invalid BROKER %s, expected redis or postgres", cfg.Get("BROKER"))
+	}
+	return New(ctx, opts...)
+}
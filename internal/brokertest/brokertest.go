@@ -0,0 +1,512 @@
+// Package brokertest is the conformance suite of the brokers. Each broker runs it from its tests,
+// so that all of them move the tasks through the same states.
+package brokertest
+
+import (
+	"context"
+	"errors"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	"testing"
+	"time"
+)
+
+// Broker is the part of a broker the suite exercises.
+type Broker interface {
+	EnqueueTask(ctx context.Context, msg *task.Message) error
+	DequeueTask(ctx context.Context, lease time.Duration, qnames ...string) (*task.Message, error)
+	UpdateTask(ctx context.Context, msg *task.Message) error
+	ExtendLease(ctx context.Context, msg *task.Message, lease time.Duration) error
+	MarkTaskAsComplete(ctx context.Context, msg *task.Message) error
+	RequeueTaskRetry(ctx context.Context, msg *task.Message) error
+	RequeueTaskPending(ctx context.Context, msg *task.Message) error
+	RequeueTaskFailed(ctx context.Context, msg *task.Message) error
+	GetTask(ctx context.Context, taskID string) (*task.Message, error)
+	GetAllTasks(ctx context.Context, qname string, status string, offset int, limit int) (int64, []*task.Message, error)
+	RemoveTask(ctx context.Context, taskID string) error
+	GetDeadTasks(ctx context.Context, qname string, offset int, limit int) (int64, []*task.Message, error)
+	RequeueDeadTask(ctx context.Context, qname string, taskID string) (*task.Message, error)
+	GetQueueStats(ctx context.Context, qname string) (*base.QueueStats, error)
+	EnqueueScheduledTasks(ctx context.Context, epoch int64) error
+	ReclaimExpiredLeases(ctx context.Context, lease time.Duration, epoch int64) error
+	AcquireLeadership(ctx context.Context, id string, ttl time.Duration) (int64, error)
+	LastEventID(ctx context.Context) (string, error)
+	ReadEvents(ctx context.Context, lastID string, block time.Duration) ([]*base.TaskEvent, error)
+}
+
+// Factory returns an empty broker which tells the time by clock, or skips the test if the broker is not available.
+type Factory func(t *testing.T, clock timeutil.Clock) Broker
+
+// NewMessage returns the task of req, with an email payload if it has none.
+func NewMessage(t *testing.T, req *task.Request) *task.Message {
+	t.Helper()
+	if req.Payload == nil {
+		req.Payload = []byte(`{"to":"gotama@gotama.io"}`)
+	}
+	msg, err := task.NewMessageFromRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// Leadership makes manager-1 the leader and returns the epoch of its leadership.
+func Leadership(t *testing.T, b Broker) int64 {
+	t.Helper()
+	epoch, err := b.AcquireLeadership(context.Background(), "manager-1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return epoch
+}
+
+// Run runs the conformance suite against the brokers of newBroker.
+func Run(t *testing.T, newBroker Factory) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	tests := []struct {
+		name string
+		test func(t *testing.T, newBroker Factory)
+	}{
+		{"TaskTransitions", testTaskTransitions},
+		{"DoubleComplete", testDoubleComplete},
+		{"RequeueDeadTask", testRequeueDeadTask},
+		{"ReclaimExpiredLeases", testReclaimExpiredLeases},
+		{"ReclaimPoisonPill", testReclaimPoisonPill},
+		{"StaleLease", testStaleLease},
+		{"TaskStatuses", testTaskStatuses},
+		{"OldestPendingAge", testOldestPendingAge},
+		{"LeadershipFencing", testLeadershipFencing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newBroker)
+		})
+	}
+}
+
+// newClock returns a simulated clock at the real time, the process time of a delayed task is relative to it,
+// so the clock is advanced a second past it.
+func newClock() *timeutil.SimulatedClock {
+	return timeutil.NewSimulatedClock(time.Now())
+}
+
+func dequeue(t *testing.T, b Broker, want *task.Message) *task.Message {
+	t.Helper()
+	running, err := b.DequeueTask(context.Background(), time.Minute, task.QueueDefault)
+	if err != nil || running.ID != want.ID {
+		t.Fatalf("expected to dequeue %s, got %v, %v", want.ID, running, err)
+	}
+	return running
+}
+
+func assertNoTasks(t *testing.T, b Broker) {
+	t.Helper()
+	if _, err := b.DequeueTask(context.Background(), time.Minute, task.QueueDefault); !errors.Is(err, base.ErrorNoTasksInQueue) {
+		t.Fatalf("expected no tasks in queue, got %v", err)
+	}
+}
+
+func assertStatus(t *testing.T, b Broker, id string, want task.Status) {
+	t.Helper()
+	msg, err := b.GetTask(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != want {
+		t.Errorf("expected task %s to be %s, got %s", id, want, msg.Status)
+	}
+}
+
+func testTaskTransitions(t *testing.T, newBroker Factory) {
+	ctx := context.Background()
+	clock := newClock()
+	b := newBroker(t, clock)
+	epoch := Leadership(t, b)
+
+	msg := NewMessage(t, &task.Request{Name: "email", Type: "once"})
+	if err := b.EnqueueTask(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.EnqueueTask(ctx, msg); err == nil {
+		t.Error("expected an error enqueueing the same task twice")
+	}
+
+	running := dequeue(t, b, msg)
+	assertNoTasks(t, b)
+
+	// a failed attempt waits for its retry time
+	retryAt := clock.Now().Add(10 * time.Second)
+	running.RetryAt = &retryAt
+	running.NumRetries = 1
+	if err := b.UpdateTask(ctx, running); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.RequeueTaskRetry(ctx, running); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.EnqueueScheduledTasks(ctx, epoch); err != nil {
+		t.Fatal(err)
+	}
+	assertNoTasks(t, b)
+	clock.AdvanceTime(10 * time.Second)
+	if err := b.EnqueueScheduledTasks(ctx, epoch); err != nil {
+		t.Fatal(err)
+	}
+	running = dequeue(t, b, msg)
+	if running.NumRetries != 1 {
+		t.Errorf("expected the retried task to keep its attempts, got %d", running.NumRetries)
+	}
+
+	if err := b.MarkTaskAsComplete(ctx, running); err != nil {
+		t.Fatal(err)
+	}
+	if total, tasks, err := b.GetAllTasks(ctx, task.QueueDefault, "succeeded", 0, 10); err != nil || total != 1 || tasks[0].ID != msg.ID {
+		t.Errorf("expected the task to have succeeded, got %d, %v, %v", total, tasks, err)
+	}
+	stats, err := b.GetQueueStats(ctx, task.QueueDefault)
+	if err != nil || stats.Total != 1 || stats.Pending != 0 || stats.Running != 0 || stats.Retry != 0 || stats.Failed != 0 {
+		t.Errorf("unexpected stats %+v, %v", stats, err)
+	}
+
+	if err := b.RemoveTask(ctx, msg.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.GetTask(ctx, msg.ID); err == nil {
+		t.Error("expected the task to be removed")
+	}
+	if total, _, err := b.GetAllTasks(ctx, "", "", 0, 10); err != nil || total != 0 {
+		t.Errorf("expected no tasks after removing the task, got %d, %v", total, err)
+	}
+}
+
+func testDoubleComplete(t *testing.T, newBroker Factory) {
+	ctx := context.Background()
+	b := newBroker(t, newClock())
+
+	msg := NewMessage(t, &task.Request{Name: "email", Type: "once"})
+	if err := b.EnqueueTask(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	running := dequeue(t, b, msg)
+	if err := b.MarkTaskAsComplete(ctx, running); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.MarkTaskAsComplete(ctx, running); err == nil {
+		t.Error("expected an error completing a task which is not running")
+	}
+	if total, _, err := b.GetAllTasks(ctx, task.QueueDefault, "succeeded", 0, 10); err != nil || total != 1 {
+		t.Errorf("expected the task to have succeeded once, got %d, %v", total, err)
+	}
+}
+
+func testRequeueDeadTask(t *testing.T, newBroker Factory) {
+	ctx := context.Background()
+	b := newBroker(t, newClock())
+
+	msg := NewMessage(t, &task.Request{Name: "email", Type: "once"})
+	if err := b.EnqueueTask(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	running := dequeue(t, b, msg)
+
+	// the last attempt ends in the dead letter queue
+	running.NumRetries = 3
+	if err := b.UpdateTask(ctx, running); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.RequeueTaskFailed(ctx, running); err != nil {
+		t.Fatal(err)
+	}
+	total, dead, err := b.GetDeadTasks(ctx, task.QueueDefault, 0, 10)
+	if err != nil || total != 1 || len(dead) != 1 || dead[0].ID != msg.ID {
+		t.Fatalf("expected %s to be dead, got %d, %v, %v", msg.ID, total, dead, err)
+	}
+	assertStatus(t, b, msg.ID, task.StatusFailed)
+
+	requeued, err := b.RequeueDeadTask(ctx, task.QueueDefault, msg.ID)
+	if err != nil || requeued.NumRetries != 0 {
+		t.Fatalf("expected the dead task to be requeued with its attempts reset, got %v, %v", requeued, err)
+	}
+	if _, err := b.RequeueDeadTask(ctx, task.QueueDefault, msg.ID); !errors.Is(err, base.ErrorNotInDeadLetterQueue) {
+		t.Errorf("expected the requeued task not to be in the dead letter queue, got %v", err)
+	}
+	if total, _, err := b.GetDeadTasks(ctx, task.QueueDefault, 0, 10); err != nil || total != 0 {
+		t.Errorf("expected no dead tasks, got %d, %v", total, err)
+	}
+
+	running = dequeue(t, b, msg)
+	if running.NumRetries != 0 {
+		t.Errorf("expected the requeued task to start over, got %d attempts", running.NumRetries)
+	}
+	if err := b.MarkTaskAsComplete(ctx, running); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testReclaimExpiredLeases(t *testing.T, newBroker Factory) {
+	ctx := context.Background()
+	clock := newClock()
+	b := newBroker(t, clock)
+	epoch := Leadership(t, b)
+
+	msg := NewMessage(t, &task.Request{Name: "email", Type: "once"})
+	if err := b.EnqueueTask(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	running := dequeue(t, b, msg)
+
+	// a renewed lease outlives the original one
+	clock.AdvanceTime(50 * time.Second)
+	if err := b.ExtendLease(ctx, running, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	clock.AdvanceTime(50 * time.Second)
+	if err := b.ReclaimExpiredLeases(ctx, time.Minute, epoch); err != nil {
+		t.Fatal(err)
+	}
+	assertNoTasks(t, b)
+
+	// the worker died, its lease expires and the attempt fails, the scheduler retries the task right away
+	clock.AdvanceTime(time.Minute)
+	if err := b.ReclaimExpiredLeases(ctx, time.Minute, epoch); err != nil {
+		t.Fatal(err)
+	}
+	failed, err := b.GetTask(ctx, msg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed.Status != task.StatusFailed || failed.NumRetries != 1 || failed.Error == nil {
+		t.Errorf("expected the attempt of the reclaimed task to fail, got %s with %d retries and error %v", failed.Status, failed.NumRetries, failed.Error)
+	}
+	if err := b.ExtendLease(ctx, running, time.Minute); !errors.Is(err, base.ErrorLeaseExpired) {
+		t.Errorf("expected the lease of the reclaimed task to be expired, got %v", err)
+	}
+	if err := b.MarkTaskAsComplete(ctx, running); err == nil {
+		t.Error("expected the reclaimed task not to be running")
+	}
+
+	if err := b.EnqueueScheduledTasks(ctx, epoch); err != nil {
+		t.Fatal(err)
+	}
+	reclaimed := dequeue(t, b, msg)
+	if reclaimed.NumRetries != 1 {
+		t.Errorf("expected the reclaimed task to keep its failed attempt, got %d", reclaimed.NumRetries)
+	}
+	if err := b.MarkTaskAsComplete(ctx, reclaimed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testReclaimPoisonPill(t *testing.T, newBroker Factory) {
+	ctx := context.Background()
+	clock := newClock()
+	b := newBroker(t, clock)
+	epoch := Leadership(t, b)
+
+	msg := NewMessage(t, &task.Request{Name: "email", Type: "once", RetryPolicy: &task.RetryPolicyRequest{MaxAttempts: 2}})
+	if err := b.EnqueueTask(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// each attempt crashes the worker processing the task, until the task runs out of attempts
+	for attempt := 1; attempt <= 2; attempt++ {
+		if err := b.EnqueueScheduledTasks(ctx, epoch); err != nil {
+			t.Fatal(err)
+		}
+		dequeue(t, b, msg)
+		clock.AdvanceTime(2 * time.Minute)
+		if err := b.ReclaimExpiredLeases(ctx, time.Minute, epoch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.EnqueueScheduledTasks(ctx, epoch); err != nil {
+		t.Fatal(err)
+	}
+	assertNoTasks(t, b)
+	total, dead, err := b.GetDeadTasks(ctx, task.QueueDefault, 0, 10)
+	if err != nil || total != 1 || dead[0].ID != msg.ID {
+		t.Fatalf("expected the task to be dead, got %d, %v, %v", total, dead, err)
+	}
+	if dead[0].NumRetries != 2 {
+		t.Errorf("expected the dead task to have failed 2 attempts, got %d", dead[0].NumRetries)
+	}
+}
+
+func testStaleLease(t *testing.T, newBroker Factory) {
+	ctx := context.Background()
+	clock := newClock()
+	b := newBroker(t, clock)
+	epoch := Leadership(t, b)
+
+	msg := NewMessage(t, &task.Request{Name: "email", Type: "once"})
+	if err := b.EnqueueTask(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	stale := dequeue(t, b, msg)
+
+	// the lease of the slow worker expires and the task is dequeued again by another worker
+	clock.AdvanceTime(2 * time.Minute)
+	if err := b.ReclaimExpiredLeases(ctx, time.Minute, epoch); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.EnqueueScheduledTasks(ctx, epoch); err != nil {
+		t.Fatal(err)
+	}
+	running := dequeue(t, b, msg)
+
+	// the slow worker finishes its attempt, but it cannot change the task the other worker is processing
+	if err := b.ExtendLease(ctx, stale, time.Minute); !errors.Is(err, base.ErrorLeaseExpired) {
+		t.Errorf("expected extending the stale lease to fail, got %v", err)
+	}
+	if err := b.UpdateTask(ctx, stale); !errors.Is(err, base.ErrorLeaseExpired) {
+		t.Errorf("expected updating the task of the stale lease to fail, got %v", err)
+	}
+	for name, requeue := range map[string]func(context.Context, *task.Message) error{
+		"complete": b.MarkTaskAsComplete,
+		"retry":    b.RequeueTaskRetry,
+		"pending":  b.RequeueTaskPending,
+		"failed":   b.RequeueTaskFailed,
+	} {
+		if err := requeue(ctx, stale); !errors.Is(err, base.ErrorLeaseExpired) {
+			t.Errorf("expected %s of the stale lease to fail, got %v", name, err)
+		}
+	}
+	assertStatus(t, b, msg.ID, task.StatusRunning)
+
+	if err := b.ExtendLease(ctx, running, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.UpdateTask(ctx, running); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.MarkTaskAsComplete(ctx, running); err != nil {
+		t.Fatal(err)
+	}
+	assertStatus(t, b, msg.ID, task.StatusSucceeded)
+}
+
+func testTaskStatuses(t *testing.T, newBroker Factory) {
+	ctx := context.Background()
+	clock := newClock()
+	b := newBroker(t, clock)
+	epoch := Leadership(t, b)
+
+	delayed := NewMessage(t, &task.Request{Name: "email", Type: "once", ProcessIn: "30s"})
+	cron := NewMessage(t, &task.Request{Name: "email", Type: "recurring", Cron: "@every 1m"})
+	for _, msg := range []*task.Message{delayed, cron} {
+		if err := b.EnqueueTask(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertStatus(t, b, delayed.ID, task.StatusDelayed)
+	assertStatus(t, b, cron.ID, task.StatusScheduled)
+
+	// a promoted task is pending, although its stored message was not updated, and it is enqueued again
+	lastID, err := b.LastEventID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.AdvanceTime(31 * time.Second)
+	if err := b.EnqueueScheduledTasks(ctx, epoch); err != nil {
+		t.Fatal(err)
+	}
+	assertStatus(t, b, delayed.ID, task.StatusPending)
+	assertStatus(t, b, cron.ID, task.StatusScheduled)
+	events, err := b.ReadEvents(ctx, lastID, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Type != base.EventEnqueued || events[0].TaskID != delayed.ID {
+		t.Errorf("expected the delayed task to be enqueued, got %v", events)
+	}
+
+	clock.AdvanceTime(30 * time.Second)
+	if err := b.EnqueueScheduledTasks(ctx, epoch); err != nil {
+		t.Fatal(err)
+	}
+	assertStatus(t, b, cron.ID, task.StatusPending)
+}
+
+func testOldestPendingAge(t *testing.T, newBroker Factory) {
+	ctx := context.Background()
+	clock := newClock()
+	b := newBroker(t, clock)
+	epoch := Leadership(t, b)
+
+	retried := NewMessage(t, &task.Request{Name: "email", Type: "once"})
+	if err := b.EnqueueTask(ctx, retried); err != nil {
+		t.Fatal(err)
+	}
+	clock.AdvanceTime(10 * time.Second)
+	waiting := NewMessage(t, &task.Request{Name: "email", Type: "once"})
+	if err := b.EnqueueTask(ctx, waiting); err != nil {
+		t.Fatal(err)
+	}
+
+	// the retried task is dequeued before the waiting one, although it became pending again after it
+	running := dequeue(t, b, retried)
+	retryAt := clock.Now().Add(time.Minute)
+	running.RetryAt = &retryAt
+	running.NumRetries = 1
+	if err := b.UpdateTask(ctx, running); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.RequeueTaskRetry(ctx, running); err != nil {
+		t.Fatal(err)
+	}
+	clock.AdvanceTime(time.Minute)
+	if err := b.EnqueueScheduledTasks(ctx, epoch); err != nil {
+		t.Fatal(err)
+	}
+	assertStatus(t, b, retried.ID, task.StatusPending)
+
+	stats, err := b.GetQueueStats(ctx, task.QueueDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Pending != 2 || stats.OldestPendingAge != "1m0s" {
+		t.Errorf("expected the waiting task to be the oldest pending one, got %d pending, oldest for %s", stats.Pending, stats.OldestPendingAge)
+	}
+
+	dequeue(t, b, retried)
+	dequeue(t, b, waiting)
+	if stats, err := b.GetQueueStats(ctx, task.QueueDefault); err != nil || stats.OldestPendingAge != "0s" {
+		t.Errorf("expected no pending tasks, got %+v, %v", stats, err)
+	}
+}
+
+func testLeadershipFencing(t *testing.T, newBroker Factory) {
+	ctx := context.Background()
+	clock := newClock()
+	b := newBroker(t, clock)
+
+	epoch, err := b.AcquireLeadership(ctx, "manager-1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.AcquireLeadership(ctx, "manager-2", time.Minute); !errors.Is(err, base.ErrorNotLeader) {
+		t.Errorf("expected manager-2 not to be the leader, got %v", err)
+	}
+	renewed, err := b.AcquireLeadership(ctx, "manager-1", time.Minute)
+	if err != nil || renewed != epoch {
+		t.Errorf("expected the renewal to keep epoch %d, got %d, %v", epoch, renewed, err)
+	}
+
+	// the leadership of manager-1 expires, the scheduler operations of its epoch are fenced off
+	clock.AdvanceTime(2 * time.Minute)
+	next, err := b.AcquireLeadership(ctx, "manager-2", time.Minute)
+	if err != nil || next <= epoch {
+		t.Fatalf("expected manager-2 to take over after epoch %d, got %d, %v", epoch, next, err)
+	}
+	if err := b.EnqueueScheduledTasks(ctx, epoch); !errors.Is(err, base.ErrorNotLeader) {
+		t.Errorf("expected the stale epoch to be fenced off, got %v", err)
+	}
+	if err := b.ReclaimExpiredLeases(ctx, time.Minute, epoch); !errors.Is(err, base.ErrorNotLeader) {
+		t.Errorf("expected the stale epoch to be fenced off, got %v", err)
+	}
+	if err := b.EnqueueScheduledTasks(ctx, next); err != nil {
+		t.Error(err)
+	}
+}
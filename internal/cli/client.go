@@ -1,115 +1,176 @@
 package cli
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
+	"errors"
+	"github.com/engpetarmarinov/gotama/client"
 	"github.com/engpetarmarinov/gotama/internal/base"
 	"github.com/engpetarmarinov/gotama/internal/task"
-	"io"
-	"net/http"
-	"net/url"
-	"strconv"
+	"os"
 	"time"
 )
 
-var (
-	client = &http.Client{
-		Timeout: 5 * time.Second,
-	}
+const (
+	defaultURL = "http://localhost:8080"
+	// requestTimeout bounds the requests, except for the event stream
+	requestTimeout = 5 * time.Second
+	// migrationPollInterval is how often the progress of a migration running in the manager is polled
+	migrationPollInterval = time.Second
 )
 
-// TODO: move this to config file, gotama-cli config to generate one?
-const baseUrl = "http://localhost:8080/api/v1/"
+// api is the client of the manager at GOTAMA_API_URL, authenticated with GOTAMA_API_TOKEN if it is set.
+var api = newClient()
 
-func get(uri string, params url.Values) (*base.Response, error) {
-	apiURL := uri
-	if params != nil {
-		apiURL = apiURL + "?" + params.Encode()
+func newClient() *client.Client {
+	url := os.Getenv("GOTAMA_API_URL")
+	if url == "" {
+		url = defaultURL
 	}
+	return client.New(url).WithToken(os.Getenv("GOTAMA_API_TOKEN"))
+}
 
-	req, err := http.NewRequest("GET", apiURL, nil)
+func GetTasks(queue string, status string, offset int, limit int) ([]task.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	list, err := api.ListTasks(ctx, client.ListOptions{Queue: queue, Status: status, Offset: offset, Limit: limit})
 	if err != nil {
 		return nil, err
 	}
+	return values(list.Tasks), nil
+}
 
-	resp, err := client.Do(req)
+func GetTask(id string) ([]task.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	t, err := api.GetTask(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	return []task.Response{*t}, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+func GetTaskSchedule(id string, n int) (*task.ScheduleResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return api.GetTaskSchedule(ctx, id, n)
+}
 
-	var response base.Response
-	err = json.Unmarshal(body, &response)
+func GetDeadTasks(queue string, offset int, limit int) ([]task.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	list, err := api.ListDeadTasks(ctx, queue, offset, limit)
 	if err != nil {
 		return nil, err
 	}
-
-	return &response, nil
+	return values(list.Tasks), nil
 }
 
-func GetTasks(offset int, limit int) ([]task.Response, error) {
-	uri := fmt.Sprintf("%stasks", baseUrl)
-
-	params := url.Values{"offset": []string{strconv.Itoa(offset)}, "limit": []string{strconv.Itoa(limit)}}
-	rsp, err := get(uri, params)
+func RequeueDeadTask(queue string, id string) ([]task.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	t, err := api.RequeueDeadTask(ctx, queue, id)
 	if err != nil {
 		return nil, err
 	}
+	return []task.Response{*t}, nil
+}
 
-	if rsp.Error != nil {
-		return nil, fmt.Errorf("error received: code: %d, message: %s", rsp.Error.Code, rsp.Error.Message)
-	}
+func RequeueDeadTasks(queue string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return api.RequeueDeadTasks(ctx, queue)
+}
 
-	data, ok := rsp.Data.(map[string]any)
-	if !ok {
-		return nil, err
+func PurgeDeadTasks(queue string, olderThan string) (int64, error) {
+	var d time.Duration
+	if olderThan != "" {
+		var err error
+		d, err = time.ParseDuration(olderThan)
+		if err != nil || d <= 0 {
+			return 0, errors.New("older-than has to be a positive duration, e.g. 24h")
+		}
 	}
 
-	var tasks []task.Response
-	tasksBytes, err := json.Marshal(data["tasks"])
-	if err != nil {
-		return nil, err
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return api.PurgeDeadTasks(ctx, queue, d)
+}
 
-	err = json.Unmarshal(tasksBytes, &tasks)
-	if err != nil {
-		return nil, err
-	}
+func MigrateEncoding(progress func(*client.Migration)) (*client.Migration, error) {
+	return runMigration(client.MigrationEncoding, api.MigrateEncoding, progress)
+}
 
-	return tasks, nil
+func RotateEncryption(progress func(*client.Migration)) (*client.Migration, error) {
+	return runMigration(client.MigrationEncryption, api.RotateEncryption, progress)
 }
 
-func GetTask(id string) ([]task.Response, error) {
-	uri := fmt.Sprintf("%stasks/%s", baseUrl, id)
-	rsp, err := get(uri, nil)
+// runMigration starts the migration in the manager and polls it until it finishes, passing its progress to progress.
+func runMigration(kind string, start func(context.Context) (*client.Migration, error), progress func(*client.Migration)) (*client.Migration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	migration, err := start(ctx)
+	cancel()
 	if err != nil {
 		return nil, err
 	}
 
-	if rsp.Error != nil {
-		return nil, fmt.Errorf("error received: code: %d, message: %s", rsp.Error.Code, rsp.Error.Message)
+	for migration.Status == client.MigrationRunning {
+		progress(migration)
+		time.Sleep(migrationPollInterval)
+
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		migration, err = api.GetMigration(ctx, kind)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if migration.Status == client.MigrationFailed && migration.Error != nil {
+		return migration, errors.New(*migration.Error)
 	}
+	return migration, nil
+}
 
-	data, ok := rsp.Data.(map[string]any)
-	if !ok {
+func GetQueues() ([]base.QueueStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	queues, err := api.ListQueues(ctx)
+	if err != nil {
 		return nil, err
 	}
+	return values(queues), nil
+}
 
-	var t task.Response
-	tasksBytes, err := json.Marshal(data)
+func GetQueue(queue string) ([]base.QueueStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	q, err := api.GetQueue(ctx, queue)
 	if err != nil {
 		return nil, err
 	}
+	return []base.QueueStats{*q}, nil
+}
 
-	err = json.Unmarshal(tasksBytes, &t)
+func GetWorkers() ([]base.WorkerInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	workers, err := api.ListWorkers(ctx)
 	if err != nil {
 		return nil, err
 	}
+	return values(workers), nil
+}
+
+// WatchEvents streams the task events which pass the filter, calling fn with each of them until the stream ends.
+func WatchEvents(filter base.EventFilter, fn func(*base.TaskEvent)) error {
+	// the stream is long-lived, so it is not bounded by requestTimeout
+	return api.WatchEvents(context.Background(), filter, fn)
+}
 
-	return []task.Response{t}, nil
+// values returns the values the pointers point to, for printing.
+func values[T any](ptrs []*T) []T {
+	vals := make([]T, 0, len(ptrs))
+	for _, p := range ptrs {
+		vals = append(vals, *p)
+	}
+	return vals
 }
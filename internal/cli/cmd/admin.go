@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/engpetarmarinov/gotama/client"
+	"github.com/engpetarmarinov/gotama/internal/cli"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var adminCmd = &cobra.Command{
+	Use:   "admin <command>",
+	Short: "Administer gotama",
+	Example: `
+$ gotama-cli admin migrate-encoding
+$ gotama-cli admin rotate-keys`,
+}
+
+var adminMigrateEncodingCmd = &cobra.Command{
+	Use:   "migrate-encoding",
+	Short: "Rewrite the tasks stored in the legacy JSON encoding",
+	Long: `
+	Rewrite the tasks still stored in the legacy JSON encoding in the binary one.
+
+	The tasks in the legacy encoding are still read, the migration only saves space.
+	It can be run again safely, the tasks in the binary encoding are left alone.
+
+	It runs in the background of the manager, the command polls it until it is done.`,
+	Example: `
+$ gotama-cli admin migrate-encoding`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		migration, err := cli.MigrateEncoding(printMigrationProgress)
+		if err != nil {
+			logger.Error("Error", "error", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("rewrote %d tasks\n", migration.Count)
+	},
+}
+
+var adminRotateKeysCmd = &cobra.Command{
+	Use:   "rotate-keys",
+	Short: "Re-encrypt the tasks with the primary encryption key",
+	Long: `
+	Re-encrypt the payloads of the tasks which are not encrypted with the primary key, the first one in ENCRYPTION_KEYS.
+
+	Put the new key first in ENCRYPTION_KEYS of the manager and the workers, keeping the old ones after it, and run it.
+	The old keys can be removed once it is done. It can be run again safely.
+
+	It runs in the background of the manager, the command polls it until it is done.`,
+	Example: `
+$ gotama-cli admin rotate-keys`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		migration, err := cli.RotateEncryption(printMigrationProgress)
+		if err != nil {
+			logger.Error("Error", "error", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("re-encrypted %d tasks\n", migration.Count)
+	},
+}
+
+func printMigrationProgress(migration *client.Migration) {
+	fmt.Printf("checked %d tasks, rewrote %d so far\n", migration.Scanned, migration.Count)
+}
+
+func init() {
+	rootCmd.AddCommand(adminCmd)
+	adminCmd.AddCommand(adminMigrateEncodingCmd)
+	adminCmd.AddCommand(adminRotateKeysCmd)
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/cli"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var dlqCmd = &cobra.Command{
+	Use:   "dlq <command> [flags]",
+	Short: "Manage the dead letter queue, the tasks which failed all their attempts",
+	Example: `
+$ gotama-cli dlq list --queue=default
+$ gotama-cli dlq requeue --queue=default --all
+$ gotama-cli dlq purge --queue=default --older-than=24h`,
+}
+
+var dlqListCmd = &cobra.Command{
+	Use:     "list [flags]",
+	Aliases: []string{"ls"},
+	Short:   "List dead tasks",
+	Long: `
+	List the dead tasks of a queue, the last failed first.
+
+	The --queue, --limit and --offset flags are optional.`,
+	Example: `
+$ gotama-cli dlq list
+$ gotama-cli dlq list --queue=critical --limit=10 --offset=0`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		queue, err := cmd.Flags().GetString("queue")
+		if err != nil {
+			logger.Error("Error", "error", err)
+			os.Exit(1)
+		}
+		limit, err := cmd.Flags().GetInt("limit")
+		if err != nil {
+			logger.Error("Error", "error", err)
+			os.Exit(1)
+		}
+		offset, err := cmd.Flags().GetInt("offset")
+		if err != nil {
+			logger.Error("Error", "error", err)
+			os.Exit(1)
+		}
+
+		tasks, err := cli.GetDeadTasks(queue, offset, limit)
+		if err != nil {
+			logger.Error("Error", "error", err)
+			os.Exit(1)
+		}
+
+		printTasksTable(tasks)
+	},
+}
+
+var dlqRequeueCmd = &cobra.Command{
+	Use:   "requeue [id] [flags]",
+	Short: "Requeue dead tasks",
+	Long: `
+	Move a dead task, or all dead tasks of a queue with --all, back to the pending tasks.
+	Their attempts and error are reset.`,
+	Example: `
+$ gotama-cli dlq requeue aac6ed79-4fc6-4b14-8614-889a8236ba54
+$ gotama-cli dlq requeue --queue=critical --all`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		queue, err := cmd.Flags().GetString("queue")
+		if err != nil {
+			logger.Error("Error", "error", err)
+			os.Exit(1)
+		}
+		all, err := cmd.Flags().GetBool("all")
+		if err != nil {
+			logger.Error("Error", "error", err)
+			os.Exit(1)
+		}
+		if all == (len(args) == 1) {
+			logger.Error("Error", "error", "provide either a task id or --all")
+			os.Exit(1)
+		}
+
+		if all {
+			count, err := cli.RequeueDeadTasks(queue)
+			if err != nil {
+				logger.Error("Error", "error", err)
+				os.Exit(1)
+			}
+			fmt.Printf("requeued %d tasks\n", count)
+			return
+		}
+
+		tasks, err := cli.RequeueDeadTask(queue, args[0])
+		if err != nil {
+			logger.Error("Error", "error", err)
+			os.Exit(1)
+		}
+
+		printTasksTable(tasks)
+	},
+}
+
+var dlqPurgeCmd = &cobra.Command{
+	Use:   "purge [flags]",
+	Short: "Delete dead tasks",
+	Long: `
+	Delete the dead tasks of a queue, only the ones which failed more than --older-than ago if provided.`,
+	Example: `
+$ gotama-cli dlq purge --queue=critical
+$ gotama-cli dlq purge --queue=critical --older-than=24h`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		queue, err := cmd.Flags().GetString("queue")
+		if err != nil {
+			logger.Error("Error", "error", err)
+			os.Exit(1)
+		}
+		olderThan, err := cmd.Flags().GetString("older-than")
+		if err != nil {
+			logger.Error("Error", "error", err)
+			os.Exit(1)
+		}
+
+		count, err := cli.PurgeDeadTasks(queue, olderThan)
+		if err != nil {
+			logger.Error("Error", "error", err)
+			os.Exit(1)
+		}
+		fmt.Printf("deleted %d tasks\n", count)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dlqCmd)
+	dlqCmd.PersistentFlags().String("queue", "default", "queue name")
+	dlqCmd.AddCommand(dlqListCmd)
+	dlqListCmd.Flags().Int("limit", 100, "page size")
+	dlqListCmd.Flags().Int("offset", 0, "offset size")
+	dlqCmd.AddCommand(dlqRequeueCmd)
+	dlqRequeueCmd.Flags().Bool("all", false, "requeue all dead tasks of the queue")
+	dlqCmd.AddCommand(dlqPurgeCmd)
+	dlqPurgeCmd.Flags().String("older-than", "", "delete only the tasks which failed more than this duration ago, e.g. 24h")
+}
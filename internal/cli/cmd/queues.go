@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/cli"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+var queuesCmd = &cobra.Command{
+	Use:   "queues [name]",
+	Short: "Show queue stats",
+	Long: `
+	Show the number of tasks of the queues in each state,
+	the age of their oldest pending task and their memory usage.`,
+	Example: `
+$ gotama-cli queues
+$ gotama-cli queues critical`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var queues []base.QueueStats
+		var err error
+		if len(args) == 0 {
+			queues, err = cli.GetQueues()
+		} else {
+			queues, err = cli.GetQueue(args[0])
+		}
+		if err != nil {
+			logger.Error("Error", "error", err)
+			os.Exit(1)
+		}
+
+		printQueuesTable(queues)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(queuesCmd)
+}
+
+func printQueuesTable(queues []base.QueueStats) {
+	printTable(
+		[]string{
+			"Queue",
+			"Total",
+			"Pending",
+			"Running",
+			"Retry",
+			"Failed",
+			"Scheduled",
+			"Cron",
+			"Delayed",
+			"OldestPendingAge",
+			"MemoryUsage",
+		},
+		func(w io.Writer, tmpl string) {
+			for _, q := range queues {
+				fmt.Fprintf(w, tmpl,
+					q.Queue,
+					q.Total,
+					q.Pending,
+					q.Running,
+					q.Retry,
+					q.Failed,
+					q.Scheduled,
+					q.Cron,
+					q.Delayed,
+					q.OldestPendingAge,
+					formatBytes(q.MemoryUsage),
+				)
+			}
+		},
+	)
+}
+
+// formatBytes formats a number of bytes in a human-readable unit, e.g. 1.5MB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
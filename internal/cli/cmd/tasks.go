@@ -24,12 +24,14 @@ var tasksListCmd = &cobra.Command{
 	Aliases: []string{"ls"},
 	Short:   "List tasks",
 	Long: `
-	List tasks.
+	List tasks, newest first.
 
-	The --limit and --offset flags are optional.`,
+	The --limit, --offset, --queue and --status flags are optional.
+	Filtering by --status requires a --queue.`,
 	Example: `
 $ gotama-cli tasks list
 $ gotama-cli tasks list --limit=10 --offset=0
+$ gotama-cli tasks list --queue=critical --status=failed
 $ gotama-cli tasks list aac6ed79-4fc6-4b14-8614-889a8236ba54`,
 	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
@@ -44,7 +46,17 @@ $ gotama-cli tasks list aac6ed79-4fc6-4b14-8614-889a8236ba54`,
 				logger.Error("Error", "error", err)
 				os.Exit(1)
 			}
-			listTasks(limit, offset)
+			queue, err := cmd.Flags().GetString("queue")
+			if err != nil {
+				logger.Error("Error", "error", err)
+				os.Exit(1)
+			}
+			status, err := cmd.Flags().GetString("status")
+			if err != nil {
+				logger.Error("Error", "error", err)
+				os.Exit(1)
+			}
+			listTasks(queue, status, limit, offset)
 		} else {
 			id := args[0]
 			listTask(id)
@@ -52,16 +64,73 @@ $ gotama-cli tasks list aac6ed79-4fc6-4b14-8614-889a8236ba54`,
 	},
 }
 
+var tasksScheduleCmd = &cobra.Command{
+	Use:   "schedule <id> [flags]",
+	Short: "Preview the next runs of a cron task",
+	Example: `
+$ gotama-cli tasks schedule aac6ed79-4fc6-4b14-8614-889a8236ba54
+$ gotama-cli tasks schedule aac6ed79-4fc6-4b14-8614-889a8236ba54 -n 10`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		n, err := cmd.Flags().GetInt("count")
+		if err != nil {
+			logger.Error("Error", "error", err)
+			os.Exit(1)
+		}
+		listTaskSchedule(args[0], n)
+	},
+}
+
+var tasksWatchCmd = &cobra.Command{
+	Use:   "watch [flags]",
+	Short: "Watch task events",
+	Long: `
+	Watch the state transitions of the tasks as they happen, until interrupted.
+
+	The --id, --name and --queue flags are optional.`,
+	Example: `
+$ gotama-cli tasks watch
+$ gotama-cli tasks watch --queue=critical
+$ gotama-cli tasks watch --id=aac6ed79-4fc6-4b14-8614-889a8236ba54`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := cmd.Flags().GetString("id")
+		if err != nil {
+			logger.Error("Error", "error", err)
+			os.Exit(1)
+		}
+		name, err := cmd.Flags().GetString("name")
+		if err != nil {
+			logger.Error("Error", "error", err)
+			os.Exit(1)
+		}
+		queue, err := cmd.Flags().GetString("queue")
+		if err != nil {
+			logger.Error("Error", "error", err)
+			os.Exit(1)
+		}
+		watchTasks(base.EventFilter{TaskID: id, Name: name, Queue: queue})
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(tasksCmd)
 	tasksCmd.AddCommand(tasksListCmd)
 	tasksListCmd.Flags().Int("limit", 100, "page size")
 	tasksListCmd.Flags().Int("offset", 0, "offset size")
+	tasksListCmd.Flags().String("queue", "", "queue name")
+	tasksListCmd.Flags().String("status", "", "task status within the queue: pending, delayed, scheduled, running, retry, failed or succeeded")
+	tasksCmd.AddCommand(tasksScheduleCmd)
+	tasksScheduleCmd.Flags().IntP("count", "n", 5, "number of runs")
+	tasksCmd.AddCommand(tasksWatchCmd)
+	tasksWatchCmd.Flags().String("id", "", "task id")
+	tasksWatchCmd.Flags().String("name", "", "task name")
+	tasksWatchCmd.Flags().String("queue", "", "queue name")
 	//TODO: implement the rest of the API
 }
 
-func listTasks(limit int, offset int) {
-	tasks, err := cli.GetTasks(offset, limit)
+func listTasks(queue string, status string, limit int, offset int) {
+	tasks, err := cli.GetTasks(queue, status, offset, limit)
 	if err != nil {
 		logger.Error("Error", "error", err)
 		os.Exit(1)
@@ -80,6 +149,41 @@ func listTask(id string) {
 	printTasksTable(tasks)
 }
 
+func listTaskSchedule(id string, n int) {
+	schedule, err := cli.GetTaskSchedule(id, n)
+	if err != nil {
+		logger.Error("Error", "error", err)
+		os.Exit(1)
+	}
+
+	printTable(
+		[]string{"#", "Run", "Cron", "Timezone"},
+		func(w io.Writer, tmpl string) {
+			for i, run := range schedule.NextRuns {
+				fmt.Fprintf(w, tmpl, i+1, run, schedule.Cron, schedule.Timezone)
+			}
+		},
+	)
+}
+
+func watchTasks(filter base.EventFilter) {
+	err := cli.WatchEvents(filter, func(event *base.TaskEvent) {
+		fmt.Printf("%s\t%-9s\t%s\t%s\t%s\t%d\t%s\n",
+			event.Time,
+			event.Type,
+			event.TaskID,
+			event.Name,
+			event.Queue,
+			event.Attempts,
+			base.NewSafeString(event.Error).String(),
+		)
+	})
+	if err != nil {
+		logger.Error("Error", "error", err)
+		os.Exit(1)
+	}
+}
+
 func printTasksTable(tasks []task.Response) {
 	printTable(
 		[]string{
@@ -88,7 +192,10 @@ func printTasksTable(tasks []task.Response) {
 			"Name",
 			"Type",
 			"Period",
+			"Cron",
+			"Queue",
 			"Payload",
+			"Attempts",
 			"Error",
 			"CreatedAt",
 			"CompletedAt",
@@ -103,7 +210,10 @@ func printTasksTable(tasks []task.Response) {
 					t.Name,
 					t.Type,
 					t.Period,
+					t.Cron,
+					t.Queue,
 					string(payload),
+					fmt.Sprintf("%d/%d", t.Attempts, t.MaxAttempts),
 					base.NewSafeString(t.Error).String(),
 					t.CreatedAt,
 					base.NewSafeString(t.CompletedAt).String(),
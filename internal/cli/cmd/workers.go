@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/cli"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strings"
+)
+
+var workersCmd = &cobra.Command{
+	Use:   "workers <command>",
+	Short: "Manage workers",
+	Example: `
+$ gotama-cli workers list`,
+}
+
+var workersListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List the workers which are alive",
+	Long: `
+	List the workers which are alive, the queues they consume and the tasks they are processing.`,
+	Example: `
+$ gotama-cli workers list`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		workers, err := cli.GetWorkers()
+		if err != nil {
+			logger.Error("Error", "error", err)
+			os.Exit(1)
+		}
+
+		printWorkersTable(workers)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(workersCmd)
+	workersCmd.AddCommand(workersListCmd)
+}
+
+func printWorkersTable(workers []base.WorkerInfo) {
+	printTable(
+		[]string{
+			"ID",
+			"Hostname",
+			"PID",
+			"Concurrency",
+			"Queues",
+			"Tasks",
+			"StartedAt",
+			"LastHeartbeat",
+		},
+		func(w io.Writer, tmpl string) {
+			for _, wrk := range workers {
+				fmt.Fprintf(w, tmpl,
+					wrk.ID,
+					wrk.Hostname,
+					wrk.PID,
+					wrk.Concurrency,
+					strings.Join(wrk.Queues, ","),
+					strings.Join(wrk.Tasks, ","),
+					wrk.StartedAt,
+					wrk.LastHeartbeat,
+				)
+			}
+		},
+	)
+}
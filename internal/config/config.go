@@ -1,7 +1,9 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"time"
 
 	"github.com/engpetarmarinov/gotama/internal/logger"
 )
@@ -25,3 +27,28 @@ func (c *Config) GetLogLevel() logger.Level {
 	logLevel := c.Get("LOG_LEVEL")
 	return logger.NewLogLevel(logLevel)
 }
+
+// GetDuration parses the duration stored under key, falling back to def when the key is not set.
+func GetDuration(config API, key string, def time.Duration) (time.Duration, error) {
+	value := config.Get(key)
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return d, nil
+}
+
+// GetPositiveDuration parses the duration stored under key like GetDuration, and rejects durations which are not positive.
+func GetPositiveDuration(config API, key string, def time.Duration) (time.Duration, error) {
+	d, err := GetDuration(config, key, def)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid %s: has to be positive", key)
+	}
+	return d, nil
+}
@@ -0,0 +1,157 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/config"
+	"strings"
+)
+
+// keySize is the size of the keys in bytes, they are AES-256 keys.
+const keySize = 32
+
+// Envelope holds data encrypted with its own data key, which is in turn encrypted with a key of the keyring.
+// Rotating the keyring only needs the data key to be encrypted with the new key.
+type Envelope struct {
+	// KeyID is the ID of the key of the keyring the data key is encrypted with
+	KeyID string
+	// WrappedKey is the encrypted data key, prefixed with its nonce
+	WrappedKey []byte
+	// Ciphertext is the encrypted data, prefixed with its nonce
+	Ciphertext []byte
+}
+
+// Keyring holds the keys data is encrypted with. The primary key encrypts new data,
+// the others only decrypt the data encrypted before they were rotated out.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyringFromConfig returns the keyring of the ENCRYPTION_KEYS config,
+// e.g. ENCRYPTION_KEYS=2024-06:<base64 key>,2024-01:<base64 key>, the first key being the primary one.
+// It returns nil if no keys are configured.
+func NewKeyringFromConfig(config config.API) (*Keyring, error) {
+	spec := config.Get("ENCRYPTION_KEYS")
+	if spec == "" {
+		return nil, nil
+	}
+	keyring, err := NewKeyring(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ENCRYPTION_KEYS: %w", err)
+	}
+	return keyring, nil
+}
+
+// NewKeyring parses a comma separated list of <id>:<base64 key> pairs, the first key being the primary one.
+func NewKeyring(spec string) (*Keyring, error) {
+	keyring := &Keyring{
+		keys: make(map[string]cipher.AEAD),
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || id == "" {
+			return nil, errors.New("keys have to be in the <id>:<base64 key> format")
+		}
+		if _, ok := keyring.keys[id]; ok {
+			return nil, fmt.Errorf("key %s is provided more than once", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %s is not base64 encoded", id)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		keyring.keys[id] = aead
+		if keyring.primary == "" {
+			keyring.primary = id
+		}
+	}
+	return keyring, nil
+}
+
+// PrimaryKeyID returns the ID of the key new data is encrypted with.
+func (k *Keyring) PrimaryKeyID() string {
+	return k.primary
+}
+
+// Seal encrypts plaintext with a new data key, which is encrypted with the primary key.
+// additionalData is authenticated but not encrypted, the same has to be passed to Open.
+func (k *Keyring) Seal(plaintext []byte, additionalData []byte) (*Envelope, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := seal(aead, plaintext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	wrappedKey, err := seal(k.keys[k.primary], dataKey, []byte(k.primary))
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{
+		KeyID:      k.primary,
+		WrappedKey: wrappedKey,
+		Ciphertext: ciphertext,
+	}, nil
+}
+
+// Open decrypts the data sealed in the envelope.
+func (k *Keyring) Open(envelope *Envelope, additionalData []byte) ([]byte, error) {
+	kek, ok := k.keys[envelope.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %s", envelope.KeyID)
+	}
+	dataKey, err := open(kek, envelope.WrappedKey, []byte(envelope.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting the data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := open(aead, envelope.Ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting the data: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key has to be %d bytes long", keySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a random nonce and prefixes the ciphertext with it.
+func seal(aead cipher.AEAD, plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
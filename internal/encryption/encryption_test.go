@@ -0,0 +1,60 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, keySize))
+}
+
+func TestSealOpen(t *testing.T) {
+	old, err := NewKeyring("k1:" + testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte(`{"to":"gotama@gotama.io"}`)
+	envelope, err := old.Seal(plaintext, []byte("task-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope.KeyID != "k1" || bytes.Contains(envelope.Ciphertext, plaintext) {
+		t.Fatalf("unexpected envelope %+v", envelope)
+	}
+
+	// the rotated keyring still opens the data of the old key
+	rotated, err := NewKeyring("k2:" + testKey(2) + ",k1:" + testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.PrimaryKeyID() != "k2" {
+		t.Errorf("expected the first key to be the primary one, got %s", rotated.PrimaryKeyID())
+	}
+	got, err := rotated.Open(envelope, []byte("task-1"))
+	if err != nil || !bytes.Equal(got, plaintext) {
+		t.Fatalf("expected %s, got %s, %v", plaintext, got, err)
+	}
+
+	if _, err := rotated.Open(envelope, []byte("task-2")); err == nil {
+		t.Error("expected an error opening with other additional data")
+	}
+	if _, err := old.Open(&Envelope{KeyID: "k2"}, nil); err == nil {
+		t.Error("expected an error opening with an unknown key")
+	}
+}
+
+func TestNewKeyringErrors(t *testing.T) {
+	for _, spec := range []string{
+		"k1",
+		":" + testKey(1),
+		"k1:not-base64!",
+		"k1:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"k1:" + testKey(1) + ",k1:" + testKey(2),
+	} {
+		if _, err := NewKeyring(spec); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
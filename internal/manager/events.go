@@ -0,0 +1,164 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/gorilla/websocket"
+)
+
+// eventsBlock is how long a read of the events waits for new ones, the idle streams are pinged that often.
+const eventsBlock = 5 * time.Second
+
+type EventsBroker interface {
+	LastEventID(ctx context.Context) (string, error)
+	ReadEvents(ctx context.Context, lastID string, block time.Duration) ([]*base.TaskEvent, error)
+}
+
+var upgrader = websocket.Upgrader{}
+
+func getEventFilter(params url.Values) base.EventFilter {
+	return base.EventFilter{
+		TaskID: strings.TrimSpace(params.Get("task_id")),
+		Name:   strings.TrimSpace(params.Get("name")),
+		Queue:  strings.TrimSpace(params.Get("queue")),
+	}
+}
+
+// getLastEventID returns the ID of the event to resume the stream after, sent by a reconnecting SSE client,
+// or the ID of the last event to stream the events from now on.
+func getLastEventID(r *http.Request, broker EventsBroker) (string, error) {
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		return lastID, nil
+	}
+	if lastID := r.URL.Query().Get("last_event_id"); lastID != "" {
+		return lastID, nil
+	}
+	return broker.LastEventID(r.Context())
+}
+
+// streamEvents sends the events after lastID which pass the filter until ctx is done or sending fails.
+// idle is called whenever no event was published for a while, to keep the connection alive.
+func streamEvents(ctx context.Context, broker EventsBroker, filter base.EventFilter, lastID string,
+	send func(*base.TaskEvent) error, idle func() error) error {
+	for ctx.Err() == nil {
+		events, err := broker.ReadEvents(ctx, lastID, eventsBlock)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if len(events) == 0 {
+			if err := idle(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, event := range events {
+			lastID = event.ID
+			if !filter.Match(event) {
+				continue
+			}
+			if err := send(event); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func getEventsHandler(broker EventsBroker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter := getEventFilter(r.URL.Query())
+		lastID, err := getLastEventID(r, broker)
+		if err != nil {
+			logger.Error("Error", "error", err)
+			writeErrorResponse(w, http.StatusInternalServerError, "error getting events")
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			logger.Error("error flushing events", "error", err)
+			return
+		}
+
+		err = streamEvents(r.Context(), broker, filter, lastID,
+			func(event *base.TaskEvent) error {
+				data, err := json.Marshal(event)
+				if err != nil {
+					return err
+				}
+				if _, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+					return err
+				}
+				return rc.Flush()
+			},
+			func() error {
+				if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return err
+				}
+				return rc.Flush()
+			})
+		if err != nil {
+			logger.Warn("events stream closed", "error", err)
+		}
+	}
+}
+
+func getEventsWebsocketHandler(broker EventsBroker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter := getEventFilter(r.URL.Query())
+		lastID, err := getLastEventID(r, broker)
+		if err != nil {
+			logger.Error("Error", "error", err)
+			writeErrorResponse(w, http.StatusInternalServerError, "error getting events")
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// the upgrader has responded with the error already
+			logger.Warn("error upgrading to websocket", "error", err)
+			return
+		}
+		defer conn.Close()
+
+		// the client only sends control messages, reading them notices when it goes away
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go func() {
+			defer cancel()
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		err = streamEvents(ctx, broker, filter, lastID,
+			func(event *base.TaskEvent) error {
+				return conn.WriteJSON(event)
+			},
+			func() error {
+				return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventsBlock))
+			})
+		if err != nil {
+			logger.Warn("events websocket closed", "error", err)
+		}
+	}
+}
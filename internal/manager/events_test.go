@@ -0,0 +1,71 @@
+package manager
+
+import (
+	"bufio"
+	"context"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	"github.com/engpetarmarinov/gotama/memory"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventsHandlerStreamsScheduledTasks(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	ctx := context.Background()
+	// the process time of a delayed task is relative to the real time
+	clock := timeutil.NewSimulatedClock(time.Now())
+	broker := memory.NewBroker(clock)
+	epoch, err := broker.AcquireLeadership(ctx, "manager-1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delayed, err := task.NewMessageFromRequest(&task.Request{Name: "email", Type: "once", ProcessIn: "30s", Payload: []byte(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.EnqueueTask(ctx, delayed); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(getEventsHandler(broker)))
+	defer server.Close()
+	streamCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodGet, server.URL+"?task_id="+delayed.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %s", ct)
+	}
+
+	// the stream starts after the enqueueing of the delayed task, the scheduler enqueues it once it is due
+	clock.AdvanceTime(30 * time.Second)
+	if err := broker.EnqueueScheduledTasks(ctx, epoch); err != nil {
+		t.Fatal(err)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	var lines []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 3 || lines[1] != "event: enqueued" || !strings.Contains(lines[2], delayed.ID) {
+		t.Errorf("expected the enqueued event of the delayed task, got %q, %v", lines, scanner.Err())
+	}
+}
@@ -3,18 +3,28 @@ package manager
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
 	"github.com/engpetarmarinov/gotama/internal/config"
 	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/metrics"
 	"github.com/engpetarmarinov/gotama/internal/processors"
 	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/engpetarmarinov/gotama/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type GetAllTasksBroker interface {
-	GetAllTasks(ctx context.Context, offset int, limit int) (int64, []*task.Message, error)
+	GetAllTasks(ctx context.Context, qname string, status string, offset int, limit int) (int64, []*task.Message, error)
 }
 
 type GetTaskBroker interface {
@@ -23,6 +33,7 @@ type GetTaskBroker interface {
 
 type GetUpdateTaskBroker interface {
 	GetTaskBroker
+	WorkersBroker
 	UpdateTask(ctx context.Context, msg *task.Message) error
 }
 
@@ -32,24 +43,73 @@ type GetDeleteTaskBroker interface {
 }
 
 type EnqueueTaskBroker interface {
+	WorkersBroker
 	EnqueueTask(ctx context.Context, msg *task.Message) error
 }
 
+type QueueStatsBroker interface {
+	GetAllQueueStats(ctx context.Context) ([]*base.QueueStats, error)
+	GetQueueStats(ctx context.Context, qname string) (*base.QueueStats, error)
+}
+
+type DeliveriesBroker interface {
+	GetTaskBroker
+	GetDeliveries(ctx context.Context, taskID string) ([]*base.Delivery, error)
+}
+
+type MigrationsBroker interface {
+	MigrateEncoding(ctx context.Context, progress base.MigrationProgress) (int64, error)
+	RotateEncryption(ctx context.Context, progress base.MigrationProgress) (int64, error)
+}
+
+type WorkersBroker interface {
+	GetWorkers(ctx context.Context) ([]*base.WorkerInfo, error)
+}
+
+type LeaderBroker interface {
+	GetLeader(ctx context.Context) (*base.Leader, error)
+}
+
+type DeadTasksBroker interface {
+	GetDeadTasks(ctx context.Context, qname string, offset int, limit int) (int64, []*task.Message, error)
+	RequeueDeadTask(ctx context.Context, qname string, taskID string) (*task.Message, error)
+	RequeueDeadTasks(ctx context.Context, qname string) (int64, error)
+	PurgeDeadTasks(ctx context.Context, qname string, olderThan time.Duration) (int64, error)
+}
+
+// getPagination returns the limit and offset query params, 100 and 0 if not provided.
+func getPagination(params url.Values) (int, int) {
+	limitStr := params.Get("limit")
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+	offsetStr := params.Get("offset")
+	offset, err := strconv.Atoi(offsetStr)
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
 func getTasksHandler(broker GetAllTasksBroker) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		params := r.URL.Query()
-		limitStr := params.Get("limit")
-		limit, err := strconv.Atoi(limitStr)
-		if err != nil || limit <= 0 {
-			limit = 100
+		limit, offset := getPagination(params)
+		queue := strings.TrimSpace(params.Get("queue"))
+		if queue != "" {
+			if err := task.ValidateQueueName(queue); err != nil {
+				writeErrorResponse(w, http.StatusBadRequest, err.Error())
+				return
+			}
 		}
-		offsetStr := params.Get("offset")
-		offset, err := strconv.Atoi(offsetStr)
-		if err != nil || offset < 0 {
-			offset = 0
+		status := strings.ToLower(strings.TrimSpace(params.Get("status")))
+		if status != "" && queue == "" {
+			writeErrorResponse(w, http.StatusBadRequest, "filtering by status requires a queue")
+			return
 		}
 
-		totalTaskMsgs, taskMsgs, err := broker.GetAllTasks(context.Background(), offset, limit)
+		totalTaskMsgs, taskMsgs, err := broker.GetAllTasks(context.Background(), queue, status, offset, limit)
 		if err != nil {
 			logger.Error("Error", "error", err)
 			writeErrorResponse(w, http.StatusInternalServerError, "error getting all tasks")
@@ -104,8 +164,83 @@ func getTaskHandler(broker GetTaskBroker) func(w http.ResponseWriter, r *http.Re
 	}
 }
 
+const (
+	defaultScheduleRuns = 5
+	maxScheduleRuns     = 100
+)
+
+func getTaskScheduleHandler(broker GetTaskBroker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskID := strings.ToLower(strings.TrimSpace(r.PathValue("id")))
+		if taskID == "" {
+			writeErrorResponse(w, http.StatusBadRequest, "no task id provided")
+			return
+		}
+		n := defaultScheduleRuns
+		if nStr := r.URL.Query().Get("n"); nStr != "" {
+			var err error
+			n, err = strconv.Atoi(nStr)
+			if err != nil || n <= 0 || n > maxScheduleRuns {
+				writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("n has to be between 1 and %d", maxScheduleRuns))
+				return
+			}
+		}
+
+		taskMsg, err := broker.GetTask(context.Background(), taskID)
+		if err != nil {
+			logger.Warn(err.Error())
+			writeErrorResponse(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		resp, err := task.NewScheduleResponseFromMessage(taskMsg, time.Now(), n)
+		if err != nil {
+			logger.Warn(err.Error())
+			writeErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		writeSuccessResponse(w, http.StatusOK, resp)
+	}
+}
+
+func getTaskDeliveriesHandler(broker DeliveriesBroker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskID := strings.ToLower(strings.TrimSpace(r.PathValue("id")))
+		if taskID == "" {
+			writeErrorResponse(w, http.StatusBadRequest, "no task id provided")
+			return
+		}
+
+		taskMsg, err := broker.GetTask(context.Background(), taskID)
+		if err != nil {
+			logger.Warn(err.Error())
+			writeErrorResponse(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		deliveries, err := broker.GetDeliveries(context.Background(), taskMsg.ID)
+		if err != nil {
+			logger.Error("Error", "error", err)
+			writeErrorResponse(w, http.StatusInternalServerError, "error getting deliveries")
+			return
+		}
+
+		resp := struct {
+			Deliveries []*base.Delivery `json:"deliveries"`
+		}{
+			Deliveries: deliveries,
+		}
+		writeSuccessResponse(w, http.StatusOK, resp)
+	}
+}
+
 func postTaskHandler(config config.API, broker EnqueueTaskBroker) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Start(tracing.ExtractHTTP(r), "POST /api/v1/tasks", trace.WithSpanKind(trace.SpanKindServer))
+		var err error
+		defer func() { tracing.End(span, err) }()
+
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
 			logger.Warn(err.Error())
@@ -128,8 +263,15 @@ func postTaskHandler(config config.API, broker EnqueueTaskBroker) func(w http.Re
 			return
 		}
 
-		taskName, _ := task.GetName(taskMsg.Name)
-		processor, err := processors.ProcessorFactory(config, taskName)
+		if taskMsg.Name == processors.NameWebhook {
+			writeErrorResponse(w, http.StatusBadRequest, "webhook tasks are enqueued by gotama itself")
+			return
+		}
+
+		processor, err := processors.NewProcessor(config, taskMsg.Name)
+		if errors.Is(err, base.ErrorUnknownTaskName) {
+			processor, err = newWorkerProcessor(ctx, broker, taskMsg.Name, taskMsg.Queue)
+		}
 		if err != nil {
 			logger.Warn(err.Error())
 			writeErrorResponse(w, http.StatusBadRequest, "no processor for this task name")
@@ -143,12 +285,27 @@ func postTaskHandler(config config.API, broker EnqueueTaskBroker) func(w http.Re
 			return
 		}
 
-		err = broker.EnqueueTask(context.Background(), taskMsg)
+		defaultRetryPolicy, err := processors.DefaultRetryPolicy(config, taskMsg.Name)
+		if err != nil {
+			logger.Error("Error", "error", err)
+			writeErrorResponse(w, http.StatusInternalServerError, "error getting the retry policy of the task")
+			return
+		}
+		taskMsg.RetryPolicy = taskMsg.RetryPolicy.Merge(defaultRetryPolicy)
+
+		span.SetAttributes(
+			attribute.String("task.id", taskMsg.ID),
+			attribute.String("task.name", taskMsg.Name),
+			attribute.String("task.queue", taskMsg.Queue),
+		)
+		taskMsg.TraceContext = tracing.Inject(ctx)
+		err = broker.EnqueueTask(ctx, taskMsg)
 		if err != nil {
 			logger.Error("Error", "error", err)
 			writeErrorResponse(w, http.StatusInternalServerError, "error enqueueing task")
 			return
 		}
+		metrics.TasksEnqueued.WithLabelValues(taskMsg.Name, taskMsg.Queue).Inc()
 
 		resp, err := task.NewResponseFromMessage(taskMsg)
 		if err != nil {
@@ -198,8 +355,20 @@ func putTaskHandler(config config.API, broker GetUpdateTaskBroker) func(w http.R
 			return
 		}
 
-		taskName, _ := task.GetName(newTaskMsg.Name)
-		processor, err := processors.ProcessorFactory(config, taskName)
+		if taskReq.Queue != "" && newTaskMsg.Queue != existingTaskMsg.Queue {
+			writeErrorResponse(w, http.StatusBadRequest, "the queue of a task cannot be changed")
+			return
+		}
+
+		if newTaskMsg.Name == processors.NameWebhook || existingTaskMsg.Name == processors.NameWebhook {
+			writeErrorResponse(w, http.StatusBadRequest, "webhook tasks are enqueued by gotama itself")
+			return
+		}
+
+		processor, err := processors.NewProcessor(config, newTaskMsg.Name)
+		if errors.Is(err, base.ErrorUnknownTaskName) {
+			processor, err = newWorkerProcessor(r.Context(), broker, newTaskMsg.Name, existingTaskMsg.Queue)
+		}
 		if err != nil {
 			logger.Warn(err.Error())
 			writeErrorResponse(w, http.StatusBadRequest, "no processor for this task name")
@@ -213,10 +382,25 @@ func putTaskHandler(config config.API, broker GetUpdateTaskBroker) func(w http.R
 			return
 		}
 
+		defaultRetryPolicy, err := processors.DefaultRetryPolicy(config, newTaskMsg.Name)
+		if err != nil {
+			logger.Error("Error", "error", err)
+			writeErrorResponse(w, http.StatusInternalServerError, "error getting the retry policy of the task")
+			return
+		}
+
 		existingTaskMsg.Name = newTaskMsg.Name
 		existingTaskMsg.Type = newTaskMsg.Type
 		existingTaskMsg.Period = newTaskMsg.Period
+		existingTaskMsg.Cron = newTaskMsg.Cron
+		existingTaskMsg.Timezone = newTaskMsg.Timezone
 		existingTaskMsg.Payload = newTaskMsg.Payload
+		existingTaskMsg.RetryPolicy = newTaskMsg.RetryPolicy.Merge(defaultRetryPolicy)
+		existingTaskMsg.Callback = newTaskMsg.Callback
+		//only a task which is still delayed can be rescheduled
+		if existingTaskMsg.Status == task.StatusDelayed && newTaskMsg.ProcessAt != nil {
+			existingTaskMsg.ProcessAt = newTaskMsg.ProcessAt
+		}
 		err = broker.UpdateTask(context.Background(), existingTaskMsg)
 		if err != nil {
 			logger.Error("Error", "error", err)
@@ -267,3 +451,318 @@ func deleteTaskHandler(broker GetDeleteTaskBroker) func(w http.ResponseWriter, r
 		writeSuccessResponse(w, http.StatusOK, resp)
 	}
 }
+
+// getQueuePathValue returns the validated queue name of the request path.
+func getQueuePathValue(r *http.Request) (string, error) {
+	queue := strings.TrimSpace(r.PathValue("queue"))
+	if err := task.ValidateQueueName(queue); err != nil {
+		return "", err
+	}
+	return queue, nil
+}
+
+func getQueuesHandler(broker QueueStatsBroker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := broker.GetAllQueueStats(context.Background())
+		if err != nil {
+			logger.Error("Error", "error", err)
+			writeErrorResponse(w, http.StatusInternalServerError, "error getting queues")
+			return
+		}
+
+		resp := struct {
+			Queues []*base.QueueStats `json:"queues"`
+		}{
+			Queues: stats,
+		}
+		writeSuccessResponse(w, http.StatusOK, resp)
+	}
+}
+
+func getQueueHandler(broker QueueStatsBroker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		queue, err := getQueuePathValue(r)
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		stats, err := broker.GetQueueStats(context.Background(), queue)
+		if errors.Is(err, base.ErrorQueueNotFound) {
+			writeErrorResponse(w, http.StatusNotFound, err.Error())
+			return
+		} else if err != nil {
+			logger.Error("Error", "error", err)
+			writeErrorResponse(w, http.StatusInternalServerError, "error getting queue")
+			return
+		}
+
+		writeSuccessResponse(w, http.StatusOK, stats)
+	}
+}
+
+func getDeadTasksHandler(broker DeadTasksBroker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		queue, err := getQueuePathValue(r)
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		limit, offset := getPagination(r.URL.Query())
+
+		totalTaskMsgs, taskMsgs, err := broker.GetDeadTasks(context.Background(), queue, offset, limit)
+		if err != nil {
+			logger.Error("Error", "error", err)
+			writeErrorResponse(w, http.StatusInternalServerError, "error getting dead tasks")
+			return
+		}
+
+		var tasks []*task.Response
+		for _, taskMsg := range taskMsgs {
+			taskResp, err := task.NewResponseFromMessage(taskMsg)
+			if err != nil {
+				logger.Error("Error", "error", err)
+				writeErrorResponse(w, http.StatusInternalServerError, "error getting task response")
+				return
+			}
+
+			tasks = append(tasks, taskResp)
+		}
+
+		resp := struct {
+			Total int64            `json:"total"`
+			Tasks []*task.Response `json:"tasks"`
+		}{
+			Total: totalTaskMsgs,
+			Tasks: tasks,
+		}
+		writeSuccessResponse(w, http.StatusOK, resp)
+	}
+}
+
+func requeueDeadTaskHandler(broker DeadTasksBroker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		queue, err := getQueuePathValue(r)
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		taskID := strings.ToLower(strings.TrimSpace(r.PathValue("id")))
+		if taskID == "" {
+			writeErrorResponse(w, http.StatusBadRequest, "no task id provided")
+			return
+		}
+
+		taskMsg, err := broker.RequeueDeadTask(context.Background(), queue, taskID)
+		if errors.Is(err, base.ErrorNotInDeadLetterQueue) {
+			writeErrorResponse(w, http.StatusNotFound, err.Error())
+			return
+		} else if err != nil {
+			logger.Error("Error", "error", err)
+			writeErrorResponse(w, http.StatusInternalServerError, "error requeueing dead task")
+			return
+		}
+
+		resp, err := task.NewResponseFromMessage(taskMsg)
+		if err != nil {
+			logger.Warn(err.Error())
+			writeErrorResponse(w, http.StatusInternalServerError, "error getting task response")
+			return
+		}
+
+		writeSuccessResponse(w, http.StatusOK, resp)
+	}
+}
+
+func requeueDeadTasksHandler(broker DeadTasksBroker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		queue, err := getQueuePathValue(r)
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		count, err := broker.RequeueDeadTasks(context.Background(), queue)
+		if err != nil {
+			logger.Error("Error", "error", err)
+			writeErrorResponse(w, http.StatusInternalServerError, "error requeueing dead tasks")
+			return
+		}
+
+		resp := struct {
+			Count int64 `json:"count"`
+		}{
+			Count: count,
+		}
+		writeSuccessResponse(w, http.StatusOK, resp)
+	}
+}
+
+func purgeDeadTasksHandler(broker DeadTasksBroker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		queue, err := getQueuePathValue(r)
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var olderThan time.Duration
+		if olderThanStr := r.URL.Query().Get("older_than"); olderThanStr != "" {
+			olderThan, err = time.ParseDuration(olderThanStr)
+			if err != nil || olderThan <= 0 {
+				writeErrorResponse(w, http.StatusBadRequest, "older_than has to be a positive duration, e.g. 24h")
+				return
+			}
+		}
+
+		count, err := broker.PurgeDeadTasks(context.Background(), queue, olderThan)
+		if err != nil {
+			logger.Error("Error", "error", err)
+			writeErrorResponse(w, http.StatusInternalServerError, "error purging dead tasks")
+			return
+		}
+
+		resp := struct {
+			Count int64 `json:"count"`
+		}{
+			Count: count,
+		}
+		writeSuccessResponse(w, http.StatusOK, resp)
+	}
+}
+
+func getLeaderHandler(broker LeaderBroker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		leader, err := broker.GetLeader(context.Background())
+		if errors.Is(err, base.ErrorNoLeader) {
+			writeErrorResponse(w, http.StatusNotFound, err.Error())
+			return
+		} else if err != nil {
+			logger.Error("Error", "error", err)
+			writeErrorResponse(w, http.StatusInternalServerError, "error getting leader")
+			return
+		}
+
+		writeSuccessResponse(w, http.StatusOK, leader)
+	}
+}
+
+func migrateEncodingHandler(migrations *migrations, broker MigrationsBroker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		startMigration(w, migrations, base.MigrationEncoding, broker.MigrateEncoding)
+	}
+}
+
+func rotateEncryptionHandler(config config.API, migrations *migrations, broker MigrationsBroker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// the broker of the manager is encrypted with the keys of ENCRYPTION_KEYS
+		if config.Get("ENCRYPTION_KEYS") == "" {
+			writeErrorResponse(w, http.StatusBadRequest, base.ErrorNoEncryptionKeys.Error())
+			return
+		}
+		startMigration(w, migrations, base.MigrationEncryption, broker.RotateEncryption)
+	}
+}
+
+// startMigration starts the migration in the background and responds with it, its progress is polled with getMigrationHandler.
+func startMigration(w http.ResponseWriter, migrations *migrations, kind string, migrate migrationFunc) {
+	migration, started := migrations.start(kind, migrate)
+	if !started {
+		writeErrorResponse(w, http.StatusConflict, fmt.Sprintf("a migration of the %s is running already", kind))
+		return
+	}
+	w.Header().Set("Location", "/api/v1/admin/migrations/"+kind)
+	writeSuccessResponse(w, http.StatusAccepted, migration)
+}
+
+func getMigrationHandler(migrations *migrations) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		kind := r.PathValue("kind")
+		if kind != base.MigrationEncoding && kind != base.MigrationEncryption {
+			writeErrorResponse(w, http.StatusNotFound, "unknown migration")
+			return
+		}
+		migration, ok := migrations.get(kind)
+		if !ok {
+			writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("no migration of the %s was started by this manager", kind))
+			return
+		}
+		writeSuccessResponse(w, http.StatusOK, migration)
+	}
+}
+
+func getWorkersHandler(broker WorkersBroker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		workers, err := broker.GetWorkers(context.Background())
+		if err != nil {
+			logger.Error("Error", "error", err)
+			writeErrorResponse(w, http.StatusInternalServerError, "error getting workers")
+			return
+		}
+
+		resp := struct {
+			Workers []*base.WorkerInfo `json:"workers"`
+		}{
+			Workers: workers,
+		}
+		writeSuccessResponse(w, http.StatusOK, resp)
+	}
+}
+
+// processorResponse describes the processor of the tasks with a name.
+type processorResponse struct {
+	Name          string                   `json:"name"`
+	Description   string                   `json:"description,omitempty"`
+	PayloadSchema json.RawMessage          `json:"payload_schema,omitempty"`
+	RetryPolicy   *task.RetryPolicyRequest `json:"retry_policy,omitempty"`
+}
+
+func getProcessorsHandler(config config.API, broker WorkersBroker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		regs := processors.Registered()
+		procs := make([]processorResponse, 0, len(regs))
+		registered := make(map[string]bool, len(regs))
+		for _, reg := range regs {
+			registered[reg.Name] = true
+			policy, err := processors.DefaultRetryPolicy(config, reg.Name)
+			if err != nil {
+				logger.Error("Error", "error", err)
+				writeErrorResponse(w, http.StatusInternalServerError, "error getting the retry policy of the processor")
+				return
+			}
+			procs = append(procs, processorResponse{
+				Name:          reg.Name,
+				Description:   reg.Description,
+				PayloadSchema: reg.PayloadSchema,
+				RetryPolicy:   policy.Request(),
+			})
+		}
+
+		// the processors registered only in the workers are known by their name
+		workers, err := broker.GetWorkers(context.Background())
+		if err != nil {
+			logger.Error("Error", "error", err)
+			writeErrorResponse(w, http.StatusInternalServerError, "error getting workers")
+			return
+		}
+		var names []string
+		for _, info := range workers {
+			for _, name := range info.Processors {
+				if !registered[name] {
+					registered[name] = true
+					names = append(names, name)
+				}
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			procs = append(procs, processorResponse{Name: name})
+		}
+
+		resp := struct {
+			Processors []processorResponse `json:"processors"`
+		}{
+			Processors: procs,
+		}
+		writeSuccessResponse(w, http.StatusOK, resp)
+	}
+}
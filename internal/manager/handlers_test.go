@@ -0,0 +1,68 @@
+package manager
+
+import (
+	"encoding/json"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	"github.com/engpetarmarinov/gotama/memory"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPostTaskRejectsWebhook(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	routes := NewRouter().RegisterRoutes(testConfig{}, memory.NewBroker(timeutil.NewRealClock()))
+
+	body := `{"name":"webhook","type":"once","payload":{"url":"https://example.com","event":"task.succeeded","task_id":"1","body":{}}}`
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/tasks", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a webhook task to be rejected, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestMigrationRunsInBackground(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	routes := NewRouter().RegisterRoutes(testConfig{}, memory.NewBroker(timeutil.NewRealClock()))
+
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/migrations/encoding", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected no migration before it is started, got %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/admin/migrations/encryption", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected the encryption migration to be rejected without ENCRYPTION_KEYS, got %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/admin/migrations/encoding", nil))
+	if rec.Code != http.StatusAccepted || rec.Header().Get("Location") != "/api/v1/admin/migrations/encoding" {
+		t.Fatalf("expected the migration to be accepted, got %d %s", rec.Code, rec.Body)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec = httptest.NewRecorder()
+		routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/migrations/encoding", nil))
+		var resp struct {
+			Data base.Migration `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("expected the migration, got %d %s", rec.Code, rec.Body)
+		}
+		if resp.Data.Status == base.MigrationSucceeded {
+			break
+		}
+		if resp.Data.Status != base.MigrationRunning || time.Now().After(deadline) {
+			t.Fatalf("expected the migration to succeed, got %+v", resp.Data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -3,13 +3,18 @@ package manager
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
 
 	"github.com/engpetarmarinov/gotama/internal/base"
 	"github.com/engpetarmarinov/gotama/internal/config"
 	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/metrics"
+	"github.com/google/uuid"
 )
 
 type Broker interface {
@@ -19,6 +24,13 @@ type Broker interface {
 	EnqueueTaskBroker
 	SchedulerBroker
 	GetUpdateTaskBroker
+	DeadTasksBroker
+	QueueStatsBroker
+	LeaderBroker
+	WorkersBroker
+	EventsBroker
+	DeliveriesBroker
+	MigrationsBroker
 }
 
 type Service interface {
@@ -31,41 +43,63 @@ type Manager struct {
 	scheduler Service
 	broker    Broker
 	config    config.API
+	// streams is the base context of the requests, cancelled on shutdown to close the event streams
+	streams      context.Context
+	closeStreams context.CancelFunc
 }
 
 func NewManager(broker Broker, config config.API) *Manager {
+	streams, closeStreams := context.WithCancel(context.Background())
 	return &Manager{
-		broker:    broker,
-		config:    config,
-		scheduler: newScheduler(broker, config),
+		broker:       broker,
+		config:       config,
+		scheduler:    newScheduler(replicaID(), broker, config),
+		streams:      streams,
+		closeStreams: closeStreams,
 	}
 }
 
+// replicaID returns an ID which tells the manager replicas apart in the leader election.
+func replicaID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "gotama-manager"
+	}
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.NewString()[:8])
+}
+
 func (m *Manager) Shutdown() error {
 	logger.Info("manager shutting down...")
+	// the leadership expires on its own, failing to release it must not keep the server running
 	if err := m.scheduler.Shutdown(); err != nil {
-		return err
+		logger.Error("error shutting down the scheduler", "error", err)
 	}
-	if err := m.server.Shutdown(context.Background()); err != nil {
-		return err
+	m.closeStreams()
+	if m.server == nil {
+		return nil
 	}
-	return nil
+	return m.server.Shutdown(context.Background())
 }
 
 func (m *Manager) Run() {
-	router := NewRouter().RegisterRoutes(m.config, m.broker)
-	go func(mux http.Handler) {
-		server := http.Server{
-			Addr:    fmt.Sprintf(":%s", m.config.Get("MANAGER_PORT")),
-			Handler: mux,
-		}
+	if err := metrics.RegisterQueueCollector(m.broker); err != nil {
+		logger.Error("error registering queue metrics", "error", err)
+	}
 
-		m.server = &server
+	router := NewRouter().RegisterRoutes(m.config, m.broker)
+	m.server = &http.Server{
+		Addr:    fmt.Sprintf(":%s", m.config.Get("MANAGER_PORT")),
+		Handler: router,
+		BaseContext: func(net.Listener) context.Context {
+			return m.streams
+		},
+	}
+	go func(server *http.Server) {
 		logger.Info("Listening on", "address", server.Addr)
-		if err := server.ListenAndServe(); err != nil {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatal(err)
 		}
-	}(router)
+	}(m.server)
 
 	m.scheduler.Run()
 }
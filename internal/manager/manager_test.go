@@ -0,0 +1,76 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	"github.com/engpetarmarinov/gotama/memory"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type testConfig map[string]string
+
+func (c testConfig) Get(key string) string {
+	return c[key]
+}
+
+// releaseFailingBroker cannot release the leadership, e.g. because its backend is unreachable.
+type releaseFailingBroker struct {
+	*memory.Broker
+}
+
+func (b releaseFailingBroker) ReleaseLeadership(context.Context, string) error {
+	return errors.New("connection refused")
+}
+
+func TestSchedulerShutdownWithoutRun(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	s := newScheduler("manager-1", memory.NewBroker(timeutil.NewRealClock()), testConfig{})
+	done := make(chan error)
+	go func() { done <- s.Shutdown() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the shutdown of a scheduler which never ran not to block")
+	}
+}
+
+func TestSchedulerRejectsLeaderTTL(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	for _, ttl := range []string{"0s", "-5s", "1s"} {
+		s := newScheduler("manager-1", memory.NewBroker(timeutil.NewRealClock()), testConfig{"MANAGER_LEADER_TTL": ttl})
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected a leader TTL of %s to be rejected", ttl)
+				}
+			}()
+			s.Run()
+		}()
+		if err := s.Shutdown(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestManagerShutdownReleaseLeadershipError(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	m := NewManager(releaseFailingBroker{memory.NewBroker(timeutil.NewRealClock())}, testConfig{"MANAGER_PORT": "0"})
+	m.Run()
+
+	if err := m.Shutdown(); err != nil {
+		t.Fatalf("expected the release error to be logged only, got %v", err)
+	}
+	if m.streams.Err() == nil {
+		t.Error("expected the event streams to be closed")
+	}
+	if err := m.server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("expected the server to be shut down, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package manager
+
+import (
+	"context"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"sync"
+	"time"
+)
+
+// migrationFunc rewrites the tasks, reporting its progress, and returns the number of rewritten tasks.
+type migrationFunc func(ctx context.Context, progress base.MigrationProgress) (int64, error)
+
+// migrations runs the migrations of the tasks in the background, since they rewrite all tasks and outlast a request.
+// It runs one migration of a kind at a time and keeps the last one of each kind, for its progress to be polled.
+// The migrations are kept by the manager replica which runs them, they can be started again safely if it stops.
+type migrations struct {
+	mu   sync.Mutex
+	last map[string]*base.Migration
+}
+
+func newMigrations() *migrations {
+	return &migrations{
+		last: make(map[string]*base.Migration),
+	}
+}
+
+// start runs the migration of the kind in the background and returns it,
+// or the running one and false if a migration of the kind is running already.
+func (m *migrations) start(kind string, migrate migrationFunc) (base.Migration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if last, ok := m.last[kind]; ok && last.Status == base.MigrationRunning {
+		return *last, false
+	}
+	migration := &base.Migration{
+		Kind:      kind,
+		Status:    base.MigrationRunning,
+		StartedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	m.last[kind] = migration
+
+	go func() {
+		count, err := migrate(context.Background(), func(scanned int64, rewritten int64) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			migration.Scanned = scanned
+			migration.Count = rewritten
+		})
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		migration.Count = count
+		migration.Status = base.MigrationSucceeded
+		if err != nil {
+			logger.Error("error migrating tasks", "kind", kind, "error", err)
+			errStr := err.Error()
+			migration.Error = &errStr
+			migration.Status = base.MigrationFailed
+		}
+		migration.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+	}()
+
+	return *migration, true
+}
+
+// get returns the last migration of the kind, false if none was started by this replica.
+func (m *migrations) get(kind string) (base.Migration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	last, ok := m.last[kind]
+	if !ok {
+		return base.Migration{}, false
+	}
+	return *last, true
+}
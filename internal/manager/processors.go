@@ -0,0 +1,41 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/processors"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"slices"
+)
+
+// workerProcessor stands for a processor which is registered only in the workers, e.g. a worker embedded in a service.
+// The manager cannot validate the payloads of its tasks, the processor does when it processes them.
+type workerProcessor struct{}
+
+func (p workerProcessor) ProcessTask(context.Context, *task.Message) error {
+	return errors.New("the task is processed by the workers")
+}
+
+func (p workerProcessor) ValidatePayload([]byte) error {
+	return nil
+}
+
+// newWorkerProcessor returns the processor of the tasks with the name in the queue
+// if a worker which is alive consumes the queue and has a processor for them.
+// The workers are checked only when a task is created or updated, not for each run of a recurring task,
+// so a run can still find no worker with the processor. The other workers hand it back until WORKER_MAX_HAND_BACKS
+// and fail it then.
+func newWorkerProcessor(ctx context.Context, broker WorkersBroker, name string, qname string) (processors.Processor, error) {
+	workers, err := broker.GetWorkers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range workers {
+		if slices.Contains(info.Processors, name) && slices.Contains(info.Queues, qname) {
+			return workerProcessor{}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no processor for %s in queue %s", base.ErrorUnknownTaskName, name, qname)
+}
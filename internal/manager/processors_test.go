@@ -0,0 +1,31 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	"github.com/engpetarmarinov/gotama/memory"
+	"testing"
+	"time"
+)
+
+func TestNewWorkerProcessor(t *testing.T) {
+	ctx := context.Background()
+	broker := memory.NewBroker(timeutil.NewRealClock())
+	info := &base.WorkerInfo{ID: "worker-1", Queues: []string{"critical"}, Processors: []string{"INVOICE"}}
+	if err := broker.WriteWorkerInfo(ctx, info, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newWorkerProcessor(ctx, broker, "INVOICE", "critical"); err != nil {
+		t.Errorf("expected the processor of the worker, got %v", err)
+	}
+	// no worker could process the tasks of the other queues
+	if _, err := newWorkerProcessor(ctx, broker, "INVOICE", "default"); !errors.Is(err, base.ErrorUnknownTaskName) {
+		t.Errorf("expected no processor in the default queue, got %v", err)
+	}
+	if _, err := newWorkerProcessor(ctx, broker, "REFUND", "critical"); !errors.Is(err, base.ErrorUnknownTaskName) {
+		t.Errorf("expected no processor for REFUND, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package manager
 
 import (
 	"github.com/engpetarmarinov/gotama/internal/config"
+	"github.com/engpetarmarinov/gotama/internal/metrics"
 	mw "github.com/engpetarmarinov/gotama/internal/middleware"
 	"net/http"
 )
@@ -21,7 +22,7 @@ func (r *Router) RegisterRoutes(config config.API, broker Broker) http.Handler {
 	//
 	// List tasks.
 	//
-	// Retrieves a list of all submitted tasks with pagination.
+	// Retrieves a list of all submitted tasks, newest first, with pagination.
 	//
 	//     Produces:
 	//     - application/json
@@ -39,6 +40,16 @@ func (r *Router) RegisterRoutes(config config.API, broker Broker) http.Handler {
 	//       required: false
 	//       type: integer
 	//       format: int32
+	//     - +name: queue
+	//       in: query
+	//       description: Return only the tasks of this queue
+	//       required: false
+	//       type: string
+	//     - +name: status
+	//       in: query
+	//       description: Return only the tasks of the queue with this status (pending, delayed, scheduled, running, retry, failed, succeeded)
+	//       required: false
+	//       type: string
 	//
 	//     Responses:
 	//       200: Response
@@ -69,11 +80,65 @@ func (r *Router) RegisterRoutes(config config.API, broker Broker) http.Handler {
 		"GET /api/v1/tasks/{id}",
 		mw.WithLogging(mw.WithCommonHeaders(mw.WithAuth(mw.WithRBAC(getTaskHandler(broker))))))
 
+	// swagger:route GET /api/v1/tasks/{taskId}/schedule tasks getTaskSchedule
+	//
+	// Preview the schedule of a task.
+	//
+	// Retrieves the next times a cron task will be processed at.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Parameters:
+	//     - +name: taskId
+	//       in: path
+	//       description: ID of the task
+	//       required: true
+	//       type: string
+	//     - +name: n
+	//       in: query
+	//       description: Number of runs to return, 5 by default and at most 100
+	//       required: false
+	//       type: integer
+	//       format: int32
+	//
+	//     Responses:
+	//       200: Response
+	//       400: Response
+	//       404: Response
+	r.mux.HandleFunc(
+		"GET /api/v1/tasks/{id}/schedule",
+		mw.WithLogging(mw.WithCommonHeaders(mw.WithAuth(mw.WithRBAC(getTaskScheduleHandler(broker))))))
+
+	// swagger:route GET /api/v1/tasks/{taskId}/deliveries tasks getTaskDeliveries
+	//
+	// List the callback deliveries of a task.
+	//
+	// Retrieves the attempts to post a task to its callback_url, the last attempt first.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Parameters:
+	//     - +name: taskId
+	//       in: path
+	//       description: ID of the task
+	//       required: true
+	//       type: string
+	//
+	//     Responses:
+	//       200: Response
+	//       404: Response
+	r.mux.HandleFunc(
+		"GET /api/v1/tasks/{id}/deliveries",
+		mw.WithLogging(mw.WithCommonHeaders(mw.WithAuth(mw.WithRBAC(getTaskDeliveriesHandler(broker))))))
+
 	// swagger:route POST /api/v1/tasks tasks addTask
 	//
 	// Add a new task.
 	//
 	// This will create a new task that can be executed immediately or periodically.
+	// The payloads of the tasks of the processors registered only in the workers are not validated.
 	//
 	//     Consumes:
 	//     - application/json
@@ -100,6 +165,7 @@ func (r *Router) RegisterRoutes(config config.API, broker Broker) http.Handler {
 	// Update a task.
 	//
 	// Updates the details of an existing task by its ID.
+	// The payloads of the tasks of the processors registered only in the workers are not validated.
 	//
 	//     Consumes:
 	//     - application/json
@@ -150,5 +216,351 @@ func (r *Router) RegisterRoutes(config config.API, broker Broker) http.Handler {
 		"DELETE /api/v1/tasks/{id}",
 		mw.WithLogging(mw.WithCommonHeaders(mw.WithAuth(mw.WithRBAC(deleteTaskHandler(broker))))))
 
+	// swagger:route GET /api/v1/queues queues listQueues
+	//
+	// List queues.
+	//
+	// Retrieves the stats of all queues, the number of their tasks in each state,
+	// the age of their oldest pending task and their memory usage.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Responses:
+	//       200: Response
+	r.mux.HandleFunc(
+		"GET /api/v1/queues",
+		mw.WithLogging(mw.WithCommonHeaders(mw.WithAuth(mw.WithRBAC(getQueuesHandler(broker))))))
+
+	// swagger:route GET /api/v1/queues/{queue} queues getQueue
+	//
+	// Get a queue.
+	//
+	// Retrieves the stats of a queue, the number of its tasks in each state,
+	// the age of its oldest pending task and its memory usage.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Parameters:
+	//     - +name: queue
+	//       in: path
+	//       description: Name of the queue
+	//       required: true
+	//       type: string
+	//
+	//     Responses:
+	//       200: Response
+	//       404: Response
+	r.mux.HandleFunc(
+		"GET /api/v1/queues/{queue}",
+		mw.WithLogging(mw.WithCommonHeaders(mw.WithAuth(mw.WithRBAC(getQueueHandler(broker))))))
+
+	// swagger:route GET /api/v1/queues/{queue}/dead queues listDeadTasks
+	//
+	// List dead tasks.
+	//
+	// Retrieves the tasks of a queue which failed all their attempts, the last failed first, with pagination.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Parameters:
+	//     - +name: queue
+	//       in: path
+	//       description: Name of the queue
+	//       required: true
+	//       type: string
+	//     - +name: limit
+	//       in: query
+	//       description: Maximum number of tasks to return
+	//       required: false
+	//       type: integer
+	//       format: int32
+	//     - +name: offset
+	//       in: query
+	//       description: Offset to start returning tasks
+	//       required: false
+	//       type: integer
+	//       format: int32
+	//
+	//     Responses:
+	//       200: Response
+	r.mux.HandleFunc(
+		"GET /api/v1/queues/{queue}/dead",
+		mw.WithLogging(mw.WithCommonHeaders(mw.WithAuth(mw.WithRBAC(getDeadTasksHandler(broker))))))
+
+	// swagger:route POST /api/v1/queues/{queue}/dead/requeue queues requeueDeadTasks
+	//
+	// Requeue all dead tasks.
+	//
+	// Moves all dead tasks of a queue back to its pending tasks, resetting their attempts and error.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Parameters:
+	//     - +name: queue
+	//       in: path
+	//       description: Name of the queue
+	//       required: true
+	//       type: string
+	//
+	//     Responses:
+	//       200: Response
+	r.mux.HandleFunc(
+		"POST /api/v1/queues/{queue}/dead/requeue",
+		mw.WithLogging(mw.WithCommonHeaders(mw.WithAuth(mw.WithRBAC(requeueDeadTasksHandler(broker))))))
+
+	// swagger:route POST /api/v1/queues/{queue}/dead/{taskId}/requeue queues requeueDeadTask
+	//
+	// Requeue a dead task.
+	//
+	// Moves a dead task back to the pending tasks of its queue, resetting its attempts and error.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Parameters:
+	//     - +name: queue
+	//       in: path
+	//       description: Name of the queue
+	//       required: true
+	//       type: string
+	//     - +name: taskId
+	//       in: path
+	//       description: ID of the task to requeue
+	//       required: true
+	//       type: string
+	//
+	//     Responses:
+	//       200: Response
+	//       404: Response
+	r.mux.HandleFunc(
+		"POST /api/v1/queues/{queue}/dead/{id}/requeue",
+		mw.WithLogging(mw.WithCommonHeaders(mw.WithAuth(mw.WithRBAC(requeueDeadTaskHandler(broker))))))
+
+	// swagger:route DELETE /api/v1/queues/{queue}/dead queues purgeDeadTasks
+	//
+	// Purge dead tasks.
+	//
+	// Deletes the dead tasks of a queue, all of them or only the ones which failed before older_than.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Parameters:
+	//     - +name: queue
+	//       in: path
+	//       description: Name of the queue
+	//       required: true
+	//       type: string
+	//     - +name: older_than
+	//       in: query
+	//       description: Delete only the tasks which failed more than this duration ago (e.g., 24h)
+	//       required: false
+	//       type: string
+	//
+	//     Responses:
+	//       200: Response
+	r.mux.HandleFunc(
+		"DELETE /api/v1/queues/{queue}/dead",
+		mw.WithLogging(mw.WithCommonHeaders(mw.WithAuth(mw.WithRBAC(purgeDeadTasksHandler(broker))))))
+
+	// swagger:route GET /api/v1/events events streamEvents
+	//
+	// Stream task events.
+	//
+	// Streams the state transitions of the tasks (enqueued, started, retrying, succeeded, failed, deleted) as server-sent events.
+	// A reconnecting client resumes after the event in its Last-Event-ID header.
+	//
+	//     Produces:
+	//     - text/event-stream
+	//
+	//     Parameters:
+	//     - +name: task_id
+	//       in: query
+	//       description: Stream only the events of this task
+	//       required: false
+	//       type: string
+	//     - +name: name
+	//       in: query
+	//       description: Stream only the events of the tasks with this name
+	//       required: false
+	//       type: string
+	//     - +name: queue
+	//       in: query
+	//       description: Stream only the events of the tasks of this queue
+	//       required: false
+	//       type: string
+	//     - +name: last_event_id
+	//       in: query
+	//       description: Stream the events after this one instead of the events from now on
+	//       required: false
+	//       type: string
+	//
+	//     Responses:
+	//       200: description: A stream of taskEvent
+	r.mux.HandleFunc(
+		"GET /api/v1/events",
+		mw.WithLogging(mw.WithCommonHeaders(mw.WithAuth(mw.WithRBAC(getEventsHandler(broker))))))
+
+	// swagger:route GET /api/v1/events/ws events streamEventsWebsocket
+	//
+	// Stream task events over a websocket.
+	//
+	// Upgrades the connection to a websocket and sends the state transitions of the tasks as taskEvent JSON messages.
+	// The filters are the same as the ones of the server-sent events.
+	//
+	//     Parameters:
+	//     - +name: task_id
+	//       in: query
+	//       description: Stream only the events of this task
+	//       required: false
+	//       type: string
+	//     - +name: name
+	//       in: query
+	//       description: Stream only the events of the tasks with this name
+	//       required: false
+	//       type: string
+	//     - +name: queue
+	//       in: query
+	//       description: Stream only the events of the tasks of this queue
+	//       required: false
+	//       type: string
+	//     - +name: last_event_id
+	//       in: query
+	//       description: Stream the events after this one instead of the events from now on
+	//       required: false
+	//       type: string
+	//
+	//     Responses:
+	//       101: description: Switching to the websocket protocol
+	r.mux.HandleFunc(
+		"GET /api/v1/events/ws",
+		mw.WithLogging(mw.WithAuth(mw.WithRBAC(getEventsWebsocketHandler(broker)))))
+
+	// swagger:route GET /api/v1/workers workers listWorkers
+	//
+	// List workers.
+	//
+	// Retrieves the workers which are alive, the queues they consume and the tasks they are processing.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Responses:
+	//       200: Response
+	r.mux.HandleFunc(
+		"GET /api/v1/workers",
+		mw.WithLogging(mw.WithCommonHeaders(mw.WithAuth(mw.WithRBAC(getWorkersHandler(broker))))))
+
+	// swagger:route GET /api/v1/processors processors listProcessors
+	//
+	// List processors.
+	//
+	// Retrieves the task names which can be submitted, with the description, payload schema and default retry policy of their processors.
+	// The processors registered only in the workers are listed by name.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Responses:
+	//       200: Response
+	r.mux.HandleFunc(
+		"GET /api/v1/processors",
+		mw.WithLogging(mw.WithCommonHeaders(mw.WithAuth(mw.WithRBAC(getProcessorsHandler(config, broker))))))
+
+	// swagger:route GET /api/v1/admin/leader admin getLeader
+	//
+	// Get the leader.
+	//
+	// Retrieves the manager replica which runs the scheduler, all replicas serve the API.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Responses:
+	//       200: Response
+	//       404: Response
+	r.mux.HandleFunc(
+		"GET /api/v1/admin/leader",
+		mw.WithLogging(mw.WithCommonHeaders(mw.WithAuth(mw.WithRBAC(getLeaderHandler(broker))))))
+
+	migrations := newMigrations()
+
+	// swagger:route POST /api/v1/admin/migrations/encoding admin migrateEncoding
+	//
+	// Migrate the encoding of the tasks.
+	//
+	// Starts rewriting the tasks still stored in the legacy JSON encoding in the binary one in the background,
+	// poll GET /api/v1/admin/migrations/encoding for its progress.
+	// It can be run again safely, the tasks in the binary encoding are left alone.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Responses:
+	//       202: Response
+	//       409: Response
+	r.mux.HandleFunc(
+		"POST /api/v1/admin/migrations/encoding",
+		mw.WithLogging(mw.WithCommonHeaders(mw.WithAuth(mw.WithRBAC(migrateEncodingHandler(migrations, broker))))))
+
+	// swagger:route POST /api/v1/admin/migrations/encryption admin rotateEncryption
+	//
+	// Rotate the encryption of the tasks.
+	//
+	// Starts re-encrypting the payloads of the tasks which are not encrypted with the primary key, the first one in ENCRYPTION_KEYS,
+	// in the background, poll GET /api/v1/admin/migrations/encryption for its progress.
+	// The keys rotated out can be removed from ENCRYPTION_KEYS once it succeeded.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Responses:
+	//       202: Response
+	//       400: Response
+	//       409: Response
+	r.mux.HandleFunc(
+		"POST /api/v1/admin/migrations/encryption",
+		mw.WithLogging(mw.WithCommonHeaders(mw.WithAuth(mw.WithRBAC(rotateEncryptionHandler(config, migrations, broker))))))
+
+	// swagger:route GET /api/v1/admin/migrations/{kind} admin getMigration
+	//
+	// Get a migration.
+	//
+	// Retrieves the progress of the last migration of the kind, encoding or encryption, started by the manager replica.
+	//
+	//     Produces:
+	//     - application/json
+	//
+	//     Parameters:
+	//     - +name: kind
+	//       in: path
+	//       description: Kind of the migration, encoding or encryption
+	//       required: true
+	//       type: string
+	//
+	//     Responses:
+	//       200: Response
+	//       404: Response
+	r.mux.HandleFunc(
+		"GET /api/v1/admin/migrations/{kind}",
+		mw.WithLogging(mw.WithCommonHeaders(mw.WithAuth(mw.WithRBAC(getMigrationHandler(migrations))))))
+
+	// swagger:route GET /metrics metrics getMetrics
+	//
+	// Get metrics.
+	//
+	// Exposes the metrics of the manager in the Prometheus text format.
+	//
+	//     Produces:
+	//     - text/plain
+	//
+	//     Responses:
+	//       200: description: Metrics in the Prometheus text format
+	r.mux.Handle("GET /metrics", metrics.Handler())
+
 	return r.mux
 }
@@ -2,56 +2,134 @@ package manager
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
 	"time"
 
+	"github.com/engpetarmarinov/gotama/internal/base"
 	"github.com/engpetarmarinov/gotama/internal/config"
 	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/metrics"
+)
+
+const (
+	defaultTaskLease = 30 * time.Second
+	defaultLeaderTTL = 10 * time.Second
+	// schedulerTick is how often the scheduler runs and renews its leadership
+	schedulerTick = time.Second
 )
 
 type SchedulerBroker interface {
-	EnqueueScheduledTasks(ctx context.Context) error
+	EnqueueScheduledTasks(ctx context.Context, epoch int64) error
+	ReclaimExpiredLeases(ctx context.Context, lease time.Duration, epoch int64) error
+	AcquireLeadership(ctx context.Context, id string, ttl time.Duration) (int64, error)
+	ReleaseLeadership(ctx context.Context, id string) error
 }
 
+// scheduler enqueues the scheduled tasks and reclaims the expired leases.
+// Only the manager replica which holds the leadership schedules, the rest take over when its leadership expires.
 type scheduler struct {
 	ctx    context.Context
+	id     string
 	broker SchedulerBroker
 	config config.API
 	cancel context.CancelFunc
+	// started tells Shutdown whether there is a goroutine to wait for
+	started atomic.Bool
+	done    chan struct{}
 }
 
-func newScheduler(broker SchedulerBroker, config config.API) *scheduler {
+func newScheduler(id string, broker SchedulerBroker, config config.API) *scheduler {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &scheduler{
 		ctx:    ctx,
+		id:     id,
 		broker: broker,
 		config: config,
 		cancel: cancel,
+		done:   make(chan struct{}),
 	}
 }
 
 func (s *scheduler) Run() {
+	lease, err := config.GetPositiveDuration(s.config, "WORKER_TASK_LEASE", defaultTaskLease)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	leaderTTL, err := config.GetPositiveDuration(s.config, "MANAGER_LEADER_TTL", defaultLeaderTTL)
+	if err != nil {
+		panic(err.Error())
+	}
+	// the leadership would expire between the renewals otherwise
+	if leaderTTL <= schedulerTick {
+		panic(fmt.Sprintf("invalid MANAGER_LEADER_TTL: has to be longer than the scheduler tick of %s", schedulerTick))
+	}
+
+	s.started.Store(true)
 	go func() {
-		duration := time.Second
-		tick := time.Tick(duration)
-		logger.Info("scheduler started", "period", duration.String())
+		defer close(s.done)
+		tick := time.Tick(schedulerTick)
+		logger.Info("scheduler started", "period", schedulerTick.String(), "id", s.id)
+		var epoch int64
 		for {
 			select {
 			case <-s.ctx.Done():
 				logger.Info("scheduler goroutine received done")
 				return
 			case <-tick:
+				epoch = s.lead(epoch, leaderTTL)
+				if epoch == 0 {
+					continue
+				}
+
 				logger.Info("scheduler checking for scheduled tasks...")
-				err := s.broker.EnqueueScheduledTasks(s.ctx)
+				start := time.Now()
+				err := s.broker.EnqueueScheduledTasks(s.ctx, epoch)
 				if err != nil {
 					logger.Error("scheduler error during enqueueing scheduled tasks", "error", err)
+					metrics.SchedulerErrors.WithLabelValues("enqueue_scheduled_tasks").Inc()
 				}
+				err = s.broker.ReclaimExpiredLeases(s.ctx, lease, epoch)
+				if err != nil {
+					logger.Error("scheduler error during reclaiming expired leases", "error", err)
+					metrics.SchedulerErrors.WithLabelValues("reclaim_expired_leases").Inc()
+				}
+				metrics.SchedulerTickDuration.Observe(time.Since(start).Seconds())
 			}
 		}
 	}()
 }
 
+// lead acquires or renews the leadership of the replica.
+// It returns the epoch of the leadership, or 0 if another replica is the leader.
+func (s *scheduler) lead(epoch int64, ttl time.Duration) int64 {
+	newEpoch, err := s.broker.AcquireLeadership(s.ctx, s.id, ttl)
+	if errors.Is(err, base.ErrorNotLeader) {
+		if epoch != 0 {
+			logger.Warn("scheduler lost the leadership", "id", s.id, "epoch", epoch)
+		}
+		return 0
+	}
+	if err != nil {
+		// the leadership can still be held, but it cannot be told, so the scheduler stops until it is renewed
+		logger.Error("scheduler error during acquiring the leadership", "error", err)
+		metrics.SchedulerErrors.WithLabelValues("acquire_leadership").Inc()
+		return 0
+	}
+	if newEpoch != epoch {
+		logger.Info("scheduler elected as the leader", "id", s.id, "epoch", newEpoch)
+	}
+	return newEpoch
+}
+
 func (s *scheduler) Shutdown() error {
 	logger.Info("scheduler shutting down...")
 	s.cancel()
-	return nil
+	if s.started.Load() {
+		<-s.done
+	}
+	// let another replica take over right away instead of waiting for the leadership to expire
+	return s.broker.ReleaseLeadership(context.Background(), s.id)
 }
@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"context"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const namespace = "gotama"
+
+var (
+	TasksEnqueued = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_enqueued_total",
+		Help:      "The number of tasks enqueued, by task name and queue.",
+	}, []string{"name", "queue"})
+
+	TasksProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_processed_total",
+		Help:      "The number of tasks processed successfully, by task name and queue.",
+	}, []string{"name", "queue"})
+
+	TasksFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_failed_total",
+		Help:      "The number of task attempts which failed, by task name and queue.",
+	}, []string{"name", "queue"})
+
+	TasksRetried = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_retried_total",
+		Help:      "The number of failed tasks scheduled for a retry, by task name and queue.",
+	}, []string{"name", "queue"})
+
+	TasksDead = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_dead_total",
+		Help:      "The number of tasks moved to the dead letter queue after failing all their attempts, by task name and queue.",
+	}, []string{"name", "queue"})
+
+	ProcessingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "task_processing_duration_seconds",
+		Help:      "The time processors take to process a task, by task name, queue and result.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"name", "queue", "result"})
+
+	SchedulerTickDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scheduler_tick_duration_seconds",
+		Help:      "The time a scheduler tick takes to enqueue the due tasks and reclaim the expired leases.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	SchedulerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scheduler_errors_total",
+		Help:      "The number of scheduler errors, by operation.",
+	}, []string{"operation"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "The latency of the HTTP requests to the API, by method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+)
+
+const (
+	ResultSucceeded = "succeeded"
+	ResultFailed    = "failed"
+)
+
+// Handler returns the HTTP handler serving the metrics in the Prometheus format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+type QueueStatsBroker interface {
+	GetAllQueueStats(ctx context.Context) ([]*base.QueueStats, error)
+}
+
+// collectTimeout bounds how long collecting the queue depths can delay a scrape.
+const collectTimeout = 5 * time.Second
+
+// queueStatsMaxAge is how long the queue depths are served from the cache, the stats of all queues are too expensive
+// to read on every scrape, e.g. the redis broker samples the memory usage of every queue.
+const queueStatsMaxAge = 15 * time.Second
+
+var queueDepthDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "queue_depth"),
+	"The number of tasks of a queue, by queue and state.",
+	[]string{"queue", "state"}, nil,
+)
+
+// queueCollector reads the queue depths from the broker when the metrics are scraped,
+// at most once every queueStatsMaxAge.
+type queueCollector struct {
+	broker QueueStatsBroker
+
+	mu        sync.Mutex
+	queues    []*base.QueueStats
+	fetchedAt time.Time
+}
+
+// RegisterQueueCollector registers the queue depths of the broker to the metrics.
+func RegisterQueueCollector(broker QueueStatsBroker) error {
+	return prometheus.Register(&queueCollector{broker: broker})
+}
+
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+}
+
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	queues, err := c.queueStats()
+	if err != nil {
+		logger.Error("error collecting queue depths", "error", err)
+		return
+	}
+
+	for _, q := range queues {
+		for state, depth := range map[string]int64{
+			"pending":   q.Pending,
+			"running":   q.Running,
+			"retry":     q.Retry,
+			"failed":    q.Failed,
+			"scheduled": q.Scheduled,
+			"cron":      q.Cron,
+			"delayed":   q.Delayed,
+		} {
+			ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(depth), q.Queue, state)
+		}
+	}
+}
+
+// queueStats returns the cached stats of the queues, read again from the broker once they are older than queueStatsMaxAge.
+// Concurrent scrapes wait for a single read.
+func (c *queueCollector) queueStats() ([]*base.QueueStats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.queues != nil && time.Since(c.fetchedAt) < queueStatsMaxAge {
+		return c.queues, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+	queues, err := c.broker.GetAllQueueStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.queues = queues
+	c.fetchedAt = time.Now()
+	return queues, nil
+}
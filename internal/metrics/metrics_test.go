@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"context"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/prometheus/client_golang/prometheus"
+	"testing"
+)
+
+type countingBroker struct {
+	calls int
+}
+
+func (b *countingBroker) GetAllQueueStats(context.Context) ([]*base.QueueStats, error) {
+	b.calls++
+	return []*base.QueueStats{{Queue: "default", Pending: 3}}, nil
+}
+
+func TestQueueCollectorCachesStats(t *testing.T) {
+	broker := &countingBroker{}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&queueCollector{broker: broker})
+
+	for range 3 {
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(families) != 1 || len(families[0].GetMetric()) != 7 {
+			t.Fatalf("expected the 7 queue depths of the queue, got %v", families)
+		}
+	}
+	if broker.calls != 1 {
+		t.Errorf("expected the stats to be read once, got %d reads", broker.calls)
+	}
+}
@@ -1,8 +1,13 @@
 package middleware
 
 import (
+	"bufio"
+	"errors"
 	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/metrics"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -11,12 +16,58 @@ func WithLogging(next http.HandlerFunc) http.HandlerFunc {
 		start := time.Now()
 		uri := r.RequestURI
 		method := r.Method
-		next.ServeHTTP(rw, r)
+		srw := &statusResponseWriter{ResponseWriter: rw, code: http.StatusOK}
+		next.ServeHTTP(srw, r)
 		duration := time.Since(start)
+		metrics.HTTPRequestDuration.WithLabelValues(method, strconv.Itoa(srw.code)).Observe(duration.Seconds())
 
 		logger.Info("Response",
 			"method", method,
 			"uri", uri,
+			"code", srw.code,
 			"duration", duration)
 	}
 }
+
+// statusResponseWriter records the status code written to the response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (w *statusResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.code = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+// Hijack lets the handler take over the connection, e.g. to upgrade it to a websocket.
+func (w *statusResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	w.code = http.StatusSwitchingProtocols
+	w.wroteHeader = true
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush it.
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,46 @@
+package processors
+
+import (
+	"context"
+	"errors"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/task"
+)
+
+// DeliveryRecorder records an attempt to deliver a callback in the delivery log of its task.
+type DeliveryRecorder func(ctx context.Context, delivery *base.Delivery) error
+
+type deliveryRecorderKey struct{}
+
+// WithDeliveryRecorder returns a copy of ctx which lets processors record their callback deliveries.
+func WithDeliveryRecorder(ctx context.Context, record DeliveryRecorder) context.Context {
+	return context.WithValue(ctx, deliveryRecorderKey{}, record)
+}
+
+// RecordDelivery records the attempt to deliver a callback within ctx.
+func RecordDelivery(ctx context.Context, delivery *base.Delivery) error {
+	record, ok := ctx.Value(deliveryRecorderKey{}).(DeliveryRecorder)
+	if !ok {
+		return errors.New("no delivery recorder in context")
+	}
+	return record(ctx, delivery)
+}
+
+// TaskGetter returns the task with the given ID.
+type TaskGetter func(ctx context.Context, id string) (*task.Message, error)
+
+type taskGetterKey struct{}
+
+// WithTaskGetter returns a copy of ctx which lets processors read other tasks, e.g. the task a webhook reports on.
+func WithTaskGetter(ctx context.Context, get TaskGetter) context.Context {
+	return context.WithValue(ctx, taskGetterKey{}, get)
+}
+
+// GetTask returns the task with the given ID within ctx.
+func GetTask(ctx context.Context, id string) (*task.Message, error) {
+	get, ok := ctx.Value(taskGetterKey{}).(TaskGetter)
+	if !ok {
+		return nil, errors.New("no task getter in context")
+	}
+	return get(ctx, id)
+}
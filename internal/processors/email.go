@@ -12,6 +12,8 @@ import (
 	"github.com/engpetarmarinov/gotama/internal/config"
 	"github.com/engpetarmarinov/gotama/internal/logger"
 	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/engpetarmarinov/gotama/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
 	"net/mail"
 )
 
@@ -23,6 +25,20 @@ type EmailPayload struct {
 	Body  string `json:"body"`
 }
 
+// NameEmail is the name of the tasks processed by the EmailProcessor.
+const NameEmail = "EMAIL"
+
+func init() {
+	Register(Registration{
+		Name:          NameEmail,
+		Description:   "Sends an email with AWS SES.",
+		PayloadSchema: json.RawMessage(`{"type":"object","properties":{"to":{"type":"string","format":"email"},"title":{"type":"string"},"body":{"type":"string"}},"required":["to","title","body"]}`),
+		New: func(config config.API) Processor {
+			return NewEmailProcessor(config)
+		},
+	})
+}
+
 func NewEmailProcessor(config config.API) *EmailProcessor {
 	return &EmailProcessor{
 		config: config,
@@ -68,7 +84,9 @@ func (ep *EmailProcessor) ProcessTask(ctx context.Context, msg *task.Message) er
 
 	logger.Info("Sending an email", "to", payload.To, "title", payload.Title, "body", payload.Body)
 
-	output, err := client.SendEmail(ctx, input)
+	sendCtx, span := tracing.Start(ctx, "SES SendEmail", trace.WithSpanKind(trace.SpanKindClient))
+	output, err := client.SendEmail(sendCtx, input)
+	tracing.End(span, err)
 	if err != nil {
 		return fmt.Errorf("error sending an email %w", err)
 	}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/config"
 	"github.com/engpetarmarinov/gotama/internal/logger"
 	"github.com/engpetarmarinov/gotama/internal/task"
 	"math/rand"
@@ -52,6 +53,20 @@ func (ep *FooProcessor) ValidatePayload(payload []byte) error {
 	return nil
 }
 
+// NameFoo is the name of the tasks processed by the FooProcessor.
+const NameFoo = "FOO"
+
+func init() {
+	Register(Registration{
+		Name:          NameFoo,
+		Description:   "Simulates a task which does some work and fails.",
+		PayloadSchema: json.RawMessage(`{"type":"object","properties":{"bar":{"type":"string"},"baz":{"type":"string"}},"required":["bar","baz"]}`),
+		New: func(_ config.API) Processor {
+			return NewFooProcessor()
+		},
+	})
+}
+
 func NewFooProcessor() *FooProcessor {
 	return &FooProcessor{}
 }
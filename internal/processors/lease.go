@@ -0,0 +1,28 @@
+package processors
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// LeaseExtender extends the lease the worker holds on the task being processed.
+type LeaseExtender func(ctx context.Context, lease time.Duration) error
+
+type leaseExtenderKey struct{}
+
+// WithLeaseExtender returns a copy of ctx which lets processors extend the lease of their task.
+func WithLeaseExtender(ctx context.Context, extend LeaseExtender) context.Context {
+	return context.WithValue(ctx, leaseExtenderKey{}, extend)
+}
+
+// ExtendLease extends the lease of the task processed within ctx by the given duration from now.
+// Processors that run longer than WORKER_TASK_LEASE should call it periodically,
+// otherwise the manager considers the worker dead and hands the task to another worker.
+func ExtendLease(ctx context.Context, lease time.Duration) error {
+	extend, ok := ctx.Value(leaseExtenderKey{}).(LeaseExtender)
+	if !ok {
+		return errors.New("no lease in context")
+	}
+	return extend(ctx, lease)
+}
@@ -3,6 +3,7 @@ package processors
 import (
 	"context"
 	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
 	"github.com/engpetarmarinov/gotama/internal/config"
 	"github.com/engpetarmarinov/gotama/internal/task"
 )
@@ -12,17 +13,26 @@ type Processor interface {
 	ValidatePayload(payload []byte) error
 }
 
-func ProcessorFactory(config config.API, name task.Name) (Processor, error) {
-	switch name {
-	case task.NameEmail:
-		return NewEmailProcessor(config), nil
-	case task.NameSMS:
-		return NewSMSProcessor(config), nil
-	case task.NameSlack:
-		return NewSlackProcessor(config), nil
-	case task.NameFoo:
-		return NewFooProcessor(), nil
-	default:
-		return nil, fmt.Errorf("unknown processor type for %s", name.String())
+// NewProcessor returns the processor registered for the tasks with the given name.
+func NewProcessor(config config.API, name string) (Processor, error) {
+	reg, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: no processor for %s", base.ErrorUnknownTaskName, name)
 	}
+	return reg.New(config), nil
+}
+
+// DefaultRetryPolicy returns the retry policy of the tasks with the given name from the RETRY_POLICY_<NAME> config,
+// e.g. RETRY_POLICY_SLACK=max_attempts=5,backoff=exponential,retry_after=30s,max_delay=10m.
+// The fields it does not set are taken from the retry policy the processor is registered with.
+// It returns nil if no policy is configured or registered for the name.
+func DefaultRetryPolicy(config config.API, name string) (*task.RetryPolicy, error) {
+	name = task.NormalizeName(name)
+	key := "RETRY_POLICY_" + name
+	policy, err := task.ParseRetryPolicy(config.Get(key))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", key, err)
+	}
+	reg, _ := Lookup(name)
+	return policy.Merge(reg.RetryPolicy), nil
 }
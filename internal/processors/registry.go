@@ -0,0 +1,69 @@
+package processors
+
+import (
+	"encoding/json"
+	"github.com/engpetarmarinov/gotama/internal/config"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"sort"
+	"sync"
+)
+
+// Registration describes the processor of the tasks with a name.
+type Registration struct {
+	// Name of the tasks the processor handles, names are case-insensitive and stored upper-cased, e.g. EMAIL.
+	Name string
+	// Description of what the tasks do.
+	Description string
+	// PayloadSchema is the JSON schema of the payload of the tasks, for documentation.
+	// The payload is validated by Processor.ValidatePayload.
+	PayloadSchema json.RawMessage
+	// RetryPolicy is the default retry policy of the tasks, the RETRY_POLICY_<NAME> config overrides its fields.
+	RetryPolicy *task.RetryPolicy
+	// New returns the processor of the tasks.
+	New func(config config.API) Processor
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Registration)
+)
+
+// Register makes a processor available under its name. It is meant to be called from the init function
+// of the package of the processor, which the worker and the manager import for its side effects.
+// It panics if the name is empty or already registered, or New is nil.
+func Register(reg Registration) {
+	reg.Name = task.NormalizeName(reg.Name)
+	if reg.Name == "" {
+		panic("processors: Register with an empty name")
+	}
+	if reg.New == nil {
+		panic("processors: Register " + reg.Name + " without New")
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[reg.Name]; ok {
+		panic("processors: Register called twice for " + reg.Name)
+	}
+	registry[reg.Name] = reg
+}
+
+// Lookup returns the registration of the processor of the tasks with the name.
+func Lookup(name string) (Registration, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	reg, ok := registry[task.NormalizeName(name)]
+	return reg, ok
+}
+
+// Registered returns the registrations of all processors, sorted by name.
+func Registered() []Registration {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	regs := make([]Registration, 0, len(registry))
+	for _, reg := range registry {
+		regs = append(regs, reg)
+	}
+	sort.Slice(regs, func(i, j int) bool { return regs[i].Name < regs[j].Name })
+	return regs
+}
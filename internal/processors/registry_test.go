@@ -0,0 +1,74 @@
+package processors
+
+import (
+	"context"
+	"errors"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/config"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"testing"
+	"time"
+)
+
+type echoProcessor struct{}
+
+func (p *echoProcessor) ProcessTask(context.Context, *task.Message) error {
+	return nil
+}
+
+func (p *echoProcessor) ValidatePayload([]byte) error {
+	return nil
+}
+
+func init() {
+	Register(Registration{
+		Name:        " Echo ",
+		Description: "Does nothing.",
+		RetryPolicy: &task.RetryPolicy{MaxAttempts: 7, RetryAfter: time.Minute},
+		New: func(config.API) Processor {
+			return &echoProcessor{}
+		},
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	if _, ok := Lookup("echo"); !ok {
+		t.Fatal("expected the processor to be registered case-insensitively")
+	}
+	if _, err := NewProcessor(config.NewConfig(), "ECHO"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewProcessor(config.NewConfig(), "nope"); !errors.Is(err, base.ErrorUnknownTaskName) {
+		t.Errorf("expected ErrorUnknownTaskName, got %v", err)
+	}
+
+	var names []string
+	for _, reg := range Registered() {
+		names = append(names, reg.Name)
+	}
+	want := []string{"ECHO", NameEmail, NameFoo, NameSlack, NameSMS, NameWebhook}
+	if len(names) != len(want) {
+		t.Fatalf("expected %v registered, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("expected %v registered, got %v", want, names)
+		}
+	}
+
+	t.Setenv("RETRY_POLICY_ECHO", "max_attempts=3")
+	policy, err := DefaultRetryPolicy(config.NewConfig(), "echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.MaxAttempts != 3 || policy.RetryAfter != time.Minute {
+		t.Errorf("expected the config to override the registered policy, got %+v", policy)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a name twice to panic")
+		}
+	}()
+	Register(Registration{Name: "echo", New: func(config.API) Processor { return &echoProcessor{} }})
+}
@@ -8,7 +8,9 @@ import (
 	"github.com/engpetarmarinov/gotama/internal/config"
 	"github.com/engpetarmarinov/gotama/internal/logger"
 	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/engpetarmarinov/gotama/internal/tracing"
 	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type SlackPayload struct {
@@ -20,6 +22,20 @@ type SlackProcessor struct {
 	config config.API
 }
 
+// NameSlack is the name of the tasks processed by the SlackProcessor.
+const NameSlack = "SLACK"
+
+func init() {
+	Register(Registration{
+		Name:          NameSlack,
+		Description:   "Posts a message to a Slack channel.",
+		PayloadSchema: json.RawMessage(`{"type":"object","properties":{"channel":{"type":"string"},"text":{"type":"string"}},"required":["channel","text"]}`),
+		New: func(config config.API) Processor {
+			return NewSlackProcessor(config)
+		},
+	})
+}
+
 func NewSlackProcessor(config config.API) *SlackProcessor {
 	return &SlackProcessor{
 		config: config,
@@ -35,7 +51,9 @@ func (sp *SlackProcessor) ProcessTask(ctx context.Context, msg *task.Message) er
 	token := sp.config.Get("SLACK_TOKEN")
 	client := slack.New(token)
 	logger.Info("Sending Slack", "channel", p.Channel, "text", p.Text)
-	channel, timestamp, err := client.PostMessage(p.Channel, slack.MsgOptionText(p.Text, true))
+	postCtx, span := tracing.Start(ctx, "Slack PostMessage", trace.WithSpanKind(trace.SpanKindClient))
+	channel, timestamp, err := client.PostMessageContext(postCtx, p.Channel, slack.MsgOptionText(p.Text, true))
+	tracing.End(span, err)
 	if err != nil {
 		return fmt.Errorf("error sending slack message: %w", err)
 	}
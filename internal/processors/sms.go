@@ -11,6 +11,8 @@ import (
 	"github.com/engpetarmarinov/gotama/internal/config"
 	"github.com/engpetarmarinov/gotama/internal/logger"
 	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/engpetarmarinov/gotama/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
 	"regexp"
 )
 
@@ -25,6 +27,20 @@ type SMSProcessor struct {
 	config config.API
 }
 
+// NameSMS is the name of the tasks processed by the SMSProcessor.
+const NameSMS = "SMS"
+
+func init() {
+	Register(Registration{
+		Name:          NameSMS,
+		Description:   "Sends a text message with AWS SNS.",
+		PayloadSchema: json.RawMessage(`{"type":"object","properties":{"phone":{"type":"string"},"text":{"type":"string"}},"required":["phone","text"]}`),
+		New: func(config config.API) Processor {
+			return NewSMSProcessor(config)
+		},
+	})
+}
+
 func NewSMSProcessor(config config.API) *SMSProcessor {
 	return &SMSProcessor{
 		config: config,
@@ -52,7 +68,9 @@ func (sp *SMSProcessor) ProcessTask(ctx context.Context, msg *task.Message) erro
 	logger.Info("Sending SMS", "phone", p.Phone, "text", p.Text)
 
 	// Send direct SMS without SNS topic
-	output, err := client.Publish(ctx, input)
+	publishCtx, span := tracing.Start(ctx, "SNS Publish", trace.WithSpanKind(trace.SpanKindClient))
+	output, err := client.Publish(publishCtx, input)
+	tracing.End(span, err)
 	if err != nil {
 		return fmt.Errorf("error publishing to sns %w", err)
 	}
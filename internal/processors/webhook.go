@@ -0,0 +1,340 @@
+package processors
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/config"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/engpetarmarinov/gotama/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	WebhookEventSucceeded = "task.succeeded"
+	WebhookEventDead      = "task.dead"
+)
+
+const (
+	WebhookEventHeader     = "X-Gotama-Event"
+	WebhookDeliveryHeader  = "X-Gotama-Delivery"
+	WebhookTimestampHeader = "X-Gotama-Timestamp"
+	WebhookSignatureHeader = "X-Gotama-Signature"
+)
+
+const defaultWebhookTimeout = 10 * time.Second
+
+// maxWebhookRedirects is the number of redirects a webhook follows, like the default of net/http.
+const maxWebhookRedirects = 10
+
+// blockedPrefixes are the ranges the webhooks cannot reach besides the private, loopback and link-local ones.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+// webhookTransport connects only to public addresses, so a callback URL cannot reach the services next to the workers.
+// The address is checked when it is dialed, after the host is resolved, which covers the hosts resolving to private
+// addresses and the redirects to them as well. The webhooks are not sent through a proxy, which would dial instead.
+var webhookTransport = func() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if addr := addrPort.Addr().Unmap(); isPrivateAddr(addr) {
+				return fmt.Errorf("callback address %s is not public", addr)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}()
+
+func isPrivateAddr(addr netip.Addr) bool {
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() || addr.IsMulticast() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookPayload is the payload of a webhook task. It carries no callback secret, since the payloads are returned
+// by the API, the secret is kept in the callback of the webhook task, which the API does not return.
+type WebhookPayload struct {
+	URL    string          `json:"url"`
+	Event  string          `json:"event"`
+	TaskID string          `json:"task_id"`
+	Body   json.RawMessage `json:"body"`
+}
+
+type WebhookProcessor struct {
+	config config.API
+	client *http.Client
+	// allowedHosts are the hosts of WEBHOOK_ALLOWED_HOSTS, e.g. example.com or *.example.com, any host is allowed if empty
+	allowedHosts []string
+}
+
+// NameWebhook is the name of the tasks processed by the WebhookProcessor.
+const NameWebhook = "WEBHOOK"
+
+func init() {
+	Register(Registration{
+		Name:          NameWebhook,
+		Description:   "Posts the outcome of a task to its callback URL, enqueued by gotama itself.",
+		PayloadSchema: json.RawMessage(`{"type":"object","properties":{"url":{"type":"string","format":"uri"},"event":{"type":"string"},"task_id":{"type":"string"},"body":{"type":"object"}},"required":["url","event","task_id","body"]}`),
+		New: func(config config.API) Processor {
+			return NewWebhookProcessor(config)
+		},
+	})
+}
+
+// NewWebhookProcessor returns a WebhookProcessor which posts only to the hosts of WEBHOOK_ALLOWED_HOSTS, if it is set,
+// and only to public addresses, unless WEBHOOK_ALLOW_PRIVATE_NETWORKS is true.
+func NewWebhookProcessor(config config.API) *WebhookProcessor {
+	wp := &WebhookProcessor{
+		config: config,
+	}
+	for _, host := range strings.Split(config.Get("WEBHOOK_ALLOWED_HOSTS"), ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			wp.allowedHosts = append(wp.allowedHosts, host)
+		}
+	}
+
+	var transport http.RoundTripper = webhookTransport
+	if config.Get("WEBHOOK_ALLOW_PRIVATE_NETWORKS") == "true" {
+		transport = http.DefaultTransport
+	}
+	wp.client = &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxWebhookRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxWebhookRedirects)
+			}
+			return wp.checkHost(req.URL)
+		},
+	}
+	return wp
+}
+
+// checkHost returns an error if the host of u is not one of the allowed hosts.
+func (wp *WebhookProcessor) checkHost(u *url.URL) error {
+	if len(wp.allowedHosts) == 0 {
+		return nil
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range wp.allowedHosts {
+		if host == allowed {
+			return nil
+		}
+		if suffix, ok := strings.CutPrefix(allowed, "*"); ok && strings.HasSuffix(host, suffix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("callback host %s is not allowed", host)
+}
+
+// NewWebhookMessage returns the task which posts msg to its callback URL, event tells the outcome of msg.
+// The webhook task goes to the queue of msg and is retried by the RETRY_POLICY_WEBHOOK policy.
+// The callback secret is copied to the webhook task, so the webhook is signed even if msg is deleted in the meantime.
+func NewWebhookMessage(config config.API, msg *task.Message, event string) (*task.Message, error) {
+	if msg.Callback == nil {
+		return nil, errors.New("task has no callback")
+	}
+
+	resp, err := task.NewResponseFromMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(WebhookPayload{
+		URL:    msg.Callback.URL,
+		Event:  event,
+		TaskID: msg.ID,
+		Body:   body,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	webhookMsg, err := task.NewMessageFromRequest(&task.Request{
+		Name:    NameWebhook,
+		Type:    task.TypeOnce.String(),
+		Queue:   msg.Queue,
+		Payload: payload,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	retryPolicy, err := DefaultRetryPolicy(config, NameWebhook)
+	if err != nil {
+		return nil, err
+	}
+	webhookMsg.RetryPolicy = retryPolicy
+	// the callback of a webhook task only holds the secret, a webhook is not reported on by another one
+	webhookMsg.Callback = &task.Callback{Secret: msg.Callback.Secret}
+	return webhookMsg, nil
+}
+
+// SignWebhook returns the X-Gotama-Signature of a webhook, the hex encoded HMAC-SHA256 of "<timestamp>.<body>".
+// Receivers recompute it with the callback secret to verify the webhook, and reject old timestamps against replays.
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (wp *WebhookProcessor) ProcessTask(ctx context.Context, msg *task.Message) error {
+	var p WebhookPayload
+	if err := json.Unmarshal(msg.Payload, &p); err != nil {
+		return fmt.Errorf("error unmarshalling webhook payload %w", err)
+	}
+
+	timeout, err := config.GetDuration(wp.config, "WEBHOOK_TIMEOUT", defaultWebhookTimeout)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Delivering webhook", "task_id", p.TaskID, "event", p.Event, "url", p.URL)
+	start := time.Now()
+	var statusCode int
+	secret, err := wp.secret(ctx, msg, p.TaskID)
+	if err == nil {
+		postCtx, span := tracing.Start(ctx, "Webhook POST", trace.WithSpanKind(trace.SpanKindClient))
+		postCtx, cancel := context.WithTimeout(postCtx, timeout)
+		statusCode, err = wp.post(postCtx, msg.ID, secret, &p)
+		cancel()
+		tracing.End(span, err)
+	}
+
+	delivery := &base.Delivery{
+		ID:         msg.ID,
+		TaskID:     p.TaskID,
+		Event:      p.Event,
+		URL:        p.URL,
+		Attempt:    msg.NumRetries + 1,
+		StatusCode: statusCode,
+		DurationMs: time.Since(start).Milliseconds(),
+		Time:       start.UTC().Format(time.RFC3339),
+	}
+	if err != nil {
+		errStr := err.Error()
+		delivery.Error = &errStr
+	}
+	if recordErr := RecordDelivery(ctx, delivery); recordErr != nil {
+		logger.Warn("error recording webhook delivery", "task_id", p.TaskID, "error", recordErr)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error delivering webhook: %w", err)
+	}
+
+	logger.Info("Delivered webhook", "task_id", p.TaskID, "event", p.Event, "url", p.URL, "status", statusCode)
+
+	return nil
+}
+
+// secret returns the secret the webhook is signed with. The webhook tasks enqueued before the secret was copied
+// to them read it from the task they report on, which fails if the task was deleted.
+func (wp *WebhookProcessor) secret(ctx context.Context, msg *task.Message, taskID string) (string, error) {
+	if msg.Callback != nil {
+		return msg.Callback.Secret, nil
+	}
+	reported, err := GetTask(ctx, taskID)
+	if err != nil {
+		return "", fmt.Errorf("error getting the task of the webhook %w", err)
+	}
+	if reported.Callback == nil {
+		return "", nil
+	}
+	return reported.Callback.Secret, nil
+}
+
+// post sends the webhook, signed with secret if there is one, and returns the status code of the response, 0 if there was none.
+func (wp *WebhookProcessor) post(ctx context.Context, deliveryID string, secret string, p *WebhookPayload) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(p.Body))
+	if err != nil {
+		return 0, err
+	}
+	if err := wp.checkHost(req.URL); err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gotama-webhook")
+	req.Header.Set(WebhookEventHeader, p.Event)
+	req.Header.Set(WebhookDeliveryHeader, deliveryID)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	if secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(secret, timestamp, p.Body))
+	}
+
+	resp, err := wp.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// drain the response, so the connection is reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (wp *WebhookProcessor) ValidatePayload(payload []byte) error {
+	var p WebhookPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+
+	if len(p.URL) <= 0 || len(p.Event) <= 0 || len(p.TaskID) <= 0 {
+		return errors.New("invalid payload: url, event and task_id are required fields")
+	}
+
+	u, err := url.ParseRequestURI(p.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("invalid payload: url has to be an absolute http or https URL")
+	}
+
+	if !json.Valid(p.Body) {
+		return errors.New("invalid payload: body has to be valid JSON")
+	}
+
+	return nil
+}
@@ -0,0 +1,246 @@
+package processors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/config"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type testConfig map[string]string
+
+func (c testConfig) Get(key string) string {
+	return c[key]
+}
+
+// privateNetworks lets the webhooks reach the test servers, which listen on the loopback address.
+var privateNetworks = testConfig{"WEBHOOK_ALLOW_PRIVATE_NETWORKS": "true"}
+
+func TestWebhookProcessor(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	var gotBody []byte
+	var gotHeader http.Header
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		gotHeader = r.Header
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	msg, err := task.NewMessageFromRequest(&task.Request{
+		Name:           "email",
+		Type:           "once",
+		Payload:        []byte(`{"to":"test@example.com"}`),
+		CallbackURL:    server.URL,
+		CallbackSecret: "s3cr3t",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhookMsg, err := NewWebhookMessage(config.NewConfig(), msg, WebhookEventSucceeded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var deliveries []*base.Delivery
+	ctx := WithDeliveryRecorder(context.Background(), func(ctx context.Context, delivery *base.Delivery) error {
+		deliveries = append(deliveries, delivery)
+		return nil
+	})
+	// the task the webhook reports on was deleted, the webhook is still signed with the secret copied to it
+	ctx = WithTaskGetter(ctx, func(ctx context.Context, id string) (*task.Message, error) {
+		return nil, errors.New("task not found")
+	})
+	wp := NewWebhookProcessor(privateNetworks)
+	if err := wp.ValidatePayload(webhookMsg.Payload); err != nil {
+		t.Fatalf("expected a valid payload, got %v", err)
+	}
+	if err := wp.ProcessTask(ctx, webhookMsg); err != nil {
+		t.Fatal(err)
+	}
+
+	var resp task.Response
+	if err := json.Unmarshal(gotBody, &resp); err != nil || resp.ID != msg.ID {
+		t.Fatalf("expected the task to be posted, got %s", gotBody)
+	}
+	if got := gotHeader.Get(WebhookEventHeader); got != WebhookEventSucceeded {
+		t.Errorf("expected event %s, got %s", WebhookEventSucceeded, got)
+	}
+	want := SignWebhook("s3cr3t", gotHeader.Get(WebhookTimestampHeader), gotBody)
+	if got := gotHeader.Get(WebhookSignatureHeader); got != want {
+		t.Errorf("expected signature %s, got %s", want, got)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := wp.ProcessTask(ctx, webhookMsg); err == nil {
+		t.Fatal("expected an error on a non-2xx response")
+	}
+
+	if len(deliveries) != 2 {
+		t.Fatalf("expected 2 deliveries, got %d", len(deliveries))
+	}
+	if deliveries[0].TaskID != msg.ID || deliveries[0].StatusCode != http.StatusOK || deliveries[0].Error != nil {
+		t.Errorf("unexpected first delivery %+v", deliveries[0])
+	}
+	if deliveries[1].StatusCode != http.StatusServiceUnavailable || deliveries[1].Error == nil {
+		t.Errorf("unexpected second delivery %+v", deliveries[1])
+	}
+}
+
+func TestWebhookMessageHidesSecret(t *testing.T) {
+	msg, err := task.NewMessageFromRequest(&task.Request{
+		Name:           "email",
+		Type:           "once",
+		Payload:        []byte(`{"to":"test@example.com"}`),
+		CallbackURL:    "https://example.com/hook",
+		CallbackSecret: "s3cr3t",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhookMsg, err := NewWebhookMessage(config.NewConfig(), msg, WebhookEventSucceeded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range []*task.Message{msg, webhookMsg} {
+		resp, err := task.NewResponseFromMessage(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded, err := json.Marshal(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(encoded), "s3cr3t") {
+			t.Errorf("expected the response of task %s not to contain the secret, got %s", m.Name, encoded)
+		}
+	}
+}
+
+func TestWebhookLegacySecretOfDeletedTask(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	var called bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	msg, err := task.NewMessageFromRequest(&task.Request{Name: "email", Type: "once", Payload: []byte(`{}`), CallbackURL: server.URL, CallbackSecret: "s3cr3t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhookMsg, err := NewWebhookMessage(config.NewConfig(), msg, WebhookEventDead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the webhook task was enqueued before the secret was copied to it
+	webhookMsg.Callback = nil
+
+	var deliveries []*base.Delivery
+	ctx := WithDeliveryRecorder(context.Background(), func(ctx context.Context, delivery *base.Delivery) error {
+		deliveries = append(deliveries, delivery)
+		return nil
+	})
+	ctx = WithTaskGetter(ctx, func(ctx context.Context, id string) (*task.Message, error) {
+		return nil, errors.New("task not found")
+	})
+	if err := NewWebhookProcessor(privateNetworks).ProcessTask(ctx, webhookMsg); err == nil {
+		t.Fatal("expected an error without the secret")
+	}
+	if called {
+		t.Error("expected the webhook not to be posted unsigned")
+	}
+	if len(deliveries) != 1 || deliveries[0].Error == nil {
+		t.Errorf("expected the failed delivery to be recorded, got %+v", deliveries)
+	}
+}
+
+func TestWebhookPrivateNetworks(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	var called bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	msg, err := task.NewMessageFromRequest(&task.Request{Name: "email", Type: "once", Payload: []byte(`{}`), CallbackURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhookMsg, err := NewWebhookMessage(config.NewConfig(), msg, WebhookEventSucceeded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var deliveries []*base.Delivery
+	ctx := WithDeliveryRecorder(context.Background(), func(ctx context.Context, delivery *base.Delivery) error {
+		deliveries = append(deliveries, delivery)
+		return nil
+	})
+	if err := NewWebhookProcessor(testConfig{}).ProcessTask(ctx, webhookMsg); err == nil || !strings.Contains(err.Error(), "not public") {
+		t.Errorf("expected the loopback address to be refused, got %v", err)
+	}
+	if called {
+		t.Error("expected the webhook not to reach the loopback address")
+	}
+	if len(deliveries) != 1 || deliveries[0].Error == nil {
+		t.Errorf("expected the refused delivery to be recorded, got %+v", deliveries)
+	}
+}
+
+func TestWebhookAllowedHosts(t *testing.T) {
+	wp := NewWebhookProcessor(testConfig{"WEBHOOK_ALLOWED_HOSTS": "hooks.example.com, *.example.org"})
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://hooks.example.com/gotama", true},
+		{"https://HOOKS.example.com:8443/gotama", true},
+		{"https://api.example.org/gotama", true},
+		{"https://example.com/gotama", false},
+		{"https://hooks.example.com.evil.com/gotama", false},
+		{"https://example.org.evil.com/gotama", false},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := wp.checkHost(u); (err == nil) != tt.allowed {
+			t.Errorf("expected %s to be allowed %v, got %v", tt.url, tt.allowed, err)
+		}
+	}
+}
+
+func TestIsPrivateAddr(t *testing.T) {
+	for addr, private := range map[string]bool{
+		"127.0.0.1":       true,
+		"10.1.2.3":        true,
+		"172.16.0.1":      true,
+		"192.168.1.1":     true,
+		"169.254.169.254": true,
+		"100.64.0.1":      true,
+		"0.0.0.0":         true,
+		"::1":             true,
+		"fd00::1":         true,
+		"fe80::1":         true,
+		"93.184.216.34":   false,
+		"2606:4700::1111": false,
+	} {
+		if got := isPrivateAddr(netip.MustParseAddr(addr)); got != private {
+			t.Errorf("expected %s to be private %v, got %v", addr, private, got)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package task
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// Callback is where the outcome of a task is posted once it succeeds or ends in the dead letter queue.
+type Callback struct {
+	URL string
+	// Secret signs the deliveries, so the receiver can verify they come from gotama
+	Secret string
+}
+
+// NewCallbackFromRequest returns the callback of the requested task, nil if it has none.
+func NewCallbackFromRequest(req *Request) (*Callback, error) {
+	callbackURL := strings.TrimSpace(req.CallbackURL)
+	if callbackURL == "" {
+		if req.CallbackSecret != "" {
+			return nil, errors.New("callback_secret is applicable only to tasks with a callback_url")
+		}
+		return nil, nil
+	}
+
+	u, err := url.ParseRequestURI(callbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("callback_url has to be an absolute http or https URL")
+	}
+
+	return &Callback{
+		URL:    callbackURL,
+		Secret: req.CallbackSecret,
+	}, nil
+}
@@ -0,0 +1,430 @@
+package task
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/encryption"
+	"google.golang.org/protobuf/encoding/protowire"
+	"time"
+)
+
+// encodingVersion is the first byte of an encoded message, it tells the schema the rest is encoded with.
+// The legacy JSON encoding has no version byte, it starts with '{'.
+const encodingVersion byte = 1
+
+// The field numbers of the message schema. They are what identifies a field in the encoded message,
+// so a field can be renamed freely, but a number must never be reused for a different field.
+const (
+	fieldID           protowire.Number = 1
+	fieldName         protowire.Number = 2
+	fieldQueue        protowire.Number = 3
+	fieldStatus       protowire.Number = 4
+	fieldType         protowire.Number = 5
+	fieldPeriod       protowire.Number = 6
+	fieldCron         protowire.Number = 7
+	fieldTimezone     protowire.Number = 8
+	fieldPayload      protowire.Number = 9
+	fieldCreatedAt    protowire.Number = 10
+	fieldProcessAt    protowire.Number = 11
+	fieldRetryAt      protowire.Number = 12
+	fieldCompletedAt  protowire.Number = 13
+	fieldFailedAt     protowire.Number = 14
+	fieldNumRetries   protowire.Number = 15
+	fieldRetryPolicy  protowire.Number = 16
+	fieldError        protowire.Number = 17
+	fieldCallback     protowire.Number = 18
+	fieldTraceContext protowire.Number = 19
+	// fieldEncryptedPayload replaces fieldPayload when the payload is encrypted
+	fieldEncryptedPayload protowire.Number = 20
+	fieldNumHandBacks     protowire.Number = 21
+)
+
+const (
+	fieldRetryPolicyMaxAttempts protowire.Number = 1
+	fieldRetryPolicyBackoff     protowire.Number = 2
+	fieldRetryPolicyRetryAfter  protowire.Number = 3
+	fieldRetryPolicyMaxDelay    protowire.Number = 4
+)
+
+const (
+	fieldCallbackURL    protowire.Number = 1
+	fieldCallbackSecret protowire.Number = 2
+)
+
+const (
+	fieldEnvelopeKeyID      protowire.Number = 1
+	fieldEnvelopeWrappedKey protowire.Number = 2
+	fieldEnvelopeCiphertext protowire.Number = 3
+)
+
+const (
+	fieldEntryKey   protowire.Number = 1
+	fieldEntryValue protowire.Number = 2
+)
+
+// EncodeMessage encodes the message in the protobuf wire format, prefixed with the version of the schema.
+// The payload is encrypted with the primary key of the keyring, it is stored in plaintext if the keyring is nil.
+func EncodeMessage(msg *Message, keyring *encryption.Keyring) ([]byte, error) {
+	b := []byte{encodingVersion}
+	b = appendString(b, fieldID, msg.ID)
+	b = appendString(b, fieldName, msg.Name)
+	b = appendString(b, fieldQueue, msg.Queue)
+	b = appendVarint(b, fieldStatus, int64(msg.Status))
+	b = appendVarint(b, fieldType, int64(msg.Type))
+	b = appendVarint(b, fieldPeriod, int64(msg.Period))
+	b = appendString(b, fieldCron, msg.Cron)
+	b = appendString(b, fieldTimezone, msg.Timezone)
+	if len(msg.Payload) > 0 && keyring != nil {
+		// the payload is bound to the task, so it cannot be swapped into another one
+		envelope, err := keyring.Seal(msg.Payload, []byte(msg.ID))
+		if err != nil {
+			return nil, fmt.Errorf("error encrypting payload: %w", err)
+		}
+		var encrypted []byte
+		encrypted = appendString(encrypted, fieldEnvelopeKeyID, envelope.KeyID)
+		encrypted = protowire.AppendTag(encrypted, fieldEnvelopeWrappedKey, protowire.BytesType)
+		encrypted = protowire.AppendBytes(encrypted, envelope.WrappedKey)
+		encrypted = protowire.AppendTag(encrypted, fieldEnvelopeCiphertext, protowire.BytesType)
+		encrypted = protowire.AppendBytes(encrypted, envelope.Ciphertext)
+		b = protowire.AppendTag(b, fieldEncryptedPayload, protowire.BytesType)
+		b = protowire.AppendBytes(b, encrypted)
+	} else if len(msg.Payload) > 0 {
+		b = protowire.AppendTag(b, fieldPayload, protowire.BytesType)
+		b = protowire.AppendBytes(b, msg.Payload)
+	}
+	if !msg.CreatedAt.IsZero() {
+		b = appendVarint(b, fieldCreatedAt, msg.CreatedAt.UnixNano())
+	}
+	b = appendTime(b, fieldProcessAt, msg.ProcessAt)
+	b = appendTime(b, fieldRetryAt, msg.RetryAt)
+	b = appendTime(b, fieldCompletedAt, msg.CompletedAt)
+	b = appendTime(b, fieldFailedAt, msg.FailedAt)
+	b = appendVarint(b, fieldNumRetries, int64(msg.NumRetries))
+	b = appendVarint(b, fieldNumHandBacks, int64(msg.NumHandBacks))
+	if msg.RetryPolicy != nil {
+		var policy []byte
+		policy = appendVarint(policy, fieldRetryPolicyMaxAttempts, int64(msg.RetryPolicy.MaxAttempts))
+		policy = appendString(policy, fieldRetryPolicyBackoff, string(msg.RetryPolicy.Backoff))
+		policy = appendVarint(policy, fieldRetryPolicyRetryAfter, int64(msg.RetryPolicy.RetryAfter))
+		policy = appendVarint(policy, fieldRetryPolicyMaxDelay, int64(msg.RetryPolicy.MaxDelay))
+		b = protowire.AppendTag(b, fieldRetryPolicy, protowire.BytesType)
+		b = protowire.AppendBytes(b, policy)
+	}
+	if msg.Error != nil {
+		// an empty error is kept, it is not the same as no error
+		b = protowire.AppendTag(b, fieldError, protowire.BytesType)
+		b = protowire.AppendString(b, *msg.Error)
+	}
+	if msg.Callback != nil {
+		var callback []byte
+		callback = appendString(callback, fieldCallbackURL, msg.Callback.URL)
+		callback = appendString(callback, fieldCallbackSecret, msg.Callback.Secret)
+		b = protowire.AppendTag(b, fieldCallback, protowire.BytesType)
+		b = protowire.AppendBytes(b, callback)
+	}
+	for key, value := range msg.TraceContext {
+		var entry []byte
+		entry = appendString(entry, fieldEntryKey, key)
+		entry = appendString(entry, fieldEntryValue, value)
+		b = protowire.AppendTag(b, fieldTraceContext, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b, nil
+}
+
+// DecodeMessage decodes a message encoded by EncodeMessage, or by the legacy JSON encoding.
+// An encrypted payload is decrypted with the keyring, which has to hold the key it was encrypted with.
+func DecodeMessage(encoded string, keyring *encryption.Keyring) (*Message, error) {
+	if IsLegacyEncoding(encoded) {
+		var msg Message
+		err := json.Unmarshal([]byte(encoded), &msg)
+		if err != nil {
+			return nil, err
+		}
+		return &msg, nil
+	}
+
+	if len(encoded) == 0 {
+		return nil, errors.New("cannot decode an empty message")
+	}
+	if encoded[0] != encodingVersion {
+		return nil, fmt.Errorf("unknown message encoding version %d", encoded[0])
+	}
+
+	var msg Message
+	var envelope *encryption.Envelope
+	err := consumeFields([]byte(encoded[1:]), func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == fieldID && typ == protowire.BytesType:
+			return consumeString(b, &msg.ID)
+		case num == fieldName && typ == protowire.BytesType:
+			return consumeString(b, &msg.Name)
+		case num == fieldQueue && typ == protowire.BytesType:
+			return consumeString(b, &msg.Queue)
+		case num == fieldStatus && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			msg.Status = Status(v)
+			return n, protowire.ParseError(n)
+		case num == fieldType && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			msg.Type = Type(v)
+			return n, protowire.ParseError(n)
+		case num == fieldPeriod && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			msg.Period = time.Duration(v)
+			return n, protowire.ParseError(n)
+		case num == fieldCron && typ == protowire.BytesType:
+			return consumeString(b, &msg.Cron)
+		case num == fieldTimezone && typ == protowire.BytesType:
+			return consumeString(b, &msg.Timezone)
+		case num == fieldPayload && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			msg.Payload = append([]byte(nil), v...)
+			return n, protowire.ParseError(n)
+		case num == fieldCreatedAt && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			msg.CreatedAt = time.Unix(0, int64(v))
+			return n, protowire.ParseError(n)
+		case num == fieldProcessAt && typ == protowire.VarintType:
+			return consumeTime(b, &msg.ProcessAt)
+		case num == fieldRetryAt && typ == protowire.VarintType:
+			return consumeTime(b, &msg.RetryAt)
+		case num == fieldCompletedAt && typ == protowire.VarintType:
+			return consumeTime(b, &msg.CompletedAt)
+		case num == fieldFailedAt && typ == protowire.VarintType:
+			return consumeTime(b, &msg.FailedAt)
+		case num == fieldNumRetries && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			msg.NumRetries = int(v)
+			return n, protowire.ParseError(n)
+		case num == fieldNumHandBacks && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			msg.NumHandBacks = int(v)
+			return n, protowire.ParseError(n)
+		case num == fieldRetryPolicy && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, protowire.ParseError(n)
+			}
+			msg.RetryPolicy = &RetryPolicy{}
+			return n, decodeRetryPolicy(v, msg.RetryPolicy)
+		case num == fieldError && typ == protowire.BytesType:
+			var errStr string
+			n, err := consumeString(b, &errStr)
+			msg.Error = &errStr
+			return n, err
+		case num == fieldCallback && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, protowire.ParseError(n)
+			}
+			msg.Callback = &Callback{}
+			return n, decodeCallback(v, msg.Callback)
+		case num == fieldTraceContext && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, protowire.ParseError(n)
+			}
+			if msg.TraceContext == nil {
+				msg.TraceContext = make(map[string]string)
+			}
+			return n, decodeEntry(v, msg.TraceContext)
+		case num == fieldEncryptedPayload && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, protowire.ParseError(n)
+			}
+			envelope = &encryption.Envelope{}
+			return n, decodeEnvelope(v, envelope)
+		}
+		// a field of a newer schema is skipped, so a rollback can still read the tasks
+		n := protowire.ConsumeFieldValue(num, typ, b)
+		return n, protowire.ParseError(n)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error decoding message: %w", err)
+	}
+
+	if envelope != nil {
+		// decrypted once all fields are read, the ID of the task is needed
+		if keyring == nil {
+			return nil, errors.New("the payload of the task is encrypted, but no encryption keys are configured")
+		}
+		msg.Payload, err = keyring.Open(envelope, []byte(msg.ID))
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting payload: %w", err)
+		}
+	}
+	return &msg, nil
+}
+
+// PayloadKeyID returns the ID of the key the payload of the encoded message is encrypted with,
+// or an empty string if the payload is not encrypted.
+func PayloadKeyID(encoded string) (string, error) {
+	if IsLegacyEncoding(encoded) {
+		return "", nil
+	}
+	if len(encoded) == 0 || encoded[0] != encodingVersion {
+		return "", errors.New("unknown message encoding")
+	}
+
+	var keyID string
+	err := consumeFields([]byte(encoded[1:]), func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num == fieldEncryptedPayload && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, protowire.ParseError(n)
+			}
+			var envelope encryption.Envelope
+			if err := decodeEnvelope(v, &envelope); err != nil {
+				return n, err
+			}
+			keyID = envelope.KeyID
+			return n, nil
+		}
+		n := protowire.ConsumeFieldValue(num, typ, b)
+		return n, protowire.ParseError(n)
+	})
+	return keyID, err
+}
+
+// IsLegacyEncoding reports whether the message was encoded as JSON, before the binary encoding was introduced.
+func IsLegacyEncoding(encoded string) bool {
+	return len(encoded) > 0 && encoded[0] == '{'
+}
+
+func decodeRetryPolicy(b []byte, policy *RetryPolicy) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == fieldRetryPolicyMaxAttempts && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			policy.MaxAttempts = int(v)
+			return n, protowire.ParseError(n)
+		case num == fieldRetryPolicyBackoff && typ == protowire.BytesType:
+			var backoff string
+			n, err := consumeString(b, &backoff)
+			policy.Backoff = Backoff(backoff)
+			return n, err
+		case num == fieldRetryPolicyRetryAfter && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			policy.RetryAfter = time.Duration(v)
+			return n, protowire.ParseError(n)
+		case num == fieldRetryPolicyMaxDelay && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			policy.MaxDelay = time.Duration(v)
+			return n, protowire.ParseError(n)
+		}
+		n := protowire.ConsumeFieldValue(num, typ, b)
+		return n, protowire.ParseError(n)
+	})
+}
+
+func decodeCallback(b []byte, callback *Callback) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == fieldCallbackURL && typ == protowire.BytesType:
+			return consumeString(b, &callback.URL)
+		case num == fieldCallbackSecret && typ == protowire.BytesType:
+			return consumeString(b, &callback.Secret)
+		}
+		n := protowire.ConsumeFieldValue(num, typ, b)
+		return n, protowire.ParseError(n)
+	})
+}
+
+func decodeEnvelope(b []byte, envelope *encryption.Envelope) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == fieldEnvelopeKeyID && typ == protowire.BytesType:
+			return consumeString(b, &envelope.KeyID)
+		case num == fieldEnvelopeWrappedKey && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			envelope.WrappedKey = append([]byte(nil), v...)
+			return n, protowire.ParseError(n)
+		case num == fieldEnvelopeCiphertext && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			envelope.Ciphertext = append([]byte(nil), v...)
+			return n, protowire.ParseError(n)
+		}
+		n := protowire.ConsumeFieldValue(num, typ, b)
+		return n, protowire.ParseError(n)
+	})
+}
+
+func decodeEntry(b []byte, m map[string]string) error {
+	var key, value string
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == fieldEntryKey && typ == protowire.BytesType:
+			return consumeString(b, &key)
+		case num == fieldEntryValue && typ == protowire.BytesType:
+			return consumeString(b, &value)
+		}
+		n := protowire.ConsumeFieldValue(num, typ, b)
+		return n, protowire.ParseError(n)
+	})
+	m[key] = value
+	return err
+}
+
+// consumeFields calls fn with the number, the type and the value of each field in b.
+// fn returns the length of the value it consumed.
+func consumeFields(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n, err := fn(num, typ, b)
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func consumeString(b []byte, s *string) (int, error) {
+	v, n := protowire.ConsumeString(b)
+	*s = v
+	return n, protowire.ParseError(n)
+}
+
+func consumeTime(b []byte, t **time.Time) (int, error) {
+	v, n := protowire.ConsumeVarint(b)
+	if n < 0 {
+		return n, protowire.ParseError(n)
+	}
+	decoded := time.Unix(0, int64(v))
+	*t = &decoded
+	return n, nil
+}
+
+// appendString appends the string field, unless it is empty.
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// appendVarint appends the integer field, unless it is zero.
+func appendVarint(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+// appendTime appends the time field in unix nano sec, unless it is not set.
+func appendTime(b []byte, num protowire.Number, t *time.Time) []byte {
+	if t == nil {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(t.UnixNano()))
+}
@@ -0,0 +1,126 @@
+package task
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/engpetarmarinov/gotama/internal/encryption"
+	"google.golang.org/protobuf/encoding/protowire"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncodeDecodeMessage(t *testing.T) {
+	now := time.Unix(0, time.Now().UnixNano())
+	errStr := "error sending an email"
+	msg := &Message{
+		ID:           "aac6ed79-4fc6-4b14-8614-889a8236ba54",
+		Name:         "EMAIL",
+		Queue:        "critical",
+		Status:       StatusFailed,
+		Type:         TypeRecurring,
+		Period:       45 * time.Minute,
+		Payload:      []byte(`{"to":"gotama@gotama.io"}`),
+		CreatedAt:    now,
+		RetryAt:      &now,
+		FailedAt:     &now,
+		NumRetries:   2,
+		RetryPolicy:  &RetryPolicy{MaxAttempts: 5, Backoff: BackoffLinear, RetryAfter: time.Second},
+		Error:        &errStr,
+		Callback:     &Callback{URL: "https://example.com/hooks/gotama", Secret: "s3cr3t"},
+		NumHandBacks: 3,
+		TraceContext: map[string]string{
+			"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+	}
+
+	encoded, err := EncodeMessage(msg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsLegacyEncoding(string(encoded)) {
+		t.Fatal("expected the binary encoding")
+	}
+	legacy, _ := json.Marshal(msg)
+	if len(encoded) >= len(legacy) {
+		t.Errorf("expected the binary encoding to be smaller than JSON, got %d >= %d bytes", len(encoded), len(legacy))
+	}
+
+	decoded, err := DecodeMessage(string(encoded), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, msg) {
+		t.Errorf("expected %+v, got %+v", msg, decoded)
+	}
+}
+
+func TestDecodeLegacyMessage(t *testing.T) {
+	legacy := `{"ID":"aac6ed79-4fc6-4b14-8614-889a8236ba54","Name":"EMAIL","Queue":"default","Status":1,"Type":1,` +
+		`"Period":0,"Payload":"e30=","CreatedAt":"2023-05-19T14:28:23Z","NumRetries":1}`
+
+	msg, err := DecodeMessage(legacy, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != "aac6ed79-4fc6-4b14-8614-889a8236ba54" || msg.Status != StatusPending || msg.NumRetries != 1 || string(msg.Payload) != "{}" {
+		t.Errorf("unexpected legacy message %+v", msg)
+	}
+}
+
+func TestDecodeMessageSkipsUnknownFields(t *testing.T) {
+	encoded, err := EncodeMessage(&Message{ID: "aac6ed79-4fc6-4b14-8614-889a8236ba54"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a field added by a newer schema
+	encoded = protowire.AppendTag(encoded, 100, protowire.BytesType)
+	encoded = protowire.AppendString(encoded, "new")
+
+	msg, err := DecodeMessage(string(encoded), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != "aac6ed79-4fc6-4b14-8614-889a8236ba54" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+
+	if _, err := DecodeMessage(string([]byte{2}), nil); err == nil {
+		t.Error("expected an error decoding an unknown version")
+	}
+}
+
+func TestEncodeDecodeEncryptedMessage(t *testing.T) {
+	keyring, err := encryption.NewKeyring("k1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &Message{
+		ID:      "aac6ed79-4fc6-4b14-8614-889a8236ba54",
+		Name:    "EMAIL",
+		Payload: []byte(`{"to":"gotama@gotama.io"}`),
+	}
+
+	encoded, err := EncodeMessage(msg, keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encoded, msg.Payload) {
+		t.Fatal("expected the payload to be encrypted")
+	}
+	if keyID, err := PayloadKeyID(string(encoded)); err != nil || keyID != "k1" {
+		t.Errorf("expected the payload to be encrypted with k1, got %q, %v", keyID, err)
+	}
+
+	if _, err := DecodeMessage(string(encoded), nil); err == nil {
+		t.Error("expected an error decoding an encrypted payload without keys")
+	}
+	decoded, err := DecodeMessage(string(encoded), keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, msg) {
+		t.Errorf("expected %+v, got %+v", msg, decoded)
+	}
+}
@@ -0,0 +1,215 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxAttempts is the number of attempts to process a task if its retry policy does not set one.
+const DefaultMaxAttempts = 3
+
+type Backoff string
+
+const (
+	BackoffExponential Backoff = "exponential"
+	BackoffLinear      Backoff = "linear"
+	BackoffConstant    Backoff = "constant"
+)
+
+func GetBackoff(backoff string) (Backoff, error) {
+	switch Backoff(strings.ToLower(backoff)) {
+	case BackoffExponential:
+		return BackoffExponential, nil
+	case BackoffLinear:
+		return BackoffLinear, nil
+	case BackoffConstant:
+		return BackoffConstant, nil
+	}
+	return "", errors.New("backoff has to be one of exponential, linear or constant")
+}
+
+// RetryPolicyRequest represents how a failed task is retried, every field is optional.
+// swagger:model retryPolicyRequest
+type RetryPolicyRequest struct {
+	// The number of attempts to process the task, including the first one
+	// example: 5
+	MaxAttempts int `json:"max_attempts,omitempty"`
+
+	// The shape of the delay between attempts (e.g., exponential, linear, constant)
+	// example: exponential
+	Backoff string `json:"backoff,omitempty"`
+
+	// The delay before the first retry, the base of the backoff (e.g., 30s, 5m)
+	// example: 30s
+	RetryAfter string `json:"retry_after,omitempty"`
+
+	// The maximum delay between attempts (e.g., 1h)
+	// example: 1h
+	MaxDelay string `json:"max_delay,omitempty"`
+}
+
+// RetryPolicy holds how a failed task is retried. Zero fields are not set and fall back to the defaults.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     Backoff
+	RetryAfter  time.Duration
+	MaxDelay    time.Duration
+}
+
+func NewRetryPolicyFromRequest(req *RetryPolicyRequest) (*RetryPolicy, error) {
+	if req == nil {
+		return nil, nil
+	}
+
+	policy := &RetryPolicy{}
+	if req.MaxAttempts < 0 {
+		return nil, errors.New("max_attempts cannot be negative")
+	}
+	policy.MaxAttempts = req.MaxAttempts
+
+	if req.Backoff != "" {
+		backoff, err := GetBackoff(req.Backoff)
+		if err != nil {
+			return nil, err
+		}
+		policy.Backoff = backoff
+	}
+
+	var err error
+	policy.RetryAfter, err = parsePolicyDuration("retry_after", req.RetryAfter)
+	if err != nil {
+		return nil, err
+	}
+	policy.MaxDelay, err = parsePolicyDuration("max_delay", req.MaxDelay)
+	if err != nil {
+		return nil, err
+	}
+	if policy.MaxDelay > 0 && policy.RetryAfter > policy.MaxDelay {
+		return nil, errors.New("retry_after cannot be greater than max_delay")
+	}
+
+	return policy, nil
+}
+
+func parsePolicyDuration(field string, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", field, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s has to be positive", field)
+	}
+	return d, nil
+}
+
+// ParseRetryPolicy parses a retry policy from config, e.g. "max_attempts=5,backoff=linear,retry_after=30s,max_delay=10m".
+func ParseRetryPolicy(s string) (*RetryPolicy, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	req := &RetryPolicyRequest{}
+	for _, item := range strings.Split(s, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(item), "=")
+		if !found {
+			return nil, fmt.Errorf("invalid retry policy setting %s", item)
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "max_attempts":
+			maxAttempts, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid max_attempts: %v", err)
+			}
+			req.MaxAttempts = maxAttempts
+		case "backoff":
+			req.Backoff = value
+		case "retry_after":
+			req.RetryAfter = value
+		case "max_delay":
+			req.MaxDelay = value
+		default:
+			return nil, fmt.Errorf("unknown retry policy setting %s", key)
+		}
+	}
+
+	return NewRetryPolicyFromRequest(req)
+}
+
+// Request returns the policy in the form it is submitted in, nil if the policy is nil.
+func (p *RetryPolicy) Request() *RetryPolicyRequest {
+	if p == nil {
+		return nil
+	}
+
+	req := &RetryPolicyRequest{
+		MaxAttempts: p.MaxAttempts,
+		Backoff:     string(p.Backoff),
+	}
+	if p.RetryAfter != 0 {
+		req.RetryAfter = p.RetryAfter.String()
+	}
+	if p.MaxDelay != 0 {
+		req.MaxDelay = p.MaxDelay.String()
+	}
+	return req
+}
+
+// Merge returns the policy with the fields it does not set taken from def.
+func (p *RetryPolicy) Merge(def *RetryPolicy) *RetryPolicy {
+	if p == nil {
+		return def
+	}
+	if def == nil {
+		return p
+	}
+
+	merged := *p
+	if merged.MaxAttempts == 0 {
+		merged.MaxAttempts = def.MaxAttempts
+	}
+	if merged.Backoff == "" {
+		merged.Backoff = def.Backoff
+	}
+	if merged.RetryAfter == 0 {
+		merged.RetryAfter = def.RetryAfter
+	}
+	if merged.MaxDelay == 0 {
+		merged.MaxDelay = def.MaxDelay
+	}
+	return &merged
+}
+
+// GetMaxAttempts returns the number of attempts to process a task with the policy.
+func (p *RetryPolicy) GetMaxAttempts() int {
+	if p == nil || p.MaxAttempts == 0 {
+		return DefaultMaxAttempts
+	}
+	return p.MaxAttempts
+}
+
+// leaseExpiredError is the error of an attempt whose lease expired, e.g. the worker crashed processing the task.
+const leaseExpiredError = "lease expired before the attempt finished"
+
+// FailExpiredLease counts the attempt of a running task whose lease expired as a failed one.
+// It returns whether the task has attempts left, the task is retried right away if it has,
+// so that a task which keeps crashing its workers ends up in the dead letter queue instead of looping forever.
+func (msg *Message) FailExpiredLease(now time.Time) bool {
+	errStr := leaseExpiredError
+	msg.Status = StatusFailed
+	msg.Error = &errStr
+	msg.FailedAt = &now
+	msg.NumRetries++
+	msg.RetryAt = nil
+	if msg.NumRetries < msg.RetryPolicy.GetMaxAttempts() {
+		msg.RetryAt = &now
+		return true
+	}
+	return false
+}
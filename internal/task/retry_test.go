@@ -0,0 +1,93 @@
+package task
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRetryPolicy(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    *RetryPolicy
+		wantErr bool
+	}{
+		{
+			input: "",
+			want:  nil,
+		},
+		{
+			input: "max_attempts=5, backoff=Linear, retry_after=30s, max_delay=10m",
+			want:  &RetryPolicy{MaxAttempts: 5, Backoff: BackoffLinear, RetryAfter: 30 * time.Second, MaxDelay: 10 * time.Minute},
+		},
+		{
+			input: "max_attempts=1",
+			want:  &RetryPolicy{MaxAttempts: 1},
+		},
+		{
+			input:   "backoff=random",
+			wantErr: true,
+		},
+		{
+			input:   "retry_after=1h,max_delay=1m",
+			wantErr: true,
+		},
+		{
+			input:   "attempts=5",
+			wantErr: true,
+		},
+		{
+			input:   "max_attempts",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		got, err := ParseRetryPolicy(tc.input)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("ParseRetryPolicy(%q) expected an error", tc.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseRetryPolicy(%q) returned error %v", tc.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ParseRetryPolicy(%q) = %+v, want %+v", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestRetryPolicyMerge(t *testing.T) {
+	def := &RetryPolicy{MaxAttempts: 5, Backoff: BackoffConstant, RetryAfter: time.Minute}
+	policy := &RetryPolicy{MaxAttempts: 2, MaxDelay: time.Hour}
+
+	want := &RetryPolicy{MaxAttempts: 2, Backoff: BackoffConstant, RetryAfter: time.Minute, MaxDelay: time.Hour}
+	if got := policy.Merge(def); !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge = %+v, want %+v", got, want)
+	}
+
+	var none *RetryPolicy
+	if got := none.Merge(nil).GetMaxAttempts(); got != DefaultMaxAttempts {
+		t.Errorf("GetMaxAttempts of no policy = %d, want %d", got, DefaultMaxAttempts)
+	}
+}
+
+func TestFailExpiredLease(t *testing.T) {
+	now := time.Now()
+	msg := &Message{RetryPolicy: &RetryPolicy{MaxAttempts: 2}}
+	if !msg.FailExpiredLease(now) {
+		t.Fatal("expected the first expired attempt to be retried")
+	}
+	if msg.NumRetries != 1 || msg.RetryAt == nil || !msg.RetryAt.Equal(now) || msg.Error == nil {
+		t.Errorf("expected the attempt to be counted and retried now, got %d retries, retry at %v, error %v", msg.NumRetries, msg.RetryAt, msg.Error)
+	}
+	if msg.FailExpiredLease(now) {
+		t.Fatal("expected the last expired attempt to fail the task")
+	}
+	if msg.NumRetries != 2 || msg.RetryAt != nil || msg.Status != StatusFailed {
+		t.Errorf("expected the task to have failed all its attempts, got %d retries, retry at %v, status %s", msg.NumRetries, msg.RetryAt, msg.Status)
+	}
+}
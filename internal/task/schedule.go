@@ -0,0 +1,64 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"time"
+	// the timezones of cron schedules should not depend on the zoneinfo of the host
+	_ "time/tzdata"
+)
+
+// cronParser parses standard 5-field cron expressions and descriptors like @daily or @every 1h.
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// ParseCron parses a cron expression evaluated in the given IANA timezone, UTC if empty.
+func ParseCron(spec string, timezone string) (cron.Schedule, *time.Location, error) {
+	schedule, err := cronParser.Parse(spec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid cron expression: %v", err)
+	}
+
+	loc := time.UTC
+	if timezone != "" {
+		loc, err = time.LoadLocation(timezone)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid timezone: %v", err)
+		}
+	}
+
+	return schedule, loc, nil
+}
+
+// NextRuns returns the next n times after the given time a cron expression fires at.
+func NextRuns(spec string, timezone string, after time.Time, n int) ([]time.Time, error) {
+	schedule, loc, err := ParseCron(spec, timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]time.Time, 0, n)
+	next := after.In(loc)
+	for i := 0; i < n; i++ {
+		next = schedule.Next(next)
+		// the schedule does not fire within the next 5 years, e.g. on February 30th
+		if next.IsZero() {
+			break
+		}
+		runs = append(runs, next)
+	}
+
+	return runs, nil
+}
+
+// NextRun returns the next time after the given time a cron expression fires at.
+func NextRun(spec string, timezone string, after time.Time) (time.Time, error) {
+	runs, err := NextRuns(spec, timezone, after, 1)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(runs) == 0 {
+		return time.Time{}, errors.New("cron expression never fires")
+	}
+	return runs[0], nil
+}
@@ -0,0 +1,99 @@
+package task
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextRuns(t *testing.T) {
+	after := time.Date(2024, 5, 17, 12, 0, 0, 0, time.UTC) // Friday
+
+	tests := []struct {
+		desc     string
+		spec     string
+		timezone string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			desc: "weekdays",
+			spec: "30 8 * * 1-5",
+			want: []string{"2024-05-20T08:30:00Z", "2024-05-21T08:30:00Z"},
+		},
+		{
+			desc:     "weekdays in a timezone",
+			spec:     "30 8 * * 1-5",
+			timezone: "Europe/Sofia",
+			want:     []string{"2024-05-20T08:30:00+03:00", "2024-05-21T08:30:00+03:00"},
+		},
+		{
+			desc: "descriptor",
+			spec: "@monthly",
+			want: []string{"2024-06-01T00:00:00Z", "2024-07-01T00:00:00Z"},
+		},
+		{
+			desc: "never fires",
+			spec: "0 0 30 2 *",
+			want: []string{},
+		},
+		{
+			desc:    "invalid expression",
+			spec:    "* * *",
+			wantErr: true,
+		},
+		{
+			desc:     "invalid timezone",
+			spec:     "@daily",
+			timezone: "Europe/Nowhere",
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		runs, err := NextRuns(tc.spec, tc.timezone, after, 2)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: NextRuns(%q, %q) expected an error", tc.desc, tc.spec, tc.timezone)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: NextRuns(%q, %q) returned error %v", tc.desc, tc.spec, tc.timezone, err)
+			continue
+		}
+		if len(runs) != len(tc.want) {
+			t.Errorf("%s: got %d runs %v, want %v", tc.desc, len(runs), runs, tc.want)
+			continue
+		}
+		for i, run := range runs {
+			if got := run.Format(time.RFC3339); got != tc.want[i] {
+				t.Errorf("%s: run %d = %s, want %s", tc.desc, i, got, tc.want[i])
+			}
+		}
+	}
+}
+
+func TestNewMessageStatus(t *testing.T) {
+	tests := []struct {
+		desc string
+		req  Request
+		want Status
+	}{
+		{desc: "once", req: Request{Name: "email", Type: "once"}, want: StatusPending},
+		{desc: "delayed", req: Request{Name: "email", Type: "once", ProcessIn: "1m"}, want: StatusDelayed},
+		{desc: "delayed recurring", req: Request{Name: "email", Type: "recurring", Period: "1m", ProcessIn: "1m"}, want: StatusDelayed},
+		{desc: "cron", req: Request{Name: "email", Type: "recurring", Cron: "@daily"}, want: StatusScheduled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			tt.req.Payload = []byte(`{}`)
+			msg, err := NewMessageFromRequest(&tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Status != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, msg.Status)
+			}
+		})
+	}
+}
@@ -4,52 +4,31 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/google/uuid"
+	"regexp"
 	"strings"
 	"time"
 )
 
-type Name int
+// NormalizeName returns the name a task is stored under, names are case-insensitive and stored upper-cased.
+// The processors are registered under the names, see processors.Register.
+func NormalizeName(name string) string {
+	return strings.ToUpper(strings.TrimSpace(name))
+}
 
 const (
-	NameUnknown Name = iota
-	NameEmail
-	NameSMS
-	NameSlack
-	NameFoo
+	QueueDefault string = "default"
 )
 
-func (n Name) String() string {
-	switch n {
-	case NameEmail:
-		return "EMAIL"
-	case NameSMS:
-		return "SMS"
-	case NameSlack:
-		return "SLACK"
-	case NameFoo:
-		return "FOO"
-	}
-	panic("task name unknown")
-}
+var queueNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
 
-func GetName(name string) (Name, error) {
-	switch strings.ToUpper(name) {
-	case "EMAIL":
-		return NameEmail, nil
-	case "SMS":
-		return NameSMS, nil
-	case "SLACK":
-		return NameSlack, nil
-	case "FOO":
-		return NameFoo, nil
+// ValidateQueueName checks that name can be used as a queue name.
+func ValidateQueueName(name string) error {
+	if !queueNameRegex.MatchString(name) {
+		return errors.New("queue name must be 1 to 64 characters long and contain only letters, digits, '-' and '_'")
 	}
-	return NameUnknown, errors.New("task name unknown")
+	return nil
 }
 
-const (
-	QueueDefault string = "default"
-)
-
 // Request represents the payload for creating a new task.
 // swagger:model taskRequest
 type Request struct {
@@ -65,6 +44,37 @@ type Request struct {
 	// example: 45m
 	Period string `json:"period"`
 
+	// The cron expression of the task, an alternative to period for recurring tasks (e.g., 30 8 * * 1-5, @daily)
+	// example: 30 8 * * 1-5
+	Cron string `json:"cron,omitempty"`
+
+	// The IANA timezone the cron expression is evaluated in, UTC if not provided
+	// example: Europe/Sofia
+	Timezone string `json:"timezone,omitempty"`
+
+	// The queue of the task, "default" if not provided
+	// example: critical
+	Queue string `json:"queue,omitempty"`
+
+	// The time to process the task at in RFC3339 format, the task is processed immediately if not provided
+	// example: 2023-05-20T09:00:00+03:00
+	ProcessAt string `json:"process_at,omitempty"`
+
+	// The delay after which to process the task (e.g., 30m, 2h), an alternative to process_at
+	// example: 30m
+	ProcessIn string `json:"process_in,omitempty"`
+
+	// How the task is retried if it fails, the defaults of the task name are used for the fields which are not provided
+	RetryPolicy *RetryPolicyRequest `json:"retry_policy,omitempty"`
+
+	// The URL the task is posted to once it succeeds or ends in the dead letter queue
+	// example: https://example.com/hooks/gotama
+	CallbackURL string `json:"callback_url,omitempty"`
+
+	// The secret the posts to callback_url are signed with in the X-Gotama-Signature header
+	// example: s3cr3t
+	CallbackSecret string `json:"callback_secret,omitempty"`
+
 	// The payload of the task containing task-specific data
 	Payload json.RawMessage `json:"payload"`
 }
@@ -92,6 +102,18 @@ type Response struct {
 	// example: 45m
 	Period string `json:"period"`
 
+	// The cron expression of the task, if it is recurring on a cron schedule
+	// example: 30 8 * * 1-5
+	Cron string `json:"cron,omitempty"`
+
+	// The IANA timezone the cron expression is evaluated in
+	// example: Europe/Sofia
+	Timezone string `json:"timezone,omitempty"`
+
+	// The queue of the task
+	// example: default
+	Queue string `json:"queue"`
+
 	// The payload of the task containing task-specific data
 	Payload any `json:"payload"`
 
@@ -99,10 +121,30 @@ type Response struct {
 	// example: null
 	Error *string `json:"error,omitempty"`
 
+	// The number of failed attempts to process the task
+	// example: 1
+	Attempts int `json:"attempts"`
+
+	// The number of attempts to process the task before it is moved to the dead letter queue
+	// example: 3
+	MaxAttempts int `json:"max_attempts"`
+
+	// The URL the task is posted to once it succeeds or ends in the dead letter queue
+	// example: https://example.com/hooks/gotama
+	CallbackURL string `json:"callback_url,omitempty"`
+
 	// The creation timestamp of the task
 	// example: 2023-05-19T14:28:23Z
 	CreatedAt string `json:"created_at"`
 
+	// The time the task is planned to be processed at, if it was delayed
+	// example: 2023-05-20T06:00:00Z
+	ProcessAt *string `json:"process_at,omitempty"`
+
+	// The time the failed task will be retried at, if it is waiting for a retry
+	// example: 2023-05-19T14:45:20Z
+	RetryAt *string `json:"retry_at,omitempty"`
+
 	// The completion timestamp of the task, if completed
 	// example: 2023-05-19T15:00:00Z
 	CompletedAt *string `json:"completed_at,omitempty"`
@@ -119,6 +161,10 @@ const (
 	StatusRunning
 	StatusSucceeded
 	StatusFailed
+	// StatusScheduled is the status of a cron task waiting for its next run.
+	StatusScheduled
+	// StatusDelayed is the status of a task waiting for its process at time.
+	StatusDelayed
 )
 
 func (s Status) String() string {
@@ -131,10 +177,36 @@ func (s Status) String() string {
 		return "SUCCEEDED"
 	case StatusFailed:
 		return "FAILED"
+	case StatusScheduled:
+		return "SCHEDULED"
+	case StatusDelayed:
+		return "DELAYED"
 	}
 	panic("task status unknown")
 }
 
+// StatusFromIndex returns the status of a task in the status index of a broker, one of
+// pending, delayed, scheduled, running, retry, failed and succeeded. A task waiting for a retry has failed.
+// The brokers move tasks between the indexes without rewriting the stored message,
+// so the index is the current status of the task.
+func StatusFromIndex(status string) (Status, bool) {
+	switch status {
+	case "pending":
+		return StatusPending, true
+	case "delayed":
+		return StatusDelayed, true
+	case "scheduled":
+		return StatusScheduled, true
+	case "running":
+		return StatusRunning, true
+	case "retry", "failed":
+		return StatusFailed, true
+	case "succeeded":
+		return StatusSucceeded, true
+	}
+	return 0, false
+}
+
 type Type int
 
 const (
@@ -169,28 +241,95 @@ type Message struct {
 	Status      Status
 	Type        Type
 	Period      time.Duration
+	Cron        string
+	Timezone    string
 	Payload     []byte
 	CreatedAt   time.Time
+	ProcessAt   *time.Time
+	RetryAt     *time.Time
 	CompletedAt *time.Time
 	FailedAt    *time.Time
 	NumRetries  int
+	RetryPolicy *RetryPolicy
 	Error       *string
+	Callback    *Callback
+	// NumHandBacks counts the times the task was handed back by the workers without its processor, which are not attempts
+	NumHandBacks int
+	// TraceContext carries the trace the task was enqueued in, so its processing continues it
+	TraceContext map[string]string
+	// LeaseToken identifies the lease the task was dequeued with, the brokers reject the changes of the running task
+	// made with another one, e.g. by a worker whose lease expired. It is not encoded with the message.
+	LeaseToken string
+}
+
+// NewLeaseToken returns a token for the lease of a dequeued task.
+func NewLeaseToken() string {
+	return uuid.NewString()
 }
 
 func NewMessageFromRequest(req *Request) (*Message, error) {
 	id := uuid.New()
-	name, err := GetName(req.Name)
+	name := NormalizeName(req.Name)
+	if name == "" {
+		return nil, errors.New("task name is required")
+	}
+
+	taskType, err := GetType(req.Type)
 	if err != nil {
 		return nil, err
 	}
 
-	taskType, err := GetType(req.Type)
+	queue := strings.TrimSpace(req.Queue)
+	if queue == "" {
+		queue = QueueDefault
+	}
+	if err := ValidateQueueName(queue); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	processAt, err := parseProcessAt(req, now)
+	if err != nil {
+		return nil, err
+	}
+	status := StatusPending
+	if processAt != nil && processAt.After(now) {
+		status = StatusDelayed
+	}
+
+	cronSpec := strings.TrimSpace(req.Cron)
+	timezone := strings.TrimSpace(req.Timezone)
+	if cronSpec == "" && timezone != "" {
+		return nil, errors.New("timezone is applicable only to cron tasks")
+	}
+
+	retryPolicy, err := NewRetryPolicyFromRequest(req.RetryPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	callback, err := NewCallbackFromRequest(req)
 	if err != nil {
 		return nil, err
 	}
 
 	var period time.Duration
-	if taskType == TypeRecurring {
+	if cronSpec != "" {
+		if taskType != TypeRecurring {
+			return nil, errors.New("cron is applicable only to recurring tasks")
+		}
+		if req.Period != "" {
+			return nil, errors.New("only one of period and cron can be provided")
+		}
+		if processAt != nil {
+			return nil, errors.New("cron tasks are processed on their schedule, process_at and process_in cannot be provided")
+		}
+		if _, err := NextRun(cronSpec, timezone, now); err != nil {
+			return nil, err
+		}
+		//a cron task waits for its first run
+		status = StatusScheduled
+	} else if taskType == TypeRecurring {
 		period, err = time.ParseDuration(req.Period)
 		if err != nil {
 			return nil, err
@@ -203,20 +342,54 @@ func NewMessageFromRequest(req *Request) (*Message, error) {
 
 	return &Message{
 		ID:          id.String(),
-		Name:        name.String(),
-		Queue:       QueueDefault,
-		Status:      StatusPending,
+		Name:        name,
+		Queue:       queue,
+		Status:      status,
 		Type:        taskType,
 		Period:      period,
+		Cron:        cronSpec,
+		Timezone:    timezone,
 		Payload:     req.Payload,
-		CreatedAt:   time.Now(),
+		CreatedAt:   now,
+		ProcessAt:   processAt,
 		CompletedAt: nil,
 		FailedAt:    nil,
 		NumRetries:  0,
+		RetryPolicy: retryPolicy,
 		Error:       nil,
+		Callback:    callback,
 	}, nil
 }
 
+// parseProcessAt returns the time the requested task should be processed at, nil if it should be processed immediately.
+func parseProcessAt(req *Request, now time.Time) (*time.Time, error) {
+	if req.ProcessAt != "" && req.ProcessIn != "" {
+		return nil, errors.New("only one of process_at and process_in can be provided")
+	}
+
+	if req.ProcessAt != "" {
+		processAt, err := time.Parse(time.RFC3339, req.ProcessAt)
+		if err != nil {
+			return nil, errors.New("process_at has to be in RFC3339 format, e.g. 2023-05-20T09:00:00+03:00")
+		}
+		return &processAt, nil
+	}
+
+	if req.ProcessIn != "" {
+		processIn, err := time.ParseDuration(req.ProcessIn)
+		if err != nil {
+			return nil, err
+		}
+		if processIn < 0 {
+			return nil, errors.New("process_in cannot be negative")
+		}
+		processAt := now.Add(processIn)
+		return &processAt, nil
+	}
+
+	return nil, nil
+}
+
 func NewResponseFromMessage(msg *Message) (*Response, error) {
 	var payload any
 	err := json.Unmarshal(msg.Payload, &payload)
@@ -236,30 +409,85 @@ func NewResponseFromMessage(msg *Message) (*Response, error) {
 		failedAt = &date
 	}
 
+	var processAt *string
+	if msg.ProcessAt != nil {
+		date := msg.ProcessAt.Format(time.RFC3339)
+		processAt = &date
+	}
+
+	var retryAt *string
+	if msg.RetryAt != nil {
+		date := msg.RetryAt.Format(time.RFC3339)
+		retryAt = &date
+	}
+
+	var callbackURL string
+	if msg.Callback != nil {
+		callbackURL = msg.Callback.URL
+	}
+
 	return &Response{
 		ID:          msg.ID,
 		Status:      msg.Status.String(),
 		Name:        msg.Name,
 		Type:        msg.Type.String(),
 		Period:      msg.Period.String(),
+		Cron:        msg.Cron,
+		Timezone:    msg.Timezone,
+		Queue:       msg.Queue,
 		Payload:     payload,
 		Error:       msg.Error,
+		Attempts:    msg.NumRetries,
+		MaxAttempts: msg.RetryPolicy.GetMaxAttempts(),
+		CallbackURL: callbackURL,
 		CreatedAt:   msg.CreatedAt.Format(time.RFC3339),
+		ProcessAt:   processAt,
+		RetryAt:     retryAt,
 		CompletedAt: completedAt,
 		FailedAt:    failedAt,
 	}, nil
 }
 
-func EncodeMessage(msg *Message) ([]byte, error) {
-	//TODO: use protobuf to save space
-	return json.Marshal(msg)
+// ScheduleResponse represents the upcoming runs of a task.
+// swagger:model taskScheduleResponse
+type ScheduleResponse struct {
+	// The unique identifier of the task
+	// example: 11ef259c-8523-42e4-8568-9d167dbba9da
+	ID string `json:"ID"`
+
+	// The cron expression of the task
+	// example: 30 8 * * 1-5
+	Cron string `json:"cron"`
+
+	// The IANA timezone the cron expression is evaluated in
+	// example: Europe/Sofia
+	Timezone string `json:"timezone,omitempty"`
+
+	// The next times the task will be processed at
+	// example: ["2023-05-22T08:30:00+03:00", "2023-05-23T08:30:00+03:00"]
+	NextRuns []string `json:"next_runs"`
 }
 
-func DecodeMessage(encoded string) (*Message, error) {
-	var msg Message
-	err := json.Unmarshal([]byte(encoded), &msg)
+// NewScheduleResponseFromMessage returns the next n runs of a cron task after the given time.
+func NewScheduleResponseFromMessage(msg *Message, after time.Time, n int) (*ScheduleResponse, error) {
+	if msg.Cron == "" {
+		return nil, errors.New("task has no cron schedule")
+	}
+
+	runs, err := NextRuns(msg.Cron, msg.Timezone, after, n)
 	if err != nil {
 		return nil, err
 	}
-	return &msg, nil
+
+	nextRuns := make([]string, 0, len(runs))
+	for _, run := range runs {
+		nextRuns = append(nextRuns, run.Format(time.RFC3339))
+	}
+
+	return &ScheduleResponse{
+		ID:       msg.ID,
+		Cron:     msg.Cron,
+		Timezone: msg.Timezone,
+		NextRuns: nextRuns,
+	}, nil
 }
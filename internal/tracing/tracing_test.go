@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+func TestInjectExtract(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := NewTracerProvider("test", sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { _ = tp.Shutdown(context.Background()) })
+
+	ctx, enqueue := Start(context.Background(), "enqueue")
+	traceContext := Inject(ctx)
+	End(enqueue, nil)
+	if traceContext["traceparent"] == "" {
+		t.Fatalf("expected a traceparent, got %v", traceContext)
+	}
+
+	_, process := Start(Extract(context.Background(), traceContext), "process")
+	End(process, errors.New("processing failed"))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	if spans[1].SpanContext.TraceID() != spans[0].SpanContext.TraceID() {
+		t.Errorf("expected the process span to continue the trace of the enqueue span")
+	}
+	if spans[1].Parent.SpanID() != spans[0].SpanContext.SpanID() {
+		t.Errorf("expected the process span to be a child of the enqueue span")
+	}
+	if spans[1].Status.Code != codes.Error || spans[1].Status.Description != "processing failed" {
+		t.Errorf("expected the process span to record the error, got %+v", spans[1].Status)
+	}
+}
+
+func TestInjectWithoutSpan(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	if traceContext := Inject(context.Background()); traceContext != nil {
+		t.Errorf("expected no trace context, got %v", traceContext)
+	}
+}
@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultRetryBackoffBase = 10 * time.Second
+	defaultRetryBackoffMax  = time.Hour
+)
+
+// backoff computes how long a failed task waits before it is retried.
+type backoff struct {
+	shape task.Backoff
+	base  time.Duration
+	max   time.Duration
+}
+
+// withPolicy returns the backoff with the settings of the retry policy of a task applied.
+func (b backoff) withPolicy(policy *task.RetryPolicy) backoff {
+	if policy == nil {
+		return b
+	}
+	if policy.Backoff != "" {
+		b.shape = policy.Backoff
+	}
+	if policy.RetryAfter > 0 {
+		b.base = policy.RetryAfter
+	}
+	if policy.MaxDelay > 0 {
+		b.max = policy.MaxDelay
+	}
+	return b
+}
+
+// delay returns the delay before the given retry, capped at max.
+// It is base * 2^(retry-1) for an exponential backoff, base * retry for a linear one and base for a constant one.
+// A random jitter of up to half of the delay is subtracted, so that tasks which failed together are not retried together.
+func (b backoff) delay(retry int) time.Duration {
+	d := b.base
+	switch b.shape {
+	case task.BackoffConstant:
+	case task.BackoffLinear:
+		for i := 1; i < retry && d < b.max; i++ {
+			d += b.base
+		}
+	default:
+		for i := 1; i < retry && d < b.max; i++ {
+			d *= 2
+		}
+	}
+	if d > b.max {
+		d = b.max
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	half := d / 2
+	return d - half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := backoff{base: 10 * time.Second, max: time.Minute}
+
+	tests := []struct {
+		retry int
+		max   time.Duration
+	}{
+		{retry: 1, max: 10 * time.Second},
+		{retry: 2, max: 20 * time.Second},
+		{retry: 3, max: 40 * time.Second},
+		{retry: 4, max: time.Minute},
+		{retry: 100, max: time.Minute},
+	}
+
+	for _, tc := range tests {
+		for i := 0; i < 100; i++ {
+			got := b.delay(tc.retry)
+			if got < tc.max/2 || got > tc.max {
+				t.Fatalf("delay(%d) = %v, want between %v and %v", tc.retry, got, tc.max/2, tc.max)
+			}
+		}
+	}
+
+	if got := (backoff{}).delay(3); got != 0 {
+		t.Errorf("delay without a base = %v, want 0", got)
+	}
+}
+
+func TestBackoffWithPolicy(t *testing.T) {
+	b := backoff{base: 10 * time.Second, max: time.Minute}
+
+	tests := []struct {
+		desc   string
+		policy *task.RetryPolicy
+		retry  int
+		max    time.Duration
+	}{
+		{
+			desc:  "no policy",
+			retry: 3,
+			max:   40 * time.Second,
+		},
+		{
+			desc:   "linear",
+			policy: &task.RetryPolicy{Backoff: task.BackoffLinear},
+			retry:  3,
+			max:    30 * time.Second,
+		},
+		{
+			desc:   "constant with a base",
+			policy: &task.RetryPolicy{Backoff: task.BackoffConstant, RetryAfter: 5 * time.Second},
+			retry:  10,
+			max:    5 * time.Second,
+		},
+		{
+			desc:   "exponential with a max delay",
+			policy: &task.RetryPolicy{MaxDelay: 15 * time.Second},
+			retry:  3,
+			max:    15 * time.Second,
+		},
+	}
+
+	for _, tc := range tests {
+		got := b.withPolicy(tc.policy).delay(tc.retry)
+		if got < tc.max/2 || got > tc.max {
+			t.Errorf("%s: delay(%d) = %v, want between %v and %v", tc.desc, tc.retry, got, tc.max/2, tc.max)
+		}
+	}
+}
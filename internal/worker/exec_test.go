@@ -0,0 +1,198 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/config"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/processors"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	"github.com/engpetarmarinov/gotama/memory"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+	"time"
+)
+
+const (
+	namePanic    = "PANIC"
+	nameBlocking = "BLOCKING"
+)
+
+type panicProcessor struct{}
+
+func (p *panicProcessor) ProcessTask(context.Context, *task.Message) error {
+	panic("processor bug")
+}
+
+func (p *panicProcessor) ValidatePayload([]byte) error {
+	return nil
+}
+
+// blockingProcessor blocks until the task is cancelled, it signals started once it is processing.
+type blockingProcessor struct {
+	started chan struct{}
+}
+
+var blocking = &blockingProcessor{started: make(chan struct{}, 1)}
+
+func (p *blockingProcessor) ProcessTask(ctx context.Context, _ *task.Message) error {
+	p.started <- struct{}{}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (p *blockingProcessor) ValidatePayload([]byte) error {
+	return nil
+}
+
+func init() {
+	processors.Register(processors.Registration{
+		Name: namePanic,
+		New: func(config.API) processors.Processor {
+			return &panicProcessor{}
+		},
+	})
+	processors.Register(processors.Registration{
+		Name: nameBlocking,
+		New: func(config.API) processors.Processor {
+			return blocking
+		},
+	})
+}
+
+// dequeueTask enqueues a task with the given name and dequeues it, as a worker does before it executes the task.
+func dequeueTask(t *testing.T, broker *memory.Broker, name string) *task.Message {
+	t.Helper()
+	ctx := context.Background()
+	msg, err := task.NewMessageFromRequest(&task.Request{Name: name, Type: "once", Payload: []byte(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.EnqueueTask(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	running, err := broker.DequeueTask(ctx, time.Minute, task.QueueDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return running
+}
+
+func TestExecPanicEndsSpanWithError(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { _ = tp.Shutdown(context.Background()) })
+
+	broker := memory.NewBroker(timeutil.NewRealClock())
+	msg := dequeueTask(t, broker, namePanic)
+	err := exec(context.Background(), context.Background(), config.NewConfig(), processors.NewProcessor, broker, timeutil.NewRealClock(), msg, time.Minute, backoff{}, defaultMaxHandBacks)
+	if err == nil {
+		t.Fatal("expected the panic to be returned as an error")
+	}
+
+	var found bool
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "exec "+namePanic {
+			found = true
+			if span.Status.Code != codes.Error {
+				t.Errorf("expected the span to end with an error, got %v", span.Status)
+			}
+		}
+	}
+	if !found {
+		t.Fatal("expected the exec span to be exported")
+	}
+}
+
+func TestExecPanicFailsAttempt(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	broker := memory.NewBroker(timeutil.NewRealClock())
+	msg := dequeueTask(t, broker, namePanic)
+	if err := exec(context.Background(), context.Background(), config.NewConfig(), processors.NewProcessor, broker, timeutil.NewRealClock(), msg, time.Minute, backoff{}, defaultMaxHandBacks); err == nil {
+		t.Fatal("expected the panic to be returned as an error")
+	}
+
+	failed, err := broker.GetTask(context.Background(), msg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed.Status != task.StatusFailed || failed.NumRetries != 1 || failed.Error == nil {
+		t.Errorf("expected the panic to fail the attempt, got %s with %d retries and error %v", failed.Status, failed.NumRetries, failed.Error)
+	}
+	if total, _, err := broker.GetAllTasks(context.Background(), task.QueueDefault, "running", 0, 10); err != nil || total != 0 {
+		t.Errorf("expected the task not to be running, got %d, %v", total, err)
+	}
+}
+
+func TestExecStopRequeuesTask(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	broker := memory.NewBroker(timeutil.NewRealClock())
+	msg := dequeueTask(t, broker, nameBlocking)
+
+	stop, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- exec(context.Background(), stop, config.NewConfig(), processors.NewProcessor, broker, timeutil.NewRealClock(), msg, time.Minute, backoff{}, defaultMaxHandBacks)
+	}()
+	<-blocking.started
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	requeued, err := broker.GetTask(context.Background(), msg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requeued.Status != task.StatusPending {
+		t.Errorf("expected the interrupted task to be pending, got %s", requeued.Status)
+	}
+	if requeued.NumRetries != 0 || requeued.Error != nil {
+		t.Errorf("expected the interrupted attempt not to be counted, got %d retries and error %v", requeued.NumRetries, requeued.Error)
+	}
+	if next, err := broker.DequeueTask(context.Background(), time.Minute, task.QueueDefault); err != nil || next.ID != msg.ID {
+		t.Errorf("expected the interrupted task to be dequeued again, got %v, %v", next, err)
+	}
+}
+
+func TestExecFailsTaskHandedBackTooOften(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	ctx := context.Background()
+	broker := memory.NewBroker(timeutil.NewRealClock())
+	noProcessor := func(_ config.API, name string) (processors.Processor, error) {
+		return nil, fmt.Errorf("%w: no processor for %s", base.ErrorUnknownTaskName, name)
+	}
+
+	msg := dequeueTask(t, broker, nameCounting)
+	for handBacks := 1; handBacks <= 2; handBacks++ {
+		err := exec(ctx, ctx, config.NewConfig(), noProcessor, broker, timeutil.NewRealClock(), msg, time.Minute, backoff{}, 2)
+		if !errors.Is(err, base.ErrorUnknownTaskName) {
+			t.Fatalf("expected the task to have no processor, got %v", err)
+		}
+		if handBacks == 1 {
+			if msg, err = broker.DequeueTask(ctx, time.Minute, task.QueueDefault); err != nil {
+				t.Fatalf("expected the task to be handed back, got %v", err)
+			}
+		}
+	}
+
+	failed, err := broker.GetTask(ctx, msg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed.Status != task.StatusFailed || failed.NumHandBacks != 2 || failed.NumRetries != 0 || failed.Error == nil {
+		t.Errorf("expected the task to be failed after 2 hand backs, got %s with %d hand backs, %d retries and error %v",
+			failed.Status, failed.NumHandBacks, failed.NumRetries, failed.Error)
+	}
+	if total, _, err := broker.GetDeadTasks(ctx, task.QueueDefault, 0, 10); err != nil || total != 1 {
+		t.Errorf("expected the task in the dead letter queue, got %d, %v", total, err)
+	}
+}
@@ -0,0 +1,103 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/processors"
+	"github.com/google/uuid"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultHeartbeatInterval = 5 * time.Second
+
+// heartbeatTTLFactor is the number of missed heartbeats after which a worker is considered dead.
+const heartbeatTTLFactor = 3
+
+// inflight tracks the IDs of the tasks being processed by the worker.
+type inflight struct {
+	mu  sync.Mutex
+	ids map[string]struct{}
+}
+
+func newInflight() *inflight {
+	return &inflight{ids: make(map[string]struct{})}
+}
+
+func (in *inflight) add(id string) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.ids[id] = struct{}{}
+}
+
+func (in *inflight) remove(id string) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	delete(in.ids, id)
+}
+
+// list returns the IDs sorted.
+func (in *inflight) list() []string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	ids := make([]string, 0, len(in.ids))
+	for id := range in.ids {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// newWorkerInfo returns the info the worker registers itself with.
+func newWorkerInfo(concurrency int, queues []string, startedAt time.Time) *base.WorkerInfo {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "gotama-worker"
+	}
+	var names []string
+	for _, reg := range processors.Registered() {
+		names = append(names, reg.Name)
+	}
+	return &base.WorkerInfo{
+		ID:          fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.NewString()[:8]),
+		Hostname:    hostname,
+		PID:         os.Getpid(),
+		Concurrency: concurrency,
+		Queues:      queues,
+		Processors:  names,
+		StartedAt:   startedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// heartbeat registers the worker and refreshes its info every interval, until ctx is done.
+// The worker is unregistered then, and if it dies without doing so, its info expires after a few missed heartbeats.
+func (w *Worker) heartbeat(ctx context.Context, interval time.Duration) {
+	ttl := interval * heartbeatTTLFactor
+	beat := func() {
+		info := *w.info
+		info.Tasks = w.inflight.list()
+		info.LastHeartbeat = w.clock.Now().UTC().Format(time.RFC3339)
+		if err := w.broker.WriteWorkerInfo(context.Background(), &info, ttl); err != nil {
+			logger.Warn("error writing worker heartbeat", "id", info.ID, "error", err)
+		}
+	}
+
+	beat()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := w.broker.ClearWorkerInfo(context.Background(), w.info.ID); err != nil {
+				logger.Warn("error clearing worker info", "id", w.info.ID, "error", err)
+			}
+			return
+		case <-ticker.C:
+			beat()
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	"github.com/engpetarmarinov/gotama/memory"
+	"testing"
+	"time"
+)
+
+func dequeueLeased(t *testing.T, broker *memory.Broker, lease time.Duration) *task.Message {
+	t.Helper()
+	msg, err := task.NewMessageFromRequest(&task.Request{Name: "email", Type: "once", Payload: []byte(`{"to":"gotama@gotama.io"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := broker.EnqueueTask(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	running, err := broker.DequeueTask(ctx, lease, task.QueueDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return running
+}
+
+func TestRenewLease(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	ctx := context.Background()
+	broker := memory.NewBroker(timeutil.NewRealClock())
+	epoch, err := broker.AcquireLeadership(ctx, "manager-1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease := 60 * time.Millisecond
+	msg := dequeueLeased(t, broker, lease)
+
+	renewCtx, stopRenewing := context.WithCancel(ctx)
+	cancelled := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		renewLease(renewCtx, func() { close(cancelled) }, broker, msg, lease)
+	}()
+
+	// the lease would have expired several times over without the renewals
+	time.Sleep(5 * lease)
+	if err := broker.ReclaimExpiredLeases(ctx, lease, epoch); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := broker.DequeueTask(ctx, lease, task.QueueDefault); !errors.Is(err, base.ErrorNoTasksInQueue) {
+		t.Fatalf("expected the renewed task not to be reclaimed, got %v", err)
+	}
+	select {
+	case <-cancelled:
+		t.Fatal("expected the renewed task not to be cancelled")
+	default:
+	}
+	stopRenewing()
+	<-done
+
+	// a lease which is not renewed anymore expires
+	time.Sleep(2 * lease)
+	if err := broker.ReclaimExpiredLeases(ctx, lease, epoch); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.EnqueueScheduledTasks(ctx, epoch); err != nil {
+		t.Fatal(err)
+	}
+	reclaimed, err := broker.DequeueTask(ctx, lease, task.QueueDefault)
+	if err != nil || reclaimed.ID != msg.ID {
+		t.Fatalf("expected the expired task to be reclaimed, got %v, %v", reclaimed, err)
+	}
+}
+
+func TestReclaimExpiredLeases(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	ctx := context.Background()
+	clock := timeutil.NewSimulatedClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	broker := memory.NewBroker(clock)
+	epoch, err := broker.AcquireLeadership(ctx, "manager-1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := dequeueLeased(t, broker, time.Minute)
+
+	clock.AdvanceTime(30 * time.Second)
+	if err := broker.ReclaimExpiredLeases(ctx, time.Minute, epoch); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := broker.DequeueTask(ctx, time.Minute, task.QueueDefault); !errors.Is(err, base.ErrorNoTasksInQueue) {
+		t.Fatalf("expected the leased task not to be reclaimed, got %v", err)
+	}
+
+	clock.AdvanceTime(time.Minute)
+	if err := broker.ReclaimExpiredLeases(ctx, time.Minute, epoch); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.EnqueueScheduledTasks(ctx, epoch); err != nil {
+		t.Fatal(err)
+	}
+	reclaimed, err := broker.DequeueTask(ctx, time.Minute, task.QueueDefault)
+	if err != nil || reclaimed.ID != msg.ID {
+		t.Fatalf("expected the expired task to be reclaimed, got %v, %v", reclaimed, err)
+	}
+	if err := broker.ExtendLease(ctx, msg, time.Minute); !errors.Is(err, base.ErrorLeaseExpired) {
+		t.Errorf("expected the expired lease not to be extended, got %v", err)
+	}
+	if err := broker.ExtendLease(ctx, reclaimed, time.Minute); err != nil {
+		t.Errorf("expected the lease of the dequeued task to be extended, got %v", err)
+	}
+}
+
+func TestRenewLeaseTinyLease(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	broker := memory.NewBroker(timeutil.NewRealClock())
+	msg := dequeueLeased(t, broker, time.Minute)
+	// a lease shorter than three nanoseconds must not panic the ticker
+	renewLease(ctx, cancel, broker, msg, time.Nanosecond)
+}
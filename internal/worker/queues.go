@@ -0,0 +1,102 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// queues holds the queues a worker consumes and decides in which order they are checked for pending tasks.
+type queues struct {
+	// names are sorted by weight, highest first
+	names   []string
+	weights map[string]int
+	strict  bool
+}
+
+// parseQueues parses a list of weighted queues, e.g. "critical=6,default=3,low=1".
+// A queue without a weight has a weight of 1.
+// With strict priority a queue is consumed only when all queues with a higher weight are empty,
+// otherwise the queues are consumed proportionally to their weights.
+func parseQueues(s string, strict bool) (*queues, error) {
+	q := &queues{
+		weights: map[string]int{},
+		strict:  strict,
+	}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		name, weightStr, found := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		weight := 1
+		if found {
+			var err error
+			weight, err = strconv.Atoi(strings.TrimSpace(weightStr))
+			if err != nil || weight <= 0 {
+				return nil, fmt.Errorf("invalid weight of queue %s: %s", name, weightStr)
+			}
+		}
+
+		if err := task.ValidateQueueName(name); err != nil {
+			return nil, err
+		}
+		if _, ok := q.weights[name]; ok {
+			return nil, fmt.Errorf("queue %s is listed more than once", name)
+		}
+		q.weights[name] = weight
+		q.names = append(q.names, name)
+	}
+
+	if len(q.names) == 0 {
+		return nil, errors.New("no queues to consume")
+	}
+
+	sort.SliceStable(q.names, func(i, j int) bool {
+		return q.weights[q.names[i]] > q.weights[q.names[j]]
+	})
+
+	return q, nil
+}
+
+// order returns the queue names in the order they should be checked for the next task.
+func (q *queues) order() []string {
+	if q.strict || len(q.names) == 1 {
+		return q.names
+	}
+
+	var total int
+	for _, name := range q.names {
+		total += q.weights[name]
+	}
+
+	// weighted random order without replacement, a queue comes first with probability weight/total
+	names := make([]string, 0, len(q.names))
+	remaining := make(map[string]bool, len(q.names))
+	for _, name := range q.names {
+		remaining[name] = true
+	}
+	for len(names) < len(q.names) {
+		n := rand.Intn(total)
+		for _, name := range q.names {
+			if !remaining[name] {
+				continue
+			}
+			n -= q.weights[name]
+			if n < 0 {
+				names = append(names, name)
+				remaining[name] = false
+				total -= q.weights[name]
+				break
+			}
+		}
+	}
+
+	return names
+}
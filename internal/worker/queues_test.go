@@ -0,0 +1,102 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQueues(t *testing.T) {
+	tests := []struct {
+		desc        string
+		input       string
+		wantNames   []string
+		wantWeights map[string]int
+		wantErr     bool
+	}{
+		{
+			desc:        "single queue without weight",
+			input:       "default",
+			wantNames:   []string{"default"},
+			wantWeights: map[string]int{"default": 1},
+		},
+		{
+			desc:        "weighted queues are sorted by weight",
+			input:       "low=1, critical=6,default=3",
+			wantNames:   []string{"critical", "default", "low"},
+			wantWeights: map[string]int{"critical": 6, "default": 3, "low": 1},
+		},
+		{
+			desc:    "invalid weight",
+			input:   "critical=high",
+			wantErr: true,
+		},
+		{
+			desc:    "zero weight",
+			input:   "critical=0",
+			wantErr: true,
+		},
+		{
+			desc:    "duplicated queue",
+			input:   "default=1,default=2",
+			wantErr: true,
+		},
+		{
+			desc:    "invalid queue name",
+			input:   "gotama:default",
+			wantErr: true,
+		},
+		{
+			desc:    "no queues",
+			input:   " , ",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		q, err := parseQueues(tc.input, false)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: parseQueues(%q) expected an error", tc.desc, tc.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: parseQueues(%q) returned error %v", tc.desc, tc.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(q.names, tc.wantNames) {
+			t.Errorf("%s: names = %v, want %v", tc.desc, q.names, tc.wantNames)
+		}
+		if !reflect.DeepEqual(q.weights, tc.wantWeights) {
+			t.Errorf("%s: weights = %v, want %v", tc.desc, q.weights, tc.wantWeights)
+		}
+	}
+}
+
+func TestQueuesOrder(t *testing.T) {
+	strict, err := parseQueues("low=1,critical=6,default=3", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if got, want := strict.order(), []string{"critical", "default", "low"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("strict order = %v, want %v", got, want)
+		}
+	}
+
+	weighted, err := parseQueues("low=1,critical=6,default=3", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		order := weighted.order()
+		if len(order) != 3 {
+			t.Fatalf("weighted order = %v, want all 3 queues", order)
+		}
+		first[order[0]]++
+	}
+	if !(first["critical"] > first["default"] && first["default"] > first["low"] && first["low"] > 0) {
+		t.Errorf("weighted order picked first %v, want it proportional to the weights", first)
+	}
+}
@@ -3,25 +3,46 @@ package worker
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/engpetarmarinov/gotama/internal/base"
 	"github.com/engpetarmarinov/gotama/internal/config"
 	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/metrics"
 	"github.com/engpetarmarinov/gotama/internal/processors"
 	"github.com/engpetarmarinov/gotama/internal/task"
 	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	"github.com/engpetarmarinov/gotama/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"runtime"
 	"strconv"
 	"sync"
 	"time"
 )
 
-var maxRetry = 3
+const (
+	defaultTaskLease       = 30 * time.Second
+	defaultTaskDeadline    = 5 * time.Minute
+	defaultShutdownTimeout = 30 * time.Second
+	pollInterval           = 5 * time.Second
+	defaultMaxHandBacks    = 10
+)
 
 type Broker interface {
+	EnqueueTask(ctx context.Context, msg *task.Message) error
 	UpdateTask(ctx context.Context, msg *task.Message) error
-	DequeueTask(ctx context.Context, qname string) (*task.Message, error)
+	DequeueTask(ctx context.Context, lease time.Duration, qnames ...string) (*task.Message, error)
+	NotifyPending(ctx context.Context, qnames ...string) <-chan struct{}
+	ExtendLease(ctx context.Context, msg *task.Message, lease time.Duration) error
 	MarkTaskAsComplete(ctx context.Context, msg *task.Message) error
 	RequeueTaskFailed(ctx context.Context, msg *task.Message) error
 	RequeueTaskRetry(ctx context.Context, msg *task.Message) error
+	RequeueTaskPending(ctx context.Context, msg *task.Message) error
+	GetTask(ctx context.Context, taskID string) (*task.Message, error)
+	WriteWorkerInfo(ctx context.Context, info *base.WorkerInfo, ttl time.Duration) error
+	ClearWorkerInfo(ctx context.Context, id string) error
+	RecordDelivery(ctx context.Context, qname string, delivery *base.Delivery) error
 }
 
 type Worker struct {
@@ -30,101 +51,361 @@ type Worker struct {
 	config config.API
 	clock  timeutil.Clock
 	cancel context.CancelFunc
+	// stop interrupts the tasks which are still processed when the shutdown timeout passes
+	stop            context.Context
+	stopCancel      context.CancelFunc
+	shutdownTimeout time.Duration
+
+	metricsServer *http.Server
+
+	info            *base.WorkerInfo
+	inflight        *inflight
+	heartbeatCancel context.CancelFunc
+	heartbeatDone   chan struct{}
+
+	newProcessor processorFactory
+	// maxHandBacks is the number of times a task without a processor is handed back before it is failed
+	maxHandBacks int
 }
 
+// processorFactory returns the processor of the tasks with a name, the workers use processors.NewProcessor.
+type processorFactory func(config config.API, name string) (processors.Processor, error)
+
 func NewWorker(config config.API, broker Broker, clock timeutil.Clock) *Worker {
 	wg := &sync.WaitGroup{}
 	return &Worker{
-		wg:     wg,
-		broker: broker,
-		config: config,
-		clock:  clock,
+		wg:           wg,
+		broker:       broker,
+		config:       config,
+		clock:        clock,
+		inflight:     newInflight(),
+		newProcessor: processors.NewProcessor,
 	}
 }
 
 func (w *Worker) Run() {
-	workerGoroutinesStr := w.config.Get("WORKER_GOROUTINES")
-	workerGoroutines, err := strconv.Atoi(workerGoroutinesStr)
+	workerGoroutines := runtime.NumCPU()
+	if workerGoroutinesStr := w.config.Get("WORKER_GOROUTINES"); workerGoroutinesStr != "" {
+		var err error
+		workerGoroutines, err = strconv.Atoi(workerGoroutinesStr)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+
+	lease, err := config.GetPositiveDuration(w.config, "WORKER_TASK_LEASE", defaultTaskLease)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	backoffBase, err := config.GetDuration(w.config, "WORKER_RETRY_BACKOFF_BASE", defaultRetryBackoffBase)
+	if err != nil {
+		panic(err.Error())
+	}
+	backoffMax, err := config.GetDuration(w.config, "WORKER_RETRY_BACKOFF_MAX", defaultRetryBackoffMax)
+	if err != nil {
+		panic(err.Error())
+	}
+	retryBackoff := backoff{shape: task.BackoffExponential, base: backoffBase, max: backoffMax}
+
+	w.shutdownTimeout, err = config.GetDuration(w.config, "WORKER_SHUTDOWN_TIMEOUT", defaultShutdownTimeout)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	w.maxHandBacks = defaultMaxHandBacks
+	if maxHandBacksStr := w.config.Get("WORKER_MAX_HAND_BACKS"); maxHandBacksStr != "" {
+		w.maxHandBacks, err = strconv.Atoi(maxHandBacksStr)
+		if err != nil || w.maxHandBacks <= 0 {
+			panic(fmt.Sprintf("invalid WORKER_MAX_HAND_BACKS %s, expected a positive number", maxHandBacksStr))
+		}
+	}
+
+	workerQueuesStr := w.config.Get("WORKER_QUEUES")
+	if workerQueuesStr == "" {
+		workerQueuesStr = task.QueueDefault
+	}
+	workerQueues, err := parseQueues(workerQueuesStr, w.config.Get("WORKER_STRICT_PRIORITY") == "true")
+	if err != nil {
+		panic(err.Error())
+	}
+	logger.Info("worker consuming queues", "queues", workerQueuesStr, "strict", workerQueues.strict)
+
+	heartbeatInterval, err := config.GetPositiveDuration(w.config, "WORKER_HEARTBEAT_INTERVAL", defaultHeartbeatInterval)
 	if err != nil {
 		panic(err.Error())
 	}
+	w.info = newWorkerInfo(workerGoroutines, workerQueues.names, w.clock.Now())
+	heartbeatCtx, heartbeatCancel := context.WithCancel(context.Background())
+	w.heartbeatCancel = heartbeatCancel
+	w.heartbeatDone = make(chan struct{})
+	go func() {
+		defer close(w.heartbeatDone)
+		w.heartbeat(heartbeatCtx, heartbeatInterval)
+	}()
+
+	w.serveMetrics()
 
 	workerCtx, workerCancel := context.WithCancel(context.Background())
 	w.cancel = workerCancel
+	w.stop, w.stopCancel = context.WithCancel(context.Background())
 
+	tasks := make(chan *task.Message)
+	idle := make(chan struct{}, workerGoroutines)
+	handedBack := make(chan string, workerGoroutines)
 	for i := 0; i < workerGoroutines; i++ {
+		idle <- struct{}{}
 		w.wg.Add(1)
-		go func(ctx context.Context, wg *sync.WaitGroup) {
+		go func(wg *sync.WaitGroup) {
 			defer wg.Done()
-			tick := time.Tick(time.Second)
-			for {
-				select {
-				case <-ctx.Done():
-					logger.Info("worker goroutine received done")
-					return
-				case <-tick:
-					err := exec(context.Background(), w.config, w.broker, w.clock)
-					if errors.Is(err, base.ErrorNoTasksInQueue) {
-						logger.Info("no tasks in queue")
-					} else if err != nil {
-						logger.Error("worker exec error", "error", err)
-					}
+			for msg := range tasks {
+				w.inflight.add(msg.ID)
+				err := exec(context.Background(), w.stop, w.config, w.newProcessor, w.broker, w.clock, msg, lease, retryBackoff, w.maxHandBacks)
+				w.inflight.remove(msg.ID)
+				if errors.Is(err, base.ErrorUnknownTaskName) {
+					// the task was handed back for a worker with its processor, the dispatcher skips its queue for a while
+					logger.Warn("no processor for the task, handed it back", "id", msg.ID, "name", msg.Name)
+					handedBack <- msg.Queue
+				} else if err != nil {
+					logger.Error("worker exec error", "error", err)
 				}
+				idle <- struct{}{}
 			}
+			logger.Info("worker goroutine received done")
+		}(w.wg)
+	}
+
+	w.wg.Add(1)
+	go func(ctx context.Context, wg *sync.WaitGroup) {
+		defer wg.Done()
+		defer close(tasks)
+		w.dispatch(ctx, workerQueues, tasks, idle, handedBack, lease)
+	}(workerCtx, w.wg)
+}
+
+// dispatch dequeues a task whenever a worker goroutine is idle and hands it over to it.
+// While the queue is empty it blocks until the broker notifies about pending tasks.
+// A queue a task without a processor was handed back to is skipped for the poll interval,
+// otherwise the worker would dequeue the task again right away instead of leaving it to the workers with its processor.
+func (w *Worker) dispatch(ctx context.Context, queues *queues, tasks chan<- *task.Message, idle <-chan struct{}, handedBack <-chan string, lease time.Duration) {
+	notify := w.broker.NotifyPending(ctx, queues.names...)
+	skipped := map[string]time.Time{}
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("worker dispatcher received done")
+			return
+		case <-idle:
+		}
 
-		}(workerCtx, w.wg)
+		msg, err := w.dequeue(ctx, queues, notify, handedBack, skipped, lease)
+		if err != nil {
+			logger.Info("worker dispatcher received done")
+			return
+		}
+		tasks <- msg
 	}
 }
 
+// dequeue returns the next pending task of the queues which are not skipped, waiting for one if they are empty.
+// It returns an error only when ctx is done.
+func (w *Worker) dequeue(ctx context.Context, queues *queues, notify <-chan struct{}, handedBack <-chan string, skipped map[string]time.Time, lease time.Duration) (*task.Message, error) {
+	for {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		qnames := unskippedQueues(queues.order(), handedBack, skipped)
+		if len(qnames) > 0 {
+			// the dequeue itself is not cancelled, otherwise a task could be leased without anyone processing it
+			msg, err := w.broker.DequeueTask(context.Background(), lease, qnames...)
+			if err == nil {
+				return msg, nil
+			}
+			if !errors.Is(err, base.ErrorNoTasksInQueue) {
+				logger.Error("worker dequeue error", "error", err)
+			}
+		}
+
+		// poll now and then in case a notification was missed, e.g. while reconnecting to the broker
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-notify:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// unskippedQueues returns the queues which are not skipped, in order.
+// The queues tasks were handed back to since the last call are skipped for the poll interval.
+func unskippedQueues(qnames []string, handedBack <-chan string, skipped map[string]time.Time) []string {
+	now := time.Now()
+	for drained := false; !drained; {
+		select {
+		case qname := <-handedBack:
+			skipped[qname] = now.Add(pollInterval)
+		default:
+			drained = true
+		}
+	}
+
+	unskipped := make([]string, 0, len(qnames))
+	for _, qname := range qnames {
+		if until, ok := skipped[qname]; ok && now.Before(until) {
+			continue
+		}
+		delete(skipped, qname)
+		unskipped = append(unskipped, qname)
+	}
+	return unskipped
+}
+
+// serveMetrics exposes the metrics of the worker on WORKER_METRICS_PORT, if it is set.
+func (w *Worker) serveMetrics() {
+	port := w.config.Get("WORKER_METRICS_PORT")
+	if port == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	w.metricsServer = &http.Server{
+		Addr:    fmt.Sprintf(":%s", port),
+		Handler: mux,
+	}
+	go func(server *http.Server) {
+		logger.Info("worker metrics listening on", "address", server.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("worker metrics server error", "error", err)
+		}
+	}(w.metricsServer)
+}
+
+// Shutdown stops dequeuing tasks and waits for the tasks being processed to finish.
+// The ones still processed after WORKER_SHUTDOWN_TIMEOUT are interrupted and handed back to the pending queue.
 func (w *Worker) Shutdown() error {
-	logger.Info("worker shutting down...")
+	logger.Info("worker shutting down...", "timeout", w.shutdownTimeout.String())
 	w.cancel()
-	w.wg.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(w.shutdownTimeout):
+		logger.Warn("worker shutdown timeout passed, interrupting the tasks being processed", "tasks", w.inflight.list())
+		w.stopCancel()
+		<-done
+	}
+	w.stopCancel()
 	logger.Info("worker gracefully shut down all goroutines")
+	// the worker stays registered while it finishes its tasks
+	w.heartbeatCancel()
+	<-w.heartbeatDone
+	if w.metricsServer != nil {
+		if err := w.metricsServer.Shutdown(context.Background()); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func exec(ctx context.Context, config config.API, broker Broker, clock timeutil.Clock) error {
-	//handle eventual panic in processors, we don't want the worker to stop
+// exec processes the task. The processing is interrupted when stop is done and the task is handed back to the pending queue.
+// A task without a processor is handed back too, for the other workers of the queue, and base.ErrorUnknownTaskName is returned.
+func exec(ctx context.Context, stop context.Context, config config.API, newProcessor processorFactory, broker Broker, clock timeutil.Clock, msg *task.Message, lease time.Duration, retryBackoff backoff, maxHandBacks int) (err error) {
+	//continue the trace the task was enqueued in, the runs of recurring tasks get their own traces linked to it
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("task.id", msg.ID),
+			attribute.String("task.name", msg.Name),
+			attribute.String("task.queue", msg.Queue),
+			attribute.Int("task.attempt", msg.NumRetries+1),
+		),
+	}
+	enqueueCtx := tracing.Extract(ctx, msg.TraceContext)
+	if msg.Type == task.TypeOnce {
+		ctx = enqueueCtx
+	} else {
+		opts = append(opts, trace.WithLinks(trace.LinkFromContext(enqueueCtx)))
+	}
+	ctx, span := tracing.Start(ctx, "exec "+msg.Name, opts...)
+	defer func() { tracing.End(span, err) }()
+	//handle eventual panic outside of the processors, we don't want the worker to stop.
+	//deferred after the span, so the span ends with the panic as its error
 	defer func() {
 		if r := recover(); r != nil {
 			logger.Error("recovering from panic", "error", r)
+			err = fmt.Errorf("panic: %v", r)
 		}
 	}()
 
-	msg, err := broker.DequeueTask(ctx, task.QueueDefault)
-	if err != nil {
+	processor, err := newProcessor(config, msg.Name)
+	if errors.Is(err, base.ErrorUnknownTaskName) {
+		if handBackErr := handBack(ctx, config, broker, clock, msg, err, maxHandBacks); handBackErr != nil {
+			return handBackErr
+		}
 		return err
 	}
-
-	msgName, err := task.GetName(msg.Name)
 	if err != nil {
 		return err
 	}
-
-	msg.Status = task.StatusRunning
-	err = broker.UpdateTask(ctx, msg)
+	// the manager does not know the retry policy of the processors registered only in the workers
+	defaultRetryPolicy, err := processors.DefaultRetryPolicy(config, msg.Name)
 	if err != nil {
 		return err
 	}
+	msg.RetryPolicy = msg.RetryPolicy.Merge(defaultRetryPolicy)
 
-	processor, err := processors.ProcessorFactory(config, msgName)
+	msg.Status = task.StatusRunning
+	msg.RetryAt = nil
+	err = broker.UpdateTask(ctx, msg)
 	if err != nil {
 		return err
 	}
 
-	taskDeadline, err := time.ParseDuration(config.Get("WORKER_TASK_DEADLINE"))
-	if err != nil {
-		return err
+	taskDeadline := defaultTaskDeadline
+	if taskDeadlineStr := config.Get("WORKER_TASK_DEADLINE"); taskDeadlineStr != "" {
+		taskDeadline, err = time.ParseDuration(taskDeadlineStr)
+		if err != nil {
+			return err
+		}
+		if taskDeadline <= 0 {
+			return fmt.Errorf("invalid WORKER_TASK_DEADLINE: has to be positive")
+		}
 	}
 
-	taskCtx, taskCancel := context.WithDeadline(context.Background(), clock.Now().Add(taskDeadline))
+	taskCtx, taskCancel := context.WithDeadline(ctx, clock.Now().Add(taskDeadline))
 	defer taskCancel()
-	err = processor.ProcessTask(taskCtx, msg)
+	defer context.AfterFunc(stop, taskCancel)()
+	taskCtx = processors.WithLeaseExtender(taskCtx, func(ctx context.Context, lease time.Duration) error {
+		return broker.ExtendLease(ctx, msg, lease)
+	})
+	taskCtx = processors.WithDeliveryRecorder(taskCtx, func(ctx context.Context, delivery *base.Delivery) error {
+		return broker.RecordDelivery(ctx, msg.Queue, delivery)
+	})
+	taskCtx = processors.WithTaskGetter(taskCtx, broker.GetTask)
+	go renewLease(taskCtx, taskCancel, broker, msg, lease)
+	processCtx, processSpan := tracing.Start(taskCtx, "ProcessTask "+msg.Name)
+	start := time.Now()
+	err = processTask(processCtx, processor, msg)
+	tracing.End(processSpan, err)
+	if err != nil && stop.Err() != nil {
+		// the attempt was cut short by the shutdown, so it is not counted
+		logger.Warn("task interrupted by the worker shutting down, requeueing it", "id", msg.ID)
+		return broker.RequeueTaskPending(ctx, msg)
+	}
 	if err != nil {
-		handleProcessTaskError(ctx, broker, clock, msg, err)
+		metrics.ProcessingDuration.WithLabelValues(msg.Name, msg.Queue, metrics.ResultFailed).Observe(time.Since(start).Seconds())
+		metrics.TasksFailed.WithLabelValues(msg.Name, msg.Queue).Inc()
+		handleProcessTaskError(ctx, config, broker, clock, msg, err, retryBackoff)
 		return err
 	}
+	metrics.ProcessingDuration.WithLabelValues(msg.Name, msg.Queue, metrics.ResultSucceeded).Observe(time.Since(start).Seconds())
+	metrics.TasksProcessed.WithLabelValues(msg.Name, msg.Queue).Inc()
 
 	msg.Status = task.StatusSucceeded
 	now := clock.Now()
@@ -132,6 +413,7 @@ func exec(ctx context.Context, config config.API, broker Broker, clock timeutil.
 	//Reset NumRetries for recurring tasks
 	if msg.Type == task.TypeRecurring {
 		msg.NumRetries = 0
+		msg.NumHandBacks = 0
 	}
 
 	err = broker.UpdateTask(ctx, msg)
@@ -139,31 +421,137 @@ func exec(ctx context.Context, config config.API, broker Broker, clock timeutil.
 		return err
 	}
 
-	return broker.MarkTaskAsComplete(ctx, msg)
+	err = broker.MarkTaskAsComplete(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	enqueueCallback(ctx, config, broker, msg, processors.WebhookEventSucceeded)
+	return nil
 }
 
-func handleProcessTaskError(ctx context.Context, broker Broker, clock timeutil.Clock, msg *task.Message, err error) {
+// handBack hands the task without a processor back to the pending queue, for the other workers of the queue.
+// The attempt is not counted, the task is not even started. A task which was handed back maxHandBacks times
+// is failed to the dead letter queue instead, since none of the workers of the queue seems to have its processor.
+func handBack(ctx context.Context, config config.API, broker Broker, clock timeutil.Clock, msg *task.Message, err error, maxHandBacks int) error {
+	msg.NumHandBacks++
+	if msg.NumHandBacks < maxHandBacks {
+		return broker.RequeueTaskPending(ctx, msg)
+	}
+
+	logger.Error("no worker of the queue has the processor of the task, failing it", "id", msg.ID, "name", msg.Name, "queue", msg.Queue)
+	msg.Status = task.StatusFailed
+	errStr := err.Error()
+	msg.Error = &errStr
+	now := clock.Now()
+	msg.FailedAt = &now
+	msg.RetryAt = nil
+	if err := broker.UpdateTask(ctx, msg); err != nil {
+		return err
+	}
+	if err := broker.RequeueTaskFailed(ctx, msg); err != nil {
+		return err
+	}
+	metrics.TasksDead.WithLabelValues(msg.Name, msg.Queue).Inc()
+	enqueueCallback(ctx, config, broker, msg, processors.WebhookEventDead)
+	return nil
+}
+
+// processTask processes the task with the processor. A panic in the processor fails the attempt like an error does,
+// so a task which always panics runs out of attempts instead of being retried forever.
+func processTask(ctx context.Context, processor processors.Processor, msg *task.Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("recovering from panic in processor", "id", msg.ID, "error", r)
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return processor.ProcessTask(ctx, msg)
+}
+
+// renewLease keeps extending the lease of msg while it is being processed.
+// The processing is cancelled if the lease was lost, since the task has been handed to another worker by then.
+func renewLease(ctx context.Context, cancel context.CancelFunc, broker Broker, msg *task.Message, lease time.Duration) {
+	// a lease of less than 3ns would make the ticker panic
+	ticker := time.NewTicker(max(lease/3, time.Nanosecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := broker.ExtendLease(ctx, msg, lease)
+			if errors.Is(err, base.ErrorLeaseExpired) {
+				logger.Error("lost lease of running task, cancelling it", "id", msg.ID)
+				cancel()
+				return
+			} else if err != nil {
+				logger.Warn("error renewing task lease", "id", msg.ID, "error", err)
+			}
+		}
+	}
+}
+
+func handleProcessTaskError(ctx context.Context, config config.API, broker Broker, clock timeutil.Clock, msg *task.Message, err error, retryBackoff backoff) {
 	msg.Status = task.StatusFailed
 	errStr := err.Error()
 	msg.Error = &errStr
 	now := clock.Now()
 	msg.FailedAt = &now
 	msg.NumRetries = msg.NumRetries + 1
+	msg.RetryAt = nil
+	canRetry := msg.NumRetries < msg.RetryPolicy.GetMaxAttempts()
+	if canRetry {
+		retryAt := now.Add(retryBackoff.withPolicy(msg.RetryPolicy).delay(msg.NumRetries))
+		msg.RetryAt = &retryAt
+	}
 	upErr := broker.UpdateTask(ctx, msg)
+	if errors.Is(upErr, base.ErrorLeaseExpired) {
+		// the attempt was already failed by the reclaim, the task belongs to another worker by now
+		logger.Error("lost lease of failed task", "id", msg.ID)
+		return
+	}
 	if upErr != nil {
 		logger.Error("error updating task when handling task error", "error", upErr)
 	}
 
-	if msg.NumRetries < maxRetry {
+	if canRetry {
 		scheduleErr := broker.RequeueTaskRetry(ctx, msg)
 		if scheduleErr != nil {
 			logger.Error("error scheduling retry", "error", scheduleErr)
+		} else {
+			metrics.TasksRetried.WithLabelValues(msg.Name, msg.Queue).Inc()
 		}
 	} else {
 		//dead letter queue
 		requeueFailedErr := broker.RequeueTaskFailed(ctx, msg)
 		if requeueFailedErr != nil {
 			logger.Error("error scheduling retry", "error", requeueFailedErr)
+		} else {
+			metrics.TasksDead.WithLabelValues(msg.Name, msg.Queue).Inc()
+			enqueueCallback(ctx, config, broker, msg, processors.WebhookEventDead)
 		}
 	}
 }
+
+// enqueueCallback enqueues a webhook task which posts msg to its callback URL, if it has one.
+// The delivery goes through the queue of msg, so it is retried and survives restarts like any other task.
+func enqueueCallback(ctx context.Context, config config.API, broker Broker, msg *task.Message, event string) {
+	// the callback of a webhook task has no URL, it only holds the secret of the webhook
+	if msg.Callback == nil || msg.Callback.URL == "" {
+		return
+	}
+
+	webhookMsg, err := processors.NewWebhookMessage(config, msg, event)
+	if err != nil {
+		logger.Error("error creating webhook task", "id", msg.ID, "error", err)
+		return
+	}
+	webhookMsg.TraceContext = tracing.Inject(ctx)
+	err = broker.EnqueueTask(ctx, webhookMsg)
+	if err != nil {
+		logger.Error("error enqueueing webhook task", "id", msg.ID, "error", err)
+		return
+	}
+	metrics.TasksEnqueued.WithLabelValues(webhookMsg.Name, webhookMsg.Queue).Inc()
+}
@@ -0,0 +1,140 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/config"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/processors"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	"github.com/engpetarmarinov/gotama/memory"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const nameCounting = "COUNTING"
+
+// countingProcessor counts the tasks it processed.
+type countingProcessor struct {
+	processed atomic.Int32
+}
+
+var counting = &countingProcessor{}
+
+func (p *countingProcessor) ProcessTask(context.Context, *task.Message) error {
+	p.processed.Add(1)
+	return nil
+}
+
+func (p *countingProcessor) ValidatePayload([]byte) error {
+	return nil
+}
+
+func init() {
+	processors.Register(processors.Registration{
+		Name: nameCounting,
+		New: func(config.API) processors.Processor {
+			return counting
+		},
+	})
+}
+
+type testConfig map[string]string
+
+func (c testConfig) Get(key string) string {
+	return c[key]
+}
+
+func TestWorkerHandsBackTaskWithoutProcessor(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	ctx := context.Background()
+	clock := timeutil.NewRealClock()
+	broker := memory.NewBroker(clock)
+	cfg := testConfig{"WORKER_GOROUTINES": "1"}
+	processed := counting.processed.Load()
+
+	lastID, err := broker.LastEventID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := task.NewMessageFromRequest(&task.Request{Name: nameCounting, Type: "once", Payload: []byte(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.EnqueueTask(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// the worker without the processor consumes the queue alone at first, so it is the one to dequeue the task
+	without := NewWorker(cfg, broker, clock)
+	without.newProcessor = func(_ config.API, name string) (processors.Processor, error) {
+		return nil, fmt.Errorf("%w: no processor for %s", base.ErrorUnknownTaskName, name)
+	}
+	without.Run()
+	defer without.Shutdown()
+	waitForEvents(t, broker, lastID, base.EventEnqueued, base.EventStarted, base.EventEnqueued)
+
+	with := NewWorker(cfg, broker, clock)
+	with.Run()
+	defer with.Shutdown()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		done, err := broker.GetTask(ctx, msg.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done.Status == task.StatusSucceeded {
+			if done.NumRetries != 0 || done.Error != nil {
+				t.Errorf("expected the hand back not to count as an attempt, got %d retries and error %v", done.NumRetries, done.Error)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the worker with the processor to process the task, got %s", done.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := counting.processed.Load() - processed; n != 1 {
+		t.Errorf("expected the task to be processed once, got %d", n)
+	}
+}
+
+func TestUnskippedQueues(t *testing.T) {
+	handedBack := make(chan string, 2)
+	skipped := map[string]time.Time{"low": time.Now().Add(-time.Second)}
+	handedBack <- "billing"
+
+	qnames := unskippedQueues([]string{"billing", "default", "low"}, handedBack, skipped)
+	if !reflect.DeepEqual(qnames, []string{"default", "low"}) {
+		t.Errorf("expected the queue the task was handed back to to be skipped, got %v", qnames)
+	}
+	if _, ok := skipped["low"]; ok {
+		t.Error("expected the skip of the queue to expire")
+	}
+}
+
+// waitForEvents waits for the events of the given types after lastID, in order.
+func waitForEvents(t *testing.T, broker *memory.Broker, lastID string, types ...base.EventType) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(types) > 0 && time.Now().Before(deadline) {
+		events, err := broker.ReadEvents(context.Background(), lastID, 100*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, event := range events {
+			if len(types) > 0 && event.Type == types[0] {
+				types = types[1:]
+			}
+			lastID = event.ID
+		}
+	}
+	if len(types) > 0 {
+		t.Fatalf("expected the events %v", types)
+	}
+}
@@ -0,0 +1,751 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/encryption"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The statuses a task can have, the same as the ones of the status index of the redis broker.
+const (
+	statusPending   = "pending"
+	statusDelayed   = "delayed"
+	statusScheduled = "scheduled"
+	statusRunning   = "running"
+	statusRetry     = "retry"
+	statusFailed    = "failed"
+	statusSucceeded = "succeeded"
+)
+
+// maxDelayedTasksPerTick bounds the delayed and the cron tasks enqueued by a single scheduler tick per queue,
+// the rest is enqueued on the next one.
+const maxDelayedTasksPerTick = 1000
+
+var errNotFound = errors.New("not found")
+
+// entry holds a stored task, the counterpart of the task hash of the redis broker.
+type entry struct {
+	queue string
+	// msg is the encoded task message, tasks are stored encoded so that callers cannot change them in place
+	msg          string
+	status       string
+	createdAt    int64
+	pendingSince int64
+	retryAt      int64
+	period       time.Duration
+	cron         string
+	timezone     string
+	// leaseToken is the token of the lease the running task was dequeued with
+	leaseToken string
+}
+
+// queue holds the lists and the sorted sets of a queue. The lists are ordered like redis lists,
+// tasks are pushed to the head and dequeued from the tail of the pending list.
+type queue struct {
+	pending   []string
+	running   []string
+	retry     []string
+	failed    []string
+	scheduled []string
+	// delayed, cron and lease map a task ID to its process at, next run and lease expiration time in unix milli sec
+	delayed map[string]int64
+	cron    map[string]int64
+	lease   map[string]int64
+}
+
+func newQueue() *queue {
+	return &queue{
+		delayed: make(map[string]int64),
+		cron:    make(map[string]int64),
+		lease:   make(map[string]int64),
+	}
+}
+
+// Broker keeps the tasks in memory with the same semantics as the redis broker.
+// It lets the manager and the worker run in a single process without redis, e.g. in tests driven by a timeutil.SimulatedClock.
+// Nothing is persisted, the tasks are gone when the process exits.
+type Broker struct {
+	mu      sync.Mutex
+	clock   timeutil.Clock
+	keyring *encryption.Keyring
+
+	tasks  map[string]*entry
+	queues map[string]*queue
+
+	subscribers map[*subscriber]struct{}
+	events      []*base.TaskEvent
+	// eventsChanged is closed and replaced whenever an event is published, to wake up the readers
+	eventsChanged chan struct{}
+
+	leader      *leader
+	leaderEpoch int64
+
+	workers    map[string]*workerEntry
+	deliveries map[string][]*base.Delivery
+}
+
+// subscriber is notified when tasks are pushed to the pending list of one of its queues.
+type subscriber struct {
+	qnames []string
+	notify chan struct{}
+}
+
+func NewBroker(clock timeutil.Clock) *Broker {
+	return &Broker{
+		clock:         clock,
+		tasks:         make(map[string]*entry),
+		queues:        make(map[string]*queue),
+		subscribers:   make(map[*subscriber]struct{}),
+		eventsChanged: make(chan struct{}),
+		workers:       make(map[string]*workerEntry),
+		deliveries:    make(map[string][]*base.Delivery),
+	}
+}
+
+// WithKeyring encrypts the payloads of the tasks stored from now on with the keyring, and decrypts them when read.
+func (b *Broker) WithKeyring(keyring *encryption.Keyring) *Broker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.keyring = keyring
+	return b
+}
+
+// queue returns the queue with the given name, creating it if it does not exist.
+func (b *Broker) queue(qname string) *queue {
+	q, ok := b.queues[qname]
+	if !ok {
+		q = newQueue()
+		b.queues[qname] = q
+	}
+	return q
+}
+
+// queueNames returns the names of all queues tasks have been enqueued to, sorted.
+func (b *Broker) queueNames() []string {
+	qnames := make([]string, 0, len(b.queues))
+	for qname := range b.queues {
+		qnames = append(qnames, qname)
+	}
+	sort.Strings(qnames)
+	return qnames
+}
+
+// lpush adds id to the head of the list.
+func lpush(list []string, id string) []string {
+	return append([]string{id}, list...)
+}
+
+// rpush adds id to the tail of the list, so it is dequeued next.
+func rpush(list []string, id string) []string {
+	return append(list, id)
+}
+
+// lrem removes all occurrences of id from the list and reports whether there were any.
+func lrem(list []string, id string) ([]string, bool) {
+	n := len(list)
+	list = slices.DeleteFunc(list, func(s string) bool { return s == id })
+	return list, len(list) < n
+}
+
+// due returns the IDs of the sorted set with a score up to until, sorted by score, at most limit of them.
+func due(set map[string]int64, until int64, limit int) []string {
+	var ids []string
+	for id, score := range set {
+		if score <= until {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if set[ids[i]] != set[ids[j]] {
+			return set[ids[i]] < set[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids
+}
+
+// notifyPending wakes up the subscribers of the queue.
+// Notifications are coalesced, so a subscriber which was not woken up yet is not notified again.
+func (b *Broker) notifyPending(qname string) {
+	for sub := range b.subscribers {
+		if !slices.Contains(sub.qnames, qname) {
+			continue
+		}
+		select {
+		case sub.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// setPending pushes the task to the pending list of the queue, to the tail if it should be processed next.
+func (b *Broker) setPending(q *queue, id string, e *entry, next bool) {
+	if next {
+		q.pending = rpush(q.pending, id)
+	} else {
+		q.pending = lpush(q.pending, id)
+	}
+	e.pendingSince = b.clock.Now().UnixMilli()
+	e.status = statusPending
+}
+
+// decode returns the task message of the entry with its current status,
+// the stored message keeps the status it was last updated with.
+func (b *Broker) decode(e *entry) (*task.Message, error) {
+	msg, err := task.DecodeMessage(e.msg, b.keyring)
+	if err != nil {
+		logger.Error("Error decoding msg", "error", err)
+		return nil, err
+	}
+	if status, ok := task.StatusFromIndex(e.status); ok {
+		msg.Status = status
+	}
+	return msg, nil
+}
+
+func (b *Broker) encode(msg *task.Message) (string, error) {
+	encoded, err := task.EncodeMessage(msg, b.keyring)
+	if err != nil {
+		return "", fmt.Errorf("cannot encode message: %v", err)
+	}
+	return string(encoded), nil
+}
+
+// GetAllTasks fetches tasks sorted by creation time, newest first, with a given offset.
+// The tasks can be narrowed down to a queue and to a status within that queue, leave them empty to get all tasks.
+func (b *Broker) GetAllTasks(ctx context.Context, qname string, status string, offset int, limit int) (int64, []*task.Message, error) {
+	if status != "" && qname == "" {
+		return 0, nil, errors.New("filtering by status requires a queue")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var ids []string
+	for id, e := range b.tasks {
+		if (qname == "" || e.queue == qname) && (status == "" || e.status == status) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		ei, ej := b.tasks[ids[i]], b.tasks[ids[j]]
+		if ei.createdAt != ej.createdAt {
+			return ei.createdAt > ej.createdAt
+		}
+		return ids[i] > ids[j]
+	})
+
+	tasks, err := b.getTasks(page(ids, offset, limit))
+	if err != nil {
+		return 0, nil, err
+	}
+	return int64(len(ids)), tasks, nil
+}
+
+// page returns the IDs from offset, at most limit of them.
+func page(ids []string, offset int, limit int) []string {
+	if offset >= len(ids) || limit <= 0 {
+		return nil
+	}
+	return ids[offset:min(offset+limit, len(ids))]
+}
+
+// getTasks returns the tasks with the given IDs, tasks which do not exist are skipped.
+func (b *Broker) getTasks(ids []string) ([]*task.Message, error) {
+	var tasks []*task.Message
+	for _, id := range ids {
+		e, ok := b.tasks[id]
+		if !ok {
+			continue
+		}
+		msg, err := b.decode(e)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, msg)
+	}
+	return tasks, nil
+}
+
+// GetTask fetches a task by its ID.
+func (b *Broker) GetTask(ctx context.Context, taskID string) (*task.Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.tasks[taskID]
+	if !ok {
+		return nil, errNotFound
+	}
+	return b.decode(e)
+}
+
+// nextCronRunMilli returns the next run time of a cron task after now in unix milli sec, 0 if msg is not a cron task.
+func nextCronRunMilli(msg *task.Message, now time.Time) (int64, error) {
+	if msg.Cron == "" {
+		return 0, nil
+	}
+	next, err := task.NextRun(msg.Cron, msg.Timezone, now)
+	if err != nil {
+		return 0, err
+	}
+	return next.UnixMilli(), nil
+}
+
+// EnqueueTask adds the given task to the pending list of the queue,
+// or to the delayed tasks of the queue if it should be processed later.
+func (b *Broker) EnqueueTask(ctx context.Context, msg *task.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	encoded, err := b.encode(msg)
+	if err != nil {
+		return err
+	}
+	now := b.clock.Now()
+	nextRun, err := nextCronRunMilli(msg, now)
+	if err != nil {
+		return err
+	}
+	if _, ok := b.tasks[msg.ID]; ok {
+		return errors.New("task id already exists")
+	}
+
+	q := b.queue(msg.Queue)
+	e := &entry{
+		queue:        msg.Queue,
+		msg:          encoded,
+		createdAt:    now.UnixMilli(),
+		pendingSince: now.UnixMilli(),
+		period:       msg.Period,
+		cron:         msg.Cron,
+		timezone:     msg.Timezone,
+	}
+	b.tasks[msg.ID] = e
+
+	switch {
+	case msg.Cron != "":
+		// a cron task is enqueued by the scheduler on its first run
+		q.cron[msg.ID] = nextRun
+		e.status = statusScheduled
+	case msg.ProcessAt != nil && msg.ProcessAt.After(now):
+		if msg.Type == task.TypeRecurring {
+			q.scheduled = lpush(q.scheduled, msg.ID)
+		}
+		q.delayed[msg.ID] = msg.ProcessAt.UnixMilli()
+		e.status = statusDelayed
+	default:
+		if msg.Type == task.TypeRecurring {
+			q.scheduled = lpush(q.scheduled, msg.ID)
+		}
+		b.setPending(q, msg.ID, e, false)
+		b.notifyPending(msg.Queue)
+	}
+
+	b.publishEvent(base.EventEnqueued, msg)
+	return nil
+}
+
+// DequeueTask moves a task from the pending to the running list of the first non-empty queue
+// and leases it for the given duration.
+func (b *Broker) DequeueTask(ctx context.Context, lease time.Duration, qnames ...string) (*task.Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, qname := range qnames {
+		q, ok := b.queues[qname]
+		if !ok || len(q.pending) == 0 {
+			continue
+		}
+		id := q.pending[len(q.pending)-1]
+		q.pending = q.pending[:len(q.pending)-1]
+		q.running = lpush(q.running, id)
+		q.lease[id] = b.clock.Now().Add(lease).UnixMilli()
+		e := b.tasks[id]
+		e.status = statusRunning
+		e.leaseToken = task.NewLeaseToken()
+
+		msg, err := b.decode(e)
+		if err != nil {
+			return nil, err
+		}
+		msg.LeaseToken = e.leaseToken
+		b.publishEvent(base.EventStarted, msg)
+		return msg, nil
+	}
+	return nil, base.ErrorNoTasksInQueue
+}
+
+// NotifyPending returns a channel which receives a value when tasks are pushed to the pending list of any of the given queues.
+// Notifications are coalesced, so a single receive may stand for many tasks. The channel is closed when ctx is done.
+func (b *Broker) NotifyPending(ctx context.Context, qnames ...string) <-chan struct{} {
+	sub := &subscriber{
+		qnames: qnames,
+		notify: make(chan struct{}, 1),
+	}
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, sub)
+		close(sub.notify)
+	}()
+
+	return sub.notify
+}
+
+// ExtendLease extends the lease of a running task by the given duration from now.
+// A lease is never shortened, so a processor can hold a longer lease than the worker renews.
+func (b *Broker) ExtendLease(ctx context.Context, msg *task.Message, lease time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[msg.Queue]
+	if !ok || !b.holdsLease(msg) {
+		return base.ErrorLeaseExpired
+	}
+	expiresAt, ok := q.lease[msg.ID]
+	if !ok {
+		return base.ErrorLeaseExpired
+	}
+	q.lease[msg.ID] = max(expiresAt, b.clock.Now().Add(lease).UnixMilli())
+	return nil
+}
+
+// UpdateTask stores the given task and reschedules it if its schedule changed.
+func (b *Broker) UpdateTask(ctx context.Context, msg *task.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	encoded, err := b.encode(msg)
+	if err != nil {
+		return err
+	}
+	nextRun, err := nextCronRunMilli(msg, b.clock.Now())
+	if err != nil {
+		return err
+	}
+	e, ok := b.tasks[msg.ID]
+	if !ok {
+		return errors.New("task id does not exist")
+	}
+	if msg.LeaseToken != "" && !b.holdsLease(msg) {
+		return base.ErrorLeaseExpired
+	}
+
+	q := b.queue(e.queue)
+	e.msg = encoded
+	e.period = msg.Period
+	e.cron = msg.Cron
+	e.timezone = msg.Timezone
+	q.scheduled, _ = lrem(q.scheduled, msg.ID)
+	if msg.Cron != "" {
+		q.cron[msg.ID] = nextRun
+	} else {
+		delete(q.cron, msg.ID)
+		if msg.Type == task.TypeRecurring {
+			q.scheduled = lpush(q.scheduled, msg.ID)
+		}
+	}
+	// reschedule only tasks which are still delayed
+	if _, ok := q.delayed[msg.ID]; ok && msg.ProcessAt != nil {
+		q.delayed[msg.ID] = msg.ProcessAt.UnixMilli()
+	}
+	return nil
+}
+
+// RemoveTask deletes the task from all queues and the task itself
+func (b *Broker) RemoveTask(ctx context.Context, taskID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.removeTask(taskID)
+}
+
+func (b *Broker) removeTask(taskID string) error {
+	e, ok := b.tasks[taskID]
+	if !ok {
+		return errNotFound
+	}
+
+	// the task is read first, so its event tells which task it was
+	msg, err := b.decode(e)
+	if err != nil {
+		msg = &task.Message{ID: taskID, Queue: e.queue}
+	}
+
+	q := b.queue(e.queue)
+	q.pending, _ = lrem(q.pending, taskID)
+	q.running, _ = lrem(q.running, taskID)
+	q.retry, _ = lrem(q.retry, taskID)
+	q.failed, _ = lrem(q.failed, taskID)
+	q.scheduled, _ = lrem(q.scheduled, taskID)
+	delete(q.delayed, taskID)
+	delete(q.cron, taskID)
+	delete(q.lease, taskID)
+	delete(b.deliveries, taskID)
+	delete(b.tasks, taskID)
+
+	b.publishEvent(base.EventDeleted, msg)
+	return nil
+}
+
+// holdsLease reports whether the task is running with the lease of msg, a worker whose lease expired
+// cannot change the task anymore, even once it is dequeued again.
+func (b *Broker) holdsLease(msg *task.Message) bool {
+	e, ok := b.tasks[msg.ID]
+	return ok && e.status == statusRunning && e.leaseToken == msg.LeaseToken
+}
+
+// takeRunning returns the queue and the stored task of a running task, or false if it does not hold the lease anymore.
+// The task is taken off the running list and its lease is released.
+func (b *Broker) takeRunning(msg *task.Message) (*queue, *entry, bool) {
+	q, ok := b.queues[msg.Queue]
+	if !ok || !b.holdsLease(msg) {
+		return nil, nil, false
+	}
+	var removed bool
+	q.running, removed = lrem(q.running, msg.ID)
+	if !removed {
+		return nil, nil, false
+	}
+	delete(q.lease, msg.ID)
+	e, ok := b.tasks[msg.ID]
+	return q, e, ok
+}
+
+// RequeueTaskRetry moves the task from running queue to the retry queue.
+// The scheduler moves it back to the pending queue once its RetryAt time has passed.
+// It returns base.ErrorLeaseExpired if the lease of the task was lost.
+func (b *Broker) RequeueTaskRetry(ctx context.Context, msg *task.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, e, ok := b.takeRunning(msg)
+	if !ok {
+		return base.ErrorLeaseExpired
+	}
+	q.retry, _ = lrem(q.retry, msg.ID)
+	q.retry = lpush(q.retry, msg.ID)
+	e.retryAt = 0
+	if msg.RetryAt != nil {
+		e.retryAt = msg.RetryAt.UnixMilli()
+	}
+	e.status = statusRetry
+
+	b.publishEvent(base.EventRetrying, msg)
+	return nil
+}
+
+// RequeueTaskPending moves the task from running queue back to the pending queue, to be processed next,
+// e.g. when the processing was interrupted by the worker shutting down. It does not count as an attempt.
+// It returns base.ErrorLeaseExpired if the lease of the task was lost, e.g. it expired and the task was reclaimed.
+func (b *Broker) RequeueTaskPending(ctx context.Context, msg *task.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	msg.Status = task.StatusPending
+	encoded, err := b.encode(msg)
+	if err != nil {
+		return err
+	}
+	q, e, ok := b.takeRunning(msg)
+	if !ok {
+		return base.ErrorLeaseExpired
+	}
+	e.msg = encoded
+	b.setPending(q, msg.ID, e, true)
+	b.notifyPending(msg.Queue)
+
+	b.publishEvent(base.EventEnqueued, msg)
+	return nil
+}
+
+// RequeueTaskFailed moves the task from running queue to the failed queue.
+// It returns base.ErrorLeaseExpired if the lease of the task was lost.
+func (b *Broker) RequeueTaskFailed(ctx context.Context, msg *task.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, e, ok := b.takeRunning(msg)
+	if !ok {
+		return base.ErrorLeaseExpired
+	}
+	q.failed = lpush(q.failed, msg.ID)
+	e.status = statusFailed
+
+	b.publishEvent(base.EventFailed, msg)
+	return nil
+}
+
+// MarkTaskAsComplete takes the task off the running queue and marks it as succeeded.
+// It returns base.ErrorLeaseExpired if the lease of the task was lost.
+func (b *Broker) MarkTaskAsComplete(ctx context.Context, msg *task.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, e, ok := b.takeRunning(msg)
+	if !ok {
+		return base.ErrorLeaseExpired
+	}
+	e.status = statusSucceeded
+
+	b.publishEvent(base.EventSucceeded, msg)
+	return nil
+}
+
+// isWaiting reports whether the task is waiting to be processed or is being processed,
+// a recurring task in such a state is not enqueued again.
+func isWaiting(status string) bool {
+	switch status {
+	case statusFailed, statusRetry, statusRunning, statusPending, statusDelayed:
+		return true
+	}
+	return false
+}
+
+// EnqueueScheduledTasks checks for scheduled tasks in all queues and pass them to their pending queue.
+// It returns base.ErrorNotLeader if epoch is not the epoch of the current leadership.
+func (b *Broker) EnqueueScheduledTasks(ctx context.Context, epoch int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.isLeader(epoch) {
+		return base.ErrorNotLeader
+	}
+	for _, qname := range b.queueNames() {
+		if b.enqueueScheduledTasks(qname) > 0 {
+			b.notifyPending(qname)
+		}
+	}
+	return nil
+}
+
+func (b *Broker) enqueueScheduledTasks(qname string) int {
+	q := b.queues[qname]
+	now := b.clock.Now()
+	nowMilli := now.UnixMilli()
+	enqueued := 0
+
+	for _, id := range due(q.delayed, nowMilli, maxDelayedTasksPerTick) {
+		delete(q.delayed, id)
+		if e, ok := b.tasks[id]; ok {
+			b.setPending(q, id, e, false)
+			enqueued++
+			b.publishEnqueued(e)
+		}
+	}
+
+	for _, id := range slices.Clone(q.retry) {
+		e, ok := b.tasks[id]
+		if !ok || e.status != statusRetry {
+			// the task was retried or removed in the meantime
+			q.retry, _ = lrem(q.retry, id)
+		} else if e.retryAt <= nowMilli {
+			q.retry, _ = lrem(q.retry, id)
+			b.setPending(q, id, e, true)
+			enqueued++
+			b.publishEnqueued(e)
+		}
+	}
+
+	for _, id := range slices.Clone(q.scheduled) {
+		e, ok := b.tasks[id]
+		if !ok || isWaiting(e.status) {
+			continue
+		}
+		if nowMilli > e.pendingSince+e.period.Milliseconds() {
+			b.setPending(q, id, e, false)
+			enqueued++
+			b.publishEnqueued(e)
+		}
+	}
+
+	for _, id := range due(q.cron, nowMilli, maxDelayedTasksPerTick) {
+		e, ok := b.tasks[id]
+		if !ok || e.cron == "" {
+			delete(q.cron, id)
+			continue
+		}
+		next, err := task.NextRun(e.cron, e.timezone, now)
+		if err != nil {
+			logger.Error("error computing next run of cron task", "id", id, "error", err)
+			continue
+		}
+		q.cron[id] = next.UnixMilli()
+		if !isWaiting(e.status) {
+			b.setPending(q, id, e, false)
+			enqueued++
+			b.publishEnqueued(e)
+		}
+	}
+
+	return enqueued
+}
+
+// ReclaimExpiredLeases fails the attempts of the running tasks whose lease has expired.
+// A lease expires when the worker processing the task died without handing it back, e.g. it crashed processing it.
+// The tasks with attempts left are retried right away, the other ones are moved to the dead letter queue.
+// It returns base.ErrorNotLeader if epoch is not the epoch of the current leadership.
+func (b *Broker) ReclaimExpiredLeases(ctx context.Context, lease time.Duration, epoch int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.isLeader(epoch) {
+		return base.ErrorNotLeader
+	}
+	now := b.clock.Now()
+	for _, qname := range b.queueNames() {
+		q := b.queues[qname]
+		expired := due(q.lease, now.UnixMilli(), 0)
+		for _, id := range expired {
+			delete(q.lease, id)
+			q.running, _ = lrem(q.running, id)
+			if e, ok := b.tasks[id]; ok {
+				b.failExpiredLease(q, id, e, now)
+			}
+		}
+		if len(expired) > 0 {
+			logger.Warn("Reclaimed tasks with expired lease", "queue", qname, "count", len(expired))
+		}
+	}
+	return nil
+}
+
+// failExpiredLease moves the task whose lease expired to the retry list, or to the failed list if it has no attempts left.
+// A task which cannot be decoded cannot be retried either.
+func (b *Broker) failExpiredLease(q *queue, id string, e *entry, now time.Time) {
+	msg, err := b.decode(e)
+	if err != nil {
+		q.failed = lpush(q.failed, id)
+		e.status = statusFailed
+		return
+	}
+	retry := msg.FailExpiredLease(now)
+	if encoded, err := b.encode(msg); err == nil {
+		e.msg = encoded
+	}
+	if retry {
+		q.retry = lpush(q.retry, id)
+		e.retryAt = msg.RetryAt.UnixMilli()
+		e.status = statusRetry
+		b.publishEvent(base.EventRetrying, msg)
+		return
+	}
+	q.failed = lpush(q.failed, id)
+	e.status = statusFailed
+	b.publishEvent(base.EventFailed, msg)
+}
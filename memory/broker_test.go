@@ -0,0 +1,208 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/brokertest"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/processors"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	"github.com/engpetarmarinov/gotama/internal/worker"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConformance(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T, clock timeutil.Clock) brokertest.Broker {
+		return NewBroker(clock)
+	})
+}
+
+func TestScheduledTasks(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	ctx := context.Background()
+	// the process time of a delayed task is relative to the real time
+	clock := timeutil.NewSimulatedClock(time.Now())
+	b := NewBroker(clock)
+	epoch := brokertest.Leadership(t, b)
+
+	recurring := brokertest.NewMessage(t, &task.Request{Name: "email", Type: "recurring", Period: "1m"})
+	delayed := brokertest.NewMessage(t, &task.Request{Name: "email", Type: "once", ProcessIn: "30s"})
+	for _, msg := range []*task.Message{recurring, delayed} {
+		if err := b.EnqueueTask(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	running, err := b.DequeueTask(ctx, time.Minute, task.QueueDefault)
+	if err != nil || running.ID != recurring.ID {
+		t.Fatalf("expected to dequeue the recurring task, got %v, %v", running, err)
+	}
+	if err := b.MarkTaskAsComplete(ctx, running); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.AdvanceTime(30 * time.Second)
+	if err := b.EnqueueScheduledTasks(ctx, epoch); err != nil {
+		t.Fatal(err)
+	}
+	running, err = b.DequeueTask(ctx, time.Minute, task.QueueDefault)
+	if err != nil || running.ID != delayed.ID {
+		t.Fatalf("expected to dequeue the delayed task, got %v, %v", running, err)
+	}
+
+	clock.AdvanceTime(31 * time.Second)
+	if err := b.EnqueueScheduledTasks(ctx, epoch); err != nil {
+		t.Fatal(err)
+	}
+	running, err = b.DequeueTask(ctx, time.Minute, task.QueueDefault)
+	if err != nil || running.ID != recurring.ID {
+		t.Fatalf("expected the recurring task to be enqueued again, got %v, %v", running, err)
+	}
+
+	// the tasks lost their worker, their leases expire and their attempts fail
+	clock.AdvanceTime(time.Minute)
+	if err := b.ReclaimExpiredLeases(ctx, time.Minute, epoch); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ExtendLease(ctx, delayed, time.Minute); !errors.Is(err, base.ErrorLeaseExpired) {
+		t.Errorf("expected the lease to be expired, got %v", err)
+	}
+	stats, err := b.GetQueueStats(ctx, task.QueueDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 2 || stats.Pending != 0 || stats.Retry != 2 || stats.Running != 0 || stats.Scheduled != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	if err := b.EnqueueScheduledTasks(ctx, epoch+1); !errors.Is(err, base.ErrorNotLeader) {
+		t.Errorf("expected a stale epoch to be fenced off, got %v", err)
+	}
+}
+
+func TestGetAllTasksPagination(t *testing.T) {
+	ctx := context.Background()
+	clock := timeutil.NewSimulatedClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	b := NewBroker(clock)
+
+	var ids []string
+	for i := 0; i < 5; i++ {
+		msg := brokertest.NewMessage(t, &task.Request{Name: "email", Type: "once"})
+		if err := b.EnqueueTask(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, msg.ID)
+		clock.AdvanceTime(time.Second)
+	}
+
+	total, tasks, err := b.GetAllTasks(ctx, "", "", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 5 || len(tasks) != 2 || tasks[0].ID != ids[3] || tasks[1].ID != ids[2] {
+		t.Errorf("expected the 2nd and 3rd newest tasks of 5, got %d, %v", total, tasks)
+	}
+	if _, _, err := b.GetAllTasks(ctx, "", "pending", 0, 2); err == nil {
+		t.Error("expected an error filtering by status without a queue")
+	}
+}
+
+type testConfig map[string]string
+
+func (c testConfig) Get(key string) string {
+	return c[key]
+}
+
+func TestWorker(t *testing.T) {
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+	ctx := context.Background()
+	clock := timeutil.NewSimulatedClock(time.Now())
+	b := NewBroker(clock)
+	epoch := brokertest.Leadership(t, b)
+
+	var calls atomic.Int32
+	var signed atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signed.Store(r.Header.Get(processors.WebhookSignatureHeader) != "")
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	config := testConfig{
+		"WORKER_GOROUTINES":    "1",
+		"WORKER_TASK_DEADLINE": "10s",
+		"RETRY_POLICY_WEBHOOK": "max_attempts=3,backoff=constant,retry_after=1m",
+		// the test server listens on the loopback address
+		"WEBHOOK_ALLOW_PRIVATE_NETWORKS": "true",
+	}
+	// the webhook reports on a task which succeeded, its secret is copied from it
+	msg := brokertest.NewMessage(t, &task.Request{Name: "email", Type: "once", CallbackURL: server.URL, CallbackSecret: "s3cr3t"})
+	if err := b.EnqueueTask(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	running, err := b.DequeueTask(ctx, time.Minute, task.QueueDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.MarkTaskAsComplete(ctx, running); err != nil {
+		t.Fatal(err)
+	}
+	webhookMsg, err := processors.NewWebhookMessage(config, running, processors.WebhookEventSucceeded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.EnqueueTask(ctx, webhookMsg); err != nil {
+		t.Fatal(err)
+	}
+
+	w := worker.NewWorker(config, b, clock)
+	w.Run()
+	defer w.Shutdown()
+
+	waitForStatus(t, b, webhookMsg.ID, "retry")
+	// the retry is due a minute later on the simulated clock
+	clock.AdvanceTime(time.Minute)
+	if err := b.EnqueueScheduledTasks(ctx, epoch); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, b, webhookMsg.ID, "succeeded")
+
+	deliveries, err := b.GetDeliveries(ctx, msg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 2 || deliveries[0].StatusCode != http.StatusOK || deliveries[1].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected a failed and a successful delivery, got %+v", deliveries)
+	}
+	if !signed.Load() {
+		t.Error("expected the webhook to be signed with the secret of its task")
+	}
+}
+
+func waitForStatus(t *testing.T, b *Broker, id string, status string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		_, tasks, err := b.GetAllTasks(context.Background(), task.QueueDefault, status, 0, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, msg := range tasks {
+			if msg.ID == id {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("task %s did not reach status %s", id, status)
+}
@@ -0,0 +1,130 @@
+package memory
+
+import (
+	"context"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"slices"
+	"time"
+)
+
+// GetDeadTasks returns the tasks of the queue which failed all their attempts, the last failed first.
+func (b *Broker) GetDeadTasks(ctx context.Context, qname string, offset int, limit int) (int64, []*task.Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[qname]
+	if !ok {
+		return 0, nil, nil
+	}
+	tasks, err := b.getTasks(page(q.failed, offset, limit))
+	if err != nil {
+		return 0, nil, err
+	}
+	return int64(len(q.failed)), tasks, nil
+}
+
+// RequeueDeadTask moves a task of the queue which failed all its attempts back to the pending queue.
+// Its attempts and error are reset, so it gets all its attempts again.
+func (b *Broker) RequeueDeadTask(ctx context.Context, qname string, taskID string) (*task.Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.requeueDeadTask(qname, taskID)
+}
+
+func (b *Broker) requeueDeadTask(qname string, taskID string) (*task.Message, error) {
+	e, ok := b.tasks[taskID]
+	if !ok || e.queue != qname {
+		return nil, base.ErrorNotInDeadLetterQueue
+	}
+	q := b.queues[qname]
+	if !slices.Contains(q.failed, taskID) {
+		return nil, base.ErrorNotInDeadLetterQueue
+	}
+
+	msg, err := b.decode(e)
+	if err != nil {
+		return nil, base.ErrorNotInDeadLetterQueue
+	}
+	msg.Status = task.StatusPending
+	msg.NumRetries = 0
+	msg.NumHandBacks = 0
+	msg.Error = nil
+	msg.FailedAt = nil
+	msg.RetryAt = nil
+	encoded, err := b.encode(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	q.failed, _ = lrem(q.failed, taskID)
+	e.msg = encoded
+	e.retryAt = 0
+	b.setPending(q, taskID, e, false)
+	b.notifyPending(qname)
+
+	b.publishEvent(base.EventEnqueued, msg)
+	return msg, nil
+}
+
+// RequeueDeadTasks moves all tasks of the queue which failed all their attempts back to the pending queue.
+// It returns the number of requeued tasks.
+func (b *Broker) RequeueDeadTasks(ctx context.Context, qname string) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[qname]
+	if !ok {
+		return 0, nil
+	}
+	var requeued int64
+	for _, id := range slices.Clone(q.failed) {
+		if _, err := b.requeueDeadTask(qname, id); err != nil {
+			logger.Warn("error requeueing dead task", "id", id, "error", err)
+			continue
+		}
+		requeued++
+	}
+	return requeued, nil
+}
+
+// PurgeDeadTasks deletes the tasks of the queue which failed all their attempts,
+// only the ones which failed more than olderThan ago if it is not 0.
+// It returns the number of deleted tasks.
+func (b *Broker) PurgeDeadTasks(ctx context.Context, qname string, olderThan time.Duration) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[qname]
+	if !ok {
+		return 0, nil
+	}
+	var cutoff time.Time
+	if olderThan > 0 {
+		cutoff = b.clock.Now().Add(-olderThan)
+	}
+
+	var purged int64
+	for _, id := range slices.Clone(q.failed) {
+		e, ok := b.tasks[id]
+		if !ok {
+			q.failed, _ = lrem(q.failed, id)
+			continue
+		}
+		msg, err := b.decode(e)
+		if err != nil {
+			logger.Warn("error purging dead task", "id", id, "error", err)
+			continue
+		}
+		if !cutoff.IsZero() && (msg.FailedAt == nil || msg.FailedAt.After(cutoff)) {
+			continue
+		}
+		if err := b.removeTask(id); err != nil {
+			logger.Warn("error purging dead task", "id", id, "error", err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
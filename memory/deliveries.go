@@ -0,0 +1,37 @@
+package memory
+
+import (
+	"context"
+	"github.com/engpetarmarinov/gotama/internal/base"
+)
+
+// maxDeliveries bounds the delivery log of a task, only the recent attempts are kept.
+const maxDeliveries = 100
+
+// RecordDelivery adds the attempt to deliver a callback to the delivery log of its task.
+// Task IDs are unique across the queues in memory, so the log is kept by the task ID alone.
+func (b *Broker) RecordDelivery(ctx context.Context, qname string, delivery *base.Delivery) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d := *delivery
+	deliveries := append([]*base.Delivery{&d}, b.deliveries[delivery.TaskID]...)
+	if len(deliveries) > maxDeliveries {
+		deliveries = deliveries[:maxDeliveries]
+	}
+	b.deliveries[delivery.TaskID] = deliveries
+	return nil
+}
+
+// GetDeliveries returns the delivery log of the callback of a task, the last attempt first.
+func (b *Broker) GetDeliveries(ctx context.Context, taskID string) ([]*base.Delivery, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	deliveries := make([]*base.Delivery, 0, len(b.deliveries[taskID]))
+	for _, delivery := range b.deliveries[taskID] {
+		d := *delivery
+		deliveries = append(deliveries, &d)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,143 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxEvents bounds the number of events kept, only the recent events can be resumed.
+const maxEvents = 10000
+
+// readEventsCount is the maximum number of events returned by a single read.
+const readEventsCount = 100
+
+// eventID is the ID of an event in the format of a redis stream ID, <unix milli sec>-<sequence>,
+// so that the IDs the clients resume after are the same with both brokers.
+type eventID struct {
+	ms  int64
+	seq int64
+}
+
+func parseEventID(id string) (eventID, error) {
+	msStr, seqStr, ok := strings.Cut(id, "-")
+	if !ok {
+		seqStr = "0"
+	}
+	ms, err := strconv.ParseInt(msStr, 10, 64)
+	if err != nil {
+		return eventID{}, fmt.Errorf("invalid event id %s", id)
+	}
+	seq, err := strconv.ParseInt(seqStr, 10, 64)
+	if err != nil {
+		return eventID{}, fmt.Errorf("invalid event id %s", id)
+	}
+	return eventID{ms: ms, seq: seq}, nil
+}
+
+func (id eventID) String() string {
+	return fmt.Sprintf("%d-%d", id.ms, id.seq)
+}
+
+func (id eventID) after(other eventID) bool {
+	return id.ms > other.ms || (id.ms == other.ms && id.seq > other.seq)
+}
+
+// publishEnqueued publishes the enqueued event of a task the scheduler moved to the pending queue.
+func (b *Broker) publishEnqueued(e *entry) {
+	msg, err := b.decode(e)
+	if err != nil {
+		return
+	}
+	b.publishEvent(base.EventEnqueued, msg)
+}
+
+// publishEvent appends a state transition of the task to the events and wakes up the readers.
+func (b *Broker) publishEvent(eventType base.EventType, msg *task.Message) {
+	now := b.clock.Now()
+	id := eventID{ms: now.UnixMilli()}
+	if len(b.events) > 0 {
+		// the IDs are increasing even if the clock is not
+		last, _ := parseEventID(b.events[len(b.events)-1].ID)
+		if !id.after(last) {
+			id = eventID{ms: last.ms, seq: last.seq + 1}
+		}
+	}
+
+	b.events = append(b.events, &base.TaskEvent{
+		ID:       id.String(),
+		Type:     eventType,
+		TaskID:   msg.ID,
+		Name:     msg.Name,
+		Queue:    msg.Queue,
+		Attempts: msg.NumRetries,
+		Error:    msg.Error,
+		Time:     now.UTC().Format(time.RFC3339),
+	})
+	if len(b.events) > maxEvents {
+		b.events = b.events[len(b.events)-maxEvents:]
+	}
+
+	close(b.eventsChanged)
+	b.eventsChanged = make(chan struct{})
+}
+
+// ReadEvents returns the events after the event with lastID, see LastEventID for reading the events from now on.
+// It waits up to block for new events and returns none if there are none by then.
+func (b *Broker) ReadEvents(ctx context.Context, lastID string, block time.Duration) ([]*base.TaskEvent, error) {
+	last, err := parseEventID(lastID)
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(block)
+	defer timer.Stop()
+	for {
+		b.mu.Lock()
+		events := b.eventsAfter(last)
+		changed := b.eventsChanged
+		b.mu.Unlock()
+		if len(events) > 0 {
+			return events, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			return nil, nil
+		case <-changed:
+		}
+	}
+}
+
+// eventsAfter returns copies of the events after last, at most readEventsCount of them.
+func (b *Broker) eventsAfter(last eventID) []*base.TaskEvent {
+	var events []*base.TaskEvent
+	for _, event := range b.events {
+		id, _ := parseEventID(event.ID)
+		if !id.after(last) {
+			continue
+		}
+		e := *event
+		events = append(events, &e)
+		if len(events) == readEventsCount {
+			break
+		}
+	}
+	return events
+}
+
+// LastEventID returns the ID of the last event, events published later are after it.
+func (b *Broker) LastEventID(ctx context.Context) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.events) == 0 {
+		return "0-0", nil
+	}
+	return b.events[len(b.events)-1].ID, nil
+}
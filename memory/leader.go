@@ -0,0 +1,82 @@
+package memory
+
+import (
+	"context"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"time"
+)
+
+// leader holds the current leadership, it expires unless it is renewed.
+type leader struct {
+	id        string
+	epoch     int64
+	electedAt time.Time
+	expiresAt time.Time
+}
+
+// currentLeader returns the leadership which has not expired yet, nil if there is none.
+func (b *Broker) currentLeader() *leader {
+	if b.leader == nil || !b.clock.Now().Before(b.leader.expiresAt) {
+		return nil
+	}
+	return b.leader
+}
+
+// isLeader checks the fencing token of a scheduler operation, so that a replica
+// which lost its leadership, e.g. while paused, cannot schedule next to the new leader.
+func (b *Broker) isLeader(epoch int64) bool {
+	l := b.currentLeader()
+	return l != nil && l.epoch == epoch
+}
+
+// AcquireLeadership makes the replica the leader for ttl if there is no leader, or renews its leadership.
+// It returns the epoch of the leadership, the fencing token of the scheduler operations,
+// or base.ErrorNotLeader if another replica is the leader.
+func (b *Broker) AcquireLeadership(ctx context.Context, id string, ttl time.Duration) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	if l := b.currentLeader(); l != nil {
+		if l.id != id {
+			return 0, base.ErrorNotLeader
+		}
+		l.expiresAt = now.Add(ttl)
+		return l.epoch, nil
+	}
+
+	b.leaderEpoch++
+	b.leader = &leader{
+		id:        id,
+		epoch:     b.leaderEpoch,
+		electedAt: now,
+		expiresAt: now.Add(ttl),
+	}
+	return b.leaderEpoch, nil
+}
+
+// ReleaseLeadership gives up the leadership of the replica, so another one can take over without waiting for it to expire.
+func (b *Broker) ReleaseLeadership(ctx context.Context, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if l := b.currentLeader(); l != nil && l.id == id {
+		b.leader = nil
+	}
+	return nil
+}
+
+// GetLeader returns the current leader or base.ErrorNoLeader if there is none.
+func (b *Broker) GetLeader(ctx context.Context) (*base.Leader, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l := b.currentLeader()
+	if l == nil {
+		return nil, base.ErrorNoLeader
+	}
+	return &base.Leader{
+		ID:        l.id,
+		Epoch:     l.epoch,
+		ElectedAt: l.electedAt.UTC().Format(time.RFC3339),
+		ExpiresAt: l.expiresAt.UTC().Format(time.RFC3339),
+	}, nil
+}
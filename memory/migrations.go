@@ -0,0 +1,75 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+)
+
+// MigrateEncoding rewrites the messages of the tasks still stored in the legacy JSON encoding in the binary one.
+// Tasks are never stored in the legacy encoding in memory, it is there for parity with the redis broker.
+func (b *Broker) MigrateEncoding(ctx context.Context, progress base.MigrationProgress) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	migrated, err := b.rewriteMessages(progress, func(encoded string) (bool, error) {
+		return task.IsLegacyEncoding(encoded), nil
+	})
+	if err != nil {
+		return migrated, fmt.Errorf("error migrating encoding: %w", err)
+	}
+	return migrated, nil
+}
+
+// RotateEncryption re-encrypts the payloads of the tasks which are not encrypted with the primary key of the keyring,
+// including the ones stored in plaintext. It returns the number of re-encrypted messages.
+func (b *Broker) RotateEncryption(ctx context.Context, progress base.MigrationProgress) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.keyring == nil {
+		return 0, base.ErrorNoEncryptionKeys
+	}
+	rotated, err := b.rewriteMessages(progress, func(encoded string) (bool, error) {
+		keyID, err := task.PayloadKeyID(encoded)
+		if err != nil {
+			return false, err
+		}
+		return keyID != b.keyring.PrimaryKeyID(), nil
+	})
+	if err != nil {
+		return rotated, fmt.Errorf("error rotating encryption: %w", err)
+	}
+	return rotated, nil
+}
+
+// rewriteMessages decodes and encodes again the messages of the tasks for which rewrite returns true.
+// The tasks are rewritten at once, so the progress is reported only when it is done.
+func (b *Broker) rewriteMessages(progress base.MigrationProgress, rewrite func(encoded string) (bool, error)) (int64, error) {
+	var rewritten int64
+	defer func() { progress(int64(len(b.tasks)), rewritten) }()
+	for id, e := range b.tasks {
+		ok, err := rewrite(e.msg)
+		if err != nil {
+			logger.Warn("error reading task, skipping it", "id", id, "error", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		msg, err := task.DecodeMessage(e.msg, b.keyring)
+		if err != nil {
+			logger.Warn("error decoding task, skipping it", "id", id, "error", err)
+			continue
+		}
+		encoded, err := b.encode(msg)
+		if err != nil {
+			return rewritten, err
+		}
+		e.msg = encoded
+		rewritten++
+	}
+	return rewritten, nil
+}
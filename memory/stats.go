@@ -0,0 +1,70 @@
+package memory
+
+import (
+	"context"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"time"
+)
+
+// GetAllQueueStats returns the stats of all queues, sorted by name.
+func (b *Broker) GetAllQueueStats(ctx context.Context) ([]*base.QueueStats, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	qnames := b.queueNames()
+	stats := make([]*base.QueueStats, 0, len(qnames))
+	for _, qname := range qnames {
+		stats = append(stats, b.queueStats(qname))
+	}
+	return stats, nil
+}
+
+// GetQueueStats returns the number of tasks of the queue in each state, the age of its oldest pending task and its memory usage.
+func (b *Broker) GetQueueStats(ctx context.Context, qname string) (*base.QueueStats, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.queues[qname]; !ok {
+		return nil, base.ErrorQueueNotFound
+	}
+	return b.queueStats(qname), nil
+}
+
+func (b *Broker) queueStats(qname string) *base.QueueStats {
+	q := b.queues[qname]
+	stats := &base.QueueStats{
+		Queue:            qname,
+		Pending:          int64(len(q.pending)),
+		Running:          int64(len(q.running)),
+		Retry:            int64(len(q.retry)),
+		Failed:           int64(len(q.failed)),
+		Scheduled:        int64(len(q.scheduled)),
+		Cron:             int64(len(q.cron)),
+		Delayed:          int64(len(q.delayed)),
+		OldestPendingAge: (0 * time.Second).String(),
+	}
+
+	// the memory usage is approximated by the size of the encoded tasks
+	for _, e := range b.tasks {
+		if e.queue == qname {
+			stats.Total++
+			stats.MemoryUsage += int64(len(e.msg))
+		}
+	}
+
+	// retried and reclaimed tasks are pushed to the tail of the pending list, so it is not sorted by pending since
+	var oldest int64
+	for _, id := range q.pending {
+		if e, ok := b.tasks[id]; ok && (oldest == 0 || e.pendingSince < oldest) {
+			oldest = e.pendingSince
+		}
+	}
+	if oldest > 0 {
+		age := b.clock.Now().Sub(time.UnixMilli(oldest)).Truncate(time.Second)
+		if age > 0 {
+			stats.OldestPendingAge = age.String()
+		}
+	}
+
+	return stats
+}
@@ -0,0 +1,65 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"sort"
+	"time"
+)
+
+// workerEntry holds the encoded info of a worker until it expires.
+type workerEntry struct {
+	info      []byte
+	expiresAt time.Time
+}
+
+// WriteWorkerInfo registers the worker until ttl passes without it being written again.
+func (b *Broker) WriteWorkerInfo(ctx context.Context, info *base.WorkerInfo, ttl time.Duration) error {
+	encoded, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("cannot encode worker info: %v", err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.workers[info.ID] = &workerEntry{
+		info:      encoded,
+		expiresAt: b.clock.Now().Add(ttl),
+	}
+	return nil
+}
+
+// ClearWorkerInfo unregisters the worker.
+func (b *Broker) ClearWorkerInfo(ctx context.Context, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.workers, id)
+	return nil
+}
+
+// GetWorkers returns the workers which are alive, sorted by ID.
+// The workers which stopped without unregistering are dropped once their info expires.
+func (b *Broker) GetWorkers(ctx context.Context) ([]*base.WorkerInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	workers := make([]*base.WorkerInfo, 0, len(b.workers))
+	for id, w := range b.workers {
+		if w.expiresAt.Before(now) {
+			delete(b.workers, id)
+			continue
+		}
+		var info base.WorkerInfo
+		if err := json.Unmarshal(w.info, &info); err != nil {
+			logger.Warn("error decoding worker info", "id", id, "error", err)
+			continue
+		}
+		workers = append(workers, &info)
+	}
+
+	sort.Slice(workers, func(i, j int) bool { return workers[i].ID < workers[j].ID })
+	return workers, nil
+}
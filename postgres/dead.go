@@ -0,0 +1,155 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+// deadBatchSize is the number of dead tasks processed at once when requeueing or purging all of them.
+const deadBatchSize = 100
+
+// GetDeadTasks returns the tasks of the queue which failed all their attempts, the last failed first.
+func (p *PDB) GetDeadTasks(ctx context.Context, qname string, offset int, limit int) (int64, []*task.Message, error) {
+	logger.Info("Fetching dead tasks", "queue", qname, "offset", offset, "limit", limit)
+	var total int64
+	err := p.pool.QueryRow(ctx, `SELECT count(*) FROM gotama_tasks WHERE queue = $1 AND status = 'failed'`, qname).Scan(&total)
+	if err != nil {
+		return 0, nil, err
+	}
+	tasks, err := p.queryTasks(ctx, `
+SELECT msg, status FROM gotama_tasks WHERE queue = $1 AND status = 'failed'
+ORDER BY failed_order DESC OFFSET $2 LIMIT $3`, qname, offset, limit)
+	if err != nil {
+		return 0, nil, err
+	}
+	return total, tasks, nil
+}
+
+// RequeueDeadTask moves a task of the queue which failed all its attempts back to the pending queue.
+// Its attempts and error are reset, so it gets all its attempts again.
+func (p *PDB) RequeueDeadTask(ctx context.Context, qname string, taskID string) (*task.Message, error) {
+	msg, err := p.GetTask(ctx, taskID)
+	if err != nil || msg.Queue != qname {
+		return nil, base.ErrorNotInDeadLetterQueue
+	}
+
+	msg.Status = task.StatusPending
+	msg.NumRetries = 0
+	msg.NumHandBacks = 0
+	msg.Error = nil
+	msg.FailedAt = nil
+	msg.RetryAt = nil
+	encoded, err := task.EncodeMessage(msg, p.keyring)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode message: %v", err)
+	}
+
+	err = pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `
+UPDATE gotama_tasks
+SET status = 'pending', msg = $3, pending_since = $4, retry_at = 0, failed_order = NULL, pending_order = `+lpushOrder+`
+WHERE id = $1 AND queue = $2 AND status = 'failed'`, taskID, qname, encoded, p.clock.Now().UnixMilli())
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return base.ErrorNotInDeadLetterQueue
+		}
+		return notifyPending(ctx, tx, qname)
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.publishEvent(ctx, base.EventEnqueued, msg)
+	return msg, nil
+}
+
+// deadTaskIDs returns a batch of the IDs of the dead tasks of the queue, the last failed first, skipping the first skip of them.
+func (p *PDB) deadTaskIDs(ctx context.Context, qname string, skip int64) ([]string, error) {
+	rows, err := p.pool.Query(ctx, `
+SELECT id FROM gotama_tasks WHERE queue = $1 AND status = 'failed'
+ORDER BY failed_order DESC OFFSET $2 LIMIT $3`, qname, skip, deadBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// RequeueDeadTasks moves all tasks of the queue which failed all their attempts back to the pending queue.
+// It returns the number of requeued tasks.
+func (p *PDB) RequeueDeadTasks(ctx context.Context, qname string) (int64, error) {
+	var requeued int64
+	var skipped int64
+	for {
+		// requeued tasks leave the dead letter queue, so the next batch starts after the ones which could not be requeued
+		ids, err := p.deadTaskIDs(ctx, qname, skipped)
+		if err != nil {
+			return requeued, err
+		}
+		if len(ids) == 0 {
+			return requeued, nil
+		}
+
+		for _, id := range ids {
+			_, err := p.RequeueDeadTask(ctx, qname, id)
+			if err != nil {
+				logger.Warn("error requeueing dead task", "id", id, "error", err)
+				skipped++
+				continue
+			}
+			requeued++
+		}
+	}
+}
+
+// PurgeDeadTasks deletes the tasks of the queue which failed all their attempts,
+// only the ones which failed more than olderThan ago if it is not 0.
+// It returns the number of deleted tasks.
+func (p *PDB) PurgeDeadTasks(ctx context.Context, qname string, olderThan time.Duration) (int64, error) {
+	var cutoff time.Time
+	if olderThan > 0 {
+		cutoff = p.clock.Now().Add(-olderThan)
+	}
+
+	var purged int64
+	var kept int64
+	for {
+		// deleted tasks leave the dead letter queue, so the next batch starts after the ones which were kept
+		ids, err := p.deadTaskIDs(ctx, qname, kept)
+		if err != nil {
+			return purged, err
+		}
+		if len(ids) == 0 {
+			return purged, nil
+		}
+
+		for _, id := range ids {
+			msg, err := p.GetTask(ctx, id)
+			if errors.Is(err, errNotFound) {
+				// removed in the meantime
+				continue
+			}
+			if err != nil {
+				logger.Warn("error purging dead task", "id", id, "error", err)
+				kept++
+				continue
+			}
+			if !cutoff.IsZero() && (msg.FailedAt == nil || msg.FailedAt.After(cutoff)) {
+				kept++
+				continue
+			}
+			if err := p.RemoveTask(ctx, id); err != nil {
+				logger.Warn("error purging dead task", "id", id, "error", err)
+				kept++
+				continue
+			}
+			purged++
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/jackc/pgx/v5"
+)
+
+// maxDeliveries bounds the delivery log of a task, only the recent attempts are kept.
+const maxDeliveries = 100
+
+// RecordDelivery adds the attempt to deliver a callback to the delivery log of its task.
+// Task IDs are unique across the queues in postgres, so the log is kept by the task ID alone.
+func (p *PDB) RecordDelivery(ctx context.Context, qname string, delivery *base.Delivery) error {
+	encoded, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("cannot encode delivery: %v", err)
+	}
+	return pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `INSERT INTO gotama_deliveries (task_id, delivery) VALUES ($1, $2)`, delivery.TaskID, encoded)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `
+DELETE FROM gotama_deliveries
+WHERE task_id = $1 AND seq NOT IN (
+    SELECT seq FROM gotama_deliveries WHERE task_id = $1 ORDER BY seq DESC LIMIT $2
+)`, delivery.TaskID, maxDeliveries)
+		return err
+	})
+}
+
+// GetDeliveries returns the delivery log of the callback of a task, the last attempt first.
+func (p *PDB) GetDeliveries(ctx context.Context, taskID string) ([]*base.Delivery, error) {
+	rows, err := p.pool.Query(ctx, `SELECT delivery FROM gotama_deliveries WHERE task_id = $1 ORDER BY seq DESC`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	values, err := pgx.CollectRows(rows, pgx.RowTo[[]byte])
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]*base.Delivery, 0, len(values))
+	for _, value := range values {
+		var delivery base.Delivery
+		if err := json.Unmarshal(value, &delivery); err != nil {
+			logger.Warn("error decoding delivery", "task_id", taskID, "error", err)
+			continue
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,179 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/jackc/pgx/v5"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// eventsChannel is notified when an event is published.
+const eventsChannel = "gotama_events"
+
+// maxEvents bounds the number of events kept, only the recent events can be resumed.
+const maxEvents = 10000
+
+// readEventsCount is the maximum number of events returned by a single read.
+const readEventsCount = 100
+
+// publishEvent appends a state transition of the task to the events.
+// The events are best effort, an error publishing one does not fail the transition.
+func (p *PDB) publishEvent(ctx context.Context, eventType base.EventType, msg *task.Message) {
+	event := base.TaskEvent{
+		Type:     eventType,
+		TaskID:   msg.ID,
+		Name:     msg.Name,
+		Queue:    msg.Queue,
+		Attempts: msg.NumRetries,
+		Error:    msg.Error,
+		Time:     p.clock.Now().UTC().Format(time.RFC3339),
+	}
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		logger.Warn("error encoding task event", "id", msg.ID, "type", eventType, "error", err)
+		return
+	}
+	err = pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		// the IDs are allocated under the lock, so the events commit in the order of their IDs
+		// and a reader never skips an event which commits after a later one
+		if _, err := tx.Exec(ctx, `LOCK TABLE gotama_events IN EXCLUSIVE MODE`); err != nil {
+			return err
+		}
+		var id int64
+		if err := tx.QueryRow(ctx, `INSERT INTO gotama_events (event) VALUES ($1) RETURNING id`, encoded).Scan(&id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM gotama_events WHERE id <= $1`, id-maxEvents); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `SELECT pg_notify($1, '')`, eventsChannel)
+		return err
+	})
+	if err != nil {
+		logger.Warn("error publishing task event", "id", msg.ID, "type", eventType, "error", err)
+	}
+}
+
+// eventsListener listens for published events on a single connection of the PDB and wakes up all the readers
+// waiting for them, so waiting readers do not hold a connection each.
+type eventsListener struct {
+	start  sync.Once
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu sync.Mutex
+	// published is closed and replaced when events are published
+	published chan struct{}
+}
+
+func newEventsListener() *eventsListener {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &eventsListener{
+		ctx:       ctx,
+		cancel:    cancel,
+		published: make(chan struct{}),
+	}
+}
+
+// wait returns a channel which is closed when events are published from now on.
+// The listener starts with the first wait and runs until the PDB is closed.
+func (l *eventsListener) wait(p *PDB) <-chan struct{} {
+	l.start.Do(func() { go l.run(p) })
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.published
+}
+
+func (l *eventsListener) run(p *PDB) {
+	for l.ctx.Err() == nil {
+		err := p.listen(l.ctx, eventsChannel, func(string) { l.broadcast() })
+		if err != nil && l.ctx.Err() == nil {
+			logger.Warn("error listening for task events, reconnecting", "error", err)
+			// notifications are lost until the listener reconnects, so the waiting readers read the events now
+			l.broadcast()
+			select {
+			case <-l.ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+func (l *eventsListener) broadcast() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	close(l.published)
+	l.published = make(chan struct{})
+}
+
+func (l *eventsListener) stop() {
+	l.cancel()
+}
+
+// ReadEvents returns the events after the event with lastID, see LastEventID for reading the events from now on.
+// It waits up to block for new events and returns none if there are none by then.
+func (p *PDB) ReadEvents(ctx context.Context, lastID string, block time.Duration) ([]*base.TaskEvent, error) {
+	last, err := strconv.ParseInt(lastID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event id %s", lastID)
+	}
+
+	// waiting starts before reading, so an event published in between wakes up the wait
+	published := p.events.wait(p)
+	events, err := p.readEvents(ctx, last)
+	if err != nil || len(events) > 0 {
+		return events, err
+	}
+
+	timer := time.NewTimer(block)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		// no events until block passed
+		return nil, nil
+	case <-published:
+	}
+	return p.readEvents(ctx, last)
+}
+
+func (p *PDB) readEvents(ctx context.Context, last int64) ([]*base.TaskEvent, error) {
+	rows, err := p.pool.Query(ctx, `SELECT id, event FROM gotama_events WHERE id > $1 ORDER BY id LIMIT $2`, last, readEventsCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*base.TaskEvent
+	for rows.Next() {
+		var id int64
+		var encoded []byte
+		if err := rows.Scan(&id, &encoded); err != nil {
+			return nil, err
+		}
+		var event base.TaskEvent
+		if err := json.Unmarshal(encoded, &event); err != nil {
+			logger.Warn("error decoding task event", "id", id, "error", err)
+			continue
+		}
+		event.ID = strconv.FormatInt(id, 10)
+		events = append(events, &event)
+	}
+	return events, rows.Err()
+}
+
+// LastEventID returns the ID of the last event, events published later are after it.
+func (p *PDB) LastEventID(ctx context.Context) (string, error) {
+	var id int64
+	if err := p.pool.QueryRow(ctx, `SELECT COALESCE(max(id), 0) FROM gotama_events`).Scan(&id); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(id, 10), nil
+}
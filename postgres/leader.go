@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+// leaderName is the key of the row of the leadership in gotama_leader.
+const leaderName = "scheduler"
+
+// AcquireLeadership makes the replica the leader for ttl if there is no leader, or renews its leadership.
+// It returns the epoch of the leadership, the fencing token of the scheduler operations,
+// or base.ErrorNotLeader if another replica is the leader.
+// The row of an expired leadership is kept, so the epoch keeps increasing with each election.
+func (p *PDB) AcquireLeadership(ctx context.Context, id string, ttl time.Duration) (int64, error) {
+	now := p.clock.Now()
+	var epoch int64
+	err := p.pool.QueryRow(ctx, `
+INSERT INTO gotama_leader AS l (name, id, epoch, elected_at, expires_at)
+VALUES ($1, $2, 1, $3, $4)
+ON CONFLICT (name) DO UPDATE
+SET id = EXCLUDED.id,
+    epoch = CASE WHEN l.id = EXCLUDED.id AND l.expires_at > $3 THEN l.epoch ELSE l.epoch + 1 END,
+    elected_at = CASE WHEN l.id = EXCLUDED.id AND l.expires_at > $3 THEN l.elected_at ELSE EXCLUDED.elected_at END,
+    expires_at = EXCLUDED.expires_at
+WHERE l.id = EXCLUDED.id OR l.expires_at <= $3
+RETURNING epoch`, leaderName, id, now.UnixMilli(), now.Add(ttl).UnixMilli()).Scan(&epoch)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, base.ErrorNotLeader
+	}
+	if err != nil {
+		return 0, err
+	}
+	return epoch, nil
+}
+
+// ReleaseLeadership gives up the leadership of the replica, so another one can take over without waiting for it to expire.
+func (p *PDB) ReleaseLeadership(ctx context.Context, id string) error {
+	_, err := p.pool.Exec(ctx, `UPDATE gotama_leader SET expires_at = 0 WHERE name = $1 AND id = $2`, leaderName, id)
+	return err
+}
+
+// GetLeader returns the current leader or base.ErrorNoLeader if there is none.
+func (p *PDB) GetLeader(ctx context.Context) (*base.Leader, error) {
+	var leader base.Leader
+	var electedAt, expiresAt int64
+	err := p.pool.QueryRow(ctx, `
+SELECT id, epoch, elected_at, expires_at FROM gotama_leader WHERE name = $1 AND expires_at > $2`,
+		leaderName, p.clock.Now().UnixMilli()).Scan(&leader.ID, &leader.Epoch, &electedAt, &expiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, base.ErrorNoLeader
+	}
+	if err != nil {
+		return nil, err
+	}
+	leader.ElectedAt = time.UnixMilli(electedAt).UTC().Format(time.RFC3339)
+	leader.ExpiresAt = time.UnixMilli(expiresAt).UTC().Format(time.RFC3339)
+	return &leader, nil
+}
@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/jackc/pgx/v5"
+)
+
+// migrationBatchSize is the number of tasks read at once during migrations.
+const migrationBatchSize = 500
+
+// MigrateEncoding rewrites the messages of the tasks still stored in the legacy JSON encoding in the binary one.
+// Postgres never stored the legacy encoding, it is there for parity with the redis broker.
+func (p *PDB) MigrateEncoding(ctx context.Context, progress base.MigrationProgress) (int64, error) {
+	logger.Info("Migrating tasks to the binary encoding...")
+	migrated, err := p.rewriteMessages(ctx, progress, func(encoded string) (bool, error) {
+		return task.IsLegacyEncoding(encoded), nil
+	})
+	if err != nil {
+		return migrated, fmt.Errorf("error migrating encoding: %w", err)
+	}
+
+	logger.Info("Migrated tasks to the binary encoding", "count", migrated)
+	return migrated, nil
+}
+
+// RotateEncryption re-encrypts the payloads of the tasks which are not encrypted with the primary key of the keyring,
+// including the ones stored in plaintext. The keys rotated out can be removed from the keyring once it is done.
+// It reports its progress after each batch of tasks, returns the number of re-encrypted messages and can be run again safely.
+func (p *PDB) RotateEncryption(ctx context.Context, progress base.MigrationProgress) (int64, error) {
+	if p.keyring == nil {
+		return 0, base.ErrorNoEncryptionKeys
+	}
+
+	logger.Info("Re-encrypting tasks...", "key", p.keyring.PrimaryKeyID())
+	rotated, err := p.rewriteMessages(ctx, progress, func(encoded string) (bool, error) {
+		keyID, err := task.PayloadKeyID(encoded)
+		if err != nil {
+			return false, err
+		}
+		return keyID != p.keyring.PrimaryKeyID(), nil
+	})
+	if err != nil {
+		return rotated, fmt.Errorf("error rotating encryption: %w", err)
+	}
+
+	logger.Info("Re-encrypted tasks", "key", p.keyring.PrimaryKeyID(), "count", rotated)
+	return rotated, nil
+}
+
+// rewriteMessages decodes and encodes again the messages of the tasks for which rewrite returns true.
+// The tasks are read in batches by ID, and a message updated while it is rewritten is left alone,
+// it is encoded by the update already.
+func (p *PDB) rewriteMessages(ctx context.Context, progress base.MigrationProgress, rewrite func(encoded string) (bool, error)) (int64, error) {
+	type taskRow struct {
+		ID  string
+		Msg []byte
+	}
+	var scanned, rewritten int64
+	var after string
+	for {
+		rows, err := p.pool.Query(ctx, `SELECT id, msg FROM gotama_tasks WHERE id > $1 ORDER BY id LIMIT $2`,
+			after, migrationBatchSize)
+		if err != nil {
+			return rewritten, err
+		}
+		batch, err := pgx.CollectRows(rows, pgx.RowToStructByPos[taskRow])
+		if err != nil {
+			return rewritten, err
+		}
+		if len(batch) == 0 {
+			return rewritten, nil
+		}
+		after = batch[len(batch)-1].ID
+
+		for _, t := range batch {
+			ok, err := rewrite(string(t.Msg))
+			if err != nil {
+				logger.Warn("error reading task, skipping it", "id", t.ID, "error", err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			msg, err := task.DecodeMessage(string(t.Msg), p.keyring)
+			if err != nil {
+				logger.Warn("error decoding task, skipping it", "id", t.ID, "error", err)
+				continue
+			}
+			encoded, err := task.EncodeMessage(msg, p.keyring)
+			if err != nil {
+				return rewritten, err
+			}
+			tag, err := p.pool.Exec(ctx, `UPDATE gotama_tasks SET msg = $3 WHERE id = $1 AND msg = $2`, t.ID, t.Msg, encoded)
+			if err != nil {
+				return rewritten, err
+			}
+			rewritten += tag.RowsAffected()
+		}
+		scanned += int64(len(batch))
+		progress(scanned, rewritten)
+	}
+}
@@ -0,0 +1,780 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/encryption"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"slices"
+	"time"
+)
+
+// PDB is a broker which stores the tasks in postgres. The state transitions are transactional,
+// like the Lua scripts of the redis broker, and the tasks are dequeued with FOR UPDATE SKIP LOCKED,
+// so the workers never wait for each other.
+type PDB struct {
+	pool    *pgxpool.Pool
+	clock   timeutil.Clock
+	keyring *encryption.Keyring
+	events  *eventsListener
+}
+
+func NewPDB(pool *pgxpool.Pool, clock timeutil.Clock) *PDB {
+	return &PDB{
+		pool:   pool,
+		clock:  clock,
+		events: newEventsListener(),
+	}
+}
+
+// WithKeyring encrypts the payloads of the tasks stored from now on with the keyring, and decrypts them when read.
+func (p *PDB) WithKeyring(keyring *encryption.Keyring) *PDB {
+	p.keyring = keyring
+	return p
+}
+
+func (p *PDB) Ping(ctx context.Context) error {
+	return p.pool.Ping(ctx)
+}
+
+func (p *PDB) Close() error {
+	p.events.stop()
+	p.pool.Close()
+	return nil
+}
+
+// notifyChannel is notified with the queue name when tasks are pushed to the pending list of a queue.
+const notifyChannel = "gotama_pending"
+
+// The next positions in the pending and in the failed list. Tasks pushed to the head of the pending list
+// get increasing positions, the ones pushed to its tail, to be dequeued next, get decreasing negative positions.
+const (
+	lpushOrder = `nextval('gotama_list_order')`
+	rpushOrder = `-nextval('gotama_list_order')`
+)
+
+// waitingStatuses are the statuses of the tasks which are waiting to be processed or are being processed,
+// a recurring task in such a state is not enqueued again.
+const waitingStatuses = `('failed', 'retry', 'running', 'pending', 'delayed')`
+
+// maxDelayedTasksPerTick bounds the delayed and the cron tasks enqueued by a single scheduler tick per queue,
+// the rest is enqueued on the next one.
+const maxDelayedTasksPerTick = 1000
+
+var errNotFound = errors.New("not found")
+
+// decode returns the task message with its current status,
+// the stored message keeps the status it was last updated with.
+func (p *PDB) decode(encoded []byte, status string) (*task.Message, error) {
+	msg, err := task.DecodeMessage(string(encoded), p.keyring)
+	if err != nil {
+		logger.Error("Error decoding msg", "error", err)
+		return nil, err
+	}
+	if s, ok := task.StatusFromIndex(status); ok {
+		msg.Status = s
+	}
+	return msg, nil
+}
+
+// GetAllTasks fetches tasks sorted by creation time, newest first, with a given offset.
+// The tasks can be narrowed down to a queue and to a status within that queue, leave them empty to get all tasks.
+func (p *PDB) GetAllTasks(ctx context.Context, qname string, status string, offset int, limit int) (int64, []*task.Message, error) {
+	where := "TRUE"
+	args := []any{}
+	if status != "" {
+		if qname == "" {
+			return 0, nil, errors.New("filtering by status requires a queue")
+		}
+		where = "queue = $1 AND status = $2"
+		args = append(args, qname, status)
+	} else if qname != "" {
+		where = "queue = $1"
+		args = append(args, qname)
+	}
+	logger.Info("Fetching all tasks", "queue", qname, "status", status, "offset", offset, "limit", limit)
+
+	var total int64
+	if err := p.pool.QueryRow(ctx, `SELECT count(*) FROM gotama_tasks WHERE `+where, args...).Scan(&total); err != nil {
+		return 0, nil, err
+	}
+	query := fmt.Sprintf(`SELECT msg, status FROM gotama_tasks WHERE %s ORDER BY created_at DESC, id DESC OFFSET %d LIMIT %d`,
+		where, offset, limit)
+	tasks, err := p.queryTasks(ctx, query, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+	return total, tasks, nil
+}
+
+// queryTasks returns the tasks of the messages and statuses selected by the query.
+func (p *PDB) queryTasks(ctx context.Context, query string, args ...any) ([]*task.Message, error) {
+	rows, err := p.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	type row struct {
+		Msg    []byte
+		Status string
+	}
+	selected, err := pgx.CollectRows(rows, pgx.RowToStructByPos[row])
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks []*task.Message
+	for _, r := range selected {
+		msg, err := p.decode(r.Msg, r.Status)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, msg)
+	}
+	return tasks, nil
+}
+
+// GetTask fetches a task by its ID.
+func (p *PDB) GetTask(ctx context.Context, taskID string) (*task.Message, error) {
+	var encoded []byte
+	var status string
+	err := p.pool.QueryRow(ctx, `SELECT msg, status FROM gotama_tasks WHERE id = $1`, taskID).Scan(&encoded, &status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p.decode(encoded, status)
+}
+
+// nextCronRunMilli returns the next run time of a cron task after now in unix milli sec, nil if msg is not a cron task.
+func nextCronRunMilli(msg *task.Message, now time.Time) (*int64, error) {
+	if msg.Cron == "" {
+		return nil, nil
+	}
+	next, err := task.NextRun(msg.Cron, msg.Timezone, now)
+	if err != nil {
+		return nil, err
+	}
+	nextRun := next.UnixMilli()
+	return &nextRun, nil
+}
+
+// EnqueueTask adds the given task to the pending list of the queue,
+// or to the delayed tasks of the queue if it should be processed later.
+func (p *PDB) EnqueueTask(ctx context.Context, msg *task.Message) error {
+	encoded, err := task.EncodeMessage(msg, p.keyring)
+	if err != nil {
+		return fmt.Errorf("cannot encode message: %v", err)
+	}
+	now := p.clock.Now()
+	nextRun, err := nextCronRunMilli(msg, now)
+	if err != nil {
+		return err
+	}
+
+	status := "pending"
+	order := lpushOrder
+	var processAt *int64
+	switch {
+	case nextRun != nil:
+		// a cron task is enqueued by the scheduler on its first run
+		status = "scheduled"
+		order = "NULL"
+	case msg.ProcessAt != nil && msg.ProcessAt.After(now):
+		status = "delayed"
+		order = "NULL"
+		at := msg.ProcessAt.UnixMilli()
+		processAt = &at
+	}
+
+	logger.Info("Adding task", "id", msg.ID, "queue", msg.Queue)
+	err = pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `INSERT INTO gotama_queues (name) VALUES ($1) ON CONFLICT DO NOTHING`, msg.Queue); err != nil {
+			return err
+		}
+		tag, err := tx.Exec(ctx, `
+INSERT INTO gotama_tasks (id, queue, msg, status, recurring, period_ms, cron, timezone,
+                          created_at, pending_since, pending_order, process_at, next_run)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9, `+order+`, $10, $11)
+ON CONFLICT (id) DO NOTHING`,
+			msg.ID, msg.Queue, encoded, status, msg.Cron == "" && msg.Type == task.TypeRecurring,
+			msg.Period.Milliseconds(), msg.Cron, msg.Timezone, now.UnixMilli(), processAt, nextRun)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return errors.New("task id already exists")
+		}
+		if status == "pending" {
+			return notifyPending(ctx, tx, msg.Queue)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	p.publishEvent(ctx, base.EventEnqueued, msg)
+	return nil
+}
+
+// notifyPending notifies the workers of the queue about pending tasks once the transaction commits.
+func notifyPending(ctx context.Context, tx pgx.Tx, qname string) error {
+	_, err := tx.Exec(ctx, `SELECT pg_notify($1, $2)`, notifyChannel, qname)
+	return err
+}
+
+// DequeueTask moves a task from the pending to the running list of the first non-empty queue
+// and leases it for the given duration. The tasks locked by other workers are skipped.
+func (p *PDB) DequeueTask(ctx context.Context, lease time.Duration, qnames ...string) (*task.Message, error) {
+	leaseExpiresAt := p.clock.Now().Add(lease).UnixMilli()
+	token := task.NewLeaseToken()
+	for _, qname := range qnames {
+		var encoded []byte
+		err := p.pool.QueryRow(ctx, `
+WITH next AS (
+    SELECT id FROM gotama_tasks
+    WHERE queue = $1 AND status = 'pending'
+    ORDER BY pending_order
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+UPDATE gotama_tasks t
+SET status = 'running', pending_order = NULL, lease_expires_at = $2, lease_token = $3
+FROM next
+WHERE t.id = next.id
+RETURNING t.msg`, qname, leaseExpiresAt, token).Scan(&encoded)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("postgres dequeue error: %v", err)
+		}
+
+		msg, err := p.decode(encoded, "running")
+		if err != nil {
+			return nil, err
+		}
+		msg.LeaseToken = token
+		p.publishEvent(ctx, base.EventStarted, msg)
+		return msg, nil
+	}
+	return nil, base.ErrorNoTasksInQueue
+}
+
+// NotifyPending returns a channel which receives a value when tasks are pushed to the pending list of any of the given queues.
+// Notifications are coalesced, so a single receive may stand for many tasks. The channel is closed when ctx is done.
+// The notifications are received on a connection of its own, which is reconnected if it is lost.
+func (p *PDB) NotifyPending(ctx context.Context, qnames ...string) <-chan struct{} {
+	queues := make(map[string]bool, len(qnames))
+	for _, qname := range qnames {
+		queues[qname] = true
+	}
+
+	notify := make(chan struct{}, 1)
+	go func() {
+		defer close(notify)
+		for ctx.Err() == nil {
+			err := p.listen(ctx, notifyChannel, func(payload string) {
+				if !queues[payload] {
+					return
+				}
+				select {
+				case notify <- struct{}{}:
+				default:
+				}
+			})
+			if err != nil && ctx.Err() == nil {
+				logger.Warn("error listening for pending tasks, reconnecting", "error", err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Second):
+				}
+			}
+		}
+	}()
+
+	return notify
+}
+
+// listen calls fn with the payload of each notification on the channel until ctx is done or the connection fails.
+func (p *PDB) listen(ctx context.Context, channel string, fn func(payload string)) error {
+	pooled, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// the connection is closed rather than released, so it does not go back to the pool listening
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return err
+	}
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		fn(notification.Payload)
+	}
+}
+
+// ExtendLease extends the lease of a running task by the given duration from now.
+// A lease is never shortened, so a processor can hold a longer lease than the worker renews.
+func (p *PDB) ExtendLease(ctx context.Context, msg *task.Message, lease time.Duration) error {
+	tag, err := p.pool.Exec(ctx, `
+UPDATE gotama_tasks SET lease_expires_at = GREATEST(lease_expires_at, $2)
+WHERE id = $1 AND status = 'running' AND lease_token = $3`, msg.ID, p.clock.Now().Add(lease).UnixMilli(), msg.LeaseToken)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return base.ErrorLeaseExpired
+	}
+	return nil
+}
+
+// UpdateTask stores the given task and reschedules it if its schedule changed.
+func (p *PDB) UpdateTask(ctx context.Context, msg *task.Message) error {
+	encoded, err := task.EncodeMessage(msg, p.keyring)
+	if err != nil {
+		return fmt.Errorf("cannot encode message: %v", err)
+	}
+	nextRun, err := nextCronRunMilli(msg, p.clock.Now())
+	if err != nil {
+		return err
+	}
+	var processAt *int64
+	if msg.ProcessAt != nil {
+		at := msg.ProcessAt.UnixMilli()
+		processAt = &at
+	}
+
+	logger.Info("Updating task", "id", msg.ID)
+	// reschedule only tasks which are still delayed, the worker processing the task updates it only while it holds the lease
+	tag, err := p.pool.Exec(ctx, `
+UPDATE gotama_tasks
+SET msg = $2, period_ms = $3, cron = $4, timezone = $5, next_run = $6, recurring = $7,
+    process_at = CASE WHEN status = 'delayed' THEN COALESCE($8, process_at) ELSE process_at END
+WHERE id = $1 AND ($9 = '' OR (status = 'running' AND lease_token = $9))`,
+		msg.ID, encoded, msg.Period.Milliseconds(), msg.Cron, msg.Timezone, nextRun,
+		msg.Cron == "" && msg.Type == task.TypeRecurring, processAt, msg.LeaseToken)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 && msg.LeaseToken != "" {
+		return base.ErrorLeaseExpired
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("task id does not exist")
+	}
+	return nil
+}
+
+// RemoveTask deletes the task from all queues and the task itself
+func (p *PDB) RemoveTask(ctx context.Context, taskID string) error {
+	// the task is read first, so its event tells which task it was
+	msg, err := p.GetTask(ctx, taskID)
+	if err != nil {
+		msg = &task.Message{ID: taskID}
+	}
+
+	err = pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM gotama_deliveries WHERE task_id = $1`, taskID); err != nil {
+			return err
+		}
+		tag, err := tx.Exec(ctx, `DELETE FROM gotama_tasks WHERE id = $1`, taskID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return errNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	p.publishEvent(ctx, base.EventDeleted, msg)
+	return nil
+}
+
+// RequeueTaskRetry moves the task from running queue to the retry queue.
+// The scheduler moves it back to the pending queue once its RetryAt time has passed.
+// It returns base.ErrorLeaseExpired if the lease of the task was lost.
+func (p *PDB) RequeueTaskRetry(ctx context.Context, msg *task.Message) error {
+	var retryAt int64
+	if msg.RetryAt != nil {
+		retryAt = msg.RetryAt.UnixMilli()
+	}
+	tag, err := p.pool.Exec(ctx, `
+UPDATE gotama_tasks SET status = 'retry', lease_expires_at = NULL, retry_at = $2
+WHERE id = $1 AND status = 'running' AND lease_token = $3`, msg.ID, retryAt, msg.LeaseToken)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return base.ErrorLeaseExpired
+	}
+	p.publishEvent(ctx, base.EventRetrying, msg)
+	return nil
+}
+
+// RequeueTaskPending moves the task from running queue back to the pending queue, to be processed next,
+// e.g. when the processing was interrupted by the worker shutting down. It does not count as an attempt.
+// It returns base.ErrorLeaseExpired if the lease of the task was lost, e.g. it expired and the task was reclaimed.
+func (p *PDB) RequeueTaskPending(ctx context.Context, msg *task.Message) error {
+	msg.Status = task.StatusPending
+	encoded, err := task.EncodeMessage(msg, p.keyring)
+	if err != nil {
+		return fmt.Errorf("cannot encode message: %v", err)
+	}
+	err = pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `
+UPDATE gotama_tasks
+SET status = 'pending', lease_expires_at = NULL, msg = $2, pending_since = $3, pending_order = `+rpushOrder+`
+WHERE id = $1 AND status = 'running' AND lease_token = $4`, msg.ID, encoded, p.clock.Now().UnixMilli(), msg.LeaseToken)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return base.ErrorLeaseExpired
+		}
+		return notifyPending(ctx, tx, msg.Queue)
+	})
+	if err != nil {
+		return err
+	}
+	p.publishEvent(ctx, base.EventEnqueued, msg)
+	return nil
+}
+
+// RequeueTaskFailed moves the task from running queue to the failed queue.
+// It returns base.ErrorLeaseExpired if the lease of the task was lost.
+func (p *PDB) RequeueTaskFailed(ctx context.Context, msg *task.Message) error {
+	tag, err := p.pool.Exec(ctx, `
+UPDATE gotama_tasks SET status = 'failed', lease_expires_at = NULL, failed_order = `+lpushOrder+`
+WHERE id = $1 AND status = 'running' AND lease_token = $2`, msg.ID, msg.LeaseToken)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return base.ErrorLeaseExpired
+	}
+	p.publishEvent(ctx, base.EventFailed, msg)
+	return nil
+}
+
+// MarkTaskAsComplete takes the task off the running queue and marks it as succeeded.
+// It returns base.ErrorLeaseExpired if the lease of the task was lost.
+func (p *PDB) MarkTaskAsComplete(ctx context.Context, msg *task.Message) error {
+	tag, err := p.pool.Exec(ctx, `
+UPDATE gotama_tasks SET status = 'succeeded', lease_expires_at = NULL
+WHERE id = $1 AND status = 'running' AND lease_token = $2`, msg.ID, msg.LeaseToken)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return base.ErrorLeaseExpired
+	}
+	p.publishEvent(ctx, base.EventSucceeded, msg)
+	return nil
+}
+
+// GetQueues returns the names of all queues tasks have been enqueued to.
+func (p *PDB) GetQueues(ctx context.Context) ([]string, error) {
+	rows, err := p.pool.Query(ctx, `SELECT name FROM gotama_queues ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// inLeadership runs fn in a transaction which holds the leadership of the given epoch,
+// it returns base.ErrorNotLeader if epoch is not the epoch of the current leadership.
+// The leadership row is locked for share, so it cannot change hands before the transaction commits.
+func (p *PDB) inLeadership(ctx context.Context, epoch int64, fn func(tx pgx.Tx) error) error {
+	return pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		var current int64
+		err := tx.QueryRow(ctx, `
+SELECT epoch FROM gotama_leader WHERE name = $1 AND expires_at > $2 FOR SHARE`,
+			leaderName, p.clock.Now().UnixMilli()).Scan(&current)
+		if errors.Is(err, pgx.ErrNoRows) || (err == nil && current != epoch) {
+			return base.ErrorNotLeader
+		}
+		if err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+// checkLeadership returns base.ErrorNotLeader if epoch is not the epoch of the current leadership,
+// so a stale leader is fenced off even when there are no queues to operate on.
+func (p *PDB) checkLeadership(ctx context.Context, epoch int64) error {
+	return p.inLeadership(ctx, epoch, func(pgx.Tx) error { return nil })
+}
+
+// EnqueueScheduledTasks checks for scheduled tasks in all queues and pass them to their pending queue.
+// It returns base.ErrorNotLeader if epoch is not the epoch of the current leadership,
+// and the errors of the queues which failed otherwise.
+func (p *PDB) EnqueueScheduledTasks(ctx context.Context, epoch int64) error {
+	qnames, err := p.GetQueues(ctx)
+	if err != nil {
+		return err
+	}
+	if len(qnames) == 0 {
+		return p.checkLeadership(ctx, epoch)
+	}
+	// a queue which fails does not hold back the others
+	var errs []error
+	for _, qname := range qnames {
+		var enqueued [][]byte
+		err := p.inLeadership(ctx, epoch, func(tx pgx.Tx) error {
+			var err error
+			enqueued, err = p.enqueueScheduledTasks(ctx, tx, qname)
+			return err
+		})
+		if errors.Is(err, base.ErrorNotLeader) {
+			return err
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("queue %s: %w", qname, err))
+			continue
+		}
+		p.publishEnqueued(ctx, enqueued)
+	}
+	return errors.Join(errs...)
+}
+
+// enqueueScheduledTasks moves the due tasks of the queue to the pending queue and returns their messages.
+func (p *PDB) enqueueScheduledTasks(ctx context.Context, tx pgx.Tx, qname string) ([][]byte, error) {
+	now := p.clock.Now()
+	nowMilli := now.UnixMilli()
+
+	delayed, err := queryMessages(ctx, tx, `
+UPDATE gotama_tasks
+SET status = 'pending', process_at = NULL, pending_since = $2, pending_order = `+lpushOrder+`
+WHERE id IN (
+    SELECT id FROM gotama_tasks
+    WHERE queue = $1 AND status = 'delayed' AND process_at <= $2
+    ORDER BY process_at
+    LIMIT $3
+    FOR UPDATE
+)
+RETURNING msg`, qname, nowMilli, maxDelayedTasksPerTick)
+	if err != nil {
+		return nil, err
+	}
+
+	retries, err := queryMessages(ctx, tx, `
+UPDATE gotama_tasks
+SET status = 'pending', pending_since = $2, pending_order = `+rpushOrder+`
+WHERE queue = $1 AND status = 'retry' AND retry_at <= $2
+RETURNING msg`, qname, nowMilli)
+	if err != nil {
+		return nil, err
+	}
+
+	recurring, err := queryMessages(ctx, tx, `
+UPDATE gotama_tasks
+SET status = 'pending', pending_since = $2, pending_order = `+lpushOrder+`
+WHERE queue = $1 AND recurring AND status NOT IN `+waitingStatuses+` AND $2 > pending_since + period_ms
+RETURNING msg`, qname, nowMilli)
+	if err != nil {
+		return nil, err
+	}
+
+	cron, err := p.enqueueCronTasks(ctx, tx, qname, now)
+	if err != nil {
+		return nil, err
+	}
+
+	enqueued := slices.Concat(delayed, retries, recurring, cron)
+	if len(enqueued) > 0 {
+		return enqueued, notifyPending(ctx, tx, qname)
+	}
+	return nil, nil
+}
+
+// queryMessages runs a statement which returns the messages of the tasks it changed.
+func queryMessages(ctx context.Context, tx pgx.Tx, query string, args ...any) ([][]byte, error) {
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[[]byte])
+}
+
+// publishEnqueued publishes the enqueued events of the tasks the scheduler moved to the pending queue.
+func (p *PDB) publishEnqueued(ctx context.Context, enqueued [][]byte) {
+	for _, encoded := range enqueued {
+		msg, err := p.decode(encoded, "pending")
+		if err != nil {
+			continue
+		}
+		p.publishEvent(ctx, base.EventEnqueued, msg)
+	}
+}
+
+// enqueueCronTasks enqueues the cron tasks of the queue which are due and moves them to their next run.
+// It returns the messages of the enqueued tasks.
+// The next run times are computed here, since cron expressions cannot be evaluated by postgres.
+func (p *PDB) enqueueCronTasks(ctx context.Context, tx pgx.Tx, qname string, now time.Time) ([][]byte, error) {
+	type cronTask struct {
+		ID       string
+		Cron     string
+		Timezone string
+		Status   string
+	}
+	rows, err := tx.Query(ctx, `
+SELECT id, cron, timezone, status FROM gotama_tasks
+WHERE queue = $1 AND next_run <= $2
+ORDER BY next_run
+LIMIT $3
+FOR UPDATE`, qname, now.UnixMilli(), maxDelayedTasksPerTick)
+	if err != nil {
+		return nil, err
+	}
+	due, err := pgx.CollectRows(rows, pgx.RowToStructByPos[cronTask])
+	if err != nil {
+		return nil, err
+	}
+
+	var enqueued [][]byte
+	for _, t := range due {
+		next, err := task.NextRun(t.Cron, t.Timezone, now)
+		if err != nil {
+			logger.Error("error computing next run of cron task", "id", t.ID, "error", err)
+			continue
+		}
+		var encoded []byte
+		err = tx.QueryRow(ctx, `
+UPDATE gotama_tasks
+SET next_run = $2,
+    status = CASE WHEN status IN `+waitingStatuses+` THEN status ELSE 'pending' END,
+    pending_since = CASE WHEN status IN `+waitingStatuses+` THEN pending_since ELSE $3 END,
+    pending_order = CASE WHEN status IN `+waitingStatuses+` THEN pending_order ELSE `+lpushOrder+` END
+WHERE id = $1
+RETURNING msg`, t.ID, next.UnixMilli(), now.UnixMilli()).Scan(&encoded)
+		if err != nil {
+			return enqueued, err
+		}
+		if !isWaiting(t.Status) {
+			enqueued = append(enqueued, encoded)
+		}
+	}
+	return enqueued, nil
+}
+
+func isWaiting(status string) bool {
+	switch status {
+	case "failed", "retry", "running", "pending", "delayed":
+		return true
+	}
+	return false
+}
+
+// ReclaimExpiredLeases fails the attempts of the running tasks whose lease has expired.
+// A lease expires when the worker processing the task died without handing it back, e.g. it crashed processing it.
+// The tasks with attempts left are retried right away, the other ones are moved to the dead letter queue.
+// It returns base.ErrorNotLeader if epoch is not the epoch of the current leadership,
+// and the errors of the queues which failed otherwise.
+func (p *PDB) ReclaimExpiredLeases(ctx context.Context, lease time.Duration, epoch int64) error {
+	qnames, err := p.GetQueues(ctx)
+	if err != nil {
+		return err
+	}
+	if len(qnames) == 0 {
+		return p.checkLeadership(ctx, epoch)
+	}
+	// a queue which fails does not hold back the others
+	var errs []error
+	for _, qname := range qnames {
+		var retried, failed []*task.Message
+		err := p.inLeadership(ctx, epoch, func(tx pgx.Tx) error {
+			var err error
+			retried, failed, err = p.failExpiredLeases(ctx, tx, qname)
+			return err
+		})
+		if errors.Is(err, base.ErrorNotLeader) {
+			return err
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("queue %s: %w", qname, err))
+			continue
+		}
+		if n := len(retried) + len(failed); n > 0 {
+			logger.Warn("Reclaimed tasks with expired lease", "queue", qname, "count", n)
+		}
+		for _, msg := range retried {
+			p.publishEvent(ctx, base.EventRetrying, msg)
+		}
+		for _, msg := range failed {
+			p.publishEvent(ctx, base.EventFailed, msg)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// failExpiredLeases moves the running tasks of the queue whose lease expired to the retry queue,
+// or to the failed queue if they have no attempts left. A task which cannot be decoded cannot be retried either.
+// It returns the retried and the failed tasks.
+func (p *PDB) failExpiredLeases(ctx context.Context, tx pgx.Tx, qname string) ([]*task.Message, []*task.Message, error) {
+	type expiredTask struct {
+		ID  string
+		Msg []byte
+	}
+	now := p.clock.Now()
+	rows, err := tx.Query(ctx, `
+SELECT id, msg FROM gotama_tasks
+WHERE queue = $1 AND status = 'running' AND lease_expires_at <= $2
+FOR UPDATE`, qname, now.UnixMilli())
+	if err != nil {
+		return nil, nil, err
+	}
+	expired, err := pgx.CollectRows(rows, pgx.RowToStructByPos[expiredTask])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var retried, failed []*task.Message
+	for _, t := range expired {
+		msg, err := p.decode(t.Msg, "running")
+		if err != nil {
+			_, err = tx.Exec(ctx, `
+UPDATE gotama_tasks SET status = 'failed', lease_expires_at = NULL, failed_order = `+lpushOrder+`
+WHERE id = $1`, t.ID)
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		retry := msg.FailExpiredLease(now)
+		encoded, err := task.EncodeMessage(msg, p.keyring)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot encode message: %v", err)
+		}
+		if retry {
+			_, err = tx.Exec(ctx, `
+UPDATE gotama_tasks SET status = 'retry', lease_expires_at = NULL, msg = $2, retry_at = $3
+WHERE id = $1`, t.ID, encoded, msg.RetryAt.UnixMilli())
+			retried = append(retried, msg)
+		} else {
+			_, err = tx.Exec(ctx, `
+UPDATE gotama_tasks SET status = 'failed', lease_expires_at = NULL, msg = $2, failed_order = `+lpushOrder+`
+WHERE id = $1`, t.ID, encoded)
+			failed = append(failed, msg)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return retried, failed, nil
+}
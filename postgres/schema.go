@@ -0,0 +1,92 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/jackc/pgx/v5"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// schemaFS holds the schema migrations, <version>_<name>.sql, applied in the order of their versions.
+//
+//go:embed schema/*.sql
+var schemaFS embed.FS
+
+// schemaLockID is the key of the advisory lock which keeps the replicas from migrating the schema at the same time.
+const schemaLockID = 7316021
+
+type schemaMigration struct {
+	version int
+	name    string
+	sql     string
+}
+
+func schemaMigrations() ([]schemaMigration, error) {
+	entries, err := schemaFS.ReadDir("schema")
+	if err != nil {
+		return nil, err
+	}
+	migrations := make([]schemaMigration, 0, len(entries))
+	for _, entry := range entries {
+		versionStr, _, ok := strings.Cut(entry.Name(), "_")
+		version, err := strconv.Atoi(versionStr)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid schema migration name %s", entry.Name())
+		}
+		sql, err := schemaFS.ReadFile(path.Join("schema", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, schemaMigration{version: version, name: entry.Name(), sql: string(sql)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// Migrate applies the schema migrations which were not applied yet, each in its own transaction.
+// It is safe to run from several replicas at once, they wait for each other.
+func (p *PDB) Migrate(ctx context.Context) error {
+	migrations, err := schemaMigrations()
+	if err != nil {
+		return err
+	}
+
+	_, err = p.pool.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS gotama_schema_migrations (
+    version integer PRIMARY KEY,
+    applied_at bigint NOT NULL
+)`)
+	if err != nil {
+		return fmt.Errorf("error creating the schema migrations table: %w", err)
+	}
+
+	for _, m := range migrations {
+		err := pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, schemaLockID); err != nil {
+				return err
+			}
+			var applied bool
+			err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM gotama_schema_migrations WHERE version = $1)`, m.version).Scan(&applied)
+			if err != nil || applied {
+				return err
+			}
+
+			logger.Info("Applying schema migration", "migration", m.name)
+			if _, err := tx.Exec(ctx, m.sql); err != nil {
+				return err
+			}
+			_, err = tx.Exec(ctx, `INSERT INTO gotama_schema_migrations (version, applied_at) VALUES ($1, $2)`,
+				m.version, p.clock.Now().UnixMilli())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error applying schema migration %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+-- The times are unix milli sec of the clock of the broker, not of the database, like in the redis broker.
+
+CREATE SEQUENCE gotama_list_order;
+
+CREATE TABLE gotama_queues (
+    name text PRIMARY KEY
+);
+
+CREATE TABLE gotama_tasks (
+    id text PRIMARY KEY,
+    queue text NOT NULL REFERENCES gotama_queues (name),
+    msg bytea NOT NULL,
+    -- pending, delayed, scheduled, running, retry, failed or succeeded
+    status text NOT NULL,
+    -- set for the recurring tasks with a period, they are enqueued again once the period passes
+    recurring boolean NOT NULL DEFAULT false,
+    period_ms bigint NOT NULL DEFAULT 0,
+    cron text NOT NULL DEFAULT '',
+    timezone text NOT NULL DEFAULT '',
+    created_at bigint NOT NULL,
+    pending_since bigint NOT NULL,
+    -- the position in the pending list, the lowest is dequeued first
+    pending_order bigint,
+    -- the time a delayed task is enqueued at
+    process_at bigint,
+    -- the next run time of a cron task
+    next_run bigint,
+    retry_at bigint NOT NULL DEFAULT 0,
+    -- the lease expiration time of a running task
+    lease_expires_at bigint,
+    -- the position in the dead letter queue, the highest failed last
+    failed_order bigint
+);
+
+CREATE INDEX gotama_tasks_created_at_idx ON gotama_tasks (created_at DESC, id DESC);
+CREATE INDEX gotama_tasks_queue_created_at_idx ON gotama_tasks (queue, created_at DESC, id DESC);
+CREATE INDEX gotama_tasks_queue_status_created_at_idx ON gotama_tasks (queue, status, created_at DESC, id DESC);
+CREATE INDEX gotama_tasks_pending_idx ON gotama_tasks (queue, pending_order) WHERE status = 'pending';
+CREATE INDEX gotama_tasks_delayed_idx ON gotama_tasks (queue, process_at) WHERE status = 'delayed';
+CREATE INDEX gotama_tasks_retry_idx ON gotama_tasks (queue, retry_at) WHERE status = 'retry';
+CREATE INDEX gotama_tasks_running_idx ON gotama_tasks (queue, lease_expires_at) WHERE status = 'running';
+CREATE INDEX gotama_tasks_failed_idx ON gotama_tasks (queue, failed_order) WHERE status = 'failed';
+CREATE INDEX gotama_tasks_recurring_idx ON gotama_tasks (queue) WHERE recurring;
+CREATE INDEX gotama_tasks_cron_idx ON gotama_tasks (queue, next_run) WHERE next_run IS NOT NULL;
+
+CREATE TABLE gotama_deliveries (
+    seq bigserial PRIMARY KEY,
+    task_id text NOT NULL,
+    delivery jsonb NOT NULL
+);
+
+CREATE INDEX gotama_deliveries_task_id_idx ON gotama_deliveries (task_id, seq DESC);
+
+CREATE TABLE gotama_events (
+    id bigserial PRIMARY KEY,
+    event jsonb NOT NULL
+);
+
+CREATE TABLE gotama_leader (
+    name text PRIMARY KEY,
+    id text NOT NULL,
+    epoch bigint NOT NULL,
+    elected_at bigint NOT NULL,
+    expires_at bigint NOT NULL
+);
+
+CREATE TABLE gotama_workers (
+    id text PRIMARY KEY,
+    info jsonb NOT NULL,
+    expires_at bigint NOT NULL
+);
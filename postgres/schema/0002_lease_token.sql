@@ -0,0 +1,2 @@
+-- the token of the lease a running task was dequeued with, a worker whose lease expired cannot change the task anymore
+ALTER TABLE gotama_tasks ADD COLUMN lease_token text;
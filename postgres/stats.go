@@ -0,0 +1,75 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"time"
+)
+
+// GetAllQueueStats returns the stats of all queues, sorted by name.
+func (p *PDB) GetAllQueueStats(ctx context.Context) ([]*base.QueueStats, error) {
+	qnames, err := p.GetQueues(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]*base.QueueStats, 0, len(qnames))
+	for _, qname := range qnames {
+		s, err := p.queueStats(ctx, qname)
+		if err != nil {
+			return nil, fmt.Errorf("queue %s: %w", qname, err)
+		}
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+// GetQueueStats returns the number of tasks of the queue in each state, the age of its oldest pending task and its disk usage.
+func (p *PDB) GetQueueStats(ctx context.Context, qname string) (*base.QueueStats, error) {
+	var exists bool
+	err := p.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM gotama_queues WHERE name = $1)`, qname).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, base.ErrorQueueNotFound
+	}
+	return p.queueStats(ctx, qname)
+}
+
+// queueStats returns the stats of the queue. The memory usage of a postgres queue is the size of its rows.
+func (p *PDB) queueStats(ctx context.Context, qname string) (*base.QueueStats, error) {
+	stats := &base.QueueStats{
+		Queue:            qname,
+		OldestPendingAge: (0 * time.Second).String(),
+	}
+	// retried and reclaimed tasks are dequeued next, so the pending order is not the order of pending since
+	var pendingSince int64
+	err := p.pool.QueryRow(ctx, `
+SELECT count(*),
+       count(*) FILTER (WHERE status = 'pending'),
+       count(*) FILTER (WHERE status = 'running'),
+       count(*) FILTER (WHERE status = 'retry'),
+       count(*) FILTER (WHERE status = 'failed'),
+       count(*) FILTER (WHERE recurring),
+       count(*) FILTER (WHERE next_run IS NOT NULL),
+       count(*) FILTER (WHERE status = 'delayed'),
+       COALESCE(sum(pg_column_size(t.*)), 0),
+       COALESCE(min(pending_since) FILTER (WHERE status = 'pending'), 0)
+FROM gotama_tasks t
+WHERE queue = $1`, qname).Scan(&stats.Total, &stats.Pending, &stats.Running, &stats.Retry, &stats.Failed,
+		&stats.Scheduled, &stats.Cron, &stats.Delayed, &stats.MemoryUsage, &pendingSince)
+	if err != nil {
+		return nil, err
+	}
+
+	if pendingSince > 0 {
+		age := p.clock.Now().Sub(time.UnixMilli(pendingSince)).Truncate(time.Second)
+		if age > 0 {
+			stats.OldestPendingAge = age.String()
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"time"
+)
+
+// WriteWorkerInfo registers the worker until ttl passes without it being written again.
+func (p *PDB) WriteWorkerInfo(ctx context.Context, info *base.WorkerInfo, ttl time.Duration) error {
+	encoded, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("cannot encode worker info: %v", err)
+	}
+	_, err = p.pool.Exec(ctx, `
+INSERT INTO gotama_workers (id, info, expires_at) VALUES ($1, $2, $3)
+ON CONFLICT (id) DO UPDATE SET info = EXCLUDED.info, expires_at = EXCLUDED.expires_at`,
+		info.ID, encoded, p.clock.Now().Add(ttl).UnixMilli())
+	return err
+}
+
+// ClearWorkerInfo unregisters the worker.
+func (p *PDB) ClearWorkerInfo(ctx context.Context, id string) error {
+	_, err := p.pool.Exec(ctx, `DELETE FROM gotama_workers WHERE id = $1`, id)
+	return err
+}
+
+// GetWorkers returns the workers which are alive, sorted by ID.
+// The workers which stopped without unregistering are dropped once their info expires.
+func (p *PDB) GetWorkers(ctx context.Context) ([]*base.WorkerInfo, error) {
+	now := p.clock.Now().UnixMilli()
+	if _, err := p.pool.Exec(ctx, `DELETE FROM gotama_workers WHERE expires_at < $1`, now); err != nil {
+		return nil, err
+	}
+	rows, err := p.pool.Query(ctx, `SELECT id, info FROM gotama_workers ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workers := make([]*base.WorkerInfo, 0)
+	for rows.Next() {
+		var id string
+		var encoded []byte
+		if err := rows.Scan(&id, &encoded); err != nil {
+			return nil, err
+		}
+		var info base.WorkerInfo
+		if err := json.Unmarshal(encoded, &info); err != nil {
+			logger.Warn("error decoding worker info", "id", id, "error", err)
+			continue
+		}
+		workers = append(workers, &info)
+	}
+	return workers, rows.Err()
+}
@@ -0,0 +1,202 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// deadBatchSize is the number of dead tasks processed at once when requeueing or purging all of them.
+const deadBatchSize = 100
+
+// GetDeadTasks returns the tasks of the queue which failed all their attempts, the last failed first.
+func (r *RDB) GetDeadTasks(ctx context.Context, qname string, offset int, limit int) (int64, []*task.Message, error) {
+	logger.Info("Fetching dead tasks", "queue", qname, "offset", offset, "limit", limit)
+	var total *redis.IntCmd
+	var ids *redis.StringSliceCmd
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		total = pipe.LLen(ctx, failedKey(qname))
+		ids = pipe.LRange(ctx, failedKey(qname), int64(offset), int64(offset+limit-1))
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	tasks, err := r.getTasks(ctx, queueNames(qname, len(ids.Val())), ids.Val())
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return total.Val(), tasks, nil
+}
+
+// queueNames returns the queue name n times, for getting n tasks of the same queue.
+func queueNames(qname string, n int) []string {
+	qnames := make([]string, n)
+	for i := range qnames {
+		qnames[i] = qname
+	}
+	return qnames
+}
+
+// KEYS[1] -> gotama:<qname>:failed
+// KEYS[2] -> gotama:<qname>:pending
+// KEYS[3] -> gotama:<qname>:t:<task_id>
+// -------
+// ARGV[1] -> task ID
+// ARGV[2] -> task message data
+// ARGV[3] -> current time in unix milli sec
+// ARGV[4] -> notify channel
+// ARGV[5] -> gotama:<qname>:
+//
+// Output:
+// Returns 1 if the task was requeued
+// Returns 0 if the task is not in the dead letter queue
+var requeueDeadTaskCmd = redis.NewScript(setStatusLua + `
+if redis.call("LREM", KEYS[1], 0, ARGV[1]) == 0 then
+    return 0
+end
+redis.call("HSET", KEYS[3],
+           "msg", ARGV[2],
+           "pending_since", ARGV[3],
+           "retry_at", 0)
+set_status(ARGV[5], ARGV[1], "pending")
+redis.call("LPUSH", KEYS[2], ARGV[1])
+redis.call("PUBLISH", ARGV[4], 1)
+return 1
+`)
+
+// RequeueDeadTask moves a task of the queue which failed all its attempts back to the pending queue.
+// Its attempts and error are reset, so it gets all its attempts again.
+func (r *RDB) RequeueDeadTask(ctx context.Context, qname string, taskID string) (*task.Message, error) {
+	taskQname, err := r.taskQueue(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	if taskQname != qname {
+		return nil, base.ErrorNotInDeadLetterQueue
+	}
+
+	msg, err := r.GetTask(ctx, taskID)
+	if err != nil {
+		return nil, base.ErrorNotInDeadLetterQueue
+	}
+
+	msg.Status = task.StatusPending
+	msg.NumRetries = 0
+	msg.NumHandBacks = 0
+	msg.Error = nil
+	msg.FailedAt = nil
+	msg.RetryAt = nil
+	encoded, err := task.EncodeMessage(msg, r.keyring)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode message: %v", err)
+	}
+
+	keys := []string{
+		failedKey(qname),
+		pendingKey(qname),
+		taskKey(qname, taskID),
+	}
+	argv := []any{
+		taskID,
+		encoded,
+		r.clock.Now().UnixMilli(),
+		notifyChannel(qname),
+		queueKeyPrefix(qname),
+	}
+	n, err := r.runScriptWithErrorCode(ctx, requeueDeadTaskCmd, keys, argv...)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, base.ErrorNotInDeadLetterQueue
+	}
+	r.publishEvent(ctx, base.EventEnqueued, msg)
+	return msg, nil
+}
+
+// RequeueDeadTasks moves all tasks of the queue which failed all their attempts back to the pending queue.
+// It returns the number of requeued tasks.
+func (r *RDB) RequeueDeadTasks(ctx context.Context, qname string) (int64, error) {
+	var requeued int64
+	var skipped int64
+	for {
+		// requeued tasks leave the list, so the next batch starts after the ones which could not be requeued
+		ids, err := r.client.LRange(ctx, failedKey(qname), skipped, skipped+deadBatchSize-1).Result()
+		if err != nil {
+			return requeued, err
+		}
+		if len(ids) == 0 {
+			return requeued, nil
+		}
+
+		for _, id := range ids {
+			_, err := r.RequeueDeadTask(ctx, qname, id)
+			if err != nil {
+				logger.Warn("error requeueing dead task", "id", id, "error", err)
+				skipped++
+				continue
+			}
+			requeued++
+		}
+	}
+}
+
+// PurgeDeadTasks deletes the tasks of the queue which failed all their attempts,
+// only the ones which failed more than olderThan ago if it is not 0.
+// It returns the number of deleted tasks.
+func (r *RDB) PurgeDeadTasks(ctx context.Context, qname string, olderThan time.Duration) (int64, error) {
+	var cutoff time.Time
+	if olderThan > 0 {
+		cutoff = r.clock.Now().Add(-olderThan)
+	}
+
+	var purged int64
+	var kept int64
+	for {
+		// deleted tasks leave the list, so the next batch starts after the ones which were kept
+		ids, err := r.client.LRange(ctx, failedKey(qname), kept, kept+deadBatchSize-1).Result()
+		if err != nil {
+			return purged, err
+		}
+		if len(ids) == 0 {
+			return purged, nil
+		}
+
+		tasks, err := r.getTasks(ctx, queueNames(qname, len(ids)), ids)
+		if err != nil {
+			return purged, err
+		}
+		// tasks which no longer exist are skipped by getTasks, they are removed from the list below
+		failedAt := make(map[string]*time.Time, len(tasks))
+		for _, msg := range tasks {
+			failedAt[msg.ID] = msg.FailedAt
+		}
+
+		for _, id := range ids {
+			at, ok := failedAt[id]
+			if !ok {
+				if err := r.client.LRem(ctx, failedKey(qname), 0, id).Err(); err != nil {
+					return purged, err
+				}
+				continue
+			}
+			if !cutoff.IsZero() && (at == nil || at.After(cutoff)) {
+				kept++
+				continue
+			}
+			if err := r.RemoveTask(ctx, id); err != nil {
+				logger.Warn("error purging dead task", "id", id, "error", err)
+				kept++
+				continue
+			}
+			purged++
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+// maxDeliveries bounds the delivery log of a task, only the recent attempts are kept.
+const maxDeliveries = 100
+
+// RecordDelivery adds the attempt to deliver a callback to the delivery log of its task in the given queue.
+func (r *RDB) RecordDelivery(ctx context.Context, qname string, delivery *base.Delivery) error {
+	encoded, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("cannot encode delivery: %v", err)
+	}
+	key := deliveriesKey(qname, delivery.TaskID)
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, key, encoded)
+		pipe.LTrim(ctx, key, 0, maxDeliveries-1)
+		return nil
+	})
+	return err
+}
+
+// GetDeliveries returns the delivery log of the callback of a task, the last attempt first.
+func (r *RDB) GetDeliveries(ctx context.Context, taskID string) ([]*base.Delivery, error) {
+	qname, err := r.taskQueue(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	values, err := r.client.LRange(ctx, deliveriesKey(qname, taskID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]*base.Delivery, 0, len(values))
+	for _, value := range values {
+		var delivery base.Delivery
+		if err := json.Unmarshal([]byte(value), &delivery); err != nil {
+			logger.Warn("error decoding delivery", "task_id", taskID, "error", err)
+			continue
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,107 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+const KeyEvents = "gotama:events" // STREAM
+
+// maxEvents bounds the length of the events stream, only the recent events can be resumed.
+const maxEvents = 10000
+
+// readEventsCount is the maximum number of events returned by a single read.
+const readEventsCount = 100
+
+// publishEvent appends a state transition of the task to the events stream.
+// The events are best effort, an error publishing one does not fail the transition.
+func (r *RDB) publishEvent(ctx context.Context, eventType base.EventType, msg *task.Message) {
+	event := base.TaskEvent{
+		Type:     eventType,
+		TaskID:   msg.ID,
+		Name:     msg.Name,
+		Queue:    msg.Queue,
+		Attempts: msg.NumRetries,
+		Error:    msg.Error,
+		Time:     r.clock.Now().UTC().Format(time.RFC3339),
+	}
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		logger.Warn("error encoding task event", "id", msg.ID, "type", eventType, "error", err)
+		return
+	}
+	err = r.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: KeyEvents,
+		MaxLen: maxEvents,
+		Approx: true,
+		Values: map[string]any{"event": encoded},
+	}).Err()
+	if err != nil {
+		logger.Warn("error publishing task event", "id", msg.ID, "type", eventType, "error", err)
+	}
+}
+
+// publishEvents publishes an event of each of the tasks of the queue with the given IDs,
+// e.g. of the tasks the scheduler moved to the pending queue.
+func (r *RDB) publishEvents(ctx context.Context, eventType base.EventType, qname string, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	tasks, err := r.getTasks(ctx, queueNames(qname, len(ids)), ids)
+	if err != nil {
+		logger.Warn("error reading tasks to publish their events", "queue", qname, "type", eventType, "error", err)
+		return
+	}
+	for _, msg := range tasks {
+		r.publishEvent(ctx, eventType, msg)
+	}
+}
+
+// ReadEvents returns the events after the event with lastID, see LastEventID for reading the events from now on.
+// It waits up to block for new events and returns none if there are none by then.
+func (r *RDB) ReadEvents(ctx context.Context, lastID string, block time.Duration) ([]*base.TaskEvent, error) {
+	streams, err := r.client.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{KeyEvents, lastID},
+		Count:   readEventsCount,
+		Block:   block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*base.TaskEvent
+	for _, stream := range streams {
+		for _, m := range stream.Messages {
+			encoded, _ := m.Values["event"].(string)
+			var event base.TaskEvent
+			if err := json.Unmarshal([]byte(encoded), &event); err != nil {
+				logger.Warn("error decoding task event", "id", m.ID, "error", err)
+				continue
+			}
+			event.ID = m.ID
+			events = append(events, &event)
+		}
+	}
+	return events, nil
+}
+
+// LastEventID returns the ID of the last event, events published later are after it.
+func (r *RDB) LastEventID(ctx context.Context) (string, error) {
+	messages, err := r.client.XRevRangeN(ctx, KeyEvents, "+", "-", 1).Result()
+	if err != nil {
+		return "", err
+	}
+	if len(messages) == 0 {
+		return "0-0", nil
+	}
+	return messages[0].ID, nil
+}
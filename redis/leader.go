@@ -0,0 +1,133 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+const KeyLeader = "gotama:leader" // HASH
+
+const KeyLeaderEpoch = "gotama:leader_epoch" // STRING
+
+// fencingLua checks the fencing token of a scheduler operation, so that a replica
+// which lost its leadership, e.g. while paused, cannot schedule next to the new leader.
+const fencingLua = `
+local function is_leader(leader_key, epoch)
+    return redis.call("HGET", leader_key, "epoch") == epoch
+end
+`
+
+// KEYS[1] -> gotama:leader
+// KEYS[2] -> gotama:leader_epoch
+// -------
+// ARGV[1] -> replica ID
+// ARGV[2] -> leadership TTL in milli sec
+// ARGV[3] -> current time in unix milli sec
+// ARGV[4] -> leadership expiration time in unix milli sec
+//
+// Output:
+// Returns the epoch of the leadership if the replica is the leader
+// Returns 0 if another replica is the leader
+var acquireLeadershipCmd = redis.NewScript(`
+local leader = redis.call("HGET", KEYS[1], "id")
+-- the leadership expires by the clock of the replicas too, not only by the TTL of the key
+if leader and (tonumber(redis.call("HGET", KEYS[1], "expires_at")) or 0) <= tonumber(ARGV[3]) then
+    leader = nil
+end
+if leader == ARGV[1] then
+    redis.call("HSET", KEYS[1], "expires_at", ARGV[4])
+    redis.call("PEXPIRE", KEYS[1], ARGV[2])
+    return tonumber(redis.call("HGET", KEYS[1], "epoch"))
+end
+if leader then
+    return 0
+end
+local epoch = redis.call("INCR", KEYS[2])
+redis.call("HSET", KEYS[1],
+           "id", ARGV[1],
+           "epoch", epoch,
+           "elected_at", ARGV[3],
+           "expires_at", ARGV[4])
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return epoch
+`)
+
+// AcquireLeadership makes the replica the leader for ttl if there is no leader, or renews its leadership.
+// It returns the epoch of the leadership, the fencing token of the scheduler operations,
+// or base.ErrorNotLeader if another replica is the leader.
+func (r *RDB) AcquireLeadership(ctx context.Context, id string, ttl time.Duration) (int64, error) {
+	keys := []string{
+		KeyLeader,
+		KeyLeaderEpoch,
+	}
+	now := r.clock.Now()
+	argv := []any{
+		id,
+		ttl.Milliseconds(),
+		now.UnixMilli(),
+		now.Add(ttl).UnixMilli(),
+	}
+	epoch, err := r.runScriptWithErrorCode(ctx, acquireLeadershipCmd, keys, argv...)
+	if err != nil {
+		return 0, err
+	}
+	if epoch == 0 {
+		return 0, base.ErrorNotLeader
+	}
+	return epoch, nil
+}
+
+// checkLeadership returns base.ErrorNotLeader if epoch is not the epoch of the current leadership,
+// so a stale leader is fenced off even when there are no queues to operate on.
+func (r *RDB) checkLeadership(ctx context.Context, epoch int64) error {
+	current, err := r.client.HGet(ctx, KeyLeader, "epoch").Result()
+	if errors.Is(err, redis.Nil) || (err == nil && current != strconv.FormatInt(epoch, 10)) {
+		return base.ErrorNotLeader
+	}
+	return err
+}
+
+// KEYS[1] -> gotama:leader
+// -------
+// ARGV[1] -> replica ID
+//
+// Output:
+// Returns 1 if the leadership was released
+// Returns 0 if the replica is not the leader
+var releaseLeadershipCmd = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "id") == ARGV[1] then
+    return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// ReleaseLeadership gives up the leadership of the replica, so another one can take over without waiting for it to expire.
+func (r *RDB) ReleaseLeadership(ctx context.Context, id string) error {
+	_, err := r.runScriptWithErrorCode(ctx, releaseLeadershipCmd, []string{KeyLeader}, id)
+	return err
+}
+
+// GetLeader returns the current leader or base.ErrorNoLeader if there is none.
+func (r *RDB) GetLeader(ctx context.Context) (*base.Leader, error) {
+	values, err := r.client.HGetAll(ctx, KeyLeader).Result()
+	if err != nil {
+		return nil, err
+	}
+	if values["id"] == "" {
+		return nil, base.ErrorNoLeader
+	}
+
+	epoch, _ := strconv.ParseInt(values["epoch"], 10, 64)
+	electedAt, _ := strconv.ParseInt(values["elected_at"], 10, 64)
+	expiresAt, _ := strconv.ParseInt(values["expires_at"], 10, 64)
+	return &base.Leader{
+		ID:        values["id"],
+		Epoch:     epoch,
+		ElectedAt: time.UnixMilli(electedAt).UTC().Format(time.RFC3339),
+		ExpiresAt: time.UnixMilli(expiresAt).UTC().Format(time.RFC3339),
+	}, nil
+}
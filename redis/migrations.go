@@ -0,0 +1,279 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"strings"
+)
+
+const KeyMigrations = "gotama:migrations" // HASH
+
+const (
+	migrationIndexes      = "indexes"
+	migrationPendingSince = "pending_since"
+)
+
+// migrationScanCount is the number of keys fetched per SCAN iteration during migrations.
+const migrationScanCount = 500
+
+// Migrate runs the migrations of the stored tasks which are needed on startup.
+func (r *RDB) Migrate(ctx context.Context) error {
+	if err := r.MigrateIndexes(ctx); err != nil {
+		return err
+	}
+	return r.MigratePendingSince(ctx)
+}
+
+// MigrateIndexes adds the tasks created before the secondary indexes existed to the indexes.
+// It scans the task hashes incrementally, so it does not block redis, and runs only once.
+func (r *RDB) MigrateIndexes(ctx context.Context) error {
+	done, err := r.client.HExists(ctx, KeyMigrations, migrationIndexes).Result()
+	if err != nil {
+		return err
+	}
+	if done {
+		return nil
+	}
+
+	logger.Info("Migrating tasks to indexes...")
+	var migrated int
+	err = r.scanTaskKeys(ctx, func(keys []string) error {
+		fields := make([]*redis.SliceCmd, len(keys))
+		_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				fields[i] = pipe.HMGet(ctx, key, "created_at", "status")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				qname, id, ok := parseTaskKey(key)
+				if !ok {
+					continue
+				}
+				values := fields[i].Val()
+				createdAtStr, _ := values[0].(string)
+				status, _ := values[1].(string)
+				createdAt, _ := strconv.ParseFloat(createdAtStr, 64)
+
+				pipe.HSetNX(ctx, KeyTaskQueues, id, qname)
+				pipe.SAdd(ctx, KeyQueues, qname)
+				pipe.ZAdd(ctx, KeyTasks, redis.Z{Score: createdAt, Member: id})
+				pipe.ZAdd(ctx, queueTasksKey(qname), redis.Z{Score: createdAt, Member: id})
+				if status != "" {
+					pipe.ZAdd(ctx, statusKey(qname, status), redis.Z{Score: createdAt, Member: id})
+				}
+				migrated++
+			}
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error migrating indexes: %w", err)
+	}
+
+	logger.Info("Migrated tasks to indexes", "count", migrated)
+	return r.client.HSet(ctx, KeyMigrations, migrationIndexes, r.clock.Now().UnixMilli()).Err()
+}
+
+// KEYS[1] -> gotama:<qname>:t:<task_id>
+// KEYS[2] -> gotama:<qname>:pending_since
+// -------
+// ARGV[1] -> task ID
+var indexPendingSinceCmd = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "status") ~= "pending" then
+    return 0
+end
+local pending_since = redis.call("HGET", KEYS[1], "pending_since") or 0
+redis.call("ZADD", KEYS[2], pending_since, ARGV[1])
+return 1
+`)
+
+// MigratePendingSince adds the tasks which were pending before the pending tasks were indexed by pending_since to the index.
+// The status is checked by a script, so a task dequeued meanwhile is not indexed. It runs only once.
+func (r *RDB) MigratePendingSince(ctx context.Context) error {
+	done, err := r.client.HExists(ctx, KeyMigrations, migrationPendingSince).Result()
+	if err != nil {
+		return err
+	}
+	if done {
+		return nil
+	}
+
+	logger.Info("Migrating pending tasks to the pending since index...")
+	var migrated int64
+	err = r.scanTaskKeys(ctx, func(keys []string) error {
+		cmds := make([]*redis.Cmd, 0, len(keys))
+		_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range keys {
+				qname, id, ok := parseTaskKey(key)
+				if !ok {
+					continue
+				}
+				cmds = append(cmds, indexPendingSinceCmd.Eval(ctx, pipe, []string{key, pendingSinceKey(qname)}, id))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, cmd := range cmds {
+			if n, _ := cmd.Int64(); n == 1 {
+				migrated++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error migrating pending since index: %w", err)
+	}
+
+	logger.Info("Migrated pending tasks to the pending since index", "count", migrated)
+	return r.client.HSet(ctx, KeyMigrations, migrationPendingSince, r.clock.Now().UnixMilli()).Err()
+}
+
+// scanTaskKeys calls fn with batches of task keys of all queues.
+func (r *RDB) scanTaskKeys(ctx context.Context, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := r.client.Scan(ctx, cursor, taskKey("*", "*"), migrationScanCount).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// parseTaskKey returns the queue name and the task ID of a task key, gotama:<qname>:t:<task_id>.
+func parseTaskKey(key string) (string, string, bool) {
+	parts := strings.Split(key, ":")
+	if len(parts) != 4 || parts[0] != "gotama" || parts[2] != "t" {
+		return "", "", false
+	}
+	return parts[1], parts[3], true
+}
+
+// KEYS[1] -> gotama:<qname>:t:<task_id>
+// -------
+// ARGV[1] -> encoded message as it was read
+// ARGV[2] -> rewritten message
+//
+// Output:
+// Returns 1 if the message was rewritten
+// Returns 0 if the message was changed since it was read
+var rewriteMessageCmd = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "msg") ~= ARGV[1] then
+    return 0
+end
+redis.call("HSET", KEYS[1], "msg", ARGV[2])
+return 1
+`)
+
+// MigrateEncoding rewrites the messages of the tasks still stored in the legacy JSON encoding in the binary one.
+// It reports its progress after each batch of tasks, returns the number of rewritten messages and can be run again safely.
+func (r *RDB) MigrateEncoding(ctx context.Context, progress base.MigrationProgress) (int64, error) {
+	logger.Info("Migrating tasks to the binary encoding...")
+	migrated, err := r.rewriteMessages(ctx, progress, func(encoded string) (bool, error) {
+		return task.IsLegacyEncoding(encoded), nil
+	})
+	if err != nil {
+		return migrated, fmt.Errorf("error migrating encoding: %w", err)
+	}
+
+	logger.Info("Migrated tasks to the binary encoding", "count", migrated)
+	return migrated, nil
+}
+
+// RotateEncryption re-encrypts the payloads of the tasks which are not encrypted with the primary key of the keyring,
+// including the ones stored in plaintext. The keys rotated out can be removed from the keyring once it is done.
+// It reports its progress after each batch of tasks, returns the number of re-encrypted messages and can be run again safely.
+func (r *RDB) RotateEncryption(ctx context.Context, progress base.MigrationProgress) (int64, error) {
+	if r.keyring == nil {
+		return 0, base.ErrorNoEncryptionKeys
+	}
+
+	logger.Info("Re-encrypting tasks...", "key", r.keyring.PrimaryKeyID())
+	rotated, err := r.rewriteMessages(ctx, progress, func(encoded string) (bool, error) {
+		keyID, err := task.PayloadKeyID(encoded)
+		if err != nil {
+			return false, err
+		}
+		return keyID != r.keyring.PrimaryKeyID(), nil
+	})
+	if err != nil {
+		return rotated, fmt.Errorf("error rotating encryption: %w", err)
+	}
+
+	logger.Info("Re-encrypted tasks", "key", r.keyring.PrimaryKeyID(), "count", rotated)
+	return rotated, nil
+}
+
+// rewriteMessages decodes and encodes again the messages of the tasks for which rewrite returns true.
+// A message updated while it is rewritten is left alone, it is encoded by the update already.
+func (r *RDB) rewriteMessages(ctx context.Context, progress base.MigrationProgress, rewrite func(encoded string) (bool, error)) (int64, error) {
+	var scanned, rewritten int64
+	err := r.scanTaskKeys(ctx, func(keys []string) error {
+		encodedCmds := make([]*redis.StringCmd, len(keys))
+		_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				encodedCmds[i] = pipe.HGet(ctx, key, "msg")
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+
+		for i, key := range keys {
+			encoded := encodedCmds[i].Val()
+			if encoded == "" {
+				continue
+			}
+			ok, err := rewrite(encoded)
+			if err != nil {
+				logger.Warn("error reading task, skipping it", "key", key, "error", err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			msg, err := task.DecodeMessage(encoded, r.keyring)
+			if err != nil {
+				logger.Warn("error decoding task, skipping it", "key", key, "error", err)
+				continue
+			}
+			reencoded, err := task.EncodeMessage(msg, r.keyring)
+			if err != nil {
+				return err
+			}
+			n, err := r.runScriptWithErrorCode(ctx, rewriteMessageCmd, []string{key}, encoded, reencoded)
+			if err != nil {
+				return err
+			}
+			rewritten += n
+		}
+		scanned += int64(len(keys))
+		progress(scanned, rewritten)
+		return nil
+	})
+	return rewritten, err
+}
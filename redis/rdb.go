@@ -5,24 +5,35 @@ import (
 	"errors"
 	"fmt"
 	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/encryption"
 	"github.com/engpetarmarinov/gotama/internal/logger"
 	"github.com/engpetarmarinov/gotama/internal/task"
 	"github.com/engpetarmarinov/gotama/internal/timeutil"
 	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
 )
 
 type RDB struct {
-	client redis.UniversalClient
-	clock  timeutil.Clock
+	client  redis.UniversalClient
+	clock   timeutil.Clock
+	keyring *encryption.Keyring
 }
 
 func NewRDB(client redis.UniversalClient, clock timeutil.Clock) *RDB {
+	client.AddHook(tracingHook{})
 	return &RDB{
 		client: client,
 		clock:  clock,
 	}
 }
 
+// WithKeyring encrypts the payloads of the tasks stored from now on with the keyring, and decrypts them when read.
+func (r *RDB) WithKeyring(keyring *encryption.Keyring) *RDB {
+	r.keyring = keyring
+	return r
+}
+
 func (r *RDB) Ping(ctx context.Context) error {
 	return r.client.Ping(ctx).Err()
 }
@@ -50,6 +61,38 @@ func (r *RDB) runScriptWithErrorCode(ctx context.Context, script *redis.Script,
 	return n, nil
 }
 
+// runLeasedScript runs a script which changes a running task, which returns 0 if the task does not hold the lease anymore.
+func (r *RDB) runLeasedScript(ctx context.Context, script *redis.Script, keys []string, args ...any) error {
+	n, err := r.runScriptWithErrorCode(ctx, script, keys, args...)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return base.ErrorLeaseExpired
+	}
+	return nil
+}
+
+// runScriptWithIDs runs a scheduler script, which returns the IDs of the tasks it changed
+// or -1 if the scheduler is no longer the leader.
+func (r *RDB) runScriptWithIDs(ctx context.Context, script *redis.Script, keys []string, args ...any) ([]string, error) {
+	res, err := script.Run(ctx, r.client, keys, args...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis eval error: %v", err)
+	}
+	switch res := res.(type) {
+	case int64:
+		return nil, base.ErrorNotLeader
+	case []any:
+		ids := make([]string, 0, len(res))
+		for _, id := range res {
+			ids = append(ids, id.(string))
+		}
+		return ids, nil
+	}
+	return nil, fmt.Errorf("unexpected return value from Lua script: %v", res)
+}
+
 // queueKeyPrefix returns a prefix for all keys in the given queue.
 func queueKeyPrefix(qname string) string {
 	return fmt.Sprintf("gotama:%s:", qname)
@@ -90,105 +133,206 @@ func retryKey(qname string) string {
 	return fmt.Sprintf("%sretry", queueKeyPrefix(qname))
 }
 
-// getAllTasksCmd fetches all tasks with an offset and limit.
-//
-// Input:
-// KEYS[1] -> gotama:<qname>:t:*
-// --
-// ARGV[1] -> offset
-// ARGV[2] -> limit
+// delayedKey returns a redis key for the tasks delayed until their process time, sorted by it.
+func delayedKey(qname string) string {
+	return fmt.Sprintf("%sdelayed", queueKeyPrefix(qname))
+}
+
+// cronKey returns a redis key for the cron tasks, sorted by their next run time.
+func cronKey(qname string) string {
+	return fmt.Sprintf("%scron", queueKeyPrefix(qname))
+}
+
+// leaseKey returns a redis key for the leases of the running tasks.
+func leaseKey(qname string) string {
+	return fmt.Sprintf("%slease", queueKeyPrefix(qname))
+}
+
+// deliveriesKey returns a redis key for the log of the callback deliveries of the given task, newest first.
+func deliveriesKey(qname, id string) string {
+	return fmt.Sprintf("%sd:%s", queueKeyPrefix(qname), id)
+}
+
+// notifyChannel returns a pub/sub channel notified when tasks are pushed to the pending list.
+func notifyChannel(qname string) string {
+	return fmt.Sprintf("%snotify", queueKeyPrefix(qname))
+}
+
+// queueTasksKey returns a redis key for the index of all tasks in the given queue, sorted by creation time.
+func queueTasksKey(qname string) string {
+	return fmt.Sprintf("%stasks", queueKeyPrefix(qname))
+}
+
+// pendingSinceKey returns a redis key for the index of the pending tasks, sorted by the time they became pending.
+func pendingSinceKey(qname string) string {
+	return fmt.Sprintf("%spending_since", queueKeyPrefix(qname))
+}
+
+// statusKey returns a redis key for the index of the tasks with the given status, sorted by creation time.
+func statusKey(qname, status string) string {
+	return fmt.Sprintf("%ss:%s", queueKeyPrefix(qname), status)
+}
+
+const KeyTasks = "gotama:tasks" // ZSET
+
+// setStatusLua is prepended to the scripts which change the status of a task.
+// set_status keeps the status index of the queue in sync with the status field of the task hash.
+// It indexes the pending tasks by their pending_since field too, so it is set before the status becomes pending.
 //
-// Output:
-// Returns {total_keys, paginated_keys}
-var getAllTasksCmd = redis.NewScript(`
-local keys = redis.call("KEYS", KEYS[1])
-local sorted_keys = {}
-for i, key in ipairs(keys) do
-	local created_at = redis.call("HGET", key, "created_at")
-	local msg = redis.call("HGET", key, "msg")
-	sorted_keys[i] = {tonumber(created_at) or 0, key , msg}
-end
-local function customSort(a, b)
-	if a[1] == b[1] then
-		return a[2] < b[2] -- Sort by key ASC
-	else
-		return a[1] > b[1] -- Sort by created_at DESC
-	end
+// qprefix -> gotama:<qname>:
+const setStatusLua = `
+local function set_status(qprefix, task_id, status)
+    local task_key = qprefix .. "t:" .. task_id
+    local old_status = redis.call("HGET", task_key, "status")
+    if old_status then
+        redis.call("ZREM", qprefix .. "s:" .. old_status, task_id)
+    end
+    if old_status == "pending" then
+        redis.call("ZREM", qprefix .. "pending_since", task_id)
+    end
+    local created_at = redis.call("HGET", task_key, "created_at") or 0
+    redis.call("ZADD", qprefix .. "s:" .. status, created_at, task_id)
+    if status == "pending" then
+        local pending_since = redis.call("HGET", task_key, "pending_since") or 0
+        redis.call("ZADD", qprefix .. "pending_since", pending_since, task_id)
+    end
+    redis.call("HSET", task_key, "status", status)
 end
-table.sort(sorted_keys, customSort)
-
-local total_keys = #sorted_keys
-local start_index = ARGV[1] + 1
-local end_index = math.min(ARGV[1] + ARGV[2], total_keys)
-local paginated_keys = {}
-for i = start_index, end_index do
-	paginated_keys[i - start_index + 1] = sorted_keys[i][3]
+`
+
+// leaseLua is prepended to the scripts which change a running task.
+// holds_lease tells whether the task is running with the lease of the given token, a worker whose lease expired
+// cannot change the task anymore, even once it is dequeued again.
+const leaseLua = `
+local function holds_lease(task_key, token)
+    local fields = redis.call("HMGET", task_key, "status", "lease_token")
+    return fields[1] == "running" and fields[2] == token
 end
-return {total_keys, paginated_keys}
-`)
+`
 
-// GetAllTasks fetches tasks with a given offset.
-func (r *RDB) GetAllTasks(ctx context.Context, offset int, limit int) (int64, []*task.Message, error) {
-	keys := []string{
-		taskKey(task.QueueDefault, "*"),
-	}
-	argv := []any{
-		offset,
-		limit,
+// GetAllTasks fetches tasks sorted by creation time, newest first, with a given offset.
+// The tasks can be narrowed down to a queue and to a status within that queue, leave them empty to get all tasks.
+func (r *RDB) GetAllTasks(ctx context.Context, qname string, status string, offset int, limit int) (int64, []*task.Message, error) {
+	index := KeyTasks
+	if status != "" {
+		if qname == "" {
+			return 0, nil, errors.New("filtering by status requires a queue")
+		}
+		index = statusKey(qname, status)
+	} else if qname != "" {
+		index = queueTasksKey(qname)
 	}
-	logger.Info("Fetching all tasks", "offset", argv[0], "limit", argv[1])
-	var tasks []*task.Message
-	res, err := getAllTasksCmd.Run(ctx, r.client, keys, argv...).Result()
+	logger.Info("Fetching all tasks", "index", index, "offset", offset, "limit", limit)
+
+	var total *redis.IntCmd
+	var ids *redis.StringSliceCmd
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		total = pipe.ZCard(ctx, index)
+		ids = pipe.ZRevRange(ctx, index, int64(offset), int64(offset+limit-1))
+		return nil
+	})
 	if err != nil {
-		return 0, tasks, fmt.Errorf("redis eval error: %v", err)
+		return 0, nil, err
 	}
-	parsedRes, ok := res.([]any)
-	if !ok {
-		return 0, tasks, fmt.Errorf("unexpected return value from Lua script: %v", res)
+
+	qnames := queueNames(qname, len(ids.Val()))
+	if qname == "" && len(qnames) > 0 {
+		res, err := r.client.HMGet(ctx, KeyTaskQueues, ids.Val()...).Result()
+		if err != nil {
+			return 0, nil, err
+		}
+		for i, q := range res {
+			qnames[i], _ = q.(string)
+			if qnames[i] == "" {
+				qnames[i] = task.QueueDefault
+			}
+		}
 	}
 
-	totalTasks, ok := parsedRes[0].(int64)
-	if !ok {
-		return 0, tasks, fmt.Errorf("unexpected return total_keys from Lua script: %v", parsedRes)
+	tasks, err := r.getTasks(ctx, qnames, ids.Val())
+	if err != nil {
+		return 0, nil, err
 	}
 
-	encodedMsgs, ok := parsedRes[1].([]any)
-	if !ok {
-		return 0, tasks, fmt.Errorf("unexpected return paginated_keys from Lua script: %v", parsedRes)
+	return total.Val(), tasks, nil
+}
+
+// decode returns the task message with its current status,
+// the stored message keeps the status it was last updated with.
+func (r *RDB) decode(encoded string, status string) (*task.Message, error) {
+	msg, err := task.DecodeMessage(encoded, r.keyring)
+	if err != nil {
+		logger.Error("Error decoding msg", "error", err)
+		return nil, err
+	}
+	if s, ok := task.StatusFromIndex(status); ok {
+		msg.Status = s
+	}
+	return msg, nil
+}
+
+// getTasks returns the tasks with the given IDs, each in the queue with the same index in qnames.
+// Tasks which do not exist are skipped.
+func (r *RDB) getTasks(ctx context.Context, qnames []string, ids []string) ([]*task.Message, error) {
+	cmds := make([]*redis.SliceCmd, len(ids))
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.HMGet(ctx, taskKey(qnames[i], id), "msg", "status")
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
 	}
 
-	for _, encoded := range encodedMsgs {
-		encodedStr, ok := encoded.(string)
+	var tasks []*task.Message
+	for _, cmd := range cmds {
+		values := cmd.Val()
+		encoded, ok := values[0].(string)
 		if !ok {
-			return 0, tasks, fmt.Errorf("error trying to cast %v to string", encoded)
+			// removed after the index was read
+			continue
 		}
-		msg, err := task.DecodeMessage(encodedStr)
+		status, _ := values[1].(string)
+		msg, err := r.decode(encoded, status)
 		if err != nil {
-			logger.Error("Error decoding msg", "error", err)
-			return 0, tasks, err
+			return nil, err
 		}
 		tasks = append(tasks, msg)
 	}
 
-	return totalTasks, tasks, nil
+	return tasks, nil
+}
+
+const KeyTaskQueues = "gotama:task_queues" // HASH
+
+// taskQueue returns the name of the queue the task with the given ID belongs to.
+func (r *RDB) taskQueue(ctx context.Context, taskID string) (string, error) {
+	qname, err := r.client.HGet(ctx, KeyTaskQueues, taskID).Result()
+	if errors.Is(err, redis.Nil) {
+		// tasks enqueued before named queues were introduced are not in the lookup
+		return task.QueueDefault, nil
+	}
+	return qname, err
 }
 
 // GetTask fetches a task by its ID.
 func (r *RDB) GetTask(ctx context.Context, taskID string) (*task.Message, error) {
-	encoded, err := r.client.HGet(ctx, taskKey(task.QueueDefault, taskID), "msg").Result()
+	qname, err := r.taskQueue(ctx, taskID)
 	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			return nil, errors.New("not found")
-		}
 		return nil, err
 	}
-	msg, err := task.DecodeMessage(encoded)
+	values, err := r.client.HMGet(ctx, taskKey(qname, taskID), "msg", "status").Result()
 	if err != nil {
-		logger.Error("Error decoding msg", "error", err)
 		return nil, err
 	}
+	encoded, ok := values[0].(string)
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	status, _ := values[1].(string)
 
-	return msg, nil
+	return r.decode(encoded, status)
 }
 
 // enqueueTaskCmd enqueues a given task message.
@@ -197,41 +341,96 @@ func (r *RDB) GetTask(ctx context.Context, taskID string) (*task.Message, error)
 // KEYS[1] -> gotama:<qname>:t:<task_id>
 // KEYS[2] -> gotama:<qname>:pending
 // KEYS[3] -> gotama:<qname>:scheduled
+// KEYS[4] -> gotama:task_queues
+// KEYS[5] -> gotama:tasks
+// KEYS[6] -> gotama:<qname>:tasks
+// KEYS[7] -> gotama:<qname>:delayed
+// KEYS[8] -> gotama:<qname>:cron
 // --
 // ARGV[1] -> task message data
 // ARGV[2] -> task ID
 // ARGV[3] -> current unix time in milli sec
 // ARGV[4] -> period in milli sec
 // ARGV[5] -> type, RECURRING or ONCE
+// ARGV[6] -> notify channel
+// ARGV[7] -> queue name
+// ARGV[8] -> gotama:<qname>:
+// ARGV[9] -> process at time in unix milli sec, 0 to process immediately
+// ARGV[10] -> cron expression, empty if the task is not a cron task
+// ARGV[11] -> timezone of the cron expression
+// ARGV[12] -> next run time of the cron task in unix milli sec
 //
 // Output:
 // Returns 1 if successfully enqueued
 // Returns 0 if task ID already exists
-var enqueueTaskCmd = redis.NewScript(`
+var enqueueTaskCmd = redis.NewScript(setStatusLua + `
 if redis.call("EXISTS", KEYS[1]) == 1 then
     return 0
 end
 redis.call("HSET", KEYS[1],
            "msg", ARGV[1],
-           "status", "pending",
            "pending_since", ARGV[3],
            "created_at", ARGV[3],
-           "period", ARGV[4])
-redis.call("LPUSH", KEYS[2], ARGV[2])
+           "period", ARGV[4],
+           "cron", ARGV[10],
+           "timezone", ARGV[11])
+redis.call("HSET", KEYS[4], ARGV[2], ARGV[7])
+redis.call("ZADD", KEYS[5], ARGV[3], ARGV[2])
+redis.call("ZADD", KEYS[6], ARGV[3], ARGV[2])
+if ARGV[10] ~= "" then
+    -- a cron task is enqueued by the scheduler on its first run
+    redis.call("ZADD", KEYS[8], ARGV[12], ARGV[2])
+    set_status(ARGV[8], ARGV[2], "scheduled")
+    return 1
+end
 if ARGV[5] == "RECURRING" then
     redis.call("LPUSH", KEYS[3], ARGV[2])
 end
+if tonumber(ARGV[9]) > tonumber(ARGV[3]) then
+    redis.call("ZADD", KEYS[7], ARGV[9], ARGV[2])
+    set_status(ARGV[8], ARGV[2], "delayed")
+    return 1
+end
+set_status(ARGV[8], ARGV[2], "pending")
+redis.call("LPUSH", KEYS[2], ARGV[2])
+redis.call("PUBLISH", ARGV[6], 1)
 return 1
 `)
 
 const KeyQueues = "queues" // SET
 
-// EnqueueTask adds the given task to the pending list of the queue.
+// processAtMilli returns the time to process msg at in unix milli sec, 0 if it should be processed immediately.
+func processAtMilli(msg *task.Message) int64 {
+	if msg.ProcessAt == nil {
+		return 0
+	}
+	return msg.ProcessAt.UnixMilli()
+}
+
+// nextCronRunMilli returns the next run time of a cron task after now in unix milli sec, 0 if msg is not a cron task.
+func nextCronRunMilli(msg *task.Message, now time.Time) (int64, error) {
+	if msg.Cron == "" {
+		return 0, nil
+	}
+	next, err := task.NextRun(msg.Cron, msg.Timezone, now)
+	if err != nil {
+		return 0, err
+	}
+	return next.UnixMilli(), nil
+}
+
+// EnqueueTask adds the given task to the pending list of the queue,
+// or to the delayed tasks of the queue if it should be processed later.
 func (r *RDB) EnqueueTask(ctx context.Context, msg *task.Message) error {
-	encoded, err := task.EncodeMessage(msg)
+	encoded, err := task.EncodeMessage(msg, r.keyring)
 	if err != nil {
 		return fmt.Errorf("cannot encode message: %v", err)
 	}
+	now := r.clock.Now()
+	nextRun, err := nextCronRunMilli(msg, now)
+	if err != nil {
+		return err
+	}
 	if err := r.client.SAdd(ctx, KeyQueues, msg.Queue).Err(); err != nil {
 		return err
 	}
@@ -239,13 +438,25 @@ func (r *RDB) EnqueueTask(ctx context.Context, msg *task.Message) error {
 		taskKey(msg.Queue, msg.ID),
 		pendingKey(msg.Queue),
 		scheduledKey(msg.Queue),
+		KeyTaskQueues,
+		KeyTasks,
+		queueTasksKey(msg.Queue),
+		delayedKey(msg.Queue),
+		cronKey(msg.Queue),
 	}
 	argv := []any{
 		encoded,
 		msg.ID,
-		r.clock.Now().UnixMilli(),
+		now.UnixMilli(),
 		msg.Period.Milliseconds(),
 		msg.Type.String(),
+		notifyChannel(msg.Queue),
+		msg.Queue,
+		queueKeyPrefix(msg.Queue),
+		processAtMilli(msg),
+		msg.Cron,
+		msg.Timezone,
+		nextRun,
 	}
 	logger.Info("Adding task", "id", keys[0], "queue", keys[1])
 	n, err := r.runScriptWithErrorCode(ctx, enqueueTaskCmd, keys, argv...)
@@ -255,36 +466,50 @@ func (r *RDB) EnqueueTask(ctx context.Context, msg *task.Message) error {
 	if n == 0 {
 		return errors.New("task id already exists")
 	}
+	r.publishEvent(ctx, base.EventEnqueued, msg)
 	return nil
 }
 
+// dequeueTaskCmd moves the first pending task of the given queues to the running list.
+// The queues are checked in the order they are passed in.
+//
 // Input:
-// KEYS[1] -> gotama:<qname>:pending
-// KEYS[2] -> gotama:<qname>:running
+// KEYS[3n-2] -> gotama:<qname>:pending
+// KEYS[3n-1] -> gotama:<qname>:running
+// KEYS[3n] -> gotama:<qname>:lease
 // --
-// ARGV[1] -> task key prefix
+// ARGV[1] -> lease expiration time in unix milli sec
+// ARGV[2] -> lease token
+// ARGV[n+2] -> gotama:<qname>: of the n-th queue
 //
 // Output:
-// Returns nil if no processable task is found in the given queue.
+// Returns nil if no processable task is found in the given queues.
 // Returns an encoded TaskMessage.
-var dequeueTaskCmd = redis.NewScript(`
-if redis.call("EXISTS", KEYS[1]) == 1 then
-    local id = redis.call("RPOPLPUSH", KEYS[1], KEYS[2])
+var dequeueTaskCmd = redis.NewScript(setStatusLua + `
+for i = 1, #KEYS / 3 do
+    local id = redis.call("RPOPLPUSH", KEYS[i * 3 - 2], KEYS[i * 3 - 1])
     if id then
-        local key = ARGV[1] .. id
-        redis.call("HSET", key, "status", "running")
-        return redis.call("HGET", key, "msg")
+        local task_key = ARGV[i + 2] .. "t:" .. id
+        set_status(ARGV[i + 2], id, "running")
+        redis.call("ZADD", KEYS[i * 3], ARGV[1], id)
+        redis.call("HSET", task_key, "lease_token", ARGV[2])
+        return redis.call("HGET", task_key, "msg")
     end
 end
 return nil`)
 
-func (r *RDB) DequeueTask(ctx context.Context, qname string) (*task.Message, error) {
-	keys := []string{
-		pendingKey(qname),
-		runningKey(qname),
-	}
+// DequeueTask moves a task from the pending to the running list of the first non-empty queue
+// and leases it for the given duration.
+func (r *RDB) DequeueTask(ctx context.Context, lease time.Duration, qnames ...string) (*task.Message, error) {
+	keys := make([]string, 0, len(qnames)*3)
+	token := task.NewLeaseToken()
 	argv := []any{
-		taskKeyPrefix(qname),
+		r.clock.Now().Add(lease).UnixMilli(),
+		token,
+	}
+	for _, qname := range qnames {
+		keys = append(keys, pendingKey(qname), runningKey(qname), leaseKey(qname))
+		argv = append(argv, queueKeyPrefix(qname))
 	}
 	encoded, err := dequeueTaskCmd.Run(ctx, r.client, keys, argv...).Result()
 	if errors.Is(err, redis.Nil) {
@@ -298,58 +523,168 @@ func (r *RDB) DequeueTask(ctx context.Context, qname string) (*task.Message, err
 		return nil, fmt.Errorf("error trying to cast %v to string", encoded)
 	}
 
-	msg, err := task.DecodeMessage(encodedStr)
+	msg, err := r.decode(encodedStr, "running")
 	if err != nil {
-		logger.Error("Error decoding msg", "error", err)
 		return nil, err
 	}
+	msg.LeaseToken = token
 
+	r.publishEvent(ctx, base.EventStarted, msg)
 	return msg, nil
 }
 
+// NotifyPending returns a channel which receives a value when tasks are pushed to the pending list of any of the given queues.
+// Notifications are coalesced, so a single receive may stand for many tasks. The channel is closed when ctx is done.
+func (r *RDB) NotifyPending(ctx context.Context, qnames ...string) <-chan struct{} {
+	channels := make([]string, len(qnames))
+	for i, qname := range qnames {
+		channels[i] = notifyChannel(qname)
+	}
+
+	notify := make(chan struct{}, 1)
+	pubsub := r.client.Subscribe(ctx, channels...)
+	go func() {
+		defer close(notify)
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-messages:
+				if !ok {
+					return
+				}
+				select {
+				case notify <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return notify
+}
+
+// extendLeaseCmd pushes the lease expiration of a running task forward.
+// A lease is never shortened, so a processor can hold a longer lease than the worker renews.
+//
+// Input:
+// KEYS[1] -> gotama:<qname>:lease
+// KEYS[2] -> gotama:<qname>:t:<task_id>
+// --
+// ARGV[1] -> task ID
+// ARGV[2] -> lease expiration time in unix milli sec
+// ARGV[3] -> lease token
+//
+// Output:
+// Returns 1 if the lease was extended
+// Returns 0 if the task does not hold the lease anymore
+var extendLeaseCmd = redis.NewScript(leaseLua + `
+if not redis.call("ZSCORE", KEYS[1], ARGV[1]) or not holds_lease(KEYS[2], ARGV[3]) then
+    return 0
+end
+redis.call("ZADD", KEYS[1], "GT", ARGV[2], ARGV[1])
+return 1
+`)
+
+// ExtendLease extends the lease of a running task by the given duration from now.
+func (r *RDB) ExtendLease(ctx context.Context, msg *task.Message, lease time.Duration) error {
+	keys := []string{
+		leaseKey(msg.Queue),
+		taskKey(msg.Queue, msg.ID),
+	}
+	argv := []any{
+		msg.ID,
+		r.clock.Now().Add(lease).UnixMilli(),
+		msg.LeaseToken,
+	}
+	n, err := r.runScriptWithErrorCode(ctx, extendLeaseCmd, keys, argv...)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return base.ErrorLeaseExpired
+	}
+	return nil
+}
+
 // updateTaskCmd enqueues a given task message.
 //
 // Input:
 // KEYS[1] -> gotama:<qname>:t:<task_id>
 // KEYS[2] -> gotama:<qname>:scheduled
+// KEYS[3] -> gotama:<qname>:delayed
+// KEYS[4] -> gotama:<qname>:cron
 // --
 // ARGV[1] -> task message data
 // ARGV[2] -> period in milli s
 // ARGV[3] -> task type - ONCE or RECURRING
 // ARGV[4] -> task id
+// ARGV[5] -> process at time in unix milli sec, 0 if not delayed
+// ARGV[6] -> cron expression, empty if the task is not a cron task
+// ARGV[7] -> timezone of the cron expression
+// ARGV[8] -> next run time of the cron task in unix milli sec
+// ARGV[9] -> lease token of the running task, empty if the task is not updated by the worker processing it
 //
 // Output:
 // Returns 1 if successfully enqueued
 // Returns 0 if task ID does not exist
-var updateTaskCmd = redis.NewScript(`
+// Returns -1 if the task is updated with a lease it does not hold anymore
+var updateTaskCmd = redis.NewScript(leaseLua + `
 if redis.call("EXISTS", KEYS[1]) == 0 then
     return 0
 end
+if ARGV[9] ~= "" and not holds_lease(KEYS[1], ARGV[9]) then
+    return -1
+end
 redis.call("HSET", KEYS[1],
            "msg", ARGV[1],
-           "period", ARGV[2])
+           "period", ARGV[2],
+           "cron", ARGV[6],
+           "timezone", ARGV[7])
 redis.call("LREM", KEYS[2], 0, ARGV[4])
-if ARGV[3] == "RECURRING" then
-    redis.call("LPUSH", KEYS[2], ARGV[4])
+if ARGV[6] ~= "" then
+    redis.call("ZADD", KEYS[4], ARGV[8], ARGV[4])
+else
+    redis.call("ZREM", KEYS[4], ARGV[4])
+    if ARGV[3] == "RECURRING" then
+        redis.call("LPUSH", KEYS[2], ARGV[4])
+    end
+end
+-- reschedule only tasks which are still delayed
+if ARGV[5] ~= "0" then
+    redis.call("ZADD", KEYS[3], "XX", ARGV[5], ARGV[4])
 end
 return 1
 `)
 
 // UpdateTask adds the given task to the pending list of the queue.
 func (r *RDB) UpdateTask(ctx context.Context, msg *task.Message) error {
-	encoded, err := task.EncodeMessage(msg)
+	encoded, err := task.EncodeMessage(msg, r.keyring)
 	if err != nil {
 		return fmt.Errorf("cannot encode message: %v", err)
 	}
+	nextRun, err := nextCronRunMilli(msg, r.clock.Now())
+	if err != nil {
+		return err
+	}
 	keys := []string{
 		taskKey(msg.Queue, msg.ID),
 		scheduledKey(msg.Queue),
+		delayedKey(msg.Queue),
+		cronKey(msg.Queue),
 	}
 	argv := []any{
 		encoded,
 		msg.Period.Milliseconds(),
 		msg.Type.String(),
 		msg.ID,
+		processAtMilli(msg),
+		msg.Cron,
+		msg.Timezone,
+		nextRun,
+		msg.LeaseToken,
 	}
 	logger.Info("Updating task", "id", keys[0])
 	n, err := r.runScriptWithErrorCode(ctx, updateTaskCmd, keys, argv...)
@@ -359,6 +694,9 @@ func (r *RDB) UpdateTask(ctx context.Context, msg *task.Message) error {
 	if n == 0 {
 		return errors.New("task id does not exist")
 	}
+	if n == -1 {
+		return base.ErrorLeaseExpired
+	}
 	return nil
 }
 
@@ -366,12 +704,36 @@ func (r *RDB) UpdateTask(ctx context.Context, msg *task.Message) error {
 // KEYS[2] -> gotama:<qname>:pending
 // KEYS[3] -> gotama:<qname>:scheduled
 // KEYS[4] -> gotama:<qname>:retry
+// KEYS[5] -> gotama:<qname>:running
+// KEYS[6] -> gotama:<qname>:failed
+// KEYS[7] -> gotama:<qname>:lease
+// KEYS[8] -> gotama:task_queues
+// KEYS[9] -> gotama:tasks
+// KEYS[10] -> gotama:<qname>:tasks
+// KEYS[11] -> gotama:<qname>:delayed
+// KEYS[12] -> gotama:<qname>:cron
+// KEYS[13] -> gotama:<qname>:d:<task_id>
 // -------
 // ARGV[1] -> task ID
+// ARGV[2] -> gotama:<qname>:
 var removeCmd = redis.NewScript(`
+local status = redis.call("HGET", KEYS[1], "status")
+if status then
+    redis.call("ZREM", ARGV[2] .. "s:" .. status, ARGV[1])
+end
+redis.call("ZREM", ARGV[2] .. "pending_since", ARGV[1])
+redis.call("ZREM", KEYS[9], ARGV[1])
+redis.call("ZREM", KEYS[10], ARGV[1])
+redis.call("ZREM", KEYS[11], ARGV[1])
+redis.call("ZREM", KEYS[12], ARGV[1])
 redis.call("LREM", KEYS[2], 0, ARGV[1])
 redis.call("LREM", KEYS[3], 0, ARGV[1])
 redis.call("LREM", KEYS[4], 0, ARGV[1])
+redis.call("LREM", KEYS[5], 0, ARGV[1])
+redis.call("LREM", KEYS[6], 0, ARGV[1])
+redis.call("ZREM", KEYS[7], ARGV[1])
+redis.call("HDEL", KEYS[8], ARGV[1])
+redis.call("DEL", KEYS[13])
 if redis.call("DEL", KEYS[1]) == 0 then
     return redis.error_reply("NOT FOUND")
 end
@@ -380,105 +742,277 @@ return redis.status_reply("OK")
 
 // RemoveTask deletes the task from all queues and the task itself
 func (r *RDB) RemoveTask(ctx context.Context, taskID string) error {
+	qname, err := r.taskQueue(ctx, taskID)
+	if err != nil {
+		return err
+	}
 	keys := []string{
-		taskKey(task.QueueDefault, taskID),
-		pendingKey(task.QueueDefault),
-		scheduledKey(task.QueueDefault),
-		retryKey(task.QueueDefault),
+		taskKey(qname, taskID),
+		pendingKey(qname),
+		scheduledKey(qname),
+		retryKey(qname),
+		runningKey(qname),
+		failedKey(qname),
+		leaseKey(qname),
+		KeyTaskQueues,
+		KeyTasks,
+		queueTasksKey(qname),
+		delayedKey(qname),
+		cronKey(qname),
+		deliveriesKey(qname, taskID),
 	}
 
 	argv := []any{
 		taskID,
+		queueKeyPrefix(qname),
 	}
 
-	return r.runScript(ctx, removeCmd, keys, argv...)
+	// the task is read first, so its event tells which task it was
+	msg, err := r.GetTask(ctx, taskID)
+	if err != nil {
+		msg = &task.Message{ID: taskID, Queue: qname}
+	}
+	if err := r.runScript(ctx, removeCmd, keys, argv...); err != nil {
+		return err
+	}
+	r.publishEvent(ctx, base.EventDeleted, msg)
+	return nil
 }
 
 // KEYS[1] -> gotama:<qname>:running
 // KEYS[2] -> gotama:<qname>:retry
 // KEYS[3] -> gotama:<qname>:t:<task_id>
+// KEYS[4] -> gotama:<qname>:lease
 // -------
 // ARGV[1] -> task ID
-var scheduleTaskRetryCmd = redis.NewScript(`
-if redis.call("LREM", KEYS[1], 0, ARGV[1]) == 0 then
-    return redis.error_reply("NOT FOUND")
+// ARGV[2] -> gotama:<qname>:
+// ARGV[3] -> time to retry the task at in unix milli sec, 0 to retry on the next scheduler tick
+// ARGV[4] -> lease token
+//
+// Output:
+// Returns 1 if the task was moved to the retry queue
+// Returns 0 if the task does not hold the lease anymore
+var scheduleTaskRetryCmd = redis.NewScript(setStatusLua + leaseLua + `
+if not holds_lease(KEYS[3], ARGV[4]) or redis.call("LREM", KEYS[1], 0, ARGV[1]) == 0 then
+    return 0
 end
+redis.call("ZREM", KEYS[4], ARGV[1])
 redis.call("LREM", KEYS[2], 0, ARGV[1])
 redis.call("LPUSH", KEYS[2], ARGV[1])
-redis.call("HSET", KEYS[3], "status", "retry")
-return redis.status_reply("OK")`)
+redis.call("HSET", KEYS[3], "retry_at", ARGV[3])
+set_status(ARGV[2], ARGV[1], "retry")
+return 1`)
 
 // RequeueTaskRetry moves the task from running queue to the retry queue.
+// The scheduler moves it back to the pending queue once its RetryAt time has passed.
+// It returns base.ErrorLeaseExpired if the lease of the task was lost.
 func (r *RDB) RequeueTaskRetry(ctx context.Context, msg *task.Message) error {
 	keys := []string{
 		runningKey(msg.Queue),
 		retryKey(msg.Queue),
 		taskKey(msg.Queue, msg.ID),
+		leaseKey(msg.Queue),
+	}
+	var retryAt int64
+	if msg.RetryAt != nil {
+		retryAt = msg.RetryAt.UnixMilli()
+	}
+	if err := r.runLeasedScript(ctx, scheduleTaskRetryCmd, keys, msg.ID, queueKeyPrefix(msg.Queue), retryAt, msg.LeaseToken); err != nil {
+		return err
+	}
+	r.publishEvent(ctx, base.EventRetrying, msg)
+	return nil
+}
+
+// KEYS[1] -> gotama:<qname>:running
+// KEYS[2] -> gotama:<qname>:pending
+// KEYS[3] -> gotama:<qname>:t:<task_id>
+// KEYS[4] -> gotama:<qname>:lease
+// -------
+// ARGV[1] -> task ID
+// ARGV[2] -> task message data
+// ARGV[3] -> current time in unix milli sec
+// ARGV[4] -> notify channel
+// ARGV[5] -> gotama:<qname>:
+// ARGV[6] -> lease token
+//
+// Output:
+// Returns 1 if the task was requeued
+// Returns 0 if the task does not hold the lease anymore, e.g. its lease expired and it was reclaimed
+var requeueTaskPendingCmd = redis.NewScript(setStatusLua + leaseLua + `
+if not holds_lease(KEYS[3], ARGV[6]) or redis.call("LREM", KEYS[1], 0, ARGV[1]) == 0 then
+    return 0
+end
+redis.call("ZREM", KEYS[4], ARGV[1])
+redis.call("HSET", KEYS[3],
+           "msg", ARGV[2],
+           "pending_since", ARGV[3])
+set_status(ARGV[5], ARGV[1], "pending")
+-- Priorities with RPUSH
+redis.call("RPUSH", KEYS[2], ARGV[1])
+redis.call("PUBLISH", ARGV[4], 1)
+return 1`)
+
+// RequeueTaskPending moves the task from running queue back to the pending queue, to be processed next,
+// e.g. when the processing was interrupted by the worker shutting down. It does not count as an attempt.
+// It returns base.ErrorLeaseExpired if the lease of the task was lost, e.g. it expired and the task was reclaimed.
+func (r *RDB) RequeueTaskPending(ctx context.Context, msg *task.Message) error {
+	msg.Status = task.StatusPending
+	encoded, err := task.EncodeMessage(msg, r.keyring)
+	if err != nil {
+		return fmt.Errorf("cannot encode message: %v", err)
+	}
+	keys := []string{
+		runningKey(msg.Queue),
+		pendingKey(msg.Queue),
+		taskKey(msg.Queue, msg.ID),
+		leaseKey(msg.Queue),
+	}
+	argv := []any{
+		msg.ID,
+		encoded,
+		r.clock.Now().UnixMilli(),
+		notifyChannel(msg.Queue),
+		queueKeyPrefix(msg.Queue),
+		msg.LeaseToken,
+	}
+	n, err := r.runScriptWithErrorCode(ctx, requeueTaskPendingCmd, keys, argv...)
+	if err != nil {
+		return err
 	}
-	return r.runScript(ctx, scheduleTaskRetryCmd, keys, msg.ID)
+	if n == 0 {
+		return base.ErrorLeaseExpired
+	}
+	r.publishEvent(ctx, base.EventEnqueued, msg)
+	return nil
 }
 
 // KEYS[1] -> gotama:<qname>:running
 // KEYS[2] -> gotama:<qname>:failed
 // KEYS[3] -> gotama:<qname>:t:<task_id>
+// KEYS[4] -> gotama:<qname>:lease
 // -------
 // ARGV[1] -> task ID
-var requeueTaskFailedCmd = redis.NewScript(`
-if redis.call("LREM", KEYS[1], 0, ARGV[1]) == 0 then
-    return redis.error_reply("NOT FOUND")
+// ARGV[2] -> gotama:<qname>:
+// ARGV[3] -> lease token
+//
+// Output:
+// Returns 1 if the task was moved to the failed queue
+// Returns 0 if the task does not hold the lease anymore
+var requeueTaskFailedCmd = redis.NewScript(setStatusLua + leaseLua + `
+if not holds_lease(KEYS[3], ARGV[3]) or redis.call("LREM", KEYS[1], 0, ARGV[1]) == 0 then
+    return 0
 end
+redis.call("ZREM", KEYS[4], ARGV[1])
 redis.call("LPUSH", KEYS[2], ARGV[1])
-redis.call("HSET", KEYS[3], "status", "failed")
-return redis.status_reply("OK")`)
+set_status(ARGV[2], ARGV[1], "failed")
+return 1`)
 
 // RequeueTaskFailed moves the task from running queue to the failed queue.
+// It returns base.ErrorLeaseExpired if the lease of the task was lost.
 func (r *RDB) RequeueTaskFailed(ctx context.Context, msg *task.Message) error {
 	keys := []string{
 		runningKey(msg.Queue),
 		failedKey(msg.Queue),
 		taskKey(msg.Queue, msg.ID),
+		leaseKey(msg.Queue),
+	}
+	if err := r.runLeasedScript(ctx, requeueTaskFailedCmd, keys, msg.ID, queueKeyPrefix(msg.Queue), msg.LeaseToken); err != nil {
+		return err
 	}
-	return r.runScript(ctx, requeueTaskFailedCmd, keys, msg.ID)
+	r.publishEvent(ctx, base.EventFailed, msg)
+	return nil
 }
 
 // KEYS[1] -> gotama:<qname>:running
 // KEYS[2] -> gotama:<qname>:t:<task_id>
+// KEYS[3] -> gotama:<qname>:lease
 // -------
 // ARGV[1] -> task ID
-var markTaskAsCompleteCmd = redis.NewScript(`
-if redis.call("LREM", KEYS[1], 0, ARGV[1]) == 0 then
-    return redis.error_reply("NOT FOUND")
+// ARGV[2] -> gotama:<qname>:
+// ARGV[3] -> lease token
+//
+// Output:
+// Returns 1 if the task was marked as succeeded
+// Returns 0 if the task does not hold the lease anymore
+var markTaskAsCompleteCmd = redis.NewScript(setStatusLua + leaseLua + `
+if not holds_lease(KEYS[2], ARGV[3]) or redis.call("LREM", KEYS[1], 0, ARGV[1]) == 0 then
+    return 0
 end
-redis.call("HSET", KEYS[2], "status", "succeeded")
-return redis.status_reply("OK")`)
+redis.call("ZREM", KEYS[3], ARGV[1])
+set_status(ARGV[2], ARGV[1], "succeeded")
+return 1`)
 
-// MarkTaskAsComplete moves the task from running queue to the failed queue.
+// MarkTaskAsComplete takes the task off the running queue and marks it as succeeded.
+// It returns base.ErrorLeaseExpired if the lease of the task was lost.
 func (r *RDB) MarkTaskAsComplete(ctx context.Context, msg *task.Message) error {
 	keys := []string{
 		runningKey(msg.Queue),
 		taskKey(msg.Queue, msg.ID),
+		leaseKey(msg.Queue),
+	}
+	if err := r.runLeasedScript(ctx, markTaskAsCompleteCmd, keys, msg.ID, queueKeyPrefix(msg.Queue), msg.LeaseToken); err != nil {
+		return err
 	}
-	return r.runScript(ctx, markTaskAsCompleteCmd, keys, msg.ID)
+	r.publishEvent(ctx, base.EventSucceeded, msg)
+	return nil
 }
 
 // KEYS[1] -> gotama:<qname>:scheduled
 // KEYS[2] -> gotama:<qname>:pending
 // KEYS[3] -> gotama:<qname>:t:
 // KEYS[4] -> gotama:<qname>:retry
+// KEYS[5] -> gotama:<qname>:delayed
+// KEYS[6] -> gotama:<qname>:cron
+// KEYS[7] -> gotama:leader
 // -------
 // ARGV[1] -> current time in unix milli sec
-var enqueueScheduledTasksCmd = redis.NewScript(`
+// ARGV[2] -> notify channel
+// ARGV[3] -> gotama:<qname>:
+// ARGV[4] -> max number of delayed tasks to enqueue
+// ARGV[5] -> epoch of the leadership of the scheduler
+// ARGV[6...] -> triples of a due cron task ID, its due run time and its next run time in unix milli sec,
+// a next run time of 0 removes a task which no longer exists from the cron tasks
+//
+// Output:
+// Returns the IDs of the enqueued tasks
+// Returns -1 if the scheduler is no longer the leader
+var enqueueScheduledTasksCmd = redis.NewScript(setStatusLua + fencingLua + `
+if not is_leader(KEYS[7], ARGV[5]) then
+    return -1
+end
+
+local enqueued = {}
+local due_task_ids = redis.call("ZRANGEBYSCORE", KEYS[5], "-inf", ARGV[1], "LIMIT", 0, ARGV[4])
+
+for _, task_id in ipairs(due_task_ids) do
+    local task_key = KEYS[3] .. task_id
+    redis.call("ZREM", KEYS[5], task_id)
+    if redis.call("EXISTS", task_key) == 1 then
+        redis.call("LPUSH", KEYS[2], task_id)
+        redis.call("HSET", task_key, "pending_since", ARGV[1])
+        set_status(ARGV[3], task_id, "pending")
+        table.insert(enqueued, task_id)
+    end
+end
+
 local retry_task_ids = redis.call("LRANGE", KEYS[4], 0, -1)
 
 for _, task_id in ipairs(retry_task_ids) do
     local task_key = KEYS[3] .. task_id
     local status = redis.call("HGET", task_key, "status")
+    local retry_at = tonumber(redis.call("HGET", task_key, "retry_at")) or 0
 
-    if status == "retry" then
+    if status ~= "retry" then
+        -- the task was retried or removed in the meantime
+        redis.call("LREM", KEYS[4], 0, task_id)
+    elseif retry_at <= tonumber(ARGV[1]) then
+        redis.call("LREM", KEYS[4], 0, task_id)
         -- Priorities with RPUSH
         redis.call("RPUSH", KEYS[2], task_id)
         redis.call("HSET", task_key, "pending_since", ARGV[1])
-        redis.call("HSET", task_key, "status", "pending")
+        set_status(ARGV[3], task_id, "pending")
+        table.insert(enqueued, task_id)
     end
 end
 
@@ -491,26 +1025,352 @@ for _, task_id in ipairs(scheduled_task_ids) do
     local period = tonumber(redis.call("HGET", task_key, "period"))
     local current_time = tonumber(ARGV[1])
 
-    if status ~= "failed" and status ~= "retry" and status ~= "running" and status ~= "pending" and current_time > pending_since + period then
+    if status ~= "failed" and status ~= "retry" and status ~= "running" and status ~= "pending" and status ~= "delayed" and current_time > pending_since + period then
         redis.call("LPUSH", KEYS[2], task_id)
         redis.call("HSET", task_key, "pending_since", ARGV[1])
-        redis.call("HSET", task_key, "status", "pending")
+        set_status(ARGV[3], task_id, "pending")
+        table.insert(enqueued, task_id)
+    end
+end
+
+for i = 6, #ARGV, 3 do
+    local task_id = ARGV[i]
+    local task_key = KEYS[3] .. task_id
+    -- the run is skipped if the task was rescheduled or removed in the meantime
+    if ARGV[i + 2] == "0" or redis.call("EXISTS", task_key) == 0 then
+        redis.call("ZREM", KEYS[6], task_id)
+    elseif tonumber(redis.call("ZSCORE", KEYS[6], task_id)) == tonumber(ARGV[i + 1]) then
+        redis.call("ZADD", KEYS[6], ARGV[i + 2], task_id)
+        local status = redis.call("HGET", task_key, "status")
+        if status ~= "failed" and status ~= "retry" and status ~= "running" and status ~= "pending" and status ~= "delayed" then
+            redis.call("LPUSH", KEYS[2], task_id)
+            redis.call("HSET", task_key, "pending_since", ARGV[1])
+            set_status(ARGV[3], task_id, "pending")
+            table.insert(enqueued, task_id)
+        end
     end
 end
 
-return redis.status_reply("OK")`)
+if #enqueued > 0 then
+    redis.call("PUBLISH", ARGV[2], #enqueued)
+end
+
+return enqueued`)
+
+// maxDelayedTasksPerTick bounds the delayed tasks enqueued by a single script run, so that redis is not blocked for long.
+// The rest is enqueued on the next scheduler tick.
+const maxDelayedTasksPerTick = 1000
 
-// EnqueueScheduledTasks checks for scheduled tasks and pass them to the pending queue
-func (r *RDB) EnqueueScheduledTasks(ctx context.Context) error {
+// GetQueues returns the names of all queues tasks have been enqueued to.
+func (r *RDB) GetQueues(ctx context.Context) ([]string, error) {
+	return r.client.SMembers(ctx, KeyQueues).Result()
+}
+
+// EnqueueScheduledTasks checks for scheduled tasks in all queues and pass them to their pending queue.
+// It returns base.ErrorNotLeader if epoch is not the epoch of the current leadership,
+// and the errors of the queues which failed otherwise.
+func (r *RDB) EnqueueScheduledTasks(ctx context.Context, epoch int64) error {
+	qnames, err := r.GetQueues(ctx)
+	if err != nil {
+		return err
+	}
+	if len(qnames) == 0 {
+		return r.checkLeadership(ctx, epoch)
+	}
+	// a queue which fails does not hold back the others
+	var errs []error
+	for _, qname := range qnames {
+		err := r.enqueueScheduledTasks(ctx, qname, epoch)
+		if errors.Is(err, base.ErrorNotLeader) {
+			return err
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("queue %s: %w", qname, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *RDB) enqueueScheduledTasks(ctx context.Context, qname string, epoch int64) error {
+	now := r.clock.Now()
 	keys := []string{
-		scheduledKey(task.QueueDefault),
-		pendingKey(task.QueueDefault),
-		taskKey(task.QueueDefault, ""),
-		retryKey(task.QueueDefault),
+		scheduledKey(qname),
+		pendingKey(qname),
+		taskKey(qname, ""),
+		retryKey(qname),
+		delayedKey(qname),
+		cronKey(qname),
+		KeyLeader,
 	}
 
 	argv := []any{
-		r.clock.Now().UnixMilli(),
+		now.UnixMilli(),
+		notifyChannel(qname),
+		queueKeyPrefix(qname),
+		maxDelayedTasksPerTick,
+		epoch,
+	}
+
+	cronRuns, err := r.dueCronRuns(ctx, qname, now)
+	if err != nil {
+		return err
+	}
+	argv = append(argv, cronRuns...)
+
+	enqueued, err := r.runScriptWithIDs(ctx, enqueueScheduledTasksCmd, keys, argv...)
+	if err != nil {
+		return err
+	}
+	r.publishEvents(ctx, base.EventEnqueued, qname, enqueued)
+	return nil
+}
+
+// dueCronRuns returns the cron tasks of the queue which are due, each followed by its due and its next run time.
+// The next run times are computed here, since cron expressions cannot be evaluated by a redis script.
+func (r *RDB) dueCronRuns(ctx context.Context, qname string, now time.Time) ([]any, error) {
+	due, err := r.client.ZRangeByScoreWithScores(ctx, cronKey(qname), &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: maxDelayedTasksPerTick,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+
+	schedules := make([]*redis.SliceCmd, len(due))
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, z := range due {
+			schedules[i] = pipe.HMGet(ctx, taskKey(qname, z.Member.(string)), "cron", "timezone")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []any
+	for i, z := range due {
+		taskID := z.Member.(string)
+		values := schedules[i].Val()
+		spec, _ := values[0].(string)
+		timezone, _ := values[1].(string)
+		if spec == "" {
+			runs = append(runs, taskID, int64(z.Score), 0)
+			continue
+		}
+		next, err := task.NextRun(spec, timezone, now)
+		if err != nil {
+			logger.Error("error computing next run of cron task", "id", taskID, "error", err)
+			continue
+		}
+		runs = append(runs, taskID, int64(z.Score), next.UnixMilli())
+	}
+
+	return runs, nil
+}
+
+// KEYS[1] -> gotama:<qname>:lease
+// KEYS[2] -> gotama:<qname>:running
+// KEYS[3] -> gotama:leader
+// -------
+// ARGV[1] -> current time in unix milli sec
+// ARGV[2] -> task key prefix
+// ARGV[3] -> lease expiration time in unix milli sec for running tasks without a lease
+// ARGV[4] -> epoch of the leadership of the scheduler
+//
+// Output:
+// Returns the IDs of the running tasks whose lease expired
+// Returns -1 if the scheduler is no longer the leader
+var expiredLeasesCmd = redis.NewScript(fencingLua + `
+if not is_leader(KEYS[3], ARGV[4]) then
+    return -1
+end
+
+-- running tasks without a lease were dequeued before leases existed, give them one to expire
+local running_task_ids = redis.call("LRANGE", KEYS[2], 0, -1)
+for _, task_id in ipairs(running_task_ids) do
+    redis.call("ZADD", KEYS[1], "NX", ARGV[3], task_id)
+end
+
+local expired = {}
+local expired_task_ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+for _, task_id in ipairs(expired_task_ids) do
+    if redis.call("EXISTS", ARGV[2] .. task_id) == 1 then
+        table.insert(expired, task_id)
+    else
+        redis.call("ZREM", KEYS[1], task_id)
+        redis.call("LREM", KEYS[2], 0, task_id)
+    end
+end
+return expired`)
+
+// KEYS[1] -> gotama:<qname>:lease
+// KEYS[2] -> gotama:<qname>:running
+// KEYS[3] -> gotama:<qname>:retry
+// KEYS[4] -> gotama:<qname>:failed
+// KEYS[5] -> gotama:<qname>:t:<task_id>
+// KEYS[6] -> gotama:leader
+// -------
+// ARGV[1] -> task ID
+// ARGV[2] -> encoded message as it was read
+// ARGV[3] -> message with the failed attempt
+// ARGV[4] -> status to move the task to, retry or failed
+// ARGV[5] -> time to retry the task at in unix milli sec
+// ARGV[6] -> current time in unix milli sec
+// ARGV[7] -> gotama:<qname>:
+// ARGV[8] -> epoch of the leadership of the scheduler
+//
+// Output:
+// Returns 1 if the attempt of the task was failed
+// Returns 0 if the lease of the task was extended or the task was changed since it was read
+// Returns -1 if the scheduler is no longer the leader
+var failExpiredLeaseCmd = redis.NewScript(setStatusLua + fencingLua + `
+if not is_leader(KEYS[6], ARGV[8]) then
+    return -1
+end
+local expires_at = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if not expires_at or tonumber(expires_at) > tonumber(ARGV[6]) or redis.call("HGET", KEYS[5], "msg") ~= ARGV[2] then
+    return 0
+end
+redis.call("ZREM", KEYS[1], ARGV[1])
+redis.call("LREM", KEYS[2], 0, ARGV[1])
+redis.call("HSET", KEYS[5], "msg", ARGV[3])
+if ARGV[4] == "retry" then
+    redis.call("LREM", KEYS[3], 0, ARGV[1])
+    redis.call("LPUSH", KEYS[3], ARGV[1])
+    redis.call("HSET", KEYS[5], "retry_at", ARGV[5])
+else
+    redis.call("LPUSH", KEYS[4], ARGV[1])
+end
+set_status(ARGV[7], ARGV[1], ARGV[4])
+return 1`)
+
+// ReclaimExpiredLeases fails the attempts of the running tasks whose lease has expired.
+// A lease expires when the worker processing the task died without handing it back, e.g. it crashed processing it.
+// The tasks with attempts left are retried right away, the other ones are moved to the dead letter queue.
+// It returns base.ErrorNotLeader if epoch is not the epoch of the current leadership,
+// and the errors of the queues which failed otherwise.
+func (r *RDB) ReclaimExpiredLeases(ctx context.Context, lease time.Duration, epoch int64) error {
+	qnames, err := r.GetQueues(ctx)
+	if err != nil {
+		return err
+	}
+	if len(qnames) == 0 {
+		return r.checkLeadership(ctx, epoch)
+	}
+	// a queue which fails does not hold back the others
+	var errs []error
+	for _, qname := range qnames {
+		err := r.reclaimExpiredLeases(ctx, qname, lease, epoch)
+		if errors.Is(err, base.ErrorNotLeader) {
+			return err
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("queue %s: %w", qname, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *RDB) reclaimExpiredLeases(ctx context.Context, qname string, lease time.Duration, epoch int64) error {
+	keys := []string{
+		leaseKey(qname),
+		runningKey(qname),
+		KeyLeader,
+	}
+	now := r.clock.Now()
+	argv := []any{
+		now.UnixMilli(),
+		taskKeyPrefix(qname),
+		now.Add(lease).UnixMilli(),
+		epoch,
+	}
+	expired, err := r.runScriptWithIDs(ctx, expiredLeasesCmd, keys, argv...)
+	if err != nil {
+		return err
+	}
+
+	var reclaimed int
+	for _, id := range expired {
+		ok, err := r.failExpiredLease(ctx, qname, id, now, epoch)
+		if err != nil {
+			return err
+		}
+		if ok {
+			reclaimed++
+		}
+	}
+	if reclaimed > 0 {
+		logger.Warn("Reclaimed tasks with expired lease", "queue", qname, "count", reclaimed)
+	}
+	return nil
+}
+
+// failExpiredLease moves the task whose lease expired to the retry list, or to the failed list if it has no attempts left.
+// A task which cannot be decoded cannot be retried either. It reports whether the task was moved,
+// a task whose lease was extended or which was changed meanwhile is left to the next scheduler tick.
+func (r *RDB) failExpiredLease(ctx context.Context, qname string, id string, now time.Time, epoch int64) (bool, error) {
+	encoded, err := r.client.HGet(ctx, taskKey(qname, id), "msg").Result()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	failed := encoded
+	status := "failed"
+	var retryAt int64
+	msg, err := r.decode(encoded, "running")
+	if err == nil {
+		if msg.FailExpiredLease(now) {
+			status = "retry"
+			retryAt = msg.RetryAt.UnixMilli()
+		}
+		rewritten, err := task.EncodeMessage(msg, r.keyring)
+		if err != nil {
+			return false, fmt.Errorf("cannot encode message: %v", err)
+		}
+		failed = string(rewritten)
+	}
+
+	keys := []string{
+		leaseKey(qname),
+		runningKey(qname),
+		retryKey(qname),
+		failedKey(qname),
+		taskKey(qname, id),
+		KeyLeader,
+	}
+	argv := []any{
+		id,
+		encoded,
+		failed,
+		status,
+		retryAt,
+		now.UnixMilli(),
+		queueKeyPrefix(qname),
+		epoch,
+	}
+	n, err := r.runScriptWithErrorCode(ctx, failExpiredLeaseCmd, keys, argv...)
+	if err != nil {
+		return false, err
+	}
+	if n == -1 {
+		return false, base.ErrorNotLeader
+	}
+	if n == 0 {
+		return false, nil
+	}
+	switch {
+	case msg == nil:
+	case status == "retry":
+		r.publishEvent(ctx, base.EventRetrying, msg)
+	default:
+		r.publishEvent(ctx, base.EventFailed, msg)
 	}
-	return r.runScript(ctx, enqueueScheduledTasksCmd, keys, argv)
+	return true, nil
 }
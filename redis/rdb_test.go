@@ -0,0 +1,105 @@
+package redis
+
+import (
+	"context"
+	"github.com/engpetarmarinov/gotama/internal/brokertest"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRDB connects to the redis of REDIS_TEST_ADDR and flushes its database, e.g. of a local container:
+// docker run --rm -p 6379:6379 redis:7-alpine
+// REDIS_TEST_ADDR=localhost:6379 go test ./redis/...
+func newTestRDB(t *testing.T, clock timeutil.Clock) *RDB {
+	t.Helper()
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR is not set")
+	}
+	logger.Init(logger.NewConfigOpt().WithLevel(logger.ERROR))
+
+	r := NewRDB(ClientOpt{Addr: addr}.NewRedisClient(), clock)
+	t.Cleanup(func() { _ = r.Close() })
+	if err := r.client.FlushDB(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestConformance(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T, clock timeutil.Clock) brokertest.Broker {
+		return newTestRDB(t, clock)
+	})
+}
+
+func TestSchedulerSkipsFailedQueue(t *testing.T) {
+	ctx := context.Background()
+	clock := timeutil.NewSimulatedClock(time.Now())
+	r := newTestRDB(t, clock)
+	epoch := brokertest.Leadership(t, r)
+
+	// the indexes of the broken queue have the wrong type, so the scripts of the queue fail
+	if err := r.client.SAdd(ctx, KeyQueues, "broken").Err(); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{retryKey("broken"), leaseKey("broken")} {
+		if err := r.client.Set(ctx, key, "corrupt", 0).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	delayed := brokertest.NewMessage(t, &task.Request{Name: "email", Type: "once", ProcessIn: "30s"})
+	if err := r.EnqueueTask(ctx, delayed); err != nil {
+		t.Fatal(err)
+	}
+	clock.AdvanceTime(31 * time.Second)
+	if err := r.EnqueueScheduledTasks(ctx, epoch); err == nil || !strings.Contains(err.Error(), "queue broken") {
+		t.Errorf("expected the error of the broken queue, got %v", err)
+	}
+	running, err := r.DequeueTask(ctx, time.Minute, task.QueueDefault)
+	if err != nil || running.ID != delayed.ID {
+		t.Fatalf("expected the delayed task to be enqueued despite the broken queue, got %v, %v", running, err)
+	}
+
+	clock.AdvanceTime(2 * time.Minute)
+	if err := r.ReclaimExpiredLeases(ctx, time.Minute, epoch); err == nil || !strings.Contains(err.Error(), "queue broken") {
+		t.Errorf("expected the error of the broken queue, got %v", err)
+	}
+	if msg, err := r.GetTask(ctx, delayed.ID); err != nil || msg.NumRetries != 1 {
+		t.Errorf("expected the expired lease to be reclaimed despite the broken queue, got %v, %v", msg, err)
+	}
+}
+
+func TestMigratePendingSince(t *testing.T) {
+	ctx := context.Background()
+	clock := timeutil.NewSimulatedClock(time.Now())
+	r := newTestRDB(t, clock)
+
+	pending := brokertest.NewMessage(t, &task.Request{Name: "email", Type: "once"})
+	running := brokertest.NewMessage(t, &task.Request{Name: "email", Type: "once"})
+	for _, msg := range []*task.Message{running, pending} {
+		if err := r.EnqueueTask(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.DequeueTask(ctx, time.Minute, task.QueueDefault); err != nil {
+		t.Fatal(err)
+	}
+
+	// the tasks were enqueued before the pending tasks were indexed by pending_since
+	if err := r.client.Del(ctx, pendingSinceKey(task.QueueDefault)).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := r.client.ZRange(ctx, pendingSinceKey(task.QueueDefault), 0, -1).Result()
+	if err != nil || len(ids) != 1 || ids[0] != pending.ID {
+		t.Errorf("expected only the pending task to be indexed, got %v, %v", ids, err)
+	}
+}
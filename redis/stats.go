@@ -0,0 +1,154 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/redis/go-redis/v9"
+	"sort"
+	"time"
+)
+
+// memorySampleSize is the number of task hashes the memory usage of all tasks of a queue is estimated from.
+const memorySampleSize = 20
+
+// taskStatuses are the statuses a task can have in its hash and the status index.
+var taskStatuses = []string{"pending", "delayed", "scheduled", "running", "retry", "failed", "succeeded"}
+
+// GetAllQueueStats returns the stats of all queues, sorted by name.
+func (r *RDB) GetAllQueueStats(ctx context.Context) ([]*base.QueueStats, error) {
+	qnames, err := r.GetQueues(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(qnames)
+
+	stats := make([]*base.QueueStats, 0, len(qnames))
+	for _, qname := range qnames {
+		s, err := r.queueStats(ctx, qname)
+		if err != nil {
+			return nil, fmt.Errorf("queue %s: %w", qname, err)
+		}
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+// GetQueueStats returns the number of tasks of the queue in each state, the age of its oldest pending task and its memory usage.
+func (r *RDB) GetQueueStats(ctx context.Context, qname string) (*base.QueueStats, error) {
+	exists, err := r.client.SIsMember(ctx, KeyQueues, qname).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, base.ErrorQueueNotFound
+	}
+	return r.queueStats(ctx, qname)
+}
+
+func (r *RDB) queueStats(ctx context.Context, qname string) (*base.QueueStats, error) {
+	var total, pending, running, retry, failed, scheduled, cron, delayed *redis.IntCmd
+	var oldestPending *redis.ZSliceCmd
+	var sample *redis.StringSliceCmd
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		total = pipe.ZCard(ctx, queueTasksKey(qname))
+		pending = pipe.LLen(ctx, pendingKey(qname))
+		running = pipe.LLen(ctx, runningKey(qname))
+		retry = pipe.LLen(ctx, retryKey(qname))
+		failed = pipe.LLen(ctx, failedKey(qname))
+		scheduled = pipe.LLen(ctx, scheduledKey(qname))
+		cron = pipe.ZCard(ctx, cronKey(qname))
+		delayed = pipe.ZCard(ctx, delayedKey(qname))
+		// retried and reclaimed tasks are pushed to the tail of the pending list, so it is not sorted by pending_since
+		oldestPending = pipe.ZRangeWithScores(ctx, pendingSinceKey(qname), 0, 0)
+		sample = pipe.ZRange(ctx, queueTasksKey(qname), 0, memorySampleSize-1)
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	stats := &base.QueueStats{
+		Queue:            qname,
+		Total:            total.Val(),
+		Pending:          pending.Val(),
+		Running:          running.Val(),
+		Retry:            retry.Val(),
+		Failed:           failed.Val(),
+		Scheduled:        scheduled.Val(),
+		Cron:             cron.Val(),
+		Delayed:          delayed.Val(),
+		OldestPendingAge: (0 * time.Second).String(),
+	}
+
+	if oldest := oldestPending.Val(); len(oldest) > 0 {
+		age := r.clock.Now().Sub(time.UnixMilli(int64(oldest[0].Score))).Truncate(time.Second)
+		if age > 0 {
+			stats.OldestPendingAge = age.String()
+		}
+	}
+
+	stats.MemoryUsage, err = r.queueMemoryUsage(ctx, qname, sample.Val(), stats.Total)
+	if err != nil {
+		// e.g. MEMORY USAGE is disabled by some managed redis services, the rest of the stats are still useful
+		logger.Warn("error measuring queue memory usage", "queue", qname, "error", err)
+	}
+
+	return stats, nil
+}
+
+// queueMemoryUsage returns the memory used by the keys of the queue.
+// The memory used by the task hashes is estimated from a sample of them, since there can be too many to measure each.
+func (r *RDB) queueMemoryUsage(ctx context.Context, qname string, sample []string, total int64) (int64, error) {
+	keys := []string{
+		pendingKey(qname),
+		pendingSinceKey(qname),
+		runningKey(qname),
+		retryKey(qname),
+		failedKey(qname),
+		scheduledKey(qname),
+		cronKey(qname),
+		delayedKey(qname),
+		leaseKey(qname),
+		queueTasksKey(qname),
+	}
+	for _, status := range taskStatuses {
+		keys = append(keys, statusKey(qname, status))
+	}
+
+	keyCmds := make([]*redis.IntCmd, len(keys))
+	sampleCmds := make([]*redis.IntCmd, len(sample))
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			keyCmds[i] = pipe.MemoryUsage(ctx, key)
+		}
+		for i, id := range sample {
+			sampleCmds[i] = pipe.MemoryUsage(ctx, taskKey(qname, id))
+		}
+		return nil
+	})
+	// the keys which do not exist use no memory
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, err
+	}
+
+	var usage int64
+	for _, cmd := range keyCmds {
+		usage += cmd.Val()
+	}
+
+	var sampled, sampleUsage int64
+	for _, cmd := range sampleCmds {
+		if cmd.Err() == nil {
+			sampled++
+			sampleUsage += cmd.Val()
+		}
+	}
+	if sampled > 0 {
+		usage += sampleUsage * total / sampled
+	}
+
+	return usage, nil
+}
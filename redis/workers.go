@@ -0,0 +1,87 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/engpetarmarinov/gotama/internal/base"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/redis/go-redis/v9"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const KeyWorkers = "gotama:workers" // ZSET
+
+// workerKey returns a redis key for the info of the given worker.
+func workerKey(id string) string {
+	return fmt.Sprintf("%s:%s", KeyWorkers, id)
+}
+
+// WriteWorkerInfo registers the worker until ttl passes without it being written again.
+func (r *RDB) WriteWorkerInfo(ctx context.Context, info *base.WorkerInfo, ttl time.Duration) error {
+	encoded, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("cannot encode worker info: %v", err)
+	}
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, workerKey(info.ID), encoded, ttl)
+		pipe.ZAdd(ctx, KeyWorkers, redis.Z{Score: float64(r.clock.Now().Add(ttl).UnixMilli()), Member: info.ID})
+		return nil
+	})
+	return err
+}
+
+// ClearWorkerInfo unregisters the worker.
+func (r *RDB) ClearWorkerInfo(ctx context.Context, id string) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, workerKey(id))
+		pipe.ZRem(ctx, KeyWorkers, id)
+		return nil
+	})
+	return err
+}
+
+// GetWorkers returns the workers which are alive, sorted by ID.
+// The workers which stopped without unregistering are dropped once their info expires.
+func (r *RDB) GetWorkers(ctx context.Context) ([]*base.WorkerInfo, error) {
+	now := strconv.FormatInt(r.clock.Now().UnixMilli(), 10)
+	if err := r.client.ZRemRangeByScore(ctx, KeyWorkers, "-inf", "("+now).Err(); err != nil {
+		return nil, err
+	}
+	ids, err := r.client.ZRange(ctx, KeyWorkers, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	workers := make([]*base.WorkerInfo, 0, len(ids))
+	if len(ids) == 0 {
+		return workers, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = workerKey(id)
+	}
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		encoded, ok := value.(string)
+		if !ok {
+			// expired in the meantime
+			continue
+		}
+		var info base.WorkerInfo
+		if err := json.Unmarshal([]byte(encoded), &info); err != nil {
+			logger.Warn("error decoding worker info", "id", ids[i], "error", err)
+			continue
+		}
+		workers = append(workers, &info)
+	}
+
+	sort.Slice(workers, func(i, j int) bool { return workers[i].ID < workers[j].ID })
+	return workers, nil
+}
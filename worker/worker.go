@@ -0,0 +1,254 @@
+// Package worker runs gotama workers inside other services, with processors which are registered by the services.
+// A worker hands the tasks it has no processor for back to their queue, for the other workers, and skips the queue for a while,
+// so the tasks of the processors of a service are best submitted to queues which only the workers of the service consume.
+package worker
+
+import (
+	"context"
+	"fmt"
+	brk "github.com/engpetarmarinov/gotama/internal/broker"
+	"github.com/engpetarmarinov/gotama/internal/config"
+	"github.com/engpetarmarinov/gotama/internal/encryption"
+	"github.com/engpetarmarinov/gotama/internal/logger"
+	"github.com/engpetarmarinov/gotama/internal/processors"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	wrk "github.com/engpetarmarinov/gotama/internal/worker"
+	rdb "github.com/engpetarmarinov/gotama/redis"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a task as its processor receives it.
+type Message = task.Message
+
+// Processor processes the tasks with a name. ValidatePayload is called by the managers which have the processor
+// registered when the tasks are submitted, and ProcessTask by the workers. A task whose ProcessTask returns an error
+// is retried by its retry policy.
+type Processor = processors.Processor
+
+// Registration describes the processor of the tasks with a name.
+type Registration = processors.Registration
+
+// Config is the configuration processors are created with, see Registration.New.
+type Config = config.API
+
+// RetryPolicy is how a failed task is retried, zero fields fall back to the defaults.
+type RetryPolicy = task.RetryPolicy
+
+// Backoff is the shape of the delay between the attempts of a task.
+type Backoff = task.Backoff
+
+const (
+	BackoffExponential = task.BackoffExponential
+	BackoffLinear      = task.BackoffLinear
+	BackoffConstant    = task.BackoffConstant
+)
+
+// Register makes a processor available under its name, see processors.Register. The built-in processors,
+// e.g. EMAIL, are registered as well, so a worker processes their tasks too if it consumes their queues.
+// The managers which do not have the processor registered accept its tasks once a worker with it is alive.
+func Register(reg Registration) {
+	processors.Register(reg)
+}
+
+// Broker is the storage of the tasks a worker processes, e.g. the broker returned by NewBroker.
+type Broker = wrk.Broker
+
+// BrokerCloser is a Broker which holds connections, closed by Close once the worker is shut down.
+type BrokerCloser interface {
+	Broker
+	Close() error
+}
+
+// BrokerOption configures the broker returned by NewBroker.
+type BrokerOption = brk.Option
+
+// Keyring holds the keys the payloads of the tasks are encrypted with.
+type Keyring = encryption.Keyring
+
+// NewKeyring parses a comma separated list of <id>:<base64 key> pairs, the first key being the primary one,
+// in the format of the ENCRYPTION_KEYS config of the manager.
+func NewKeyring(spec string) (*Keyring, error) {
+	return encryption.NewKeyring(spec)
+}
+
+// WithRedis stores the tasks in the redis server of opt, the default is a redis server at localhost:6379.
+func WithRedis(opt rdb.ClientOpt) BrokerOption {
+	return brk.WithRedis(opt)
+}
+
+// WithRedisClient stores the tasks with an existing redis client, which is closed with the broker.
+func WithRedisClient(client redis.UniversalClient) BrokerOption {
+	return brk.WithRedisClient(client)
+}
+
+// WithPostgres stores the tasks in the postgres database of url.
+func WithPostgres(url string) BrokerOption {
+	return brk.WithPostgres(url)
+}
+
+// WithKeyring encrypts the payloads of the tasks with the keyring, it has to have the keys of the manager.
+func WithKeyring(keyring *Keyring) BrokerOption {
+	return brk.WithKeyring(keyring)
+}
+
+// NewBroker returns the broker configured by opts, redis at localhost:6379 if no storage is set.
+func NewBroker(ctx context.Context, opts ...BrokerOption) (BrokerCloser, error) {
+	return brk.New(ctx, opts...)
+}
+
+// Worker processes the tasks of the queues it consumes.
+type Worker struct {
+	worker *wrk.Worker
+}
+
+// Option configures a Worker. The options take precedence over the WORKER_* configuration.
+type Option func(*options)
+
+// options is the configuration of a worker, the values set by the options and the fallback for the rest.
+type options struct {
+	values   map[string]string
+	fallback Config
+	logLevel logger.Level
+}
+
+func (o *options) Get(key string) string {
+	if value, ok := o.values[key]; ok {
+		return value
+	}
+	return o.fallback.Get(key)
+}
+
+// WithConfig sets the configuration of the worker and its processors, the environment by default.
+// It is read with the keys of the gotama-worker binary, e.g. WORKER_QUEUES or EMAIL_FROM.
+func WithConfig(cfg Config) Option {
+	return func(o *options) {
+		o.fallback = cfg
+	}
+}
+
+// WithConcurrency sets the number of goroutines processing tasks, the number of CPUs by default.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		o.values["WORKER_GOROUTINES"] = strconv.Itoa(n)
+	}
+}
+
+// WithQueues sets the queues the worker consumes, e.g. "critical=6", "default=3", "low=1",
+// with an optional weight each. The default queue by default.
+func WithQueues(queues ...string) Option {
+	return func(o *options) {
+		o.values["WORKER_QUEUES"] = strings.Join(queues, ",")
+	}
+}
+
+// WithStrictPriority makes the worker consume a queue only when all queues with a higher weight are empty,
+// instead of proportionally to their weights.
+func WithStrictPriority(strict bool) Option {
+	return func(o *options) {
+		o.values["WORKER_STRICT_PRIORITY"] = strconv.FormatBool(strict)
+	}
+}
+
+// WithTaskDeadline sets how long a task can be processed for, 5 minutes by default.
+func WithTaskDeadline(d time.Duration) Option {
+	return func(o *options) {
+		o.values["WORKER_TASK_DEADLINE"] = d.String()
+	}
+}
+
+// WithTaskLease sets the lease of the tasks being processed, renewed while they are,
+// after which another worker can take over a task of a worker which died. 30 seconds by default.
+func WithTaskLease(d time.Duration) Option {
+	return func(o *options) {
+		o.values["WORKER_TASK_LEASE"] = d.String()
+	}
+}
+
+// WithShutdownTimeout sets how long Shutdown waits for the tasks being processed, 30 seconds by default.
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.values["WORKER_SHUTDOWN_TIMEOUT"] = d.String()
+	}
+}
+
+// WithMetricsPort exposes the prometheus metrics of the worker on /metrics on the port, none by default.
+func WithMetricsPort(port int) Option {
+	return func(o *options) {
+		o.values["WORKER_METRICS_PORT"] = strconv.Itoa(port)
+	}
+}
+
+// WithLogLevel sets the level of the logs of the worker, one of DEBUG, INFO, WARN or ERROR. INFO by default.
+func WithLogLevel(level string) Option {
+	return func(o *options) {
+		o.logLevel = logger.NewLogLevel(level)
+	}
+}
+
+// New returns a worker which processes the tasks of broker, it starts with Run.
+func New(broker Broker, opts ...Option) (*Worker, error) {
+	o := &options{
+		values:   make(map[string]string),
+		fallback: config.NewConfig(),
+		logLevel: logger.INFO,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if err := validate(o); err != nil {
+		return nil, err
+	}
+
+	logger.Init(logger.NewConfigOpt().WithLevel(o.logLevel))
+	return &Worker{
+		worker: wrk.NewWorker(o, broker, timeutil.NewRealClock()),
+	}, nil
+}
+
+// validate checks the configuration of a worker, which panics on an invalid one when it runs.
+func validate(cfg Config) error {
+	for _, key := range []string{
+		"WORKER_GOROUTINES",
+		"WORKER_MAX_HAND_BACKS",
+	} {
+		if n := cfg.Get(key); n != "" {
+			if v, err := strconv.Atoi(n); err != nil || v <= 0 {
+				return fmt.Errorf("invalid %s %s, expected a positive number", key, n)
+			}
+		}
+	}
+	for _, key := range []string{
+		"WORKER_TASK_DEADLINE",
+		"WORKER_TASK_LEASE",
+		"WORKER_HEARTBEAT_INTERVAL",
+	} {
+		if _, err := config.GetPositiveDuration(cfg, key, time.Second); err != nil {
+			return err
+		}
+	}
+	for _, key := range []string{
+		"WORKER_SHUTDOWN_TIMEOUT",
+		"WORKER_RETRY_BACKOFF_BASE",
+		"WORKER_RETRY_BACKOFF_MAX",
+	} {
+		if _, err := config.GetDuration(cfg, key, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run starts processing tasks in the background.
+func (w *Worker) Run() {
+	w.worker.Run()
+}
+
+// Shutdown stops dequeuing tasks and waits for the tasks being processed to finish.
+// The ones still processed after the shutdown timeout are interrupted and handed back to the pending queue.
+func (w *Worker) Shutdown() error {
+	return w.worker.Shutdown()
+}
@@ -0,0 +1,104 @@
+package worker
+
+import (
+	"context"
+	"github.com/engpetarmarinov/gotama/internal/task"
+	"github.com/engpetarmarinov/gotama/internal/timeutil"
+	"github.com/engpetarmarinov/gotama/memory"
+	"slices"
+	"testing"
+	"time"
+)
+
+type invoiceProcessor struct {
+	processed chan string
+}
+
+func (p *invoiceProcessor) ProcessTask(_ context.Context, msg *Message) error {
+	p.processed <- string(msg.Payload)
+	return nil
+}
+
+func (p *invoiceProcessor) ValidatePayload([]byte) error {
+	return nil
+}
+
+var processed = make(chan string, 1)
+
+func init() {
+	Register(Registration{
+		Name:        "invoice",
+		Description: "Sends an invoice.",
+		RetryPolicy: &RetryPolicy{MaxAttempts: 2, Backoff: BackoffConstant},
+		New: func(Config) Processor {
+			return &invoiceProcessor{processed: processed}
+		},
+	})
+}
+
+func TestWorker(t *testing.T) {
+	broker := memory.NewBroker(timeutil.NewRealClock())
+	w, err := New(broker,
+		WithConcurrency(1),
+		WithQueues("billing"),
+		WithShutdownTimeout(time.Second),
+		WithLogLevel("ERROR"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Run()
+	defer w.Shutdown()
+
+	msg, err := task.NewMessageFromRequest(&task.Request{
+		Name:    "invoice",
+		Type:    "once",
+		Queue:   "billing",
+		Payload: []byte(`{"invoice":42}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.EnqueueTask(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case payload := <-processed:
+		if payload != `{"invoice":42}` {
+			t.Errorf("unexpected payload %s", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the task was not processed")
+	}
+
+	// the worker registers itself in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		workers, err := broker.GetWorkers(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(workers) == 1 && slices.Contains(workers[0].Processors, "INVOICE") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the worker to advertise its processors, got %+v", workers)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	broker := memory.NewBroker(timeutil.NewRealClock())
+	if _, err := New(broker, WithConcurrency(0)); err == nil {
+		t.Error("expected an error for no goroutines")
+	}
+	if _, err := New(broker, WithTaskLease(0)); err == nil {
+		t.Error("expected an error for a zero lease")
+	}
+	t.Setenv("WORKER_HEARTBEAT_INTERVAL", "-1s")
+	if _, err := New(broker); err == nil {
+		t.Error("expected an error for a negative heartbeat interval")
+	}
+}